@@ -0,0 +1,100 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/nalbury/promql-cli/pkg/assertion"
+)
+
+// cmd line args
+var (
+	assertCondition string
+	assertMode      string
+	expectRows      string
+	// condition and rowCount are parsed from the --assert and --expect-rows flags
+	condition *assertion.Condition
+	rowCount  *assertion.RowCount
+)
+
+// parseAssertions parses the --assert and --expect-rows flags, so invalid assertions fail before running any queries
+func parseAssertions() error {
+	if assertMode != assertion.ModeAll && assertMode != assertion.ModeAny {
+		return fmt.Errorf("unknown --assert-mode %q, options: %s,%s", assertMode, assertion.ModeAll, assertion.ModeAny)
+	}
+	if assertCondition != "" {
+		c, err := assertion.ParseCondition(assertCondition)
+		if err != nil {
+			return err
+		}
+		condition = &c
+	}
+	if expectRows != "" {
+		r, err := assertion.ParseRowCount(expectRows)
+		if err != nil {
+			return err
+		}
+		rowCount = &r
+	}
+	return nil
+}
+
+// checkAssertions evaluates the --fail-on-empty, --assert and --expect-rows flags against a query result
+// Violations are logged, and an error exiting with exitAssertionFailed is returned if any assertion failed
+func checkAssertions(result model.Value) error {
+	series, _ := resultSize(result)
+	if err := checkNotEmpty(series); err != nil {
+		return err
+	}
+	if condition == nil && rowCount == nil {
+		return nil
+	}
+	samples, err := assertion.Samples(result)
+	if err != nil {
+		return err
+	}
+	failed := false
+	if rowCount != nil && !rowCount.Eval(len(samples)) {
+		errlog.Printf("Assertion failed: expected %s rows, got %d\n", rowCount, len(samples))
+		failed = true
+	}
+	if condition != nil {
+		passed, violations, err := assertion.Check(samples, *condition, assertMode)
+		if err != nil {
+			return err
+		}
+		if !passed {
+			errlog.Printf("Assertion failed: %s (%s), %d of %d series violated it\n", condition, assertMode, len(violations), len(samples))
+			for _, v := range violations {
+				errlog.Printf("  %s %v\n", v.Metric, model.SampleValue(v.Value))
+			}
+			failed = true
+		}
+	}
+	if failed {
+		return &exitError{code: exitAssertionFailed, err: fmt.Errorf("assertions failed")}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&assertCondition, "assert", "", "condition checked against the value of each series e.g. 'value > 0.99', exiting with code 5 if it fails. Range queries check the last sample of each series")
+	rootCmd.PersistentFlags().StringVar(&assertMode, "assert-mode", assertion.ModeAll, "whether all or any of the series must satisfy --assert. Options: all,any")
+	rootCmd.PersistentFlags().StringVar(&expectRows, "expect-rows", "", "expected number of result series, either exact e.g. 3 or a comparison e.g. '>0', exiting with code 5 if it doesn't match")
+}
@@ -0,0 +1,86 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/nalbury/promql-cli/pkg/promql"
+)
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check [query_string]",
+	Short: "Check the syntax of a query without running it",
+	Long: `Check the syntax of a query with the prometheus promql parser without sending it to prometheus, e.g. in pre-commit hooks.
+The first syntax error is written to stderr with its line:column position, and the command exits with 1 if there are any.
+Use --query-file to check every query of a query file, positioning errors in the file.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if queryFile != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if queryFile != "" {
+			if err := checkQueryFile(queryFile); err != nil {
+				fatal(err)
+			}
+			return
+		}
+		if err := promql.CheckSyntax(query, 1); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+// checkQueryFile checks the syntax of every query in a query file, writing their errors as path:line:column: error
+func checkQueryFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading query file: %v", err)
+	}
+	defer f.Close()
+	queries, err := promql.ParseQueryFile(f)
+	if err != nil {
+		return fmt.Errorf("error reading query file: %v", err)
+	}
+	failed := 0
+	for _, q := range queries {
+		expr, err := expandQuery(q.Expr)
+		if err != nil {
+			errlog.Printf("%s:%d: %v\n", path, q.Line, err)
+			failed++
+			continue
+		}
+		if err := promql.CheckSyntax(expr, q.Line); err != nil {
+			errlog.Printf("%s:%v\n", path, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d queries have errors", failed, len(queries))
+	}
+	return nil
+}
+
+func init() {
+	checkCmd.Flags().StringVar(&queryFile, "query-file", "", "path to a file of queries to check instead of a query argument, see promql --help")
+	rootCmd.AddCommand(checkCmd)
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// testConfig resolves the root command's flags from args, the PROMQL_ env vars already set, a config file, and a context
+func testConfig(t *testing.T, args []string, config string, context map[string]interface{}) *viper.Viper {
+	t.Helper()
+	flags := rootCmd.PersistentFlags()
+	// The root command's flags are shared, so reset any we set
+	t.Cleanup(func() {
+		for _, name := range []string{"host", "output", "step", "timeout"} {
+			f := flags.Lookup(name)
+			if err := f.Value.Set(f.DefValue); err != nil {
+				t.Fatal(err)
+			}
+			f.Changed = false
+		}
+	})
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	v := viper.New()
+	configureEnv(v)
+	if err := bindFlags(v, flags, "host", "output", "step", "timeout"); err != nil {
+		t.Fatal(err)
+	}
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	if context != nil {
+		if err := v.MergeConfigMap(context); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return v
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	config := "host: http://config:9090\noutput: json\nstep: 5m\n"
+	context := map[string]interface{}{"host": "http://context:9090"}
+	cases := []struct {
+		args     []string
+		env      map[string]string
+		context  map[string]interface{}
+		key      string
+		expected string
+	}{
+		// Defaults are used when nothing else sets a key
+		{key: "timeout", expected: "10"},
+		{key: "host", expected: "http://config:9090"},
+		{key: "host", context: context, expected: "http://context:9090"},
+		{key: "host", context: context, env: map[string]string{"PROMQL_HOST": "http://env:9090"}, expected: "http://env:9090"},
+		{
+			key:      "host",
+			args:     []string{"--host", "http://flag:9090"},
+			context:  context,
+			env:      map[string]string{"PROMQL_HOST": "http://env:9090"},
+			expected: "http://flag:9090",
+		},
+		{key: "host", env: map[string]string{"PROMQL_CLI_HOST": "http://cli-env:9090"}, expected: "http://cli-env:9090"},
+		{key: "host", context: context, env: map[string]string{"PROMETHEUS_URL": "http://url-env:9090"}, expected: "http://url-env:9090"},
+		{
+			key:      "host",
+			env:      map[string]string{"PROMQL_HOST": "http://env:9090", "PROMQL_CLI_HOST": "http://cli-env:9090", "PROMETHEUS_URL": "http://url-env:9090"},
+			expected: "http://env:9090",
+		},
+		{
+			key:      "host",
+			env:      map[string]string{"PROMQL_CLI_HOST": "http://cli-env:9090", "PROMETHEUS_URL": "http://url-env:9090"},
+			expected: "http://cli-env:9090",
+		},
+		{
+			key:      "host",
+			args:     []string{"--host", "http://flag:9090"},
+			env:      map[string]string{"PROMETHEUS_URL": "http://url-env:9090"},
+			expected: "http://flag:9090",
+		},
+		{key: "output", env: map[string]string{"PROMQL_OUTPUT": "csv"}, expected: "csv"},
+		{key: "step", args: []string{"--step", "1h"}, env: map[string]string{"PROMQL_STEP": "30s"}, expected: "1h"},
+		{key: "step", expected: "5m"},
+		{key: "timeout", env: map[string]string{"PROMQL_TIMEOUT": "30"}, expected: "30"},
+	}
+	for i, c := range cases {
+		t.Run("", func(t *testing.T) {
+			for k, v := range c.env {
+				t.Setenv(k, v)
+			}
+			v := testConfig(t, c.args, config, c.context)
+			if got := v.GetString(c.key); got != c.expected {
+				t.Errorf("Unexpected output for case %d: %s", i, got)
+			}
+		})
+	}
+}
+
+func TestConfigEnvNames(t *testing.T) {
+	t.Setenv("PROMQL_TLS_CONFIG_CA_CERT_FILE", "/etc/ca.pem")
+	t.Setenv("PROMQL_AUTH_TYPE", "Bearer")
+	v := viper.New()
+	configureEnv(v)
+	if got := v.GetString("tls_config.ca_cert_file"); got != "/etc/ca.pem" {
+		t.Errorf("Unexpected output for tls_config.ca_cert_file: %s", got)
+	}
+	if got := v.GetString("auth-type"); got != "Bearer" {
+		t.Errorf("Unexpected output for auth-type: %s", got)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/nalbury/promql-cli/pkg/contexts"
+)
+
+// loadContext merges the selected context from the contexts file into our config
+// The context is selected with --context or PROMQL_CONTEXT, falling back to the file's current-context
+func loadContext() error {
+	f, err := loadContextsFile()
+	if err != nil {
+		return err
+	}
+	name := viper.GetString("context")
+	if name == "" {
+		name = f.CurrentContext
+	}
+	if name == "" {
+		return nil
+	}
+	ctx, err := f.Get(name)
+	if err != nil {
+		// A stale current-context shouldn't stop us from running e.g. "context use" to fix it
+		if viper.GetString("context") == "" {
+			errlog.Printf("Could not load current context: %v\n", err)
+			return nil
+		}
+		return err
+	}
+	// Context values are merged in on top of the config file, so flags and env vars still take precedence over them
+	return viper.MergeConfigMap(ctx)
+}
+
+// contextCmd represents the context command
+var contextCmd = &cobra.Command{
+	Use:   "context",
+	Short: "Manage named server contexts",
+	Long: `Manage the named server contexts defined in the contexts file (default $HOME/.config/promql-cli/config.yaml).
+Each context is a set of flag values e.g. host, auth-type, header, step, and output, used unless overridden on the command line.`,
+}
+
+// contextListCmd represents the context list command
+var contextListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the contexts in the contexts file",
+	Long:  "List the contexts in the contexts file, marking the current context with *",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := loadContextsFile()
+		if err != nil {
+			errlog.Fatalln(err)
+		}
+		w := tabwriter.NewWriter(stdout, 0, 0, 4, ' ', 0)
+		if !pql.NoHeaders {
+			fmt.Fprintln(w, "CURRENT\tNAME\tHOST")
+		}
+		for _, name := range f.Names() {
+			current := ""
+			if name == f.CurrentContext {
+				current = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%v\n", current, name, f.Contexts[name]["host"])
+		}
+		if err := w.Flush(); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+// contextUseCmd represents the context use command
+var contextUseCmd = &cobra.Command{
+	Use:   "use [context_name]",
+	Short: "Set the current context",
+	Long:  "Set the current context in the contexts file, used when no --context is given",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := loadContextsFile()
+		if err != nil {
+			errlog.Fatalln(err)
+		}
+		if err := f.Use(args[0]); err != nil {
+			errlog.Fatalln(err)
+		}
+		if err := f.Save(); err != nil {
+			errlog.Fatalln(err)
+		}
+		fmt.Printf("Switched to context %q\n", args[0])
+	},
+}
+
+// loadContextsFile loads the contexts file from its default location
+func loadContextsFile() (*contexts.File, error) {
+	path, err := contexts.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return contexts.Load(path)
+}
+
+func init() {
+	contextCmd.AddCommand(contextListCmd)
+	contextCmd.AddCommand(contextUseCmd)
+	rootCmd.AddCommand(contextCmd)
+}
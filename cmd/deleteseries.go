@@ -0,0 +1,88 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// delete-series flags
+var (
+	forceDelete     bool
+	cleanTombstones bool
+)
+
+// deleteSeriesCmd represents the delete-series command
+var deleteSeriesCmd = &cobra.Command{
+	Use:   "delete-series [match_selector...]",
+	Short: "Delete series matching the provided selectors using the admin API",
+	Long: `Delete series matching the provided selectors using the admin API.
+
+The number of matching series is shown first and deletion requires confirmation, unless --force is set.
+Use --start and --end to limit the deletion window, by default all data up to now is deleted.
+Requires prometheus to be started with --web.enable-admin-api.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		start, end, err := pql.DeleteRange()
+		if err != nil {
+			errlog.Fatalln(err)
+		}
+		count, warnings, err := pql.DeleteSeriesCount(args)
+		if len(warnings) > 0 {
+			errlog.Printf("Warnings: %v\n", warnings)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		from := "the beginning of time"
+		if !start.IsZero() {
+			from = start.Format(time.RFC3339)
+		}
+		errlog.Printf("Found %d series matching %s from %s to %s\n", count, strings.Join(args, ", "), from, end.Format(time.RFC3339))
+		if count == 0 {
+			errlog.Println("Nothing to delete")
+			return
+		}
+		if !forceDelete {
+			fmt.Fprint(os.Stderr, "Type 'yes' to delete them: ")
+			answer, err := readLine()
+			if err != nil || strings.TrimSpace(answer) != "yes" {
+				errlog.Fatalln("Aborting, no series were deleted")
+			}
+		}
+		if err := pql.DeleteSeries(args); err != nil {
+			fatal(err)
+		}
+		errlog.Printf("Deleted %d series\n", count)
+		if cleanTombstones {
+			if err := pql.CleanTombstones(); err != nil {
+				fatal(err)
+			}
+			errlog.Println("Cleaned tombstones")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(deleteSeriesCmd)
+	deleteSeriesCmd.Flags().BoolVar(&forceDelete, "force", false, "delete without asking for confirmation")
+	deleteSeriesCmd.Flags().BoolVar(&cleanTombstones, "clean-tombstones", false, "remove the deleted data from disk after deleting the series")
+}
@@ -0,0 +1,107 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/spf13/cobra"
+
+	"github.com/nalbury/promql-cli/pkg/promql"
+	"github.com/nalbury/promql-cli/pkg/writer"
+)
+
+// diff flags
+var (
+	diffHostA     string
+	diffHostB     string
+	diffTolerance string
+)
+
+// diffQuery runs the instant query against host and applies any requested transformations
+func diffQuery(host string) (model.Vector, error) {
+	p := pql
+	p.Host = host
+	cl, err := promql.CreateAPIClientWithOptions(p.Host, p.Auth, p.TLSConfig, p.ClientOptions)
+	if err != nil {
+		return nil, err
+	}
+	p.APIClient = cl
+	p.Client = v1.NewAPI(cl)
+	result, warnings, err := p.InstantQuery(query)
+	if len(warnings) > 0 {
+		errlog.Printf("Warnings from %s: %v\n", host, warnings)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t, err := transformResult(result)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := t.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("unable to compare %s results from %s, diff requires an instant vector result", t.Type(), host)
+	}
+	return v, nil
+}
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff [query_string]",
+	Short: "Compare the results of an instant query across two prometheus servers",
+	Long: `Compare the results of an instant query across two prometheus servers.
+
+Results are joined by label set and written with VALUE_A, VALUE_B, DIFF and DIFF_PCT columns.
+Series that differ by more than --tolerance, or are only returned by one server, are flagged in the STATUS column
+and make the command exit with code 5, like a failed --assert, so the comparison can gate CI jobs.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tolerance, err := writer.ParseTolerance(diffTolerance)
+		if err != nil {
+			errlog.Fatalln(err)
+		}
+		a, err := diffQuery(diffHostA)
+		if err != nil {
+			fatal(fmt.Errorf("error querying %s: %w", diffHostA, err))
+		}
+		b, err := diffQuery(diffHostB)
+		if err != nil {
+			fatal(fmt.Errorf("error querying %s: %w", diffHostB, err))
+		}
+		r := writer.Diff(a, b, tolerance)
+		if err := writer.WriteInstant(stdout, &r, pql.Output, writerOpts); err != nil {
+			fatal(err)
+		}
+		if n := r.Mismatches(); n > 0 {
+			fatal(&exitError{code: exitAssertionFailed, err: fmt.Errorf("%d of %d series do not match", n, len(r))})
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringVar(&diffHostA, "host-a", "", "url of the first prometheus server to compare")
+	diffCmd.Flags().StringVar(&diffHostB, "host-b", "", "url of the second prometheus server to compare")
+	diffCmd.Flags().StringVar(&diffTolerance, "tolerance", "0", "allowed difference before values are considered a mismatch, either absolute (e.g. 0.5) or relative to the first server (e.g. 0.1%)")
+	for _, f := range []string{"host-a", "host-b"} {
+		if err := diffCmd.MarkFlagRequired(f); err != nil {
+			errlog.Fatalln(err)
+		}
+	}
+}
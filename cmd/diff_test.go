@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffExitCode(t *testing.T) {
+	a := exitCodeServer()
+	defer a.Close()
+	// up is 1 on a, and 0 on b, as it never gets to the run up turns 1 on
+	var polls int64
+	b := waitServer(math.MaxInt64, &polls)
+	defer b.Close()
+	cases := []struct {
+		hostB    string
+		expected int
+		stderr   string
+	}{
+		{hostB: a.URL, expected: 0},
+		{hostB: b.URL, expected: exitAssertionFailed, stderr: `"errorType":"assertion_failed"`},
+	}
+	for i, c := range cases {
+		_, stderr, code := runPromql(t, []string{"diff", "up", "--host-a", a.URL, "--host-b", c.hostB, "--output", "csv", "--error-format", "json"})
+		assert.Equal(t, c.expected, code, "Unexpected err for case %d, %s", i, stderr)
+		assert.Contains(t, stderr, c.stderr, "Unexpected output for case %d", i)
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/nalbury/promql-cli/pkg/promql"
+)
+
+// cmd line args
+var (
+	dryRun bool
+	curl   bool
+	// dryRunner writes out requests in place of sending them when --dry-run or --curl is set
+	dryRunner *promql.DryRun
+)
+
+// setupDryRun sets up the client to write out requests instead of sending them if --dry-run or --curl is set
+func setupDryRun() {
+	if !dryRun && !curl {
+		return
+	}
+	dryRunner = promql.NewDryRun(pipeWriter{os.Stdout}, curl)
+	pql.ClientOptions.DryRun = dryRunner
+}
+
+// dryRunRequests returns the number of requests written out in dry run mode
+// Every query fails once its request is written out, so callers compare counts to tell those failures apart from real ones
+func dryRunRequests() int {
+	if dryRunner == nil {
+		return 0
+	}
+	return dryRunner.Requests()
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print the http requests that would be sent to prometheus (method, url, and headers with secrets redacted) without sending them")
+	rootCmd.PersistentFlags().BoolVar(&curl, "curl", false, "print the http requests that would be sent to prometheus as equivalent curl commands without sending them, implies --dry-run")
+}
@@ -0,0 +1,306 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+
+	"github.com/nalbury/promql-cli/pkg/promql"
+)
+
+// Exit codes for failures scripts may want to tell apart, any other error exits with exitUsage
+const (
+	// exitUsage is returned for invalid flags and arguments, and any error without a code of its own
+	exitUsage = 1
+	// exitNetwork is returned when the server can't be reached, or doesn't respond before --timeout
+	exitNetwork = 2
+	// exitBadQuery is returned when the server rejects a query as invalid
+	exitBadQuery = 3
+	// exitEmpty is returned when a query or metadata subcommand returns no results and --fail-on-empty is set
+	exitEmpty = 4
+	// exitAssertionFailed is returned when a query result fails --assert or --expect-rows, or diff finds series that don't match
+	exitAssertionFailed = 5
+	// exitWaitTimeout is returned when the --wait-for condition isn't met before --wait-timeout
+	exitWaitTimeout = 6
+	// exitAuth is returned when the server rejects a request as unauthenticated or forbidden
+	// It's 7 rather than 5, which was already exitAssertionFailed when auth failures got their own code
+	exitAuth = 7
+)
+
+// exitCodes describes each exit code in --help
+var exitCodes = []struct {
+	code        int
+	description string
+}{
+	{0, "success"},
+	{exitUsage, "invalid flags or arguments, or any other error"},
+	{exitNetwork, "the server can't be reached, or doesn't respond before --timeout"},
+	{exitBadQuery, "the server rejects the query as invalid"},
+	{exitEmpty, "the result is empty and --fail-on-empty is set"},
+	{exitAssertionFailed, "the result fails --assert or --expect-rows, or diff finds series that don't match"},
+	{exitWaitTimeout, "the --wait-for condition isn't met before --wait-timeout"},
+	{exitAuth, "the server rejects the request as unauthenticated or forbidden"},
+}
+
+// exitCodesHelp returns the exit codes section of --help
+func exitCodesHelp() string {
+	var b strings.Builder
+	b.WriteString("Exit codes:")
+	for _, c := range exitCodes {
+		fmt.Fprintf(&b, "\n  %d  %s", c.code, c.description)
+	}
+	return b.String()
+}
+
+// cmd line args
+var (
+	errorFormat string
+	// failOnEmpty exits with exitEmpty when a result has no series
+	failOnEmpty bool
+)
+
+// errEmptyResult is the error of results --fail-on-empty rejects
+var errEmptyResult = errors.New("the query returned no results")
+
+// checkNotEmpty returns an error exiting with exitEmpty if --fail-on-empty is set and a result has no series, e.g. from resultSize
+func checkNotEmpty(series int) error {
+	if failOnEmpty && series == 0 {
+		return &exitError{code: exitEmpty, err: errEmptyResult}
+	}
+	return nil
+}
+
+// The error formats of the --error-format flag
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+// exitError is an error that exits with a specific exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the exit code for err
+// Other prometheus api errors, e.g. a query timing out on the server, exit with 1 rather than exitNetwork
+func exitCode(err error) int {
+	var (
+		e      *exitError
+		apiErr *v1.Error
+	)
+	switch {
+	case errors.As(err, &e):
+		return e.code
+	case promql.IsAuthError(err):
+		return exitAuth
+	case errors.As(err, &apiErr):
+		if apiErr.Type == v1.ErrBadData {
+			return exitBadQuery
+		}
+		return exitUsage
+	}
+	switch errorType(err) {
+	case "connection", "timeout":
+		return exitNetwork
+	default:
+		return exitUsage
+	}
+}
+
+// stdout is where results are written
+var stdout io.Writer = pipeWriter{os.Stdout}
+
+// pipeWriter exits successfully once the reader of a pipe has gone away, e.g. promql ... | head
+// Like other unix tools there's no point writing the rest of the result, and nothing to report
+type pipeWriter struct {
+	w io.Writer
+}
+
+func (p pipeWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	if errors.Is(err, syscall.EPIPE) {
+		closePortForward()
+		os.Exit(0)
+	}
+	return n, err
+}
+
+// fatal logs err and exits with its exit code, tearing down any --k8s-service port-forward
+// In dry run mode requests fail on purpose once they're written out, so we exit successfully once any have been
+func fatal(err error) {
+	flushPager()
+	exitIfInterrupted()
+	closePortForward()
+	if dryRunRequests() > 0 {
+		os.Exit(0)
+	}
+	err = requestIDError(portForwardError(err))
+	logError(err)
+	os.Exit(exitCode(err))
+}
+
+// requestIDError adds the id of the last request sent to err when --request-id-header is set, so it can be found in server logs
+func requestIDError(err error) error {
+	if pql.ClientOptions.RequestIDs == nil || pql.ClientOptions.RequestIDs.Last() == "" {
+		return err
+	}
+	return fmt.Errorf("%w (request id: %s)", err, pql.ClientOptions.RequestIDs.Last())
+}
+
+// logError writes err to stderr, as {"error":"...","type":"...","errorType":"...","exitCode":N} with --error-format json
+// Json errors include the query being run and the http status of error responses, when there is one
+func logError(err error) {
+	if errorFormat != errorFormatJSON {
+		errlog.Println(err)
+		return
+	}
+	var apiErr *promql.APIError
+	status := 0
+	if errors.As(err, &apiErr) {
+		status = apiErr.StatusCode
+	}
+	// type and status are the field names the request specified, kept alongside errorType and httpStatus
+	typ := errorType(err)
+	b, jsonErr := json.Marshal(struct {
+		Error      string `json:"error"`
+		Type       string `json:"type"`
+		ErrorType  string `json:"errorType"`
+		Status     int    `json:"status,omitempty"`
+		HTTPStatus int    `json:"httpStatus,omitempty"`
+		Query      string `json:"query,omitempty"`
+		ExitCode   int    `json:"exitCode"`
+	}{Error: err.Error(), Type: typ, ErrorType: typ, Status: status, HTTPStatus: status, Query: query, ExitCode: exitCode(err)})
+	if jsonErr != nil {
+		errlog.Println(err)
+		return
+	}
+	errlog.Println(string(b))
+}
+
+// errorLogger logs to stderr like log.Logger, but reports fatal errors with logError so every failure follows --error-format
+type errorLogger struct {
+	*log.Logger
+}
+
+func (l errorLogger) Fatal(v ...any) {
+	l.exit(v, fmt.Sprint(v...))
+}
+
+func (l errorLogger) Fatalf(format string, v ...any) {
+	l.exit(nil, fmt.Sprintf(format, v...))
+}
+
+func (l errorLogger) Fatalln(v ...any) {
+	l.exit(v, fmt.Sprintln(v...))
+}
+
+// exit reports a fatal error and exits with exitUsage, like log.Fatal
+// A lone error argument is reported as is, so its errorType is kept
+func (l errorLogger) exit(v []any, msg string) {
+	err, ok := error(nil), false
+	if len(v) == 1 {
+		err, ok = v[0].(error)
+	}
+	if !ok {
+		err = errors.New(strings.TrimSuffix(msg, "\n"))
+	}
+	flushPager()
+	closePortForward()
+	logError(&exitError{code: exitUsage, err: err})
+	os.Exit(exitUsage)
+}
+
+// errorType returns the kind of failure err is for --error-format json, so scripts can tell failures apart without matching messages
+// Prometheus api errors keep their error type, except bad_data which is reported as bad_query, and requests rejected as unauthenticated or forbidden are auth
+func errorType(err error) string {
+	var (
+		e      *exitError
+		apiErr *v1.Error
+		netErr net.Error
+		opErr  *net.OpError
+		dnsErr *net.DNSError
+	)
+	switch {
+	case errors.As(err, &e) && e.code == exitAssertionFailed:
+		return "assertion_failed"
+	case errors.As(err, &e) && e.code == exitWaitTimeout:
+		return "wait_timeout"
+	case errors.As(err, &e) && e.code == exitEmpty:
+		return "empty_result"
+	case promql.IsAuthError(err):
+		return "auth"
+	case errors.As(err, &apiErr):
+		if apiErr.Type == v1.ErrBadData {
+			return "bad_query"
+		}
+		return string(apiErr.Type)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &opErr), errors.As(err, &dnsErr):
+		return "connection"
+	default:
+		return "error"
+	}
+}
+
+// errorFormatArg returns the --error-format given in args, or def if there isn't one
+// Flags after one that fails to parse aren't parsed, so this finds whether the failure should be reported as json
+func errorFormatArg(args []string, def string) string {
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			return def
+		case strings.HasPrefix(arg, "--error-format="):
+			return strings.TrimPrefix(arg, "--error-format=")
+		case arg == "--error-format" && i+1 < len(args):
+			return args[i+1]
+		}
+	}
+	return def
+}
+
+// checkErrorFormat validates the --error-format flag
+func checkErrorFormat() {
+	if errorFormat != errorFormatText && errorFormat != errorFormatJSON {
+		errlog.Fatalf("unknown --error-format %q. Options: %s,%s\n", errorFormat, errorFormatText, errorFormatJSON)
+	}
+}
+
+func init() {
+	rootCmd.Long += "\n\n" + exitCodesHelp()
+	rootCmd.PersistentFlags().BoolVar(&failOnEmpty, "fail-on-empty", false, fmt.Sprintf("exit with code %d when a query, or the metrics, labels, or meta subcommands, return no results", exitEmpty))
+	rootCmd.PersistentFlags().StringVar(&errorFormat, "error-format", errorFormatText, "format of errors written to stderr. Options: text,json (as {\"error\":\"...\",\"type\":\"...\",\"errorType\":\"...\",\"exitCode\":N} for scripts, e.g. errorType bad_query, timeout, connection, or empty_result)")
+}
@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nalbury/promql-cli/pkg/writer"
+)
+
+// closedPipeEnv is set when the test binary is re-run by TestClosedPipe to write results to a closed stdout pipe
+const closedPipeEnv = "PROMQL_CLI_TEST_CLOSED_PIPE"
+
+func TestClosedPipe(t *testing.T) {
+	if os.Getenv(closedPipeEnv) != "" {
+		// Like Execute, so writes to the closed pipe fail with EPIPE rather than killing the process
+		signal.Ignore(syscall.SIGPIPE)
+		m := make(model.Matrix, 0, 100)
+		for i := 0; i < cap(m); i++ {
+			s := &model.SampleStream{Metric: model.Metric{"__name__": "up", "instance": model.LabelValue(rune('a' + i%26))}}
+			for j := 0; j < 100; j++ {
+				s.Values = append(s.Values, model.SamplePair{Timestamp: model.Time(j * 60000), Value: model.SampleValue(j)})
+			}
+			m = append(m, s)
+		}
+		for _, format := range []string{"csv", "json"} {
+			if err := writer.WriteRange(stdout, &writer.RangeResult{Matrix: m}, format, writer.Options{}); err != nil {
+				errlog.Println(err)
+			}
+		}
+		os.Exit(3)
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("closed pipes don't fail writes with EPIPE on windows")
+	}
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	defer w.Close()
+	var stderr bytes.Buffer
+	c := exec.Command(os.Args[0], "-test.run=^TestClosedPipe$")
+	c.Env = append(os.Environ(), closedPipeEnv+"=1")
+	c.Stdout = w
+	c.Stderr = &stderr
+	err = c.Run()
+	assert.NoError(t, err, "Expected a successful exit writing to a closed pipe")
+	assert.Empty(t, stderr.String())
+}
+
+// exitCodeArgsEnv holds the json encoded args the test binary runs promql with when it's re-run by TestExitCodes
+const exitCodeArgsEnv = "PROMQL_CLI_TEST_EXIT_CODE_ARGS"
+
+// exitCodeServer is a fake prometheus server for TestExitCodes
+// The query absent returns no results, sum( is rejected as invalid, and requests with credentials are rejected as unauthenticated
+func exitCodeServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		send := func(status int, body string) {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+		}
+		if r.Header.Get("Authorization") != "" {
+			send(http.StatusUnauthorized, `{"status":"error","errorType":"unauthorized","error":"bad credentials"}`)
+			return
+		}
+		_ = r.ParseForm()
+		query := r.Form.Get("query")
+		switch {
+		case query == "sum(":
+			send(http.StatusBadRequest, `{"status":"error","errorType":"bad_data","error":"1:5: parse error: unclosed left parenthesis"}`)
+		case r.URL.Path == "/api/v1/query" && query == "absent":
+			send(http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+		case r.URL.Path == "/api/v1/query":
+			send(http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"up","job":"web"},"value":[1600000000,"1"]}]}}`)
+		case r.URL.Path == "/api/v1/query_range" && query == "absent":
+			send(http.StatusOK, `{"status":"success","data":{"resultType":"matrix","result":[]}}`)
+		case r.URL.Path == "/api/v1/query_range":
+			send(http.StatusOK, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"up","job":"web"},"values":[[1600000000,"1"]]}]}}`)
+		case r.URL.Path == "/api/v1/series":
+			send(http.StatusOK, `{"status":"success","data":[]}`)
+		case r.URL.Path == "/api/v1/metadata":
+			send(http.StatusOK, `{"status":"success","data":{}}`)
+		default:
+			send(http.StatusNotFound, `{"status":"error","errorType":"not_found","error":"not found"}`)
+		}
+	}))
+}
+
+func TestExitCodes(t *testing.T) {
+	if v := os.Getenv(exitCodeArgsEnv); v != "" {
+		var args []string
+		if err := json.Unmarshal([]byte(v), &args); err != nil {
+			errlog.Fatalln(err)
+		}
+		os.Args = append([]string{"promql"}, args...)
+		Execute()
+		os.Exit(0)
+	}
+	srv := exitCodeServer()
+	defer srv.Close()
+	// Nothing listens on the port of a closed server
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	cases := []struct {
+		args     []string
+		expected int
+	}{
+		{args: []string{"up"}, expected: 0},
+		{args: []string{"up", "--since", "5m"}, expected: 0},
+		{args: []string{"absent"}, expected: 0},
+		{args: []string{"up", "--no-such-flag"}, expected: exitUsage},
+		{args: []string{"up", "--time", "yesterday"}, expected: exitUsage},
+		{args: []string{"up", "--host", closed.URL}, expected: exitNetwork},
+		{args: []string{"sum("}, expected: exitBadQuery},
+		{args: []string{"absent", "--fail-on-empty"}, expected: exitEmpty},
+		{args: []string{"absent", "--since", "5m", "--fail-on-empty"}, expected: exitEmpty},
+		{args: []string{"absent", "--value-only", "--fail-on-empty"}, expected: exitEmpty},
+		{args: []string{"absent", "--count-only", "--fail-on-empty"}, expected: exitEmpty},
+		{args: []string{"metrics", "--fail-on-empty"}, expected: exitEmpty},
+		{args: []string{"labels", "absent", "--fail-on-empty"}, expected: exitEmpty},
+		{args: []string{"labels", "--match[]", "up", "--match[]=absent"}, expected: 0},
+		{args: []string{"metrics", "--match[]", "up"}, expected: 0},
+		{args: []string{"series", "--match[]", "up", "absent"}, expected: 0},
+		{args: []string{"series"}, expected: exitUsage},
+		{args: []string{"series", "up", "--fail-on-empty"}, expected: exitEmpty},
+		{args: []string{"meta", "--fail-on-empty"}, expected: exitEmpty},
+		{args: []string{"up", "--fail-on-empty"}, expected: 0},
+		{args: []string{"up", "--assert", "value > 1"}, expected: exitAssertionFailed},
+		{args: []string{"up", "--since", "5m", "--expect-rows", "2"}, expected: exitAssertionFailed},
+		{args: []string{"up", "--wait-for", "value > 1", "--wait-interval", "10ms", "--wait-timeout", "50ms"}, expected: exitWaitTimeout},
+		{args: []string{"up", "--bearer-token", "secret"}, expected: exitAuth},
+	}
+	home := t.TempDir()
+	for i, c := range cases {
+		args, err := json.Marshal(append([]string{"--host", srv.URL}, c.args...))
+		assert.NoError(t, err)
+		cmd := exec.Command(os.Args[0], "-test.run=^TestExitCodes$")
+		cmd.Env = append(os.Environ(), exitCodeArgsEnv+"="+string(args), "HOME="+home, "NO_COLOR=1")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		code := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		} else {
+			assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		}
+		assert.Equal(t, c.expected, code, "Unexpected output for case %d %v: %s", i, c.args, stderr.String())
+	}
+}
+
+// runPromql runs promql with args in a re-run of the test binary, like TestExitCodes, with the extra environment variables env
+// It returns what was written to stdout and stderr and the exit code
+func runPromql(t *testing.T, args []string, env ...string) (string, string, int) {
+	t.Helper()
+	a, err := json.Marshal(args)
+	assert.NoError(t, err)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestExitCodes$")
+	cmd.Env = append(append(os.Environ(), exitCodeArgsEnv+"="+string(a), "HOME="+t.TempDir(), "NO_COLOR=1"), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	code := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	} else {
+		assert.NoError(t, err)
+	}
+	return stdout.String(), stderr.String(), code
+}
+
+func TestErrorFormatJSON(t *testing.T) {
+	srv := exitCodeServer()
+	defer srv.Close()
+	cases := []struct {
+		args     []string
+		expected map[string]any
+	}{
+		{
+			args:     []string{"sum("},
+			expected: map[string]any{"error": "error querying prometheus: 400 Bad Request: bad_data: 1:5: parse error: unclosed left parenthesis", "type": "bad_query", "errorType": "bad_query", "status": 400.0, "httpStatus": 400.0, "query": "sum(", "exitCode": 3.0},
+		},
+		{
+			args:     []string{"up", "--time", "yesterday"},
+			expected: map[string]any{"error": `parsing time "yesterday" as "2006-01-02T15:04:05Z07:00": cannot parse "yesterday" as "2006"`, "type": "error", "errorType": "error", "exitCode": 1.0},
+		},
+		{
+			args:     []string{"up", "--no-such-flag"},
+			expected: map[string]any{"error": "unknown flag: --no-such-flag", "type": "error", "errorType": "error", "exitCode": 1.0},
+		},
+		{
+			args:     []string{"check", "sum("},
+			expected: map[string]any{"error": "1:5: parse error: unclosed left parenthesis", "type": "error", "errorType": "error", "query": "sum(", "exitCode": 1.0},
+		},
+		{
+			args:     []string{"absent", "--fail-on-empty"},
+			expected: map[string]any{"error": "the query returned no results", "type": "empty_result", "errorType": "empty_result", "query": "absent", "exitCode": 4.0},
+		},
+		{
+			args:     []string{"labels", "absent", "--fail-on-empty"},
+			expected: map[string]any{"error": "the query returned no results", "type": "empty_result", "errorType": "empty_result", "query": "absent", "exitCode": 4.0},
+		},
+		{
+			args:     []string{"metrics", "--fail-on-empty"},
+			expected: map[string]any{"error": "the query returned no results", "type": "empty_result", "errorType": "empty_result", "exitCode": 4.0},
+		},
+		{
+			args:     []string{"meta", "--fail-on-empty"},
+			expected: map[string]any{"error": "the query returned no results", "type": "empty_result", "errorType": "empty_result", "exitCode": 4.0},
+		},
+	}
+	home := t.TempDir()
+	for i, c := range cases {
+		args, err := json.Marshal(append([]string{"--host", srv.URL}, append(c.args, "--error-format", "json")...))
+		assert.NoError(t, err)
+		cmd := exec.Command(os.Args[0], "-test.run=^TestExitCodes$")
+		cmd.Env = append(os.Environ(), exitCodeArgsEnv+"="+string(args), "HOME="+home, "NO_COLOR=1")
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		assert.Error(t, err, "Expected err for case %d", i)
+		var e map[string]any
+		assert.NoError(t, json.Unmarshal(stderr.Bytes(), &e), "Unexpected err for case %d, %s", i, stderr.String())
+		assert.Equal(t, c.expected, e, "Unexpected output for case %d", i)
+		assert.Empty(t, stdout.String(), "Unexpected output for case %d", i)
+	}
+}
+
+func TestErrorFormatArg(t *testing.T) {
+	cases := []struct {
+		args     []string
+		expected string
+	}{
+		{args: []string{"up", "--bad", "--error-format", "json"}, expected: "json"},
+		{args: []string{"up", "--error-format=json", "--bad"}, expected: "json"},
+		{args: []string{"up", "--bad"}, expected: "text"},
+		{args: []string{"up", "--error-format"}, expected: "text"},
+		{args: []string{"--", "--error-format", "json"}, expected: "text"},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.expected, errorFormatArg(c.args, errorFormatText), "Unexpected output for case %d", i)
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/nalbury/promql-cli/pkg/promql"
+)
+
+// explainASTCmd represents the explain-ast command
+var explainASTCmd = &cobra.Command{
+	Use:   "explain-ast [query_string]",
+	Short: "Print the parsed syntax tree of a query without running it",
+	Long: `Parse a query with the prometheus promql parser and print its syntax tree, without sending it to prometheus.
+Each line is a node of the tree: aggregations, binary operators, function calls, ranges and selectors, with their operands indented below them.
+This shows how operator precedence groups a query, e.g. that a / b * c is (a / b) * c.
+Syntax errors are written to stderr like the check command, and the command exits with 1.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tree, err := promql.ExplainAST(query)
+		if err != nil {
+			fatal(err)
+		}
+		if _, err := io.WriteString(stdout, tree); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(explainASTCmd)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/prometheus/common/model"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/nalbury/promql-cli/pkg/promql"
+	"github.com/nalbury/promql-cli/pkg/transform"
+	"github.com/nalbury/promql-cli/pkg/writer"
+)
+
+// cmd line args
+var federateMatches []string
+
+// federateCmd represents the federate command
+var federateCmd = &cobra.Command{
+	Use:   "federate [match_selector...]",
+	Short: "Get the current series matching the provided selectors from the federation endpoint",
+	Long: `Get the current series matching the provided selectors from the federation endpoint. By default the raw exposition format payload is printed, use --output (table,json,csv) to parse and write it like an instant query.
+Selectors can be given as arguments or with --match[] (or --match), like the endpoint's match[] parameter.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && len(federateMatches) == 0 {
+			return fmt.Errorf("requires at least one match selector, as an argument or with --match[]")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		payload, err := pql.FederateQuery(append(federateMatches, args...))
+		if err != nil {
+			fatal(err)
+		}
+		// With no explicit output format write out the payload as is
+		if pql.Output == "" {
+			if _, err := stdout.Write(payload); err != nil {
+				fatal(err)
+			}
+			return
+		}
+		result, err := promql.ParseFederation(payload)
+		if err != nil {
+			fatal(err)
+		}
+		t, err := transformResult(result)
+		if err != nil {
+			fatal(err)
+		}
+		r := writer.InstantResult{Vector: transform.SortSeries(t).(model.Vector)}
+		if err := writer.WriteInstant(stdout, &r, pql.Output, writerOpts); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+func init() {
+	federateCmd.Flags().StringArrayVar(&federateMatches, "match", []string{}, "series selector of the series to federate, can be repeated. Also accepted as --match[]")
+	// Accept the endpoint's parameter name as a flag, so selectors can be copied from federation scrape configs
+	federateCmd.Flags().SetNormalizeFunc(normalizeMatchFlag)
+	rootCmd.AddCommand(federateCmd)
+}
+
+// normalizeMatchFlag accepts --match[], the name of the api's match[] parameter, as the --match flag
+func normalizeMatchFlag(f *pflag.FlagSet, name string) pflag.NormalizedName {
+	if name == "match[]" {
+		name = "match"
+	}
+	return pflag.NormalizedName(name)
+}
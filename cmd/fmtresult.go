@@ -0,0 +1,54 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/nalbury/promql-cli/pkg/promql"
+)
+
+// fmtResultCmd represents the fmt-result command
+var fmtResultCmd = &cobra.Command{
+	Use:   "fmt-result",
+	Short: "Write out a prometheus api query response read from stdin",
+	Long: `Read a prometheus api query response from stdin and write out its result like promql writes query results, without querying prometheus.
+This formats responses fetched another way, e.g. curl -s 'https://prometheus/api/v1/query?query=up' | promql fmt-result --output csv, when the auth is easier with curl.
+Every output and transform flag applies. Warnings in the response are written to stderr, and error responses exit non-zero with the server's error.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if includeQuery {
+			errlog.Fatalln("--include-query needs a query, it can't be combined with fmt-result")
+		}
+		result, warnings, err := promql.ReadResult(os.Stdin)
+		if len(warnings) > 0 {
+			errlog.Printf("Warnings: %v\n", warnings)
+		}
+		if err != nil {
+			fatal(fmt.Errorf("error reading the response: %w", err))
+		}
+		if err := outputSavedResult(result, "the response"); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fmtResultCmd)
+}
@@ -0,0 +1,104 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/nalbury/promql-cli/pkg/promql"
+)
+
+// cmd line args
+var (
+	// inputFile is the path of a saved query result to write out instead of running a query, - for stdin
+	inputFile string
+)
+
+// checkInputFile validates the flags combined with --input-file, which writes out a saved result rather than querying prometheus
+func checkInputFile() {
+	if inputFile == "" {
+		return
+	}
+	switch {
+	case queryFile != "":
+		errlog.Fatalln("please specify either --input-file or --query-file, not both")
+	case watchInterval != 0:
+		errlog.Fatalln("--watch reruns a query, it can't be combined with --input-file")
+	case waitFor != "":
+		errlog.Fatalln("--wait-for reruns a query, it can't be combined with --input-file")
+	case includeQuery:
+		errlog.Fatalln("--include-query needs a query, it can't be combined with --input-file")
+	}
+}
+
+// runInputFile writes out the saved query result at path like the result of a query, with every output and transform flag applied
+// The file may hold a prometheus api query response or the json output of promql, whether it's a vector or a matrix is detected from its samples
+func runInputFile(path string) error {
+	result, err := readInputFile(path)
+	if err != nil {
+		return err
+	}
+	return outputSavedResult(result, path)
+}
+
+// outputSavedResult writes out a query result read from source, like the result of a range query if it's a matrix or an instant query otherwise
+// Flags only supported for the other kind of result fail with an error naming what source holds
+func outputSavedResult(result model.Value, source string) error {
+	switch r := result.(type) {
+	case model.Matrix:
+		if valueOnly {
+			return fmt.Errorf("--value-only isn't supported for range vector results, %s holds a matrix", source)
+		}
+		return outputRangeResult(r, "")
+	default:
+		if o := pql.Output; o == "graph" || o == "heatmap" {
+			return fmt.Errorf("--output %s is only supported for range vector results, %s holds a %s", o, source, result.Type())
+		}
+		if err := checkInstantFlags(); err != nil {
+			return fmt.Errorf("%w, %s holds a %s", err, source, result.Type())
+		}
+		return outputInstantResult(result, "")
+	}
+}
+
+// readInputFile reads the saved query result at path, or from stdin if it's -
+func readInputFile(path string) (model.Value, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading input file: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	result, warnings, err := promql.ReadResult(r)
+	if len(warnings) > 0 {
+		errlog.Printf("Warnings: %v\n", warnings)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading input file %s: %w", path, err)
+	}
+	return result, nil
+}
+
+func init() {
+	rootCmd.Flags().StringVar(&inputFile, "input-file", "", "write out a saved query result from this file (- for stdin) instead of running a query, with every output flag applied. Either a prometheus api query response or promql json output")
+}
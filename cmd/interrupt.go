@@ -0,0 +1,87 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// exitInterrupted is returned when promql is interrupted with Ctrl-C, matching the shell convention of 128+SIGINT
+const exitInterrupted = 130
+
+// interruptCtx is cancelled on the first Ctrl-C (or SIGTERM), aborting queries in flight so prometheus stops evaluating them
+var interruptCtx = context.Background()
+
+// handleInterrupts cancels interruptCtx, the parent context of every query, on Ctrl-C
+// The default handling is restored once it's cancelled, so a second Ctrl-C exits right away e.g. if something doesn't stop in time
+func handleInterrupts() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	interruptCtx = ctx
+	pql.Context = ctx
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+}
+
+// interrupted returns whether promql has been interrupted
+func interrupted() bool {
+	return interruptCtx.Err() != nil
+}
+
+// exitIfInterrupted exits with exitInterrupted if promql has been interrupted, tearing down any --k8s-service port-forward
+// Errors caused by the interruption, e.g. cancelled queries, aren't worth reporting
+func exitIfInterrupted() {
+	if !interrupted() {
+		return
+	}
+	closePortForward()
+	restoreTerminal()
+	os.Exit(exitInterrupted)
+}
+
+// restoreTerminal resets colors and shows the cursor, in case output cut short left them changed
+func restoreTerminal() {
+	if outputIsTerminal() {
+		fmt.Fprint(os.Stdout, "\x1b[0m\x1b[?25h")
+	}
+}
+
+// readLine reads a line of input from stdin, e.g. the answer to a prompt
+// Reads don't return on Ctrl-C, so it exits with exitInterrupted if promql is interrupted while waiting for it
+func readLine() (string, error) {
+	type line struct {
+		s   string
+		err error
+	}
+	read := make(chan line, 1)
+	go func() {
+		s, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		read <- line{s, err}
+	}()
+	select {
+	case l := <-read:
+		return l.s, l.err
+	case <-interruptCtx.Done():
+		exitIfInterrupted()
+		return "", interruptCtx.Err()
+	}
+}
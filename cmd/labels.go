@@ -16,32 +16,66 @@ limitations under the License.
 package cmd
 
 import (
-	"github.com/nalbury/promql-cli/pkg/writer"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
 	"github.com/spf13/cobra"
+
+	"github.com/nalbury/promql-cli/pkg/util"
+	"github.com/nalbury/promql-cli/pkg/writer"
 )
 
+// cmd line args
+var labelsMatches []string
+
 // labelsCmd represents the labels command
 var labelsCmd = &cobra.Command{
 	Use:   "labels [query_string]",
 	Short: "Get a list of all labels for a given query",
-	Long:  `Get a list of all labels for a given query`,
-	Args:  cobra.ExactArgs(1),
+	Long:  `Get a list of all labels for a given query, or of the series matching any of the --match selectors`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		// Labels come from either a query or series selectors
+		if len(labelsMatches) > 0 {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		result, warnings, err := pql.LabelsQuery(query)
+		checkPage()
+		var (
+			result   model.Vector
+			warnings v1.Warnings
+			err      error
+		)
+		if len(labelsMatches) > 0 {
+			result, warnings, err = pql.SeriesLabelsQuery(labelsMatches)
+		} else {
+			result, warnings, err = pql.LabelsQuery(query)
+		}
 		if len(warnings) > 0 {
 			errlog.Printf("Warnings: %v\n", warnings)
 		}
 		if err != nil {
-			errlog.Fatalln(err)
+			fatal(err)
+		}
+		labels, err := util.UniqLabels(result)
+		if err != nil {
+			fatal(err)
+		}
+		if err := checkNotEmpty(len(labels)); err != nil {
+			fatal(err)
 		}
 		// Write out result
-		r := writer.LabelsResult{Vector: result}
-		if err := writer.WriteInstant(&r, pql.Output, pql.NoHeaders); err != nil {
-			errlog.Fatalln(err)
+		r := writer.LabelsResult{Vector: result, Page: page}
+		if err := writer.WriteInstant(stdout, &r, pql.Output, writerOpts); err != nil {
+			fatal(err)
 		}
+		logPage(len(labels))
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(labelsCmd)
+	addPageFlags(labelsCmd, "labels")
+	labelsCmd.Flags().StringArrayVar(&labelsMatches, "match", []string{}, "series selector to get the labels of in place of a query, can be repeated, also accepted as --match[]. Like prometheus's match[] parameter, series matching any of the selectors are included")
+	labelsCmd.Flags().SetNormalizeFunc(normalizeMatchFlag)
 }
@@ -22,18 +22,23 @@ import (
 
 // metaCmd represents the meta command
 var metaCmd = &cobra.Command{
-	Use:   "meta",
-	Short: "Get the type and help metadata for a metric",
-	Long:  "Get the type and help metadata for a metric",
+	Use:     "meta [metric]",
+	Aliases: []string{"metadata"},
+	Short:   "Get the type and help metadata for a metric",
+	Long:    "Get the type and help metadata for a metric, or for all metrics if none is provided",
+	Args:    cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		var r writer.MetaResult
 		result, err := pql.MetaQuery(query)
 		if err != nil {
-			errlog.Fatalln(err)
+			fatal(err)
 		}
 		r = result
-		if err := writer.WriteInstant(&r, pql.Output, pql.NoHeaders); err != nil {
-			errlog.Fatalln(err)
+		if err := checkNotEmpty(len(r)); err != nil {
+			fatal(err)
+		}
+		if err := writer.WriteInstant(stdout, &r, pql.Output, writerOpts); err != nil {
+			fatal(err)
 		}
 	},
 }
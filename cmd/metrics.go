@@ -16,35 +16,58 @@ limitations under the License.
 package cmd
 
 import (
+	"sort"
+
+	"github.com/nalbury/promql-cli/pkg/util"
 	"github.com/nalbury/promql-cli/pkg/writer"
 	"github.com/spf13/cobra"
 )
 
+// cmd line args
+var metricsMatches []string
+
 // metricsCmd represents the metrics command
 var metricsCmd = &cobra.Command{
 	Use:   "metrics [query_string]",
 	Short: "Get a list of prometheus metric names matching the provided query",
 	Long:  `Get a list of prometheus metric names matching the provided query. If no query is provided, all metric names will be returned.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		checkPage()
 		var r writer.SeriesResult
-		if query == "" {
-			query = `{job=~".+"}`
+		matches := metricsMatches
+		if query != "" {
+			matches = append([]string{query}, matches...)
+		}
+		if len(matches) == 0 {
+			matches = []string{`{job=~".+"}`}
 		}
-		result, warnings, err := pql.SeriesQuery(query)
+		result, warnings, err := pql.SeriesQuery(matches)
 		if len(warnings) > 0 {
 			errlog.Printf("Warnings: %v\n", warnings)
 		}
 		if err != nil {
-			errlog.Fatalln(err)
+			fatal(err)
 		}
 		r = result
 		var m writer.MetricsResult = r.Metrics()
-		if err := writer.WriteInstant(&m, pql.Output, pql.NoHeaders); err != nil {
-			errlog.Fatalln(err)
+		total := len(m)
+		if page.Enabled() {
+			sort.Strings(m)
+			m = util.Paginate(m, page)
+		}
+		if err := checkNotEmpty(total); err != nil {
+			fatal(err)
+		}
+		if err := writer.WriteInstant(stdout, &m, pql.Output, writerOpts); err != nil {
+			fatal(err)
 		}
+		logPage(total)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(metricsCmd)
+	addPageFlags(metricsCmd, "metrics")
+	metricsCmd.Flags().StringArrayVar(&metricsMatches, "match", []string{}, "series selector to match, can be repeated, also accepted as --match[]. Like prometheus's match[] parameter, series matching any of the selectors are returned")
+	metricsCmd.Flags().SetNormalizeFunc(normalizeMatchFlag)
 }
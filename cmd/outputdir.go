@@ -0,0 +1,51 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/nalbury/promql-cli/pkg/writer"
+)
+
+// cmd line args
+var outputDir string
+
+// checkOutputDir validates the --output-dir flag, which only writes csv files
+func checkOutputDir() {
+	if outputDir != "" && pql.Output != "" && pql.Output != "csv" {
+		errlog.Fatalln("--output-dir writes a csv file per series, it can only be used with --output csv")
+	}
+}
+
+// writeOutputDir writes each series of a range query result to its own csv file in --output-dir
+func writeOutputDir(m model.Matrix) error {
+	paths, err := writer.WriteSeriesFiles(m, outputDir, pql.NoHeaders)
+	if err != nil {
+		return err
+	}
+	errlog.Printf("Wrote %d series to %s\n", len(paths), outputDir)
+	return nil
+}
+
+// errOutputDirInstant is returned for instant queries with --output-dir, whose series have a single sample each
+var errOutputDirInstant = fmt.Errorf("--output-dir is only supported for range queries")
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&outputDir, "output-dir", "", "write each series of a range query to its own csv file in this directory, named by its labels, instead of to stdout")
+}
@@ -0,0 +1,60 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/nalbury/promql-cli/pkg/util"
+)
+
+// cmd line args
+var outputFile string
+
+// setupOutputFile validates the --output-file flag, and for every format but sqlite opens the file for results to be written to in place of stdout
+// Like a shell redirect, the file is truncated if it exists
+func setupOutputFile() {
+	if outputFile == "" {
+		if pql.Output == "sqlite" {
+			errlog.Fatalln("--output sqlite writes to a database file, set it with --output-file")
+		}
+		return
+	}
+	if outputDir != "" {
+		errlog.Fatalln("please specify either --output-file or --output-dir, not both")
+	}
+	if pql.Output == "sqlite" {
+		checkSQLiteMode()
+		return
+	}
+	f, err := os.Create(outputFile)
+	if err != nil {
+		errlog.Fatalf("error creating output file: %v\n", err)
+	}
+	stdout = pipeWriter{f}
+}
+
+// stdoutIsTerminal returns whether stdout is a terminal, it's a variable so tests can stub it
+var stdoutIsTerminal = func() bool { return util.IsTerminal(os.Stdout) }
+
+// outputIsTerminal returns whether results are written to a terminal, rather than to a pipe or --output-file
+func outputIsTerminal() bool {
+	return outputFile == "" && stdoutIsTerminal()
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&outputFile, "output-file", "", "write results to this file instead of stdout, replacing it if it exists. Required by --output sqlite, as the database to write to")
+}
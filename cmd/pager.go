@@ -0,0 +1,119 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	"github.com/nalbury/promql-cli/pkg/util"
+)
+
+// defaultPager pages results when $PAGER isn't set, -R passes colors through
+const defaultPager = "less -R"
+
+// cmd line args
+var (
+	pageOutput   bool
+	noPageOutput bool
+)
+
+// terminalSize looks up the height results are paged past, it's a variable so tests can stub it
+var terminalSize = util.TerminalSize
+
+// pager holds the results written to a terminal until they're written out by flushPager, nil when they aren't paged
+var pager *pageBuffer
+
+// pageBuffer buffers results in place of stdout, so they can be paged once they're all written
+type pageBuffer struct {
+	bytes.Buffer
+	out io.Writer
+}
+
+// setupPager buffers results written to a terminal for flushPager to page, unless --no-page is set
+// --watch redraws the terminal itself and isn't paged
+func setupPager() {
+	if pageOutput && noPageOutput {
+		errlog.Fatalln("please specify either --page or --no-page, not both")
+	}
+	if noPageOutput || watchInterval != 0 || !outputIsTerminal() {
+		return
+	}
+	pager = &pageBuffer{out: stdout}
+	stdout = pager
+}
+
+// flushPager writes out the buffered results, through $PAGER (less -R by default) if --page is set or they don't fit in the terminal
+// Results are written out directly if the pager can't be started, or promql has been interrupted
+func flushPager() {
+	if pager == nil {
+		return
+	}
+	p := pager
+	pager = nil
+	stdout = p.out
+	if p.Len() == 0 {
+		return
+	}
+	if (pageOutput || !fitsTerminal(p.Bytes())) && !interrupted() {
+		err := runPager(p.Bytes())
+		if err == nil {
+			return
+		}
+		errlog.Printf("Warning: unable to run pager (%v), writing results directly\n", err)
+	}
+	_, _ = stdout.Write(p.Bytes())
+}
+
+// fitsTerminal returns whether b has fewer lines than the terminal is high, leaving a line for the prompt
+// Output is assumed to fit when the size of the terminal can't be determined
+func fitsTerminal(b []byte) bool {
+	dim, err := terminalSize()
+	if err != nil {
+		return true
+	}
+	return bytes.Count(b, []byte("\n")) < dim.Height
+}
+
+// runPager pages b through $PAGER, returning an error if it can't be started
+// Like git, Ctrl-C is left to the pager while it runs, so e.g. less can stop searching without promql exiting
+func runPager(b []byte) error {
+	args := strings.Fields(os.Getenv("PAGER"))
+	if len(args) == 0 {
+		args = strings.Fields(defaultPager)
+	}
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdin = bytes.NewReader(b)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Start(); err != nil {
+		return err
+	}
+	// Only once the pager has started, so Ctrl-C still interrupts promql when results are written out directly
+	signal.Ignore(os.Interrupt)
+	// The pager's exit status is of no interest, e.g. less exits as soon as it's quit
+	_ = c.Wait()
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&pageOutput, "page", false, "page results written to a terminal through $PAGER (default less -R), even if they fit on screen. Results taller than the terminal are paged by default")
+	rootCmd.PersistentFlags().BoolVar(&noPageOutput, "no-page", false, "don't page results written to a terminal that are taller than it")
+}
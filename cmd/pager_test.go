@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nalbury/promql-cli/pkg/util"
+)
+
+// stubPager stubs the terminal and restores the pager's state once t is done
+func stubPager(t *testing.T, terminal bool, height int) {
+	t.Helper()
+	out, isTerminal, size, log := stdout, stdoutIsTerminal, terminalSize, errlog
+	t.Cleanup(func() {
+		stdout, stdoutIsTerminal, terminalSize, errlog = out, isTerminal, size, log
+		pager, pageOutput, noPageOutput, watchInterval = nil, false, false, 0
+	})
+	stdoutIsTerminal = func() bool { return terminal }
+	terminalSize = func() (util.TermDimensions, error) {
+		if height == 0 {
+			return util.TermDimensions{}, errors.New("stty size: exit status 1")
+		}
+		return util.TermDimensions{Height: height, Width: 80}, nil
+	}
+}
+
+func TestSetupPager(t *testing.T) {
+	cases := []struct {
+		terminal bool
+		noPage   bool
+		watch    time.Duration
+		paged    bool
+	}{
+		{terminal: true, paged: true},
+		{terminal: false, paged: false},
+		{terminal: true, noPage: true, paged: false},
+		// --watch redraws the terminal itself
+		{terminal: true, watch: time.Second, paged: false},
+	}
+	for i, c := range cases {
+		stubPager(t, c.terminal, 24)
+		var buf bytes.Buffer
+		stdout = &buf
+		noPageOutput, watchInterval = c.noPage, c.watch
+		setupPager()
+		assert.Equal(t, c.paged, pager != nil, "Unexpected output for case %d", i)
+		if c.paged {
+			assert.Equal(t, pager, stdout, "Unexpected output for case %d", i)
+		} else {
+			assert.Equal(t, &buf, stdout, "Unexpected output for case %d", i)
+		}
+		pager, stdout = nil, &buf
+	}
+
+	// --page and --no-page contradict each other
+	_, stderr, code := runPromql(t, []string{"up", "--page", "--no-page"})
+	assert.Equal(t, exitUsage, code)
+	assert.Equal(t, "please specify either --page or --no-page, not both\n", stderr)
+}
+
+func TestFitsTerminal(t *testing.T) {
+	cases := []struct {
+		height   int
+		lines    int
+		expected bool
+	}{
+		{height: 24, lines: 23, expected: true},
+		// A line is left for the prompt
+		{height: 24, lines: 24, expected: false},
+		{height: 24, lines: 100, expected: false},
+		// Output is assumed to fit when the size of the terminal can't be determined
+		{height: 0, lines: 100, expected: true},
+	}
+	for i, c := range cases {
+		stubPager(t, true, c.height)
+		assert.Equal(t, c.expected, fitsTerminal([]byte(strings.Repeat("up 1\n", c.lines))), "Unexpected output for case %d", i)
+	}
+}
+
+func TestFlushPager(t *testing.T) {
+	t.Setenv("PAGER", "/nonexistent")
+	cases := []struct {
+		page   bool
+		lines  int
+		warned bool
+	}{
+		// Results that fit in the terminal are written out directly
+		{lines: 2},
+		// Results are written out directly when the pager can't be started
+		{lines: 100, warned: true},
+		{page: true, lines: 2, warned: true},
+	}
+	for i, c := range cases {
+		stubPager(t, true, 24)
+		var out, warnings bytes.Buffer
+		stdout = &out
+		errlog = errorLogger{log.New(&warnings, "", 0)}
+		pageOutput = c.page
+		setupPager()
+		results := strings.Repeat("up 1\n", c.lines)
+		_, err := io.WriteString(stdout, results)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Empty(t, out.String(), "Unexpected output for case %d", i)
+		flushPager()
+		assert.Equal(t, results, out.String(), "Unexpected output for case %d", i)
+		assert.Equal(t, c.warned, strings.HasPrefix(warnings.String(), "Warning: unable to run pager ("), "Unexpected output for case %d, %s", i, warnings.String())
+		assert.Equal(t, &out, stdout, "Unexpected output for case %d", i)
+	}
+}
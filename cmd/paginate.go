@@ -0,0 +1,45 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/nalbury/promql-cli/pkg/util"
+)
+
+// cmd line args
+var page util.Page
+
+// addPageFlags adds the --limit and --offset flags to a command that lists names
+func addPageFlags(cmd *cobra.Command, name string) {
+	cmd.Flags().IntVar(&page.Limit, "limit", 0, "write at most this many "+name+" of the sorted listing (0 for no limit)")
+	cmd.Flags().IntVar(&page.Offset, "offset", 0, "skip this many "+name+" of the sorted listing before writing them")
+}
+
+// checkPage validates the --limit and --offset flags
+func checkPage() {
+	if page.Limit < 0 || page.Offset < 0 {
+		errlog.Fatalln("--limit and --offset must be positive numbers")
+	}
+}
+
+// logPage writes which items of a listing of total items were written to stderr, e.g. "showing 1-100 of 50234", when it's paginated
+func logPage(total int) {
+	if page.Enabled() {
+		errlog.Println(page.Footer(total))
+	}
+}
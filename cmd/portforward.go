@@ -0,0 +1,82 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/viper"
+
+	"github.com/nalbury/promql-cli/pkg/kube"
+)
+
+// portForward is the port-forward established for --k8s-service, torn down on exit
+var portForward *kube.Forward
+
+// setupPortForward port-forwards to --k8s-service if set, and points the client at the local end of the forward
+// The forward is closed by closePortForward on exit, including when promql is interrupted
+func setupPortForward() {
+	target := viper.GetString("k8s-service")
+	if target == "" {
+		return
+	}
+	if viper.IsSet("host") || viper.GetString("gcp-project") != "" || len(viper.GetStringSlice("hosts")) > 0 {
+		errlog.Fatalln("please specify either --k8s-service or --host, --hosts, or --gcp-project, not both")
+	}
+	if viper.GetString("proxy") != "" {
+		errlog.Fatalln("--proxy can't be used with --k8s-service, the forwarded port is local")
+	}
+	if viper.GetString("unix-socket") != "" {
+		errlog.Fatalln("--unix-socket can't be used with --k8s-service, requests are sent to the forwarded port")
+	}
+	svc, err := kube.ParseService(target)
+	if err != nil {
+		errlog.Fatalln(err)
+	}
+	portForward, err = kube.PortForward(svc, kube.Options{
+		Kubeconfig: viper.GetString("k8s-kubeconfig"),
+		Context:    viper.GetString("k8s-context"),
+	}, os.Stderr)
+	if err != nil {
+		errlog.Fatalln(err)
+	}
+	pql.Host = portForward.URL
+}
+
+// closePortForward tears down the --k8s-service port-forward, if any
+func closePortForward() {
+	if portForward != nil {
+		portForward.Close()
+	}
+}
+
+// portForwardError adds the reason the port-forward failed to err, as requests over a broken forward only see their connection closed
+func portForwardError(err error) error {
+	if portForward == nil || portForward.Err() == nil {
+		return err
+	}
+	return fmt.Errorf("%w (port-forward to %s: %v)", err, portForward.Pod, portForward.Err())
+}
+
+func init() {
+	rootCmd.PersistentFlags().String("k8s-service", "", "kubernetes service to query prometheus through a port-forward to, as [namespace/]name:port e.g. monitoring/prometheus-operated:9090. The namespace defaults to the kubeconfig context's")
+	bindFlag("k8s-service")
+	rootCmd.PersistentFlags().String("k8s-context", "", "kubeconfig context to use for --k8s-service (default the kubeconfig's current context)")
+	bindFlag("k8s-context")
+	rootCmd.PersistentFlags().String("k8s-kubeconfig", "", "kubeconfig file to use for --k8s-service (default $KUBECONFIG or $HOME/.kube/config)")
+	bindFlag("k8s-kubeconfig")
+}
@@ -0,0 +1,92 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/common/model"
+	"github.com/spf13/cobra"
+
+	"github.com/nalbury/promql-cli/pkg/promql"
+)
+
+// cmd line args
+var (
+	pushGateway        string
+	pushJob            string
+	pushGroupingLabels []string
+	pushMetricName     string
+)
+
+// pushCmd represents the push command
+var pushCmd = &cobra.Command{
+	Use:   "push [query_string]",
+	Short: "Push the result of an instant query to a pushgateway as gauges",
+	Long: `Run an instant query and push each sample of its result to a pushgateway as a gauge, e.g. to publish values derived from other metrics for batch jobs.
+
+Samples are pushed under --job, grouped by the values of their --grouping-label labels, which together with the job make up the grouping key of each push.
+Each push replaces the metrics previously pushed with the same grouping key. The other labels of each sample become the labels of its gauge, except job, which the pushgateway sets to --job.
+Samples missing a grouping label, and samples that would be pushed as the same gauge, are errors, and nothing is pushed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		labels := make([]model.LabelName, 0, len(pushGroupingLabels))
+		for _, l := range pushGroupingLabels {
+			labels = append(labels, model.LabelName(l))
+		}
+		result, warnings, err := pql.InstantQuery(query)
+		if len(warnings) > 0 {
+			errlog.Printf("Warnings: %v\n", warnings)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		t, err := transformResult(result)
+		if err != nil {
+			fatal(err)
+		}
+		v, ok := t.(model.Vector)
+		if !ok {
+			fatal(fmt.Errorf("only instant vector results can be pushed, the query returned a %s", t.Type()))
+		}
+		if err := checkNotEmpty(len(v)); err != nil {
+			fatal(err)
+		}
+		groups, err := promql.GroupPushSamples(v, labels, pushMetricName)
+		if err != nil {
+			fatal(err)
+		}
+		client := &http.Client{Timeout: pql.TimeoutDuration}
+		if err := promql.Push(interruptCtx, client, pushGateway, pushJob, groups); err != nil {
+			fatal(err)
+		}
+		errlog.Printf("Pushed %d samples in %d groups to %s\n", len(v), len(groups), pushGateway)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pushCmd)
+	pushCmd.Flags().StringVar(&pushGateway, "gateway", "", "url of the pushgateway to push to e.g. http://pushgateway:9091")
+	pushCmd.Flags().StringVar(&pushJob, "job", "", "job to push the samples under, the first label of their grouping key")
+	pushCmd.Flags().StringSliceVar(&pushGroupingLabels, "grouping-label", []string{}, "comma separated list of labels of the result whose values are added to the grouping key of each push, rather than pushed as labels of the gauges e.g. instance. Can be repeated")
+	pushCmd.Flags().StringVar(&pushMetricName, "metric-name", "", "metric name of samples without one, e.g. of the result of sum(...). Samples without a name are an error if it's not set")
+	for _, f := range []string{"gateway", "job"} {
+		if err := pushCmd.MarkFlagRequired(f); err != nil {
+			errlog.Fatalln(err)
+		}
+	}
+}
@@ -16,23 +16,28 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 
 	"github.com/nalbury/promql-cli/pkg/promql"
 	"github.com/nalbury/promql-cli/pkg/writer"
 )
 
 // global error logger
-var errlog = log.New(os.Stderr, "", 0)
+var errlog = errorLogger{log.New(os.Stderr, "", 0)}
 
 // cmd line args
 var (
@@ -42,33 +47,123 @@ var (
 	timeout int
 	// timeStr is a placeholder for the inital "time" flag value. We parse it to a time.Time for use in our queries
 	timeStr string
+	// templateString and templateFile are the raw values of the output template flags. We parse them into writerOpts.Template
+	templateString string
+	templateFile   string
+	// writerOpts are the options passed to our writers when writing out results
+	writerOpts writer.Options
+	// valueOnly writes out just the value of a single sample instant query result
+	valueOnly bool
+	// countOnly writes out just the number of series in the result
+	countOnly bool
+	// distinctLabel writes out just the distinct values of the label across the series of the result
+	distinctLabel string
+	// withMetadata writes out the type and help metadata of each series' metric along with instant query results
+	withMetadata bool
+	// wide is the value of the --wide flag, which rules out truncating label cells
+	wide bool
+	// colorThreshold is the raw value of the --color-threshold flag, we parse it into writerOpts.Table.Colors
+	colorThreshold string
+	noColor        bool
+	// verbosity is the number of times the --verbose flag was given
+	verbosity int
+	// queryFile is the path to a file of queries to run in place of a query argument
+	queryFile string
+	// since is a shortcut for a range query from now-since until now
+	since time.Duration
+	// queryVars are the raw key=value values of the --var flag, we parse them into vars
+	queryVars []string
+	vars      map[string]string
+	// macros are the definitions from the --macros file, expanded in queries before their variables are substituted
+	macros promql.Macros
 )
 
+// version is the promql-cli release, also sent in the default User-Agent
+const version = "v0.2.1"
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Version: "v0.2.1",
+	Version: version,
 	Use:     "promql [query_string]",
 	Short:   "Query prometheus from the command line",
 	Long:    `Query prometheus from the command line for quick analysis.`,
-	Args:    cobra.ExactArgs(1),
+	Args: func(cmd *cobra.Command, args []string) error {
+		// Queries come from either the command line or a query file, and aren't needed to write out a saved result
+		if queryFile != "" || inputFile != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		checkErrorFormat()
 		pql.Auth.Type = viper.GetString("auth-type")
 		pql.Auth.Credentials = config.Secret(viper.GetString("auth-credentials"))
 		pql.Auth.CredentialsFile = viper.GetString("auth-credentials-file")
+		httpAuth, err := parseHTTPAuth()
+		if err != nil {
+			errlog.Fatalln(err)
+		}
+		pql.ClientOptions.HTTPAuth = httpAuth
+		pql.ClientOptions.Tenant = viper.GetString("tenant")
+		pql.ClientOptions.SigV4 = promql.SigV4{
+			Region:  viper.GetString("sigv4-region"),
+			Profile: viper.GetString("sigv4-profile"),
+			RoleARN: viper.GetString("sigv4-role-arn"),
+		}
 		pql.TLSConfig = config.TLSConfig{
-			CAFile:             viper.GetString("tls_config.ca_cert_file"),
-			CertFile:           viper.GetString("tls_config.cert_file"),
-			KeyFile:            viper.GetString("tls_config.key_file"),
-			ServerName:         viper.GetString("tls_config.servername"),
-			InsecureSkipVerify: viper.GetBool("tls_config.insecure_skip_verify"),
+			CAFile:             firstString("ca-cert", "tls_config.ca_cert_file"),
+			CertFile:           firstString("client-cert", "tls_config.cert_file"),
+			KeyFile:            firstString("client-key", "tls_config.key_file"),
+			ServerName:         firstString("server-name", "tls_config.servername"),
+			InsecureSkipVerify: viper.GetBool("insecure-skip-verify") || viper.GetBool("tls_config.insecure_skip_verify"),
+		}
+		if pql.TLSConfig.InsecureSkipVerify {
+			errlog.Println("Warning: TLS certificate verification is disabled, connections to prometheus can be intercepted")
 		}
 
 		pql.Host = viper.GetString("host")
+		if project := viper.GetString("gcp-project"); project != "" {
+			if viper.IsSet("host") {
+				errlog.Fatalln("please specify either --host or --gcp-project, not both")
+			}
+			pql.Host = promql.GCPPrometheusURL(project)
+		}
+		pql.ClientOptions.GCPAuth = viper.GetBool("gcp-auth")
+		if proxy := viper.GetString("proxy"); proxy != "" {
+			u, err := promql.ParseProxyURL(proxy)
+			if err != nil {
+				errlog.Fatalln(err)
+			}
+			pql.ClientOptions.Proxy = u
+		}
+		if hosts := viper.GetStringSlice("hosts"); len(hosts) > 0 {
+			if viper.GetString("unix-socket") != "" {
+				errlog.Fatalln("please specify either --hosts or --unix-socket, not both")
+			}
+			targets, err := promql.ParseHosts(hosts)
+			if err != nil {
+				errlog.Fatalln(err)
+			}
+			pql.Hosts = targets
+		}
+		setupPortForward()
+		applySince()
 		pql.Step = viper.GetString("step")
 		pql.Output = viper.GetString("output")
+		checkOutputDir()
+		setupOutputFile()
+		setupPager()
 		// Convert our timeout flag into a time.Duration
 		timeout = viper.GetInt("timeout")
 		pql.TimeoutDuration = time.Duration(int64(timeout)) * time.Second
+		// Write timestamps in the --tz timezone, by making it the local one
+		if tz := viper.GetString("tz"); tz != "" {
+			loc, err := time.LoadLocation(tz)
+			if err != nil {
+				errlog.Fatalf("invalid --tz %q: %v\n", tz, err)
+			}
+			time.Local = loc
+		}
 		// Parse the timeStr from our --time flag if it was provided
 		pql.Time = time.Now()
 		if timeStr != "now" {
@@ -78,55 +173,407 @@ var rootCmd = &cobra.Command{
 			}
 			pql.Time = t
 		}
-		// Create and set client interface
-		cl, err := promql.CreateClientWithAuth(pql.Host, pql.Auth, pql.TLSConfig)
+		if err := parseAssertions(); err != nil {
+			errlog.Fatalln(err)
+		}
+		// Set up our writer options, parsing the output template if one was provided
+		writerOpts.NoHeaders = pql.NoHeaders
+		writerOpts.Graph.Style = viper.GetString("graph-style")
+		if wide && (writerOpts.Table.MaxColWidth > 0 || writerOpts.Table.Wrap) {
+			errlog.Fatalln("--wide writes every label in full, it can't be combined with --max-col-width or --wrap")
+		}
+		if writerOpts.Table.Wrap && writerOpts.Table.MaxColWidth <= 0 {
+			errlog.Fatalln("--wrap requires a --max-col-width to wrap at")
+		}
+		if countOnly && distinctLabel != "" {
+			errlog.Fatalln("please specify either --count-only or --distinct, not both")
+		}
+		if writerOpts.CSVPivot && rangeOutput() != "csv" {
+			errlog.Fatalln("--csv-pivot is only supported for range queries written as csv")
+		}
+		if writerOpts.JSONFlat && pql.Output != "json" {
+			errlog.Fatalln("--json-flat is only supported with --output json")
+		}
+		if writerOpts.Table.RepeatHeader < 0 {
+			errlog.Fatalln("--repeat-header must be a positive number of rows")
+		}
+		if writer.ScientificExponent <= 0 {
+			errlog.Fatalln("--scientific-exponent must be a positive exponent")
+		}
+		if err := writer.SetTimePrecision(viper.GetString("time-precision")); err != nil {
+			errlog.Fatalln(err)
+		}
+		if colorThreshold != "" {
+			thresholds, err := writer.ParseColorThresholds(colorThreshold)
+			if err != nil {
+				errlog.Fatalln(err)
+			}
+			if colorsEnabled() {
+				writerOpts.Table.Colors = thresholds
+			}
+		}
+		if templateString != "" && templateFile != "" {
+			errlog.Fatalln("please specify either --template-string or --template-file, not both")
+		}
+		if templateFile != "" {
+			b, err := os.ReadFile(templateFile)
+			if err != nil {
+				errlog.Fatalf("error reading template file: %v\n", err)
+			}
+			templateString = string(b)
+		}
+		if templateString != "" {
+			t, err := writer.NewTemplate(templateString)
+			if err != nil {
+				errlog.Fatalln(err)
+			}
+			writerOpts.Template = t
+			// A template implies the template output format
+			if pql.Output == "" {
+				pql.Output = "template"
+			}
+		}
+		// Create and set client interfaces
+		pql.ClientOptions.Retries = viper.GetInt("retries")
+		if pql.ClientOptions.Method, err = promql.ParseMethod(viper.GetString("http-method")); err != nil {
+			errlog.Fatalln(err)
+		}
+		pql.ClientOptions.MaxURLLength = viper.GetInt("max-url-length")
+		pql.ClientOptions.UnixSocket = viper.GetString("unix-socket")
+		pql.ClientOptions.NoCompress = viper.GetBool("no-compress")
+		pql.ClientOptions.UserAgent = viper.GetString("user-agent")
+		if pql.ClientOptions.UserAgent == "" {
+			pql.ClientOptions.UserAgent = "promql-cli/" + strings.TrimPrefix(version, "v")
+		}
+		if header := viper.GetString("request-id-header"); header != "" {
+			pql.ClientOptions.RequestIDs = &promql.RequestIDs{Header: header}
+		}
+		if qps := viper.GetFloat64("max-qps"); qps > 0 {
+			pql.ClientOptions.RateLimiter = promql.NewRateLimiter(qps, errlog.Logger)
+		} else if qps < 0 {
+			errlog.Fatalln("--max-qps must be positive")
+		}
+		pql.ClientOptions.Logger = errlog.Logger
+		pql.ClientOptions.Verbosity = verbosity
+		setupDryRun()
+		setupTiming()
+		pql.ClientOptions.QueryParams = promql.ThanosParams(optionalBool("thanos-dedup"), optionalBool("thanos-partial-response"))
+		cl, err := promql.CreateAPIClientWithOptions(pql.Host, pql.Auth, pql.TLSConfig, pql.ClientOptions)
 		if err != nil {
 			errlog.Fatalln(err)
 		}
-		pql.Client = cl
+		pql.APIClient = cl
+		pql.Client = v1.NewAPI(cl)
 
-		// Set query string if present
+		// Set query string if present, substituting any variables
 		// Downstream consumption of the query variable should handle any validation they need
-		if len(args) > 0 {
-			query = args[0]
+		vars, err = promql.ParseParams(queryVars)
+		if err != nil {
+			errlog.Fatalln(err)
 		}
-	},
-	Run: func(cmd *cobra.Command, args []string) {
-		// If we have a start time for the query, assume we're doing a range query
-		if pql.Start != "" {
-			result, warnings, err := pql.RangeQuery(query)
-			if len(warnings) > 0 {
-				errlog.Printf("Warnings: %v\n", warnings)
+		if path := viper.GetString("macros"); path != "" {
+			if macros, err = readMacros(path); err != nil {
+				errlog.Fatalln(err)
 			}
+		}
+		if len(args) > 0 {
+			query, err = expandQuery(args[0])
 			if err != nil {
 				errlog.Fatalln(err)
 			}
-			r := writer.RangeResult{Matrix: result}
-			if err := writer.WriteRange(&r, pql.Output, pql.NoHeaders); err != nil {
-				errlog.Println(err)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if inputFile != "" {
+			checkInputFile()
+			if err := runInputFile(inputFile); err != nil {
+				fatal(err)
 			}
-		} else {
-			// Run query
-			result, warnings, err := pql.InstantQuery(query)
-			if len(warnings) > 0 {
-				errlog.Printf("Warnings: %v\n", warnings)
+			return
+		}
+		if err := snapStep(); err != nil {
+			fatal(err)
+		}
+		// There's nothing to watch in dry run mode either
+		if watchInterval != 0 && dryRunner == nil {
+			if err := runWatch(query); err != nil {
+				fatal(err)
 			}
-			if err != nil {
-				errlog.Fatalln(err)
+			return
+		}
+		if queryFile != "" {
+			if err := runQueryFile(queryFile); err != nil {
+				fatal(err)
 			}
-			// Write out result
-			r := writer.InstantResult{Vector: result}
-			if err := writer.WriteInstant(&r, pql.Output, pql.NoHeaders); err != nil {
-				errlog.Fatalln(err)
+			return
+		}
+		// There's nothing to wait for in dry run mode, the query is only written out once
+		if waitFor != "" && dryRunner == nil {
+			if err := runWait(query); err != nil {
+				fatal(err)
 			}
+			return
+		}
+		if err := runQuery(query); err != nil {
+			fatal(err)
 		}
 	},
 }
 
+// runQuery runs a single instant or range query and writes out the result
+func runQuery(query string) error {
+	// If we have a start time for the query, assume we're doing a range query
+	if pql.Start != "" {
+		if valueOnly {
+			return fmt.Errorf("--value-only is only supported for instant queries")
+		}
+		startTiming()
+		result, warnings, err := pql.RangeQuery(query)
+		logTiming(result)
+		if len(warnings) > 0 {
+			errlog.Printf("Warnings: %v\n", warnings)
+		}
+		if err != nil {
+			return err
+		}
+		return outputRangeResult(result, query)
+	}
+	if err := checkInstantFlags(); err != nil {
+		return err
+	}
+	// Run query
+	startTiming()
+	result, warnings, err := pql.InstantQuery(query)
+	logTiming(result)
+	if len(warnings) > 0 {
+		errlog.Printf("Warnings: %v\n", warnings)
+	}
+	if err != nil {
+		return err
+	}
+	return outputInstantResult(result, query)
+}
+
+// checkInstantFlags returns an error if any flags only supported for range query results are set
+func checkInstantFlags() error {
+	if outputDir != "" {
+		return errOutputDirInstant
+	}
+	if resample != "" {
+		return fmt.Errorf("--resample is only supported for range queries")
+	}
+	if headSamples != 0 || tailSamples != 0 {
+		return fmt.Errorf("--head and --tail are only supported for range queries")
+	}
+	if writerOpts.CSVPivot {
+		return fmt.Errorf("--csv-pivot is only supported for range queries")
+	}
+	return nil
+}
+
+// outputRangeResult transforms and writes out the result of a range query, then checks any assertions against it
+func outputRangeResult(result model.Matrix, query string) error {
+	t, err := transformResult(result)
+	if err != nil {
+		return err
+	}
+	if t, err = includeQueryLabel(t, query); err != nil {
+		return err
+	}
+	t = sortResult(t, query)
+	if countOnly {
+		return writeCount(t)
+	}
+	if distinctLabel != "" {
+		return writeDistinct(t)
+	}
+	m, err := fillRangeGaps(t.(model.Matrix))
+	if err != nil {
+		return err
+	}
+	if m, err = resampleRange(m); err != nil {
+		return err
+	}
+	if m, err = windowRange(m); err != nil {
+		return err
+	}
+	// Check for empty results before writing e.g. a csv header
+	if err := checkNotEmpty(len(m)); err != nil {
+		return err
+	}
+	if outputDir != "" {
+		if err := writeOutputDir(m); err != nil {
+			return err
+		}
+		return checkAssertions(t)
+	}
+	if pql.Output == "sqlite" {
+		if err := writeSQLite(m); err != nil {
+			return err
+		}
+		return checkAssertions(t)
+	}
+	if err := writer.Write(m, rangeOutput(), writerOpts, stdout); err != nil {
+		return err
+	}
+	return checkAssertions(t)
+}
+
+// outputInstantResult transforms and writes out the result of an instant query, then checks any assertions against it
+func outputInstantResult(result model.Value, query string) error {
+	t, err := transformResult(result)
+	if err != nil {
+		return err
+	}
+	if t, err = includeQueryLabel(t, query); err != nil {
+		return err
+	}
+	t = sortResult(t, query)
+	// Write out result
+	if countOnly {
+		return writeCount(t)
+	}
+	if distinctLabel != "" {
+		return writeDistinct(t)
+	}
+	// Check for empty results before writing e.g. a table header, and before --value-only fails on them to exit with the empty result code
+	series, _ := resultSize(t)
+	if err := checkNotEmpty(series); err != nil {
+		return err
+	}
+	if err := writeInstantResult(t); err != nil {
+		return err
+	}
+	return checkAssertions(t)
+}
+
+// writeInstantResult writes out the result of an instant query, which may be a vector, a matrix (e.g. for a range selector like up[5m]), a scalar, or a string
+func writeInstantResult(result model.Value) error {
+	if pql.Output == "sqlite" && !valueOnly {
+		return writeSQLite(result)
+	}
+	if valueOnly {
+		return writeValue(result)
+	}
+	switch v := result.(type) {
+	case model.Vector:
+		if withMetadata {
+			return writeWithMetadata(v)
+		}
+	case model.Matrix:
+		return writer.Write(v, rangeOutput(), writerOpts, stdout)
+	}
+	return writer.Write(result, pql.Output, writerOpts, stdout)
+}
+
+// writeValue writes out only the value of an instant query result, for --value-only
+func writeValue(result model.Value) error {
+	switch v := result.(type) {
+	case model.Vector:
+		return writer.WriteValue(stdout, &writer.InstantResult{Vector: v})
+	case model.Matrix:
+		return fmt.Errorf("--value-only isn't supported for range vector results")
+	case *model.Scalar:
+		return writer.WriteValue(stdout, &writer.ScalarResult{Scalar: v})
+	case *model.String:
+		return writer.WriteValue(stdout, &writer.StringResult{String: v})
+	default:
+		return fmt.Errorf("unable to write result: unknown query result type: %T", v)
+	}
+}
+
+// applySince sets up the range query over the last --since, from the relative start of its duration until now
+func applySince() {
+	if since < 0 {
+		errlog.Fatalln("--since must be a positive duration")
+	}
+	if since > 0 {
+		pql.Start = since.String()
+		pql.End = "now"
+	}
+}
+
+// colorsEnabled returns true if colors should be written
+// Only write colors when they'll be displayed, following https://no-color.org
+func colorsEnabled() bool {
+	return !noColor && os.Getenv("NO_COLOR") == "" && outputIsTerminal()
+}
+
+// rangeOutput returns the output format of range vector results
+// Graphs are only useful in a terminal, so if stdout isn't one (e.g. it's piped or --output-file is set) results default to csv instead, as they do with --output-dir
+func rangeOutput() string {
+	if pql.Output == "" && (outputDir != "" || !outputIsTerminal()) {
+		return "csv"
+	}
+	return pql.Output
+}
+
+// writeWithMetadata writes out an instant query result along with the type and help metadata of its metrics
+func writeWithMetadata(v model.Vector) error {
+	var names []string
+	seen := map[model.LabelValue]struct{}{}
+	for _, s := range v {
+		name, ok := s.Metric[model.MetricNameLabel]
+		if _, dup := seen[name]; !ok || dup {
+			continue
+		}
+		seen[name] = struct{}{}
+		names = append(names, string(name))
+	}
+	meta, err := pql.MetricsMetadataQuery(names)
+	if err != nil {
+		return err
+	}
+	r := writer.MetadataResult{InstantResult: writer.InstantResult{Vector: v}, Metadata: meta}
+	return writer.WriteInstant(stdout, &r, pql.Output, writerOpts)
+}
+
+// writeCount writes out the number of series in a query result, then checks any assertions against it
+func writeCount(result model.Value) error {
+	c, err := writer.Count(result)
+	if err != nil {
+		return err
+	}
+	if err := writer.WriteCount(stdout, c, pql.Output); err != nil {
+		return err
+	}
+	return checkAssertions(result)
+}
+
+// writeDistinct writes out the distinct values of the --distinct label across the series of a query result, then checks any assertions against it
+func writeDistinct(result model.Value) error {
+	values, err := writer.Distinct(result, model.LabelName(distinctLabel))
+	if err != nil {
+		return err
+	}
+	if err := writer.WriteDistinct(stdout, values, pql.Output); err != nil {
+		return err
+	}
+	return checkAssertions(result)
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// Have writes to a closed stdout fail with EPIPE rather than killing us, so stdout can exit cleanly
+	signal.Ignore(syscall.SIGPIPE)
+	handleInterrupts()
+	// Errors are reported by errlog, so they follow --error-format
+	rootCmd.SilenceErrors = true
+	rootCmd.SilenceUsage = true
+	cmd, err := rootCmd.ExecuteC()
+	flushPager()
+	exitIfInterrupted()
+	closePortForward()
+	if err != nil {
+		if !cmd.Flags().Changed("error-format") {
+			errorFormat = errorFormatArg(os.Args[1:], errorFormat)
+		}
+		// Like cobra, usage errors e.g. an unknown flag are followed by the usage of the command
+		if errorFormat != errorFormatJSON {
+			cmd.PrintErrln("Error:", err.Error())
+			cmd.PrintErrln(cmd.UsageString())
+		}
 		errlog.Fatalln(err)
 	}
 }
@@ -135,63 +582,182 @@ func init() {
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVar(&pql.CfgFile, "config", "", "config file location (default $HOME/.promql-cli.yaml)")
-	rootCmd.PersistentFlags().String("host", "http://0.0.0.0:9090", "prometheus server url")
-	if err := viper.BindPFlag("host", rootCmd.PersistentFlags().Lookup("host")); err != nil {
-		errlog.Fatalln(err)
-	}
+	rootCmd.PersistentFlags().String("context", "", "named context from the contexts file (default $HOME/.config/promql-cli/config.yaml) to use, overrides the file's current-context. Flags override context values")
+	bindFlag("context")
+	rootCmd.PersistentFlags().String("host", "http://0.0.0.0:9090", "prometheus server url. Replicas to fail over between on connection errors and 5xx responses can be given as a comma separated list, or as a DNS SRV name e.g. dnssrv+_prometheus._tcp.example.com")
+	bindFlag("host")
+	rootCmd.PersistentFlags().StringSlice("hosts", []string{}, "comma separated list of prometheus server urls (optionally named, e.g. us=https://us.prom,eu=https://eu.prom) to query concurrently and merge the results of, overrides --host")
+	bindFlag("hosts")
+	rootCmd.PersistentFlags().StringVar(&pql.HostLabel, "host-label", promql.DefaultHostLabel, "label added to every series identifying the host it came from when querying multiple hosts")
+	rootCmd.PersistentFlags().BoolVar(&pql.RequireAllHosts, "require-all", false, "fail instead of writing partial results when any of multiple hosts fails")
 	rootCmd.PersistentFlags().String("step", "1m", "results step duration (h,m,s e.g. 1m)")
-	if err := viper.BindPFlag("step", rootCmd.PersistentFlags().Lookup("step")); err != nil {
-		errlog.Fatalln(err)
-	}
+	bindFlag("step")
+	rootCmd.Flags().StringVar(&queryFile, "query-file", "", "path to a file of queries to run instead of a query argument. Queries are separated by blank lines and preceded by optional # comments, used as the header of each result")
 	rootCmd.PersistentFlags().StringVar(&pql.Start, "start", "", "query range start duration (either as a lookback in h,m,s e.g. 1m, or as an ISO 8601 formatted date string). Required for range queries")
 	rootCmd.PersistentFlags().StringVar(&pql.End, "end", "now", "query range end (either 'now', or an ISO 8601 formatted date string)")
+	rootCmd.PersistentFlags().DurationVar(&since, "since", 0, "run a range query over the last duration (h,m,s e.g. 6h) until now, shortcut for --start DURATION --end now")
+	rootCmd.MarkFlagsMutuallyExclusive("since", "start")
+	rootCmd.MarkFlagsMutuallyExclusive("since", "end")
+	rootCmd.PersistentFlags().DurationVar(&pql.Chunk, "chunk", 0, "split range queries into sequential sub-range queries of this duration (h,m,s e.g. 24h) to stay under server limits")
+	rootCmd.PersistentFlags().IntVar(&pql.ChunkParallel, "chunk-parallel", 1, "number of chunked sub-range queries to run in parallel")
+	rootCmd.PersistentFlags().StringArrayVar(&queryVars, "var", []string{}, "value for a $var or ${var} variable in the query as key=value e.g. --var instance=web-1, can be repeated. Values are escaped inside quotes")
+	rootCmd.PersistentFlags().String("macros", "", "file of name = expr macro definitions, referenced in queries as $name or ${name} and expanded before the query is sent. Macros may reference other macros")
+	bindFlag("macros")
 	rootCmd.PersistentFlags().StringVar(&timeStr, "time", "now", "time for instant queries (either 'now', or an ISO 8601 formatted date string)")
-	rootCmd.PersistentFlags().String("output", "", "override the default output format (graph for range queries, table for instant queries and metric names). Options: json,csv")
-	if err := viper.BindPFlag("output", rootCmd.PersistentFlags().Lookup("output")); err != nil {
-		errlog.Fatalln(err)
-	}
+	rootCmd.PersistentFlags().String("output", "", "override the default output format (graph for range queries, or csv when stdout isn't a terminal, and table for instant queries and metric names). Options: json,csv,template,graph,heatmap (range queries of histogram buckets),sqlite (see --output-file)")
+	bindFlag("output")
+	rootCmd.PersistentFlags().StringVar(&writerOpts.CSVQuote, "csv-quote", writer.CSVQuoteMinimal, "when to quote csv fields. Options: minimal (only fields that need it),always (every field)")
+	rootCmd.PersistentFlags().IntVar(&writer.ScientificExponent, "scientific-exponent", writer.DefaultScientificExponent, "write table and csv values of at least 10^N, or below 10^-N, in scientific notation e.g. 1.5e+22. Other values are written as plain decimals with full precision, json values are always written as returned")
+	rootCmd.PersistentFlags().BoolVar(&writer.JSONStringValues, "json-string-values", false, "write the values of promql diff json output as strings like sample values, so consumers parsing numbers as doubles don't round them and NaN and infinities can be written. Query result values are always strings, except with --json-flat, where it applies too")
+	rootCmd.PersistentFlags().BoolVar(&writerOpts.CSVPivot, "csv-pivot", false, "write range query csv output with a timestamp column and a column per series headed by its labels, and a row per timestamp. Cells of series without a sample at a timestamp are left empty")
+	rootCmd.PersistentFlags().BoolVar(&writerOpts.JSONFlat, "json-flat", false, "write instant and range query json output as an object per sample with its labels as top level fields, and its value and timestamp as __value__ and __timestamp__. Labels with the same names are prefixed with label_")
+	rootCmd.PersistentFlags().StringVar(&writerOpts.TimeBuckets, "time-buckets", "", "add csv columns bucketing each sample by its timestamp in the --tz timezone, e.g. for spreadsheet pivot tables. Options: hour (date,hour columns),day (date column),week (ISO week column e.g. 2020-W39)")
+	rootCmd.PersistentFlags().String("tz", "", "timezone timestamps are written in, e.g. UTC or Europe/Paris. Defaults to the local timezone")
+	bindFlag("tz")
+	rootCmd.PersistentFlags().String("time-precision", writer.TimePrecisionSeconds, "precision of table and csv timestamps and graph captions, e.g. ms to tell apart samples less than a second apart. Options: s,ms,ns")
+	bindFlag("time-precision")
+	rootCmd.PersistentFlags().StringVar(&templateString, "template-string", "", "go text/template used to write results with the template output format")
+	rootCmd.PersistentFlags().StringVar(&templateFile, "template-file", "", "path to a go text/template file used to write results with the template output format")
+	rootCmd.PersistentFlags().BoolVar(&valueOnly, "value-only", false, "print only the value of an instant query result for use in scripts, errors unless the result is a single series")
+	rootCmd.PersistentFlags().BoolVar(&withMetadata, "with-metadata", false, "write the type and help text of each series' metric from the metadata api alongside instant query results")
+	rootCmd.PersistentFlags().StringVar(&distinctLabel, "distinct", "", "print only the sorted distinct values of the given label across the series of the result, one per line or as an array with --output json. Series without the label are skipped")
+	rootCmd.PersistentFlags().BoolVar(&countOnly, "count-only", false, "print only the number of series in the result, as {\"count\":N} with --output json")
+	rootCmd.MarkFlagsMutuallyExclusive("count-only", "value-only")
+	rootCmd.PersistentFlags().String("graph-style", writer.GraphStyleLine, "style of range query graphs. Options: line,ascii (line graph without unicode box drawing characters),scatter (a marker per sample, positioned by timestamp)")
+	bindFlag("graph-style")
+	rootCmd.PersistentFlags().BoolVar(&writerOpts.Graph.Normalize, "graph-normalize", false, "scale each series of range query graphs to 0..1 by its own min and max, noting its real range in its header, to compare the shapes of series of very different magnitudes")
+	rootCmd.PersistentFlags().BoolVar(&writerOpts.Graph.Separator, "graph-separator", false, "draw a horizontal rule the width of the terminal between the graphs of each series of range queries, to tell them apart when scrolling through many")
+	rootCmd.PersistentFlags().BoolVar(&writerOpts.Graph.TimeAxis, "absolute-time-axis", false, "label the x axis of range query graphs with the times of a few evenly spaced samples, to tell when a spike happened")
+	rootCmd.PersistentFlags().IntVar(&writerOpts.Table.MaxColWidth, "max-col-width", 0, "truncate table label cells longer than this many characters with an ellipsis (0 for unlimited). Values and timestamps are never truncated")
+	rootCmd.PersistentFlags().BoolVar(&writerOpts.Table.Wrap, "wrap", false, "wrap table label cells longer than --max-col-width onto multiple lines instead of truncating them")
+	rootCmd.PersistentFlags().BoolVar(&wide, "wide", false, "write a table column for every label of every series, in full. Series without a label get an empty cell")
+	rootCmd.PersistentFlags().IntVar(&writerOpts.Table.RepeatHeader, "repeat-header", 0, "write the table header again every N rows of long tables (0 to only write it once). Ignored by other output formats")
+	rootCmd.PersistentFlags().StringVar(&colorThreshold, "color-threshold", "", "color table values green, yellow, or red by the thresholds they cross e.g. 'warn:>80,crit:>95'. Only applied when writing to a terminal")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "disable colors, also disabled by setting the NO_COLOR environment variable")
 	rootCmd.PersistentFlags().BoolVar(&pql.NoHeaders, "no-headers", false, "disable table headers for instant queries")
 	rootCmd.PersistentFlags().String("timeout", "10", "the timeout in seconds for all queries")
-	if err := viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout")); err != nil {
-		errlog.Fatalln(err)
-	}
+	bindFlag("timeout")
+	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "log http requests and responses (status, time, and size) to stderr, -vv also logs headers and bodies. Credentials are redacted")
+	rootCmd.PersistentFlags().Int("retries", 0, "the number of times to retry queries that fail with a transport error, 5xx, or 429 response, using exponential backoff")
+	bindFlag("retries")
+	rootCmd.PersistentFlags().Float64("max-qps", 0, "the most requests per second to send, across chunks, hosts, and polling, e.g. to stay under a shared server's rate limits. 429 responses with a Retry-After header are retried once it's passed. Unlimited if 0")
+	bindFlag("max-qps")
+	rootCmd.PersistentFlags().String("http-method", promql.MethodAuto, "how to send instant and range queries. Options: auto (GET, or a form encoded POST when the url would be longer than --max-url-length),get,post")
+	bindFlag("http-method")
+	rootCmd.PersistentFlags().Int("max-url-length", promql.DefaultMaxURLLength, "longest url in bytes queries are sent as GET requests with before --http-method auto POSTs them instead, e.g. to stay under load balancer limits")
+	bindFlag("max-url-length")
+	rootCmd.PersistentFlags().Bool("no-compress", false, "don't request gzipped responses, e.g. to debug the raw responses sent by a proxy")
+	bindFlag("no-compress")
+	rootCmd.PersistentFlags().String("user-agent", "", "User-Agent header sent to prometheus (default promql-cli/<version>)")
+	bindFlag("user-agent")
+	rootCmd.PersistentFlags().String("request-id-header", "", "header to stamp each request with a fresh uuid in e.g. "+promql.DefaultRequestIDHeader+", the id of the last request is printed alongside errors to find it in server logs")
+	bindFlag("request-id-header")
+	rootCmd.PersistentFlags().Bool("thanos-dedup", false, "set thanos' dedup query parameter, deduplicating series from replicas. Unset by default, leaving thanos' default")
+	bindFlag("thanos-dedup")
+	rootCmd.PersistentFlags().Bool("thanos-partial-response", false, "set thanos' partial_response query parameter, returning partial results when some stores are unavailable. Unset by default, leaving thanos' default")
+	bindFlag("thanos-partial-response")
 	rootCmd.PersistentFlags().String("auth-type", "", "optional auth scheme for http requests to prometheus e.g. \"Basic\" or \"Bearer\"")
-	if err := viper.BindPFlag("auth-type", rootCmd.PersistentFlags().Lookup("auth-type")); err != nil {
-		errlog.Fatalln(err)
-	}
+	bindFlag("auth-type")
 	rootCmd.PersistentFlags().String("auth-credentials", "", "optional auth credentials string for http requests to prometheus")
-	if err := viper.BindPFlag("auth-credentials", rootCmd.PersistentFlags().Lookup("auth-credentials")); err != nil {
-		errlog.Fatalln(err)
-	}
+	bindFlag("auth-credentials")
 	rootCmd.PersistentFlags().String("auth-credentials-file", "", "optional path to an auth credentials file for http requests to prometheus")
-	if err := viper.BindPFlag("auth-credentials-file", rootCmd.PersistentFlags().Lookup("auth-credentials-file")); err != nil {
-		errlog.Fatalln(err)
-	}
-	rootCmd.PersistentFlags().StringArrayP("header", "H", []string{}, "Header(s) for http requests to prometheus")
-	if err := viper.BindPFlag("header", rootCmd.PersistentFlags().Lookup("header")); err != nil {
-		errlog.Fatalln(err)
-	}
+	bindFlag("auth-credentials-file")
+	rootCmd.PersistentFlags().String("user", "", "basic auth credentials for http requests to prometheus as user:password, the password may be omitted and set with --password or --password-file")
+	bindFlag("user")
+	rootCmd.PersistentFlags().String("username", "", "basic auth username for http requests to prometheus")
+	bindFlag("username")
+	rootCmd.PersistentFlags().String("password", "", "basic auth password for http requests to prometheus, prefer the PROMQL_PASSWORD env var or --password-file to keep it out of your shell history")
+	bindFlag("password")
+	rootCmd.PersistentFlags().String("password-file", "", "path to a file containing the basic auth password for http requests to prometheus")
+	bindFlag("password-file")
+	rootCmd.PersistentFlags().String("bearer-token", "", "bearer token for http requests to prometheus")
+	bindFlag("bearer-token")
+	rootCmd.PersistentFlags().String("bearer-token-file", "", "path to a file containing the bearer token for http requests to prometheus")
+	bindFlag("bearer-token-file")
+	rootCmd.PersistentFlags().String("sigv4-region", "", "sign requests with AWS SigV4 for this region e.g. us-east-1, for Amazon Managed Prometheus. Credentials are looked up with the default AWS credential chain, like the AWS cli")
+	bindFlag("sigv4-region")
+	rootCmd.PersistentFlags().String("sigv4-profile", "", "AWS profile to sign requests with, from ~/.aws/config or ~/.aws/credentials. Signs requests for the profile's region unless --sigv4-region is set")
+	bindFlag("sigv4-profile")
+	rootCmd.PersistentFlags().String("sigv4-role-arn", "", "AWS role to assume and sign requests as, with the credentials of the default chain or --sigv4-profile")
+	bindFlag("sigv4-role-arn")
+	rootCmd.PersistentFlags().Bool("gcp-auth", false, "authenticate with Google application default credentials, e.g. for Managed Service for Prometheus. Used by default for monitoring.googleapis.com hosts")
+	bindFlag("gcp-auth")
+	rootCmd.PersistentFlags().String("gcp-project", "", "query the Managed Service for Prometheus api of this Google Cloud project, in place of --host")
+	bindFlag("gcp-project")
+	rootCmd.PersistentFlags().String("unix-socket", "", "path of a unix socket to send requests to --host over, e.g. for a prometheus sidecar without a tcp port. Requests keep the host and path of --host")
+	bindFlag("unix-socket")
+	rootCmd.PersistentFlags().String("proxy", "", "http or socks5 proxy url to send requests to prometheus through e.g. socks5://localhost:1080, may include credentials. Overrides the HTTPS_PROXY, HTTP_PROXY, and NO_PROXY env vars")
+	bindFlag("proxy")
+	rootCmd.PersistentFlags().String("tenant", "", "tenant (org id) sent as the X-Scope-OrgID header to multi-tenant servers like cortex, mimir, and thanos. Join multiple tenants with | e.g. team-a|team-b")
+	bindFlag("tenant")
+	rootCmd.PersistentFlags().StringArrayP("header", "H", []string{}, "header for http requests to prometheus as 'Name: value', can be repeated. Setting Host overrides the request's host")
+	bindFlag("header")
 
+	rootCmd.PersistentFlags().String("ca-cert", "", "path to a PEM encoded CA cert used to verify the prometheus server's certificate")
+	bindFlag("ca-cert")
+	rootCmd.PersistentFlags().String("client-cert", "", "path to a PEM encoded client cert for mTLS, requires --client-key")
+	bindFlag("client-cert")
+	rootCmd.PersistentFlags().String("client-key", "", "path to the PEM encoded key of --client-cert")
+	bindFlag("client-key")
+	rootCmd.PersistentFlags().String("server-name", "", "server name used to verify the prometheus server's certificate and for SNI, e.g. when connecting by IP")
+	bindFlag("server-name")
+	rootCmd.PersistentFlags().Bool("insecure-skip-verify", false, "disable the TLS verification of server certificates, logging a warning")
+	bindFlag("insecure-skip-verify")
 	rootCmd.PersistentFlags().String("tls_config.ca_cert_file", "", "CA cert Path for TLS config")
-	if err := viper.BindPFlag("tls_config.ca_cert_file", rootCmd.PersistentFlags().Lookup("tls_config.ca_cert_file")); err != nil {
-		errlog.Fatalln(err)
-	}
+	bindFlag("tls_config.ca_cert_file")
 	rootCmd.PersistentFlags().String("tls_config.cert_file", "", "client cert Path for TLS config")
-	if err := viper.BindPFlag("tls_config.cert_file", rootCmd.PersistentFlags().Lookup("tls_config.cert_file")); err != nil {
-		errlog.Fatalln(err)
-	}
+	bindFlag("tls_config.cert_file")
 	rootCmd.PersistentFlags().String("tls_config.key_file", "", "client key for TLS config")
-	if err := viper.BindPFlag("tls_config.key_file", rootCmd.PersistentFlags().Lookup("tls_config.key_file")); err != nil {
-		errlog.Fatalln(err)
-	}
+	bindFlag("tls_config.key_file")
 	rootCmd.PersistentFlags().String("tls_config.servername", "", "server name for TLS config")
-	if err := viper.BindPFlag("tls_config.servername", rootCmd.PersistentFlags().Lookup("tls_config.servername")); err != nil {
-		errlog.Fatalln(err)
-	}
+	bindFlag("tls_config.servername")
 	rootCmd.PersistentFlags().Bool("tls_config.insecure_skip_verify", false, "disable the TLS verification of server certificates.")
-	if err := viper.BindPFlag("tls_config.insecure_skip_verify", rootCmd.PersistentFlags().Lookup("tls_config.insecure_skip_verify")); err != nil {
-		errlog.Fatalln(err)
+	bindFlag("tls_config.insecure_skip_verify")
+}
+
+// parseHTTPAuth parses the basic auth and bearer token flags
+// --user takes the form user:password, or just user with the password set separately
+func parseHTTPAuth() (promql.HTTPAuth, error) {
+	a := promql.HTTPAuth{
+		Username:        viper.GetString("username"),
+		Password:        viper.GetString("password"),
+		PasswordFile:    viper.GetString("password-file"),
+		BearerToken:     viper.GetString("bearer-token"),
+		BearerTokenFile: viper.GetString("bearer-token-file"),
+	}
+	if user := viper.GetString("user"); user != "" {
+		if a.Username != "" {
+			return a, fmt.Errorf("please specify either --user or --username, not both")
+		}
+		username, password, ok := strings.Cut(user, ":")
+		if ok && (a.Password != "" || a.PasswordFile != "") {
+			return a, fmt.Errorf("please specify the password in either --user or --password/--password-file, not both")
+		}
+		a.Username = username
+		if ok {
+			a.Password = password
+		}
 	}
+	return a, nil
+}
+
+// firstString returns the value of the first of the config keys that's set, e.g. for flags with aliases
+func firstString(keys ...string) string {
+	for _, k := range keys {
+		if v := viper.GetString(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// optionalBool returns the value of a boolean config key, or nil if it isn't set by a flag, env var, or config file
+func optionalBool(key string) *bool {
+	if !viper.IsSet(key) {
+		return nil
+	}
+	b := viper.GetBool(key)
+	return &b
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -210,9 +776,7 @@ func initConfig() {
 		viper.AddConfigPath(home)
 		viper.SetConfigName(".promql-cli")
 	}
-	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
-	viper.SetEnvPrefix("promql")
-	viper.AutomaticEnv() // read in environment variables that match
+	configureEnv(viper.GetViper())
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err != nil {
@@ -220,4 +784,96 @@ func initConfig() {
 			errlog.Printf("Could not read config file: %v\n", err)
 		}
 	}
+
+	if err := loadContext(); err != nil {
+		errlog.Fatalln(err)
+	}
+}
+
+// readMacros reads a --macros file
+func readMacros(path string) (promql.Macros, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading macros file: %v", err)
+	}
+	defer f.Close()
+	m, err := promql.ParseMacros(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading macros file %s: %v", path, err)
+	}
+	return m, nil
+}
+
+// expandQuery expands the --macros in query, then substitutes its --var variables
+// Queries are left as they are when neither is set, so $ in them is never mistaken for a variable
+func expandQuery(query string) (string, error) {
+	if macros == nil && len(vars) == 0 {
+		return query, nil
+	}
+	if macros != nil {
+		expanded, err := macros.Expand(query, vars)
+		if err != nil {
+			return "", err
+		}
+		query = expanded
+	}
+	return promql.Substitute(query, vars)
+}
+
+// runQueryFile runs every query in a query file, writing each result under a header made from its comment
+// A failing query doesn't stop the rest of the file from running
+func runQueryFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading query file: %v", err)
+	}
+	defer f.Close()
+	queries, err := promql.ParseQueryFile(f)
+	if err != nil {
+		return fmt.Errorf("error reading query file: %v", err)
+	}
+	failed, assertionsFailed := 0, 0
+	for i, q := range queries {
+		if !pql.NoHeaders {
+			header := q.Comment
+			if header == "" {
+				header = q.Expr
+			}
+			sep := ""
+			if i > 0 {
+				sep = "\n"
+			}
+			if _, err := fmt.Fprintf(stdout, "%s# %s\n", sep, header); err != nil {
+				return err
+			}
+		}
+		sent := dryRunRequests()
+		expr, err := expandQuery(q.Expr)
+		if err == nil {
+			err = runQuery(expr)
+		}
+		if interrupted() {
+			return err
+		}
+		// Queries fail on purpose in dry run mode once their requests are written out
+		if err != nil && dryRunRequests() > sent {
+			continue
+		}
+		if err != nil {
+			errlog.Printf("%s:%d: %v\n", path, q.Line, requestIDError(err))
+			failed++
+			if exitCode(err) == exitAssertionFailed {
+				assertionsFailed++
+			}
+		}
+	}
+	if failed > 0 {
+		err := fmt.Errorf("%d of %d queries failed", failed, len(queries))
+		// Only exit as an assertion failure if every query ran
+		if assertionsFailed == failed {
+			return &exitError{code: exitAssertionFailed, err: err}
+		}
+		return err
+	}
+	return nil
 }
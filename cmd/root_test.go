@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangeOutput(t *testing.T) {
+	srv := exitCodeServer()
+	defer srv.Close()
+	cases := []struct {
+		args     []string
+		expected string
+		graph    bool
+	}{
+		// Piped range results are written as csv rather than graphed
+		{
+			args:     []string{"up", "--since", "5m"},
+			expected: "__name__,job,value,timestamp\nup,web,1,2020-09-13T12:26:40Z\n",
+		},
+		// Unless a graph is asked for
+		{
+			args:  []string{"up", "--since", "5m", "--output", "graph"},
+			graph: true,
+		},
+		{
+			args:     []string{"up", "--since", "5m", "--output", "json"},
+			expected: `[{"metric":{"__name__":"up","job":"web"},"values":[[1600000000,"1"]]}]` + "\n",
+		},
+	}
+	for i, c := range cases {
+		stdout, stderr, code := runPromql(t, append([]string{"--host", srv.URL}, c.args...))
+		assert.Equal(t, 0, code, "Unexpected err for case %d, %s", i, stderr)
+		assert.Equal(t, c.graph, strings.Contains(stdout, "# METRIC: up{job=\"web\"}"), "Unexpected output for case %d, %s", i, stdout)
+		if !c.graph {
+			assert.Equal(t, c.expected, stdout, "Unexpected output for case %d", i)
+		}
+	}
+
+	// Results written to a terminal are graphed by default, unless they're written to --output-dir
+	defer func(f func() bool, output, dir string) {
+		stdoutIsTerminal, pql.Output, outputDir = f, output, dir
+	}(stdoutIsTerminal, pql.Output, outputDir)
+	stdoutIsTerminal = func() bool { return true }
+	pql.Output, outputDir = "", ""
+	assert.Equal(t, "", rangeOutput())
+	outputDir = t.TempDir()
+	assert.Equal(t, "csv", rangeOutput())
+	stdoutIsTerminal = func() bool { return false }
+	pql.Output, outputDir = "graph", ""
+	assert.Equal(t, "graph", rangeOutput())
+}
+
+func TestSince(t *testing.T) {
+	defer func(d time.Duration, start, end string) {
+		since, pql.Start, pql.End = d, start, end
+	}(since, pql.Start, pql.End)
+	since, pql.Start, pql.End = 6*time.Hour, "", ""
+	applySince()
+	assert.Equal(t, "6h0m0s", pql.Start)
+	assert.Equal(t, "now", pql.End)
+
+	// The range query runs over the last 6h, until now
+	before := time.Now()
+	stdout, stderr, code := runPromql(t, []string{"--host", "http://127.0.0.1:1", "up", "--since", "6h", "--dry-run"})
+	assert.Equal(t, 0, code, "Unexpected err, %s", stderr)
+	m := regexp.MustCompile(`(?m)^GET (\S+)$`).FindStringSubmatch(stdout)
+	if assert.Len(t, m, 2, "Unexpected output %s", stdout) {
+		u, err := url.Parse(m[1])
+		assert.NoError(t, err)
+		assert.Equal(t, "/api/v1/query_range", u.Path)
+		start, err := strconv.ParseFloat(u.Query().Get("start"), 64)
+		assert.NoError(t, err)
+		end, err := strconv.ParseFloat(u.Query().Get("end"), 64)
+		assert.NoError(t, err)
+		assert.InDelta(t, (6 * time.Hour).Seconds(), end-start, 0.001)
+		assert.InDelta(t, float64(before.Unix()), end, 5)
+	}
+
+	cases := []struct {
+		args     []string
+		expected string
+	}{
+		{args: []string{"up", "--since", "-6h"}, expected: "--since must be a positive duration\n"},
+		{args: []string{"up", "--since", "6h", "--start", "1h"}, expected: "if any flags in the group [since start] are set none of the others can be; [since start] were all set"},
+		{args: []string{"up", "--since", "6h", "--end", "now"}, expected: "if any flags in the group [since end] are set none of the others can be; [end since] were all set"},
+	}
+	for i, c := range cases {
+		stdout, stderr, code := runPromql(t, append([]string{"--host", "http://127.0.0.1:1", "--dry-run"}, c.args...))
+		assert.Equal(t, exitUsage, code, "Unexpected err for case %d, %s", i, stderr)
+		assert.Contains(t, stderr, c.expected, "Unexpected output for case %d", i)
+		assert.NotContains(t, stdout, "GET ", "Unexpected output for case %d", i)
+	}
+}
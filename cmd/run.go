@@ -0,0 +1,115 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/nalbury/promql-cli/pkg/promql"
+)
+
+var (
+	// queryParams are the raw key=value values of the --param flag
+	queryParams []string
+	// listQueries lists the saved queries instead of running one
+	listQueries bool
+)
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run [query_name]",
+	Short: "Run a saved query from the config file",
+	Long: `Run a saved query from the "queries" section of the config file.
+Saved queries may contain $param placeholders, set with --param key=value or from the query's default params.
+Param values are escaped when used inside quotes e.g. label matchers, and must be a bare duration, number, or name anywhere else.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if listQueries {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		queries, err := savedQueries()
+		if err != nil {
+			errlog.Fatalln(err)
+		}
+		if listQueries {
+			if err := writeSavedQueries(queries); err != nil {
+				fatal(err)
+			}
+			return
+		}
+		q, ok := queries[args[0]]
+		if !ok {
+			errlog.Fatalf("no saved query named %q, see \"promql run --list\" for the available queries\n", args[0])
+		}
+		params, err := promql.ParseParams(queryParams)
+		if err != nil {
+			errlog.Fatalln(err)
+		}
+		expr, err := q.Render(params)
+		if err != nil {
+			errlog.Fatalf("error rendering query %s: %v\n", args[0], err)
+		}
+		if err := runQuery(expr); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+// savedQueries reads the saved queries from the config file
+func savedQueries() (map[string]promql.SavedQuery, error) {
+	var queries map[string]promql.SavedQuery
+	if err := viper.UnmarshalKey("queries", &queries); err != nil {
+		return nil, fmt.Errorf("error reading saved queries: %v", err)
+	}
+	return queries, nil
+}
+
+// writeSavedQueries writes out a table of the saved query names, params, and descriptions
+func writeSavedQueries(queries map[string]promql.SavedQuery) error {
+	names := make([]string, 0, len(queries))
+	for name := range queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(stdout, 0, 0, 4, ' ', 0)
+	if !pql.NoHeaders {
+		fmt.Fprintln(w, "NAME\tPARAMS\tDESCRIPTION")
+	}
+	for _, name := range names {
+		q := queries[name]
+		var params []string
+		for k, v := range q.Params {
+			params = append(params, fmt.Sprintf("%s=%s", k, v))
+		}
+		sort.Strings(params)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, strings.Join(params, ","), q.Description)
+	}
+	return w.Flush()
+}
+
+func init() {
+	runCmd.Flags().StringArrayVar(&queryParams, "param", []string{}, "value for a saved query placeholder as key=value e.g. --param service=checkout, can be repeated")
+	runCmd.Flags().BoolVar(&listQueries, "list", false, "list the saved queries and their descriptions")
+	rootCmd.AddCommand(runCmd)
+}
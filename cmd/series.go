@@ -0,0 +1,69 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nalbury/promql-cli/pkg/util"
+	"github.com/nalbury/promql-cli/pkg/writer"
+	"github.com/spf13/cobra"
+)
+
+// cmd line args
+var seriesMatches []string
+
+// seriesCmd represents the series command
+var seriesCmd = &cobra.Command{
+	Use:   "series [match_selector...]",
+	Short: "Get the series matching the provided selectors",
+	Long: `Get the series matching the provided selectors from the series api, within the --start and --end of a range query or just before --time.
+Selectors can be given as arguments or with --match[] (or --match). Like prometheus's match[] parameter, series matching any of the selectors are returned, each only once.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && len(seriesMatches) == 0 {
+			return fmt.Errorf("requires at least one match selector, as an argument or with --match[]")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		checkPage()
+		result, warnings, err := pql.SeriesQuery(append(args, seriesMatches...))
+		if len(warnings) > 0 {
+			errlog.Printf("Warnings: %v\n", warnings)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		if err := checkNotEmpty(len(result)); err != nil {
+			fatal(err)
+		}
+		// The union of the selectors is in the order the server returned each of them, sort it so it's the same every run
+		sort.Slice(result, func(i, j int) bool { return result[i].String() < result[j].String() })
+		r := writer.SeriesResult(util.Paginate(result, page))
+		if err := writer.WriteInstant(stdout, &r, pql.Output, writerOpts); err != nil {
+			fatal(err)
+		}
+		logPage(len(result))
+	},
+}
+
+func init() {
+	seriesCmd.Flags().StringArrayVar(&seriesMatches, "match", []string{}, "series selector to match, can be repeated, also accepted as --match[]")
+	seriesCmd.Flags().SetNormalizeFunc(normalizeMatchFlag)
+	rootCmd.AddCommand(seriesCmd)
+	addPageFlags(seriesCmd, "series")
+}
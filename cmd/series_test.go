@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// seriesServer is a fake prometheus server whose series api returns series out of order, with a duplicate as if from overlapping selectors
+func seriesServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"success","data":[{"__name__":"up","job":"node"},{"__name__":"go_goroutines","job":"api"},{"__name__":"up","job":"api"},{"__name__":"up","job":"node"}]}`))
+	}))
+}
+
+func TestSeries(t *testing.T) {
+	srv := seriesServer()
+	defer srv.Close()
+	cases := []struct {
+		args     []string
+		expected string
+	}{
+		{
+			args:     []string{"series", "--match[]", "up", "--match[]", "{job=~\".+\"}", "--output", "csv"},
+			expected: "__name__,job\ngo_goroutines,api\nup,api\nup,node\n",
+		},
+		{
+			args:     []string{"series", "up", "--output", "json"},
+			expected: `[{"__name__":"go_goroutines","job":"api"},{"__name__":"up","job":"api"},{"__name__":"up","job":"node"}]` + "\n",
+		},
+	}
+	for i, c := range cases {
+		stdout, stderr, code := runPromql(t, append([]string{"--host", srv.URL}, c.args...))
+		assert.Equal(t, 0, code, "Unexpected err for case %d, %s", i, stderr)
+		assert.Equal(t, c.expected, stdout, "Unexpected output for case %d", i)
+	}
+
+	// Pages are taken from the sorted series, and which page was written goes to stderr
+	stdout, stderr, code := runPromql(t, []string{"--host", srv.URL, "series", "up", "--output", "csv", "--no-headers", "--limit", "1", "--offset", "1"})
+	assert.Equal(t, 0, code, "Unexpected err, %s", stderr)
+	assert.Equal(t, "up,api\n", stdout)
+	assert.Contains(t, stderr, "showing 2-2 of 3")
+}
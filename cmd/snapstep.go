@@ -0,0 +1,69 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nalbury/promql-cli/pkg/promql"
+)
+
+// cmd line args
+var (
+	// snapStepToScrape rounds the step of range queries up to a multiple of the scrape interval
+	snapStepToScrape bool
+	// scrapeInterval overrides the scrape interval --snap-step-to-scrape reads from the server's config
+	scrapeInterval time.Duration
+)
+
+// snapStep rounds the step of range queries up to a multiple of the scrape interval if --snap-step-to-scrape is set
+// Without --scrape-interval, the interval is the global scrape interval of the server's config
+func snapStep() error {
+	if !snapStepToScrape {
+		if scrapeInterval != 0 {
+			return fmt.Errorf("--scrape-interval is only used with --snap-step-to-scrape")
+		}
+		return nil
+	}
+	if scrapeInterval < 0 {
+		return fmt.Errorf("--scrape-interval must be a positive duration")
+	}
+	if pql.Start == "" {
+		return nil
+	}
+	step, err := time.ParseDuration(pql.Step)
+	if err != nil {
+		return fmt.Errorf("unable to parse step duration, %v", err)
+	}
+	interval := scrapeInterval
+	if interval == 0 {
+		// In dry run mode the config request is written out like any other, and the step is left as is
+		if interval, err = pql.ScrapeInterval(); errors.Is(err, promql.ErrDryRun) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	pql.Step = promql.SnapStep(step, interval).String()
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&snapStepToScrape, "snap-step-to-scrape", false, "round the step of range queries up to a multiple of the server's global scrape interval, so each point lines up with a scrape rather than being interpolated between them")
+	rootCmd.PersistentFlags().DurationVar(&scrapeInterval, "scrape-interval", 0, "scrape interval --snap-step-to-scrape rounds the step to, instead of reading it from the server's config e.g. when its config api is disabled")
+}
@@ -0,0 +1,47 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"github.com/prometheus/common/model"
+
+	"github.com/nalbury/promql-cli/pkg/writer"
+)
+
+// cmd line args
+var sqliteOpts writer.SQLiteOptions
+
+// checkSQLiteMode validates the --sqlite-mode flag
+func checkSQLiteMode() {
+	if sqliteOpts.Mode != writer.SQLiteAppend && sqliteOpts.Mode != writer.SQLiteReplace {
+		errlog.Fatalf("unknown --sqlite-mode %q, options: %s,%s\n", sqliteOpts.Mode, writer.SQLiteAppend, writer.SQLiteReplace)
+	}
+}
+
+// writeSQLite writes an instant or range query result to the --sqlite-table table of the --output-file database
+func writeSQLite(result model.Value) error {
+	rows, err := writer.WriteSQLite(outputFile, result, sqliteOpts)
+	if err != nil {
+		return err
+	}
+	errlog.Printf("Wrote %d rows to table %s of %s\n", rows, writer.SQLiteIdentifier(sqliteOpts.Table), outputFile)
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&sqliteOpts.Table, "sqlite-table", writer.DefaultSQLiteTable, "table of the --output-file database --output sqlite writes rows to, created with a column per label followed by value and timestamp columns")
+	rootCmd.PersistentFlags().StringVar(&sqliteOpts.Mode, "sqlite-mode", writer.SQLiteAppend, "how --output sqlite writes to an existing table. Options: append (adding columns for new labels),replace")
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/nalbury/promql-cli/pkg/promql"
+)
+
+// cmd line args
+var (
+	timing bool
+	// queryTiming accumulates the timings of each query's requests when --timing is set
+	queryTiming *promql.Timing
+)
+
+// setupTiming sets up the client to time requests if --timing is set
+func setupTiming() {
+	if !timing {
+		return
+	}
+	queryTiming = &promql.Timing{}
+	pql.ClientOptions.Timing = queryTiming
+}
+
+// startTiming resets the request timings before a query
+func startTiming() {
+	if queryTiming != nil {
+		queryTiming.Reset()
+	}
+}
+
+// logTiming logs a one line summary of the timings of the query's requests and the size of its result to stderr
+func logTiming(result model.Value) {
+	if queryTiming == nil {
+		return
+	}
+	s := queryTiming.Summary()
+	if s.Requests == 0 {
+		return
+	}
+	series, samples := resultSize(result)
+	round := func(d time.Duration) time.Duration { return d.Round(time.Microsecond) }
+	errlog.Printf(
+		"Timing: dns=%s connect=%s tls=%s ttfb=%s total=%s requests=%d series=%d samples=%d\n",
+		round(s.DNS), round(s.Connect), round(s.TLS), round(s.TTFB), round(s.Total), s.Requests, series, samples,
+	)
+}
+
+// resultSize returns the number of series and samples in a query result
+func resultSize(result model.Value) (series int, samples int) {
+	switch r := result.(type) {
+	case model.Vector:
+		return len(r), len(r)
+	case model.Matrix:
+		for _, s := range r {
+			samples += len(s.Values) + len(s.Histograms)
+		}
+		return len(r), samples
+	case *model.Scalar, *model.String:
+		return 1, 1
+	default:
+		return 0, 0
+	}
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&timing, "timing", false, "log a one line summary of each query to stderr: dns, connect, tls, time to first byte, and total request durations, and the number of series and samples returned")
+}
@@ -0,0 +1,217 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/nalbury/promql-cli/pkg/promql"
+	"github.com/nalbury/promql-cli/pkg/transform"
+)
+
+// transform flags
+var (
+	// labelFilters and labelFiltersNot hold the raw label=regex values of the --filter and --filter-not flags
+	labelFilters, labelFiltersNot []string
+	// metricRenames holds the raw old=new values of the --metric-rename flag
+	metricRenames []string
+	// relabelRules holds the raw values of the --relabel flag
+	relabelRules []string
+	// dropLabels holds the label names of the --drop-labels flag
+	dropLabels []string
+	// fillGaps is the --fill-gaps mode, it's applied to range query results written as csv
+	fillGaps string
+	// resample is the raw interval:aggregation value of the --resample flag, it's applied to range query results
+	resample string
+	// headSamples and tailSamples keep the first or last samples of each series of range query results, as set by --head and --tail
+	headSamples, tailSamples int
+	// flattenLabels joins the labels of each series into a single labels column, as set by --label-separator and --label-kv-separator
+	flattenLabels bool
+	flatten       transform.FlattenOptions
+	// noSanitize leaves the samples of range results as the server returned them, rather than sorting and deduplicating them
+	noSanitize bool
+	// mergeDuplicates is how sanitizing merges samples at the same timestamp, as set by --merge-duplicate-timestamps
+	mergeDuplicates string
+	// includeQuery adds a query label holding the expression to each series of the result of --include-query
+	includeQuery bool
+)
+
+// queryLabel is the label --include-query writes the expression of each query to
+const queryLabel model.LabelName = "query"
+
+// transformResult applies any user requested transformations to a query result before it's written
+func transformResult(result model.Value) (model.Value, error) {
+	if noSanitize && mergeDuplicates != "" {
+		return result, fmt.Errorf("please specify either --no-sanitize or --merge-duplicate-timestamps, not both")
+	}
+	if m, ok := result.(model.Matrix); ok && !noSanitize {
+		var err error
+		if result, err = sanitizeRange(m); err != nil {
+			return result, err
+		}
+	}
+	// Filter before the other transformations, on the labels the server returned
+	if len(labelFilters) > 0 || len(labelFiltersNot) > 0 {
+		keep, err := transform.ParseLabelFilters(labelFilters, false)
+		if err != nil {
+			return result, err
+		}
+		drop, err := transform.ParseLabelFilters(labelFiltersNot, true)
+		if err != nil {
+			return result, err
+		}
+		if result, err = transform.FilterSeries(result, append(keep, drop...)); err != nil {
+			return result, err
+		}
+	}
+	if len(metricRenames) > 0 {
+		renames, err := transform.ParseRenames(metricRenames)
+		if err != nil {
+			return result, err
+		}
+		if result, err = transform.RenameMetrics(result, renames); err != nil {
+			return result, err
+		}
+	}
+	if len(relabelRules) > 0 {
+		rules, err := transform.ParseRelabelRules(relabelRules)
+		if err != nil {
+			return result, err
+		}
+		if result, err = transform.Relabel(result, rules); err != nil {
+			return result, err
+		}
+	}
+	if len(dropLabels) > 0 {
+		labels := make([]model.LabelName, 0, len(dropLabels))
+		for _, l := range dropLabels {
+			labels = append(labels, model.LabelName(l))
+		}
+		var err error
+		if result, err = transform.DropLabels(result, labels); err != nil {
+			return result, err
+		}
+	}
+	// Flatten last, so the other transformations see the real labels
+	if flattenLabels {
+		return transform.FlattenLabels(result, flatten)
+	}
+	return result, nil
+}
+
+// includeQueryLabel adds query to each series of its result as a query label if --include-query is set
+// It's added after transformResult, so it's written as its own column even with --flatten-labels
+func includeQueryLabel(result model.Value, query string) (model.Value, error) {
+	if !includeQuery {
+		return result, nil
+	}
+	switch result.(type) {
+	case model.Vector, model.Matrix:
+		return transform.SetLabel(result, queryLabel, model.LabelValue(query))
+	default:
+		return result, fmt.Errorf("--include-query is only supported for vector and matrix results, not %s", result.Type())
+	}
+}
+
+// sortResult sorts the series of a query result by their metric, so they're written in the same order on every run
+// Results of queries that order them with one of the sort functions, e.g. sort_desc(...), are left in the order the server returned them in
+func sortResult(result model.Value, query string) model.Value {
+	if promql.SortsResult(query) {
+		return result
+	}
+	return transform.SortSeries(result)
+}
+
+// fillRangeGaps fills the missing steps of each series of a range query result if --fill-gaps is set
+func fillRangeGaps(m model.Matrix) (model.Matrix, error) {
+	if fillGaps == "" {
+		return m, nil
+	}
+	if rangeOutput() != "csv" {
+		return m, fmt.Errorf("--fill-gaps is only supported for range queries written as csv")
+	}
+	r, err := pql.Range()
+	if err != nil {
+		return m, err
+	}
+	return transform.FillGaps(m, r.Start, r.End, r.Step, fillGaps)
+}
+
+// sanitizeRange sorts the samples of each series of a range result by timestamp and merges repeated samples
+// Unless --merge-duplicate-timestamps picks how to merge them, series whose samples at the same timestamp disagree are logged, as the values dropped may have been the right ones
+func sanitizeRange(m model.Matrix) (model.Matrix, error) {
+	if mergeDuplicates == "" {
+		m, conflicts := transform.Sanitize(m, transform.MergeLast)
+		for _, metric := range conflicts {
+			errlog.Printf("Warning: %s has samples at the same timestamp with different values, keeping the last of them\n", metric)
+		}
+		return m, nil
+	}
+	merge, err := transform.ParseMerge(mergeDuplicates)
+	if err != nil {
+		return m, err
+	}
+	m, _ = transform.Sanitize(m, merge)
+	return m, nil
+}
+
+// resampleRange downsamples each series of a range query result if --resample is set
+func resampleRange(m model.Matrix) (model.Matrix, error) {
+	if resample == "" {
+		return m, nil
+	}
+	interval, agg, err := transform.ParseResample(resample)
+	if err != nil {
+		return m, err
+	}
+	return transform.Resample(m, interval, agg)
+}
+
+// windowRange keeps the first or last samples of each series of a range query result if --head or --tail is set
+func windowRange(m model.Matrix) (model.Matrix, error) {
+	if headSamples < 0 || tailSamples < 0 {
+		return m, fmt.Errorf("--head and --tail must not be negative")
+	}
+	switch {
+	case headSamples > 0 && tailSamples > 0:
+		return m, fmt.Errorf("please specify either --head or --tail, not both")
+	case headSamples > 0:
+		return transform.Head(m, headSamples), nil
+	case tailSamples > 0:
+		return transform.Tail(m, tailSamples), nil
+	}
+	return m, nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringArrayVar(&labelFilters, "filter", []string{}, "keep only the series whose label matches the regex, in the form label=regex e.g. job=api|web (repeatable, series must match every filter). Series without the label match it as empty")
+	rootCmd.PersistentFlags().StringArrayVar(&labelFiltersNot, "filter-not", []string{}, "drop the series whose label matches the regex, in the form label=regex e.g. job=kube-.* (repeatable). Applied along with --filter before any other transformation")
+	rootCmd.PersistentFlags().StringArrayVar(&metricRenames, "metric-rename", []string{}, "rewrite the __name__ label of matching series before writing results, in the form old=new (repeatable)")
+	rootCmd.PersistentFlags().StringArrayVar(&relabelRules, "relabel", []string{}, "relabel rule applied to each series before writing results, in the form source_label,target_label,replacement[,regex] (repeatable, applied in order)")
+	rootCmd.PersistentFlags().StringVar(&fillGaps, "fill-gaps", "", "fill the missing steps of each series of range query csv output, for tools expecting regularly spaced samples. Options: zero,previous,nan")
+	rootCmd.PersistentFlags().StringVar(&resample, "resample", "", "downsample each series of range query results into intervals before writing them, in the form interval:aggregation e.g. 5m:avg. Aggregations: avg,min,max,last,sum")
+	rootCmd.PersistentFlags().IntVar(&headSamples, "head", 0, "keep only the first N samples of each series of range query results")
+	rootCmd.PersistentFlags().IntVar(&tailSamples, "tail", 0, "keep only the last N samples of each series of range query results")
+	rootCmd.PersistentFlags().BoolVar(&flattenLabels, "flatten-labels", false, "write the labels of each series other than its name as a single labels column of key=value pairs, e.g. for csv consumers expecting a fixed set of columns")
+	rootCmd.PersistentFlags().StringVar(&flatten.Separator, "label-separator", ",", "separator joining the key=value pairs of --flatten-labels, e.g. ; or | to keep the column from being split by csv parsers")
+	rootCmd.PersistentFlags().StringVar(&flatten.KeyValueSeparator, "label-kv-separator", "=", "separator joining each key and value of --flatten-labels")
+	rootCmd.PersistentFlags().BoolVar(&noSanitize, "no-sanitize", false, "write the samples of range results as the server returned them. By default they're sorted by timestamp, and samples repeating a timestamp are collapsed into the last of them with a warning if their values differ")
+	rootCmd.PersistentFlags().StringVar(&mergeDuplicates, "merge-duplicate-timestamps", "", "merge the samples of range result series repeating a timestamp into one, without warning about their values differing. Options: first,last,max,min,avg. Defaults to last, with a warning")
+	rootCmd.PersistentFlags().BoolVar(&includeQuery, "include-query", false, "add a query label holding the query expression to each series, written as a query column in table and csv output and a label in json output, e.g. to tell apart the results of a --query-file")
+	rootCmd.PersistentFlags().StringSliceVar(&dropLabels, "drop-labels", []string{}, "comma separated list of labels to remove from each series. This changes series identity, series left with identical labels are merged by summing their values")
+}
@@ -0,0 +1,123 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/nalbury/promql-cli/pkg/assertion"
+)
+
+// cmd line args
+var (
+	waitFor      string
+	waitTimeout  time.Duration
+	waitInterval time.Duration
+	waitEmptyOk  bool
+)
+
+// runWait repeatedly runs an instant query until every sample satisfies the --wait-for condition, or --wait-timeout passes
+// Progress is logged to stderr and the result that satisfied the condition is written out
+func runWait(query string) error {
+	cond, err := assertion.ParseCondition(waitFor)
+	if err != nil {
+		return err
+	}
+	if pql.Start != "" {
+		return fmt.Errorf("--wait-for is only supported for instant queries")
+	}
+	if timeStr != "now" {
+		return fmt.Errorf("--wait-for can't be used with --time, each attempt queries the current time")
+	}
+	if waitInterval <= 0 {
+		return fmt.Errorf("--wait-interval must be a positive duration")
+	}
+	deadline := time.Now().Add(waitTimeout)
+	for attempt := 1; ; attempt++ {
+		pql.Time = time.Now()
+		status, result, err := waitAttempt(query, cond)
+		if interrupted() {
+			return err
+		}
+		if err != nil {
+			status = fmt.Sprintf("query failed: %v", err)
+		}
+		if result != nil {
+			remaining := time.Until(deadline).Round(time.Second)
+			errlog.Printf("Condition %s met (attempt %d, %s remaining)\n", cond, attempt, remaining)
+			return writeInstantResult(result)
+		}
+		if time.Now().Add(waitInterval).After(deadline) {
+			return &exitError{
+				code: exitWaitTimeout,
+				err:  fmt.Errorf("timed out after %s waiting for %s: %s", waitTimeout, cond, status),
+			}
+		}
+		errlog.Printf("Waiting for %s: %s (attempt %d, %s remaining)\n", cond, status, attempt, time.Until(deadline).Round(time.Second))
+		select {
+		case <-interruptCtx.Done():
+			return interruptCtx.Err()
+		case <-time.After(waitInterval):
+		}
+	}
+}
+
+// waitAttempt runs the query once, returning the result if it satisfied the condition, otherwise a description of why it didn't
+func waitAttempt(query string, cond assertion.Condition) (string, model.Value, error) {
+	result, warnings, err := pql.InstantQuery(query)
+	if len(warnings) > 0 {
+		errlog.Printf("Warnings: %v\n", warnings)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	t, err := transformResult(result)
+	if err != nil {
+		return "", nil, err
+	}
+	if t, err = includeQueryLabel(t, query); err != nil {
+		return "", nil, err
+	}
+	t = sortResult(t, query)
+	samples, err := assertion.Samples(t)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(samples) == 0 {
+		if waitEmptyOk {
+			return "", t, nil
+		}
+		return "empty result", nil, nil
+	}
+	passed, violations, err := assertion.Check(samples, cond, assertion.ModeAll)
+	if err != nil {
+		return "", nil, err
+	}
+	if passed {
+		return "", t, nil
+	}
+	return fmt.Sprintf("%d of %d series not met", len(violations), len(samples)), nil, nil
+}
+
+func init() {
+	rootCmd.Flags().StringVar(&waitFor, "wait-for", "", "repeat the instant query until every series satisfies this condition e.g. 'value < 0.01', exiting with code 6 if --wait-timeout passes first")
+	rootCmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 10*time.Minute, "how long to wait for the --wait-for condition before giving up")
+	rootCmd.Flags().DurationVar(&waitInterval, "wait-interval", 15*time.Second, "how often to run the query while waiting for the --wait-for condition")
+	rootCmd.Flags().BoolVar(&waitEmptyOk, "wait-empty-ok", false, "treat an empty result as satisfying the --wait-for condition instead of continuing to wait")
+}
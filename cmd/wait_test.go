@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitServer is a fake prometheus server whose up query is 0 until its n-th run, and whose absent query never returns results
+func waitServer(n int64, polls *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = r.ParseForm()
+		if r.Form.Get("query") == "absent" {
+			atomic.AddInt64(polls, 1)
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+			return
+		}
+		value := 0
+		if atomic.AddInt64(polls, 1) >= n {
+			value = 1
+		}
+		_, _ = fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"up","job":"web"},"value":[1600000000,"%d"]}]}}`, value)
+	}))
+}
+
+func TestWait(t *testing.T) {
+	cases := []struct {
+		args     []string
+		code     int
+		polls    int64
+		expected string
+		stderr   string
+	}{
+		// The result is written once the condition is met
+		{
+			args:     []string{"up", "--wait-for", "value > 0"},
+			polls:    3,
+			expected: "__name__,job,value,timestamp\nup,web,1,2020-09-13T12:26:40Z\n",
+			stderr:   "met (attempt 3,",
+		},
+		// Empty results satisfy the condition with --wait-empty-ok
+		{
+			args:     []string{"absent", "--wait-for", "value > 0", "--wait-empty-ok"},
+			polls:    1,
+			expected: "value,timestamp\n",
+			stderr:   "met (attempt 1,",
+		},
+		// Otherwise they're waited on until --wait-timeout
+		{
+			args:   []string{"absent", "--wait-for", "value > 0", "--wait-empty-ok=false", "--wait-timeout", "50ms"},
+			code:   exitWaitTimeout,
+			stderr: "empty result",
+		},
+	}
+	for i, c := range cases {
+		var polls int64
+		srv := waitServer(3, &polls)
+		args := append([]string{"--host", srv.URL, "--output", "csv", "--wait-interval", "10ms", "--wait-timeout", "10s"}, c.args...)
+		stdout, stderr, code := runPromql(t, args)
+		srv.Close()
+		assert.Equal(t, c.code, code, "Unexpected err for case %d, %s", i, stderr)
+		assert.Equal(t, c.expected, stdout, "Unexpected output for case %d", i)
+		assert.Contains(t, stderr, c.stderr, "Unexpected output for case %d", i)
+		if c.polls > 0 {
+			assert.Equal(t, c.polls, atomic.LoadInt64(&polls), "Unexpected output for case %d", i)
+		} else {
+			assert.Greater(t, atomic.LoadInt64(&polls), int64(1), "Unexpected output for case %d", i)
+		}
+	}
+}
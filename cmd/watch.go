@@ -0,0 +1,84 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nalbury/promql-cli/pkg/writer"
+)
+
+// cmd line args
+var watchInterval time.Duration
+
+// clearScreen moves the cursor to the top left of the terminal and clears it
+const clearScreen = "\x1b[H\x1b[2J"
+
+// runWatch reruns an instant query every --watch interval until interrupted, rewriting its result in place when writing to a terminal
+// Table values that went up or down since the previous refresh are marked with an arrow, and colored unless colors are disabled
+// Failed queries are logged and retried at the next refresh, like watch(1)
+func runWatch(query string) error {
+	switch {
+	case watchInterval < 0:
+		return fmt.Errorf("--watch must be a positive duration")
+	case pql.Start != "":
+		return fmt.Errorf("--watch is only supported for instant queries")
+	case timeStr != "now":
+		return fmt.Errorf("--watch can't be used with --time, each refresh queries the current time")
+	case queryFile != "":
+		return fmt.Errorf("please specify either --watch or --query-file, not both")
+	case waitFor != "":
+		return fmt.Errorf("please specify either --watch or --wait-for, not both")
+	}
+	writerOpts.Table.Deltas = &writer.Deltas{Color: colorsEnabled()}
+	for {
+		pql.Time = time.Now()
+		if err := writeWatchHeader(stdout, query); err != nil {
+			return err
+		}
+		if err := runQuery(query); err != nil {
+			if interrupted() {
+				return err
+			}
+			logError(requestIDError(err))
+		}
+		select {
+		case <-interruptCtx.Done():
+			return interruptCtx.Err()
+		case <-time.After(watchInterval):
+		}
+	}
+}
+
+// writeWatchHeader clears the terminal for the next refresh of --watch, and writes a header with the query and time of the refresh unless --no-headers is set
+func writeWatchHeader(w io.Writer, query string) error {
+	if outputIsTerminal() {
+		if _, err := io.WriteString(w, clearScreen); err != nil {
+			return err
+		}
+	}
+	if pql.NoHeaders {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "Every %s: %s    %s\n\n", watchInterval, query, pql.Time.Format(time.RFC3339))
+	return err
+}
+
+func init() {
+	rootCmd.Flags().DurationVar(&watchInterval, "watch", 0, "rerun the instant query every interval e.g. 5s until interrupted, rewriting its result. Table values that went up or down since the previous refresh are marked with an arrow, and colored green or red unless --no-color is set")
+}
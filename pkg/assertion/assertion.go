@@ -0,0 +1,176 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// assertion provides simple comparisons against query results, for gating scripts and CI pipelines on metrics
+package assertion
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// Assertion modes
+const (
+	// ModeAll requires every sample to satisfy the condition
+	ModeAll = "all"
+	// ModeAny requires at least one sample to satisfy the condition
+	ModeAny = "any"
+)
+
+// operators are the supported comparison operators, two character operators first so they're matched before their prefixes
+var operators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// Condition is a comparison of a value against a threshold e.g. value > 0.99
+type Condition struct {
+	Op        string
+	Threshold float64
+}
+
+// parseComparison splits a comparison like "> 0.99" into its operator and operand
+// A bare operand is compared with ==
+func parseComparison(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			return op, strings.TrimSpace(strings.TrimPrefix(s, op))
+		}
+	}
+	return "==", s
+}
+
+// ParseCondition parses a condition in the form "value > 0.99", the "value" prefix is optional
+func ParseCondition(s string) (Condition, error) {
+	expr := strings.TrimSpace(s)
+	expr = strings.TrimSpace(strings.TrimPrefix(expr, "value"))
+	op, operand := parseComparison(expr)
+	threshold, err := strconv.ParseFloat(operand, 64)
+	if err != nil {
+		return Condition{}, fmt.Errorf("invalid condition %q, expected a comparison like 'value > 0.99'", s)
+	}
+	return Condition{Op: op, Threshold: threshold}, nil
+}
+
+// compare compares a with b using op
+func compare(op string, a, b float64) bool {
+	switch op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case "!=":
+		return a != b
+	default:
+		return a == b
+	}
+}
+
+// Eval returns whether v satisfies the condition
+func (c Condition) Eval(v float64) bool {
+	return compare(c.Op, v, c.Threshold)
+}
+
+// String returns the condition in the form it's parsed from
+func (c Condition) String() string {
+	return fmt.Sprintf("value %s %s", c.Op, strconv.FormatFloat(c.Threshold, 'g', -1, 64))
+}
+
+// RowCount is an expected number of result rows e.g. 3 or >0
+type RowCount struct {
+	Op string
+	N  int
+}
+
+// ParseRowCount parses an expected row count, either an exact count like "3" or a comparison like ">0"
+func ParseRowCount(s string) (RowCount, error) {
+	op, operand := parseComparison(s)
+	n, err := strconv.Atoi(operand)
+	if err != nil || n < 0 {
+		return RowCount{}, fmt.Errorf("invalid row count %q, expected a count like '3' or a comparison like '>0'", s)
+	}
+	return RowCount{Op: op, N: n}, nil
+}
+
+// Eval returns whether n rows satisfies the row count
+func (r RowCount) Eval(n int) bool {
+	return compare(r.Op, float64(n), float64(r.N))
+}
+
+// String returns the row count in the form it's parsed from
+func (r RowCount) String() string {
+	if r.Op == "==" {
+		return strconv.Itoa(r.N)
+	}
+	return r.Op + strconv.Itoa(r.N)
+}
+
+// Sample is the value of a single series that assertions are evaluated against
+type Sample struct {
+	Metric model.Metric
+	Value  float64
+}
+
+// Samples returns a sample per series of a query result
+// Range query results use the last sample of each series
+func Samples(result model.Value) ([]Sample, error) {
+	var samples []Sample
+	switch r := result.(type) {
+	case model.Vector:
+		for _, s := range r {
+			samples = append(samples, Sample{Metric: s.Metric, Value: float64(s.Value)})
+		}
+	case model.Matrix:
+		for _, s := range r {
+			if len(s.Values) == 0 {
+				continue
+			}
+			samples = append(samples, Sample{Metric: s.Metric, Value: float64(s.Values[len(s.Values)-1].Value)})
+		}
+	case *model.Scalar:
+		samples = append(samples, Sample{Metric: model.Metric{}, Value: float64(r.Value)})
+	default:
+		return nil, fmt.Errorf("unable to evaluate assertion: unsupported query result type: %T", r)
+	}
+	return samples, nil
+}
+
+// Check evaluates the condition against samples with the given mode, returning whether it passed and the samples that violated it
+// With ModeAny every sample is a violation when none of them satisfy the condition
+func Check(samples []Sample, c Condition, mode string) (bool, []Sample, error) {
+	var violations []Sample
+	for _, s := range samples {
+		if !c.Eval(s.Value) {
+			violations = append(violations, s)
+		}
+	}
+	switch mode {
+	case "", ModeAll:
+		return len(violations) == 0, violations, nil
+	case ModeAny:
+		if len(violations) < len(samples) {
+			return true, nil, nil
+		}
+		return false, violations, nil
+	default:
+		return false, nil, fmt.Errorf("unknown assertion mode %q, options: %s,%s", mode, ModeAll, ModeAny)
+	}
+}
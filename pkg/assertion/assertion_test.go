@@ -0,0 +1,83 @@
+package assertion
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCondition(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected Condition
+		err      bool
+	}{
+		{input: "value > 0.99", expected: Condition{Op: ">", Threshold: 0.99}},
+		{input: "value>=1", expected: Condition{Op: ">=", Threshold: 1}},
+		{input: "< 0.01", expected: Condition{Op: "<", Threshold: 0.01}},
+		{input: "value != 0", expected: Condition{Op: "!=", Threshold: 0}},
+		{input: "3", expected: Condition{Op: "==", Threshold: 3}},
+		{input: "value > high", err: true},
+		{input: "", err: true},
+	}
+	for i, c := range cases {
+		cond, err := ParseCondition(c.input)
+		if c.err {
+			assert.Error(t, err, "Expected err for case %d", i)
+			continue
+		}
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.expected, cond, "Unexpected output for case %d", i)
+	}
+}
+
+func TestParseRowCount(t *testing.T) {
+	r, err := ParseRowCount(">0")
+	assert.NoError(t, err)
+	assert.False(t, r.Eval(0))
+	assert.True(t, r.Eval(2))
+	r, err = ParseRowCount("3")
+	assert.NoError(t, err)
+	assert.True(t, r.Eval(3))
+	assert.False(t, r.Eval(2))
+	_, err = ParseRowCount("-1")
+	assert.Error(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	now := model.Now()
+	result := model.Matrix{
+		{
+			Metric: model.Metric{"job": "api"},
+			Values: []model.SamplePair{{Timestamp: now, Value: 0.5}, {Timestamp: now + 1, Value: 0.995}},
+		},
+		{
+			Metric: model.Metric{"job": "web"},
+			Values: []model.SamplePair{{Timestamp: now, Value: 0.999}, {Timestamp: now + 1, Value: 0.98}},
+		},
+	}
+	samples, err := Samples(result)
+	assert.NoError(t, err)
+	cond := Condition{Op: ">", Threshold: 0.99}
+	cases := []struct {
+		mode       string
+		passed     bool
+		violations []Sample
+	}{
+		{mode: ModeAll, passed: false, violations: []Sample{{Metric: model.Metric{"job": "web"}, Value: 0.98}}},
+		{mode: ModeAny, passed: true},
+	}
+	for i, c := range cases {
+		passed, violations, err := Check(samples, cond, c.mode)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.passed, passed, "Unexpected result for case %d", i)
+		assert.Equal(t, c.violations, violations, "Unexpected violations for case %d", i)
+	}
+	passed, violations, err := Check(samples, Condition{Op: ">", Threshold: 1}, ModeAny)
+	assert.NoError(t, err)
+	assert.False(t, passed)
+	assert.Len(t, violations, 2)
+	_, _, err = Check(samples, cond, "most")
+	assert.Error(t, err)
+}
@@ -0,0 +1,213 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// contexts reads and writes the named server contexts config file
+package contexts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"gopkg.in/yaml.v3"
+)
+
+// currentContextKey is the key of the selected context in the config file
+const currentContextKey = "current-context"
+
+// contextsKey is the key of the context definitions in the config file
+const contextsKey = "contexts"
+
+// Context is a named set of flag values e.g. host, auth-type, header, step, and output, keyed by flag name
+type Context map[string]interface{}
+
+// File is a contexts config file
+type File struct {
+	Path string `yaml:"-"`
+	// CurrentContext is the context used when none is selected with --context or PROMQL_CONTEXT
+	CurrentContext string             `yaml:"current-context,omitempty"`
+	Contexts       map[string]Context `yaml:"contexts"`
+	// doc is the parsed yaml document, kept so rewriting the file preserves its comments and ordering
+	doc *yaml.Node
+}
+
+// DefaultPath returns the default location of the contexts config file, $XDG_CONFIG_HOME/promql-cli/config.yaml
+// falling back to ~/.config/promql-cli/config.yaml
+func DefaultPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "promql-cli", "config.yaml"), nil
+}
+
+// Load reads the contexts config file at path. A missing file is treated as an empty one
+func Load(path string) (*File, error) {
+	f := &File{Path: path, Contexts: map[string]Context{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading contexts file: %v", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing contexts file %s: %v", path, err)
+	}
+	// An empty file has no document node
+	if len(doc.Content) == 0 {
+		return f, nil
+	}
+	if err := doc.Decode(f); err != nil {
+		return nil, fmt.Errorf("error parsing contexts file %s: %v", path, err)
+	}
+	if f.Contexts == nil {
+		f.Contexts = map[string]Context{}
+	}
+	f.doc = &doc
+	return f, nil
+}
+
+// Names returns the sorted names of all contexts in the file
+func (f *File) Names() []string {
+	names := make([]string, 0, len(f.Contexts))
+	for name := range f.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the named context, erroring with the available context names if it doesn't exist
+func (f *File) Get(name string) (Context, error) {
+	ctx, ok := f.Contexts[name]
+	if !ok {
+		if len(f.Contexts) == 0 {
+			return nil, fmt.Errorf("context %q not found, no contexts are defined in %s", name, f.Path)
+		}
+		return nil, fmt.Errorf("context %q not found in %s, available contexts: %s", name, f.Path, strings.Join(f.Names(), ", "))
+	}
+	if ctx == nil {
+		ctx = Context{}
+	}
+	return ctx, nil
+}
+
+// Use sets the current context, it does not write the file
+func (f *File) Use(name string) error {
+	if _, err := f.Get(name); err != nil {
+		return err
+	}
+	f.CurrentContext = name
+	if f.doc == nil {
+		return nil
+	}
+	root := f.doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("error updating contexts file %s: expected a mapping at the top level", f.Path)
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == currentContextKey {
+			root.Content[i+1].SetString(name)
+			return nil
+		}
+	}
+	// Add the current context ahead of the context definitions
+	key := &yaml.Node{}
+	key.SetString(currentContextKey)
+	value := &yaml.Node{}
+	value.SetString(name)
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+	return nil
+}
+
+// Set adds the named context, replacing any existing context of the same name, it does not write the file
+func (f *File) Set(name string, ctx Context) error {
+	f.Contexts[name] = ctx
+	if f.doc == nil {
+		return nil
+	}
+	root := f.doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("error updating contexts file %s: expected a mapping at the top level", f.Path)
+	}
+	value := &yaml.Node{}
+	if err := value.Encode(ctx); err != nil {
+		return fmt.Errorf("error encoding context %q: %v", name, err)
+	}
+	var contexts *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != contextsKey {
+			continue
+		}
+		// An empty contexts key is null rather than a mapping
+		if root.Content[i+1].Kind != yaml.MappingNode {
+			root.Content[i+1] = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		contexts = root.Content[i+1]
+	}
+	if contexts == nil {
+		key := &yaml.Node{}
+		key.SetString(contextsKey)
+		contexts = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		root.Content = append(root.Content, key, contexts)
+	}
+	for i := 0; i+1 < len(contexts.Content); i += 2 {
+		if contexts.Content[i].Value == name {
+			contexts.Content[i+1] = value
+			return nil
+		}
+	}
+	key := &yaml.Node{}
+	key.SetString(name)
+	contexts.Content = append(contexts.Content, key, value)
+	return nil
+}
+
+// Save writes the file back to its path
+func (f *File) Save() error {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	var err error
+	if f.doc != nil {
+		err = enc.Encode(f.doc)
+	} else {
+		err = enc.Encode(f)
+	}
+	if err != nil {
+		return fmt.Errorf("error encoding contexts file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o755); err != nil {
+		return fmt.Errorf("error writing contexts file: %v", err)
+	}
+	// Contexts may hold credentials, so keep the file private
+	if err := os.WriteFile(f.Path, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("error writing contexts file: %v", err)
+	}
+	return nil
+}
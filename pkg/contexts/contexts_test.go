@@ -0,0 +1,156 @@
+package contexts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testFile = `# staging and production prometheus servers
+current-context: staging
+contexts:
+  # the staging server
+  staging:
+    host: https://staging.prom
+    step: 30s
+  production:
+    host: https://prod.prom
+    header:
+      - "X-Scope-OrgID: prod"
+`
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(testFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(path)
+	assert.Nil(t, err, "Unexpected err loading contexts file")
+	assert.Equal(t, "staging", f.CurrentContext, "Unexpected current context")
+	assert.Equal(t, []string{"production", "staging"}, f.Names(), "Unexpected context names")
+
+	ctx, err := f.Get("production")
+	assert.Nil(t, err, "Unexpected err getting context")
+	assert.Equal(t, "https://prod.prom", ctx["host"], "Unexpected host")
+	assert.Equal(t, []interface{}{"X-Scope-OrgID: prod"}, ctx["header"], "Unexpected headers")
+
+	_, err = f.Get("dev")
+	assert.EqualError(t, err, `context "dev" not found in `+path+`, available contexts: production, staging`)
+
+	// A missing file is empty
+	f, err = Load(filepath.Join(dir, "missing.yaml"))
+	assert.Nil(t, err, "Unexpected err loading missing contexts file")
+	assert.Empty(t, f.Names(), "Unexpected contexts in missing file")
+}
+
+func TestUse(t *testing.T) {
+	cases := []struct {
+		file     string
+		expected string
+	}{
+		{
+			file: testFile,
+			expected: `# staging and production prometheus servers
+current-context: production
+contexts:
+  # the staging server
+  staging:
+    host: https://staging.prom
+    step: 30s
+  production:
+    host: https://prod.prom
+    header:
+      - "X-Scope-OrgID: prod"
+`,
+		},
+		{
+			file: `contexts:
+  production:
+    host: https://prod.prom
+`,
+			expected: `current-context: production
+contexts:
+  production:
+    host: https://prod.prom
+`,
+		},
+	}
+	for i, c := range cases {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(c.file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		f, err := Load(path)
+		assert.Nil(t, err, "Unexpected err for case %d, %v", i, err)
+		err = f.Use("production")
+		assert.Nil(t, err, "Unexpected err for case %d, %v", i, err)
+		err = f.Save()
+		assert.Nil(t, err, "Unexpected err for case %d, %v", i, err)
+		b, err := os.ReadFile(path)
+		assert.Nil(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.expected, string(b), "Unexpected output for case %d", i)
+
+		err = f.Use("dev")
+		assert.NotNil(t, err, "Expected err using a missing context for case %d", i)
+	}
+}
+
+func TestSet(t *testing.T) {
+	cases := []struct {
+		file     string
+		expected string
+	}{
+		// Existing contexts are replaced in place, keeping comments
+		{
+			file: testFile,
+			expected: `# staging and production prometheus servers
+current-context: staging
+contexts:
+  # the staging server
+  staging:
+    host: https://staging.prom
+    step: 30s
+  production:
+    host: https://prod2.prom
+    tenant: prod
+`,
+		},
+		{
+			file: `contexts:
+`,
+			expected: `contexts:
+  production:
+    host: https://prod2.prom
+    tenant: prod
+`,
+		},
+		// A missing file is created
+		{
+			expected: `contexts:
+  production:
+    host: https://prod2.prom
+    tenant: prod
+`,
+		},
+	}
+	for i, c := range cases {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if c.file != "" {
+			if err := os.WriteFile(path, []byte(c.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		f, err := Load(path)
+		assert.Nil(t, err, "Unexpected err for case %d, %v", i, err)
+		err = f.Set("production", Context{"host": "https://prod2.prom", "tenant": "prod"})
+		assert.Nil(t, err, "Unexpected err for case %d, %v", i, err)
+		err = f.Save()
+		assert.Nil(t, err, "Unexpected err for case %d, %v", i, err)
+		b, err := os.ReadFile(path)
+		assert.Nil(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.expected, string(b), "Unexpected output for case %d", i)
+	}
+}
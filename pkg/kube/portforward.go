@@ -0,0 +1,242 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kube port-forwards to prometheus servers running in kubernetes, so they can be queried without running kubectl port-forward alongside
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// readyTimeout is how long we wait for a port-forward to be established
+const readyTimeout = 30 * time.Second
+
+// Service is a port of a kubernetes service, in the form namespace/name:port
+// The namespace defaults to the kubeconfig context's, and the port may be a port name
+type Service struct {
+	Namespace string
+	Name      string
+	Port      string
+}
+
+// ParseService parses a service in the form [namespace/]name:port e.g. monitoring/prometheus-operated:9090
+func ParseService(s string) (Service, error) {
+	var svc Service
+	name, port, ok := strings.Cut(s, ":")
+	if !ok || port == "" {
+		return svc, fmt.Errorf("invalid service %q, expected [namespace/]name:port e.g. monitoring/prometheus-operated:9090", s)
+	}
+	if ns, n, ok := strings.Cut(name, "/"); ok {
+		svc.Namespace, name = ns, n
+	}
+	if name == "" {
+		return svc, fmt.Errorf("invalid service %q, expected [namespace/]name:port e.g. monitoring/prometheus-operated:9090", s)
+	}
+	svc.Name, svc.Port = name, port
+	return svc, nil
+}
+
+func (s Service) String() string {
+	return s.Namespace + "/" + s.Name + ":" + s.Port
+}
+
+// Options selects the cluster to port-forward to
+type Options struct {
+	// Kubeconfig is the path of the kubeconfig file, defaulting to $KUBECONFIG or ~/.kube/config
+	Kubeconfig string
+	// Context is the kubeconfig context to use, defaulting to its current context
+	Context string
+}
+
+// Forward is an established port-forward to a pod of a service
+type Forward struct {
+	// URL is the url of the local end of the forward, which prometheus can be queried at
+	URL      string
+	Pod      string
+	stop     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
+	err      error
+}
+
+// Close tears the port-forward down
+func (f *Forward) Close() {
+	f.stopOnce.Do(func() { close(f.stop) })
+}
+
+// Err returns the last error forwarding a connection, e.g. from the pod refusing it
+// Requests that fail over the forward only see their connection closed, this says why
+func (f *Forward) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+func (f *Forward) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// PortForward port-forwards a local port to a running pod of svc, like kubectl port-forward svc/name does
+// Errors forwarding connections are recorded for Err, errors listening on the local port are written to errOut
+func PortForward(svc Service, opts Options, errOut io.Writer) (*Forward, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = opts.Kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: opts.Context})
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error loading kubeconfig: %v", err)
+	}
+	if svc.Namespace == "" {
+		if svc.Namespace, _, err = clientConfig.Namespace(); err != nil {
+			return nil, fmt.Errorf("error loading kubeconfig: %v", err)
+		}
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating kubernetes client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), readyTimeout)
+	defer cancel()
+	pod, port, err := servicePod(ctx, client, svc)
+	if err != nil {
+		return nil, err
+	}
+	return forwardPod(config, client, pod, port, errOut)
+}
+
+// servicePod returns a running, ready pod of svc and the pod port its service port targets
+func servicePod(ctx context.Context, client kubernetes.Interface, svc Service) (*corev1.Pod, int, error) {
+	s, err := client.CoreV1().Services(svc.Namespace).Get(ctx, svc.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting service %s/%s: %v", svc.Namespace, svc.Name, err)
+	}
+	if len(s.Spec.Selector) == 0 {
+		return nil, 0, fmt.Errorf("service %s/%s has no selector to find its pods with", svc.Namespace, svc.Name)
+	}
+	var servicePort *corev1.ServicePort
+	for i, p := range s.Spec.Ports {
+		if p.Name == svc.Port || strconv.Itoa(int(p.Port)) == svc.Port {
+			servicePort = &s.Spec.Ports[i]
+			break
+		}
+	}
+	if servicePort == nil {
+		return nil, 0, fmt.Errorf("service %s/%s has no port %s", svc.Namespace, svc.Name, svc.Port)
+	}
+
+	pods, err := client.CoreV1().Pods(svc.Namespace).List(ctx, metav1.ListOptions{LabelSelector: labels.SelectorFromSet(s.Spec.Selector).String()})
+	if err != nil {
+		return nil, 0, fmt.Errorf("error listing the pods of service %s/%s: %v", svc.Namespace, svc.Name, err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil || !podReady(pod) {
+			continue
+		}
+		port, err := targetPort(pod, *servicePort)
+		if err != nil {
+			return nil, 0, err
+		}
+		return pod, port, nil
+	}
+	return nil, 0, fmt.Errorf("service %s/%s has no running, ready pods", svc.Namespace, svc.Name)
+}
+
+// podReady returns whether pod's Ready condition is true
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// targetPort returns the port of pod that port targets, looking up named target ports in its containers
+func targetPort(pod *corev1.Pod, port corev1.ServicePort) (int, error) {
+	switch {
+	case port.TargetPort.Type == intstr.Int && port.TargetPort.IntVal == 0:
+		return int(port.Port), nil
+	case port.TargetPort.Type == intstr.Int:
+		return int(port.TargetPort.IntVal), nil
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == port.TargetPort.StrVal {
+				return int(p.ContainerPort), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("pod %s/%s has no port named %s", pod.Namespace, pod.Name, port.TargetPort.StrVal)
+}
+
+// forwardPod port-forwards a random local port to port of pod
+func forwardPod(config *rest.Config, client kubernetes.Interface, pod *corev1.Pod, port int, errOut io.Writer) (*Forward, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating port-forward transport: %v", err)
+	}
+	u := client.CoreV1().RESTClient().Post().Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("portforward").URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, u)
+
+	f := &Forward{Pod: pod.Namespace + "/" + pod.Name, stop: make(chan struct{})}
+	// Errors forwarding connections are only handed to the runtime's error handlers, which log them by default
+	utilruntime.ErrorHandlers = []func(error){f.setErr}
+	ready := make(chan struct{})
+	pf, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{fmt.Sprintf("0:%d", port)}, f.stop, ready, io.Discard, errOut)
+	if err != nil {
+		return nil, fmt.Errorf("error creating port-forward to %s: %v", f.Pod, err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- pf.ForwardPorts()
+	}()
+	select {
+	case <-ready:
+	case err := <-errs:
+		return nil, fmt.Errorf("error port-forwarding to %s: %v", f.Pod, err)
+	case <-time.After(readyTimeout):
+		f.Close()
+		return nil, fmt.Errorf("timed out port-forwarding to %s", f.Pod)
+	}
+	ports, err := pf.GetPorts()
+	if err != nil || len(ports) == 0 {
+		f.Close()
+		return nil, fmt.Errorf("error getting the local port forwarded to %s: %v", f.Pod, err)
+	}
+	f.URL = fmt.Sprintf("http://127.0.0.1:%d", ports[0].Local)
+	return f, nil
+}
@@ -0,0 +1,103 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseService(t *testing.T) {
+	cases := []struct {
+		input     string
+		expected  Service
+		shouldErr bool
+	}{
+		{input: "monitoring/prometheus-operated:9090", expected: Service{Namespace: "monitoring", Name: "prometheus-operated", Port: "9090"}},
+		{input: "prometheus:web", expected: Service{Name: "prometheus", Port: "web"}},
+		{input: "monitoring/prometheus", shouldErr: true},
+		{input: "monitoring/:9090", shouldErr: true},
+		{input: "prometheus:", shouldErr: true},
+	}
+	for i, c := range cases {
+		svc, err := ParseService(c.input)
+		if c.shouldErr {
+			if err == nil {
+				t.Errorf("Expected err for case %d", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected err for case %d, %v", i, err)
+			continue
+		}
+		if svc != c.expected {
+			t.Errorf("Unexpected output for case %d: %+v", i, svc)
+		}
+	}
+}
+
+func testPod(name string, phase corev1.PodPhase, ready corev1.ConditionStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: name, Labels: map[string]string{"app": "prometheus"}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "prometheus",
+			Ports: []corev1.ContainerPort{{Name: "web", ContainerPort: 9090}},
+		}}},
+		Status: corev1.PodStatus{
+			Phase:      phase,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+		},
+	}
+}
+
+func TestServicePod(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "prometheus-operated"},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "prometheus"},
+			Ports: []corev1.ServicePort{
+				{Name: "web", Port: 9090, TargetPort: intstr.FromString("web")},
+				{Name: "http", Port: 80, TargetPort: intstr.FromInt(9091)},
+				{Name: "default", Port: 8080},
+			},
+		},
+	}
+	client := fake.NewSimpleClientset(
+		service,
+		testPod("prometheus-0", corev1.PodPending, corev1.ConditionFalse),
+		testPod("prometheus-1", corev1.PodRunning, corev1.ConditionFalse),
+		testPod("prometheus-2", corev1.PodRunning, corev1.ConditionTrue),
+	)
+	cases := []struct {
+		svc       Service
+		pod       string
+		port      int
+		shouldErr bool
+	}{
+		{svc: Service{Namespace: "monitoring", Name: "prometheus-operated", Port: "9090"}, pod: "prometheus-2", port: 9090},
+		{svc: Service{Namespace: "monitoring", Name: "prometheus-operated", Port: "http"}, pod: "prometheus-2", port: 9091},
+		{svc: Service{Namespace: "monitoring", Name: "prometheus-operated", Port: "8080"}, pod: "prometheus-2", port: 8080},
+		{svc: Service{Namespace: "monitoring", Name: "prometheus-operated", Port: "9093"}, shouldErr: true},
+		{svc: Service{Namespace: "default", Name: "prometheus-operated", Port: "9090"}, shouldErr: true},
+	}
+	for i, c := range cases {
+		pod, port, err := servicePod(context.Background(), client, c.svc)
+		if c.shouldErr {
+			if err == nil {
+				t.Errorf("Expected err for case %d", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected err for case %d, %v", i, err)
+			continue
+		}
+		if pod.Name != c.pod || port != c.port {
+			t.Errorf("Unexpected output for case %d: %s %d", i, pod.Name, port)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// maxErrorBody is the number of bytes of a non api error response body kept in its APIError, e.g. of the html error page of a proxy
+const maxErrorBody = 500
+
+// APIError is the error of a request the server responded to with an error status
+// It unwraps to the *v1.Error the prometheus client would have returned, so api error types can still be checked with errors.As
+type APIError struct {
+	// StatusCode and Status are the http status of the response e.g. 400 and "400 Bad Request"
+	StatusCode int
+	Status     string
+	// Type and Msg are the errorType and error of a prometheus api error response
+	Type v1.ErrorType
+	Msg  string
+	// Body is the start of any other response body, up to maxErrorBody bytes
+	Body string
+}
+
+// newAPIError returns the APIError of an error response with the given body
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	e := &APIError{StatusCode: resp.StatusCode, Status: resp.Status}
+	var r struct {
+		ErrorType v1.ErrorType `json:"errorType"`
+		Error     string       `json:"error"`
+	}
+	if err := json.Unmarshal(body, &r); err == nil && (r.ErrorType != "" || r.Error != "") {
+		e.Type, e.Msg = r.ErrorType, r.Error
+		return e
+	}
+	e.Body = truncateBody(strings.TrimSpace(string(body)), maxErrorBody)
+	return e
+}
+
+// truncateBody truncates body to at most n bytes without splitting a character, marking that it was truncated
+func truncateBody(body string, n int) string {
+	if len(body) <= n {
+		return body
+	}
+	for n > 0 && !utf8.RuneStart(body[n]) {
+		n--
+	}
+	return body[:n] + "..."
+}
+
+func (e *APIError) Error() string {
+	switch {
+	case e.Type != "":
+		return fmt.Sprintf("%s: %s: %s", e.Status, e.Type, e.Msg)
+	case e.Body != "":
+		return fmt.Sprintf("%s: %s", e.Status, e.Body)
+	default:
+		return e.Status
+	}
+}
+
+func (e *APIError) Unwrap() error {
+	t := e.Type
+	if t == "" {
+		t = v1.ErrClient
+		if e.StatusCode/100 == 5 {
+			t = v1.ErrServer
+		}
+	}
+	msg := e.Msg
+	if msg == "" {
+		msg = e.Body
+	}
+	return &v1.Error{Type: t, Msg: msg, Detail: e.Body}
+}
+
+// IsAuthError returns true if err is a request rejected by the server as unauthenticated or forbidden
+func IsAuthError(err error) bool {
+	var e *APIError
+	return errors.As(err, &e) && (e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden)
+}
+
+// apiErrorClient returns the error responses of requests as APIErrors
+// The prometheus client only keeps the error of api error responses, and fails to decode error pages that aren't json
+type apiErrorClient struct {
+	api.Client
+}
+
+func (c apiErrorClient) Do(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	resp, body, err := c.Client.Do(ctx, req)
+	// The errors of our round trippers are wrapped in a *url.Error naming the request's url, which they already describe
+	var (
+		urlErr *url.Error
+		apiErr *APIError
+	)
+	if errors.As(err, &urlErr) && errors.As(urlErr.Err, &apiErr) {
+		return resp, body, urlErr.Err
+	}
+	if err != nil || resp.StatusCode < http.StatusBadRequest {
+		return resp, body, err
+	}
+	// The v1 api retries POST queries as GET requests when servers respond with either of these
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		return resp, body, nil
+	}
+	return resp, body, newAPIError(resp, body)
+}
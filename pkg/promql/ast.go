@@ -0,0 +1,168 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// ExplainAST parses expr with the prometheus promql parser and returns its AST as an indented tree, one node per line
+// Each node is written as its type and what it does, e.g. aggregation: sum by (instance), with its operands below it
+func ExplainAST(expr string) (string, error) {
+	if err := CheckSyntax(expr, 1); err != nil {
+		return "", err
+	}
+	e, err := parser.ParseExpr(expr)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	writeNode(&b, e, "", "")
+	return b.String(), nil
+}
+
+// writeNode writes node and its children to b, prefix is written before the node and indent before each of its children
+func writeNode(b *strings.Builder, node parser.Expr, prefix, indent string) {
+	fmt.Fprintf(b, "%s%s\n", prefix, describeNode(node))
+	children := nodeChildren(node)
+	for i, child := range children {
+		if i == len(children)-1 {
+			writeNode(b, child, indent+"└── ", indent+"    ")
+			continue
+		}
+		writeNode(b, child, indent+"├── ", indent+"│   ")
+	}
+}
+
+// describeNode returns the single line description of node, without its children
+func describeNode(node parser.Expr) string {
+	switch n := node.(type) {
+	case *parser.AggregateExpr:
+		d := "aggregation: " + n.Op.String()
+		if n.Without {
+			d += " without (" + strings.Join(n.Grouping, ", ") + ")"
+		} else if len(n.Grouping) > 0 {
+			d += " by (" + strings.Join(n.Grouping, ", ") + ")"
+		}
+		return d
+	case *parser.BinaryExpr:
+		d := "binary: " + n.Op.String()
+		if n.ReturnBool {
+			d += " bool"
+		}
+		if vm := n.VectorMatching; vm != nil {
+			if vm.On {
+				d += " on (" + strings.Join(vm.MatchingLabels, ", ") + ")"
+			} else if len(vm.MatchingLabels) > 0 {
+				d += " ignoring (" + strings.Join(vm.MatchingLabels, ", ") + ")"
+			}
+			switch vm.Card {
+			case parser.CardManyToOne:
+				d += " group_left"
+			case parser.CardOneToMany:
+				d += " group_right"
+			}
+			if len(vm.Include) > 0 {
+				d += " (" + strings.Join(vm.Include, ", ") + ")"
+			}
+		}
+		return d
+	case *parser.Call:
+		return "call: " + n.Func.Name
+	case *parser.MatrixSelector:
+		return "range: [" + model.Duration(n.Range).String() + "]"
+	case *parser.SubqueryExpr:
+		d := "subquery: [" + model.Duration(n.Range).String() + ":"
+		if n.Step != 0 {
+			d += model.Duration(n.Step).String()
+		}
+		d += "]"
+		if n.OriginalOffset != 0 {
+			d += " offset " + model.Duration(n.OriginalOffset).String()
+		}
+		return d
+	case *parser.VectorSelector:
+		// The selector's String() writes its offset and @ modifiers too
+		return "selector: " + n.String()
+	case *parser.NumberLiteral:
+		return "number: " + n.String()
+	case *parser.StringLiteral:
+		return "string: " + n.String()
+	case *parser.ParenExpr:
+		return "parentheses"
+	case *parser.UnaryExpr:
+		return "unary: " + n.Op.String()
+	case *parser.StepInvariantExpr:
+		return "step invariant"
+	}
+	return fmt.Sprintf("%T: %s", node, node)
+}
+
+// nodeChildren returns the operands of node in the order they're written in the query
+func nodeChildren(node parser.Expr) []parser.Expr {
+	switch n := node.(type) {
+	case *parser.AggregateExpr:
+		// The parameter of count_values, quantile, topk and bottomk comes first
+		if n.Param != nil {
+			return []parser.Expr{n.Param, n.Expr}
+		}
+		return []parser.Expr{n.Expr}
+	case *parser.BinaryExpr:
+		return []parser.Expr{n.LHS, n.RHS}
+	case *parser.Call:
+		return n.Args
+	case *parser.MatrixSelector:
+		return []parser.Expr{n.VectorSelector}
+	case *parser.SubqueryExpr:
+		return []parser.Expr{n.Expr}
+	case *parser.ParenExpr:
+		return []parser.Expr{n.Expr}
+	case *parser.UnaryExpr:
+		return []parser.Expr{n.Expr}
+	case *parser.StepInvariantExpr:
+		return []parser.Expr{n.Expr}
+	}
+	return nil
+}
+
+// sortFunctions are the functions whose results prometheus returns in the order they sort them in
+// The experimental sort_by_label functions aren't included, the parser rejects them unless experimental functions are enabled
+var sortFunctions = map[string]bool{"sort": true, "sort_desc": true}
+
+// SortsResult returns true if expr orders its own result, i.e. it's a call of one of the sort functions
+// The order of such results is meaningful, so they shouldn't be sorted again before they're written. Invalid queries return false
+func SortsResult(expr string) bool {
+	e, err := parser.ParseExpr(expr)
+	if err != nil {
+		return false
+	}
+	for {
+		switch n := e.(type) {
+		case *parser.ParenExpr:
+			e = n.Expr
+		case *parser.StepInvariantExpr:
+			e = n.Expr
+		case *parser.Call:
+			return sortFunctions[n.Func.Name]
+		default:
+			return false
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// HTTPAuth holds the credentials for either basic auth or bearer token auth
+// Passwords and tokens can be read from files, which are trimmed of trailing newlines
+type HTTPAuth struct {
+	Username        string
+	Password        string
+	PasswordFile    string
+	BearerToken     string
+	BearerTokenFile string
+}
+
+// basic returns whether any basic auth options are set
+func (a HTTPAuth) basic() bool {
+	return a.Username != "" || a.Password != "" || a.PasswordFile != ""
+}
+
+// bearer returns whether any bearer token options are set
+func (a HTTPAuth) bearer() bool {
+	return a.BearerToken != "" || a.BearerTokenFile != ""
+}
+
+// readSecretFile reads a password or token from a file, trimming trailing newlines
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// RoundTripper returns rt wrapped to authenticate requests with the configured credentials, or rt if none are set
+func (a HTTPAuth) RoundTripper(rt http.RoundTripper) (http.RoundTripper, error) {
+	switch {
+	case a.basic() && a.bearer():
+		return nil, fmt.Errorf("please specify either basic auth (--user, --username) or a bearer token (--bearer-token, --bearer-token-file), not both")
+	case a.basic():
+		if a.Username == "" {
+			return nil, fmt.Errorf("please specify a username for basic auth with --user or --username")
+		}
+		if a.Password != "" && a.PasswordFile != "" {
+			return nil, fmt.Errorf("please specify either a password or a password file, not both")
+		}
+		password := a.Password
+		if a.PasswordFile != "" {
+			var err error
+			if password, err = readSecretFile(a.PasswordFile); err != nil {
+				return nil, fmt.Errorf("error reading password file: %v", err)
+			}
+		}
+		return &BasicAuthRoundTripper{username: a.Username, password: password, rt: rt}, nil
+	case a.bearer():
+		if a.BearerToken != "" && a.BearerTokenFile != "" {
+			return nil, fmt.Errorf("please specify either a bearer token or a bearer token file, not both")
+		}
+		token := a.BearerToken
+		if a.BearerTokenFile != "" {
+			var err error
+			if token, err = readSecretFile(a.BearerTokenFile); err != nil {
+				return nil, fmt.Errorf("error reading bearer token file: %v", err)
+			}
+		}
+		return &BearerTokenRoundTripper{token: token, rt: rt}, nil
+	default:
+		return rt, nil
+	}
+}
+
+// BasicAuthRoundTripper sets basic auth credentials on every request
+type BasicAuthRoundTripper struct {
+	username string
+	password string
+	rt       http.RoundTripper
+}
+
+func (rt *BasicAuthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	req.SetBasicAuth(rt.username, rt.password)
+	return rt.rt.RoundTrip(req)
+}
+
+// BearerTokenRoundTripper sets a bearer token Authorization header on every request
+type BearerTokenRoundTripper struct {
+	token string
+	rt    http.RoundTripper
+}
+
+func (rt *BearerTokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	req.Header.Set("Authorization", "Bearer "+rt.token)
+	return rt.rt.RoundTrip(req)
+}
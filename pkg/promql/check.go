@@ -0,0 +1,35 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"errors"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// CheckSyntax parses expr with the prometheus promql parser without running it, returning its first syntax error
+// Later errors usually follow from the first one, so they're left out
+// The error is positioned as line:column, counting lines from line, so expressions from a file are positioned in the file
+func CheckSyntax(expr string, line int) error {
+	_, err := parser.ParseExpr(expr)
+	var parseErrs parser.ParseErrors
+	if err == nil || !errors.As(err, &parseErrs) || len(parseErrs) == 0 {
+		return err
+	}
+	parseErrs[0].LineOffset = line - 1
+	return &parseErrs[0]
+}
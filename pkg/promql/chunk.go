@@ -0,0 +1,128 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// chunkResult holds the outcome of a single sub-range query
+type chunkResult struct {
+	matrix   model.Matrix
+	warnings v1.Warnings
+	err      error
+}
+
+// splitRange splits r into sequential sub-ranges of at most chunk duration.
+// The chunk duration is rounded up to a multiple of the step so every sub-range evaluates on the same step grid as r.
+func splitRange(r v1.Range, chunk time.Duration) []v1.Range {
+	if r.Step > 0 && chunk%r.Step != 0 {
+		chunk = (chunk/r.Step + 1) * r.Step
+	}
+	var ranges []v1.Range
+	for s := r.Start; s.Before(r.End); s = s.Add(chunk) {
+		e := s.Add(chunk)
+		if e.After(r.End) {
+			e = r.End
+		}
+		ranges = append(ranges, v1.Range{Start: s, End: e, Step: r.Step})
+	}
+	if len(ranges) == 0 {
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// mergeChunks stitches the matrices from sequential sub-range queries back together per series.
+// Samples that are repeated on chunk boundaries are deduplicated.
+func mergeChunks(results []chunkResult) model.Matrix {
+	var merged model.Matrix
+	series := make(map[model.Fingerprint]*model.SampleStream)
+	for _, c := range results {
+		for _, s := range c.matrix {
+			fp := s.Metric.Fingerprint()
+			existing, ok := series[fp]
+			if !ok {
+				existing = &model.SampleStream{Metric: s.Metric}
+				series[fp] = existing
+				merged = append(merged, existing)
+			}
+			for _, v := range s.Values {
+				if n := len(existing.Values); n > 0 && !v.Timestamp.After(existing.Values[n-1].Timestamp) {
+					continue
+				}
+				existing.Values = append(existing.Values, v)
+			}
+		}
+	}
+	return merged
+}
+
+// chunkedRangeQuery performs a range query as a series of sub-range queries of p.Chunk duration,
+// running up to p.ChunkParallel of them at once, and merges the results.
+func (p *PromQL) chunkedRangeQuery(queryString string, r v1.Range) (model.Matrix, v1.Warnings, error) {
+	ranges := splitRange(r, p.Chunk)
+	results := make([]chunkResult, len(ranges))
+
+	parallel := p.ChunkParallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, cr := range ranges {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, cr v1.Range) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ctx, cancel := p.queryContext()
+			defer cancel()
+			result, warnings, err := p.Client.QueryRange(ctx, queryString, cr)
+			results[i] = chunkResult{warnings: warnings, err: err}
+			if err != nil {
+				return
+			}
+			if m, ok := result.(model.Matrix); ok {
+				results[i].matrix = m
+			} else {
+				results[i].err = fmt.Errorf("did not receive a range result")
+			}
+		}(i, cr)
+	}
+	wg.Wait()
+
+	var (
+		warnings v1.Warnings
+		failed   []string
+	)
+	for i, c := range results {
+		warnings = append(warnings, c.warnings...)
+		if c.err != nil {
+			failed = append(failed, fmt.Sprintf("chunk %d/%d (%s -> %s): %v", i+1, len(ranges), ranges[i].Start.Format(time.RFC3339), ranges[i].End.Format(time.RFC3339), c.err))
+		}
+	}
+	if len(failed) > 0 {
+		return nil, warnings, fmt.Errorf("%d of %d chunks failed:\n%s", len(failed), len(ranges), strings.Join(failed, "\n"))
+	}
+	return mergeChunks(results), warnings, nil
+}
@@ -0,0 +1,242 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDryRun is returned for every request made in dry run mode, after the request is written out instead of being sent
+var ErrDryRun = errors.New("dry run, request not sent")
+
+// secretHeaders are the headers that always hold credentials
+var secretHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+}
+
+// secretHeaderWords mark other headers that likely hold credentials e.g. X-Api-Key
+var secretHeaderWords = []string{"token", "secret", "password", "key", "auth"}
+
+// RedactSecret redacts a secret down to its first 4 characters
+func RedactSecret(s string) string {
+	if len(s) <= 4 {
+		return "****"
+	}
+	return s[:4] + "****"
+}
+
+// isSecretHeader returns whether the header with the canonical name k likely holds credentials
+func isSecretHeader(k string) bool {
+	if secretHeaders[k] {
+		return true
+	}
+	lower := strings.ToLower(k)
+	for _, w := range secretHeaderWords {
+		if strings.Contains(lower, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactHeaders returns a copy of h with the values of headers holding credentials redacted
+// Authorization headers keep their scheme e.g. "Bearer abcd****"
+func RedactHeaders(h http.Header) http.Header {
+	redacted := make(http.Header, len(h))
+	for k, values := range h {
+		for _, v := range values {
+			if isSecretHeader(k) {
+				if scheme, creds, ok := strings.Cut(v, " "); ok && strings.HasSuffix(k, "Authorization") {
+					v = scheme + " " + RedactSecret(creds)
+				} else {
+					v = RedactSecret(v)
+				}
+			}
+			redacted[k] = append(redacted[k], v)
+		}
+	}
+	return redacted
+}
+
+// redactURL returns the url of req with any password redacted
+func redactURL(req *http.Request) string {
+	return RedactURL(req.URL)
+}
+
+// RedactURL returns original with any password redacted
+func RedactURL(original *url.URL) string {
+	u := *original
+	p, ok := u.User.Password()
+	if !ok {
+		return u.String()
+	}
+	// Set the redacted userinfo after encoding as url.URL would escape the *s
+	userinfo := url.User(u.User.Username()).String() + ":" + RedactSecret(url.QueryEscape(p))
+	u.User = nil
+	return strings.Replace(u.String(), "://", "://"+userinfo+"@", 1)
+}
+
+// DryRun is the transport used in dry run mode, it writes out requests instead of sending them
+type DryRun struct {
+	w io.Writer
+	// Curl writes requests out as equivalent curl commands
+	curl     bool
+	mu       sync.Mutex
+	requests int
+}
+
+// NewDryRun creates a dry run transport writing requests to w, as curl commands if curl is set
+func NewDryRun(w io.Writer, curl bool) *DryRun {
+	return &DryRun{w: w, curl: curl}
+}
+
+// Requests returns the number of requests written out
+func (d *DryRun) Requests() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.requests
+}
+
+// RoundTrip writes out req with its secrets redacted and returns ErrDryRun without sending it
+func (d *DryRun) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body string
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = string(b)
+	}
+	headers := RedactHeaders(req.Header)
+	// Host overrides are sent from the request's Host field rather than its headers
+	if req.Host != "" && req.Host != req.URL.Host {
+		headers.Set("Host", req.Host)
+	}
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	if d.curl {
+		fmt.Fprintf(&b, "curl -X %s %s", req.Method, shellQuote(redactURL(req)))
+		for _, k := range keys {
+			for _, v := range headers[k] {
+				fmt.Fprintf(&b, " \\\n  -H %s", shellQuote(k+": "+v))
+			}
+		}
+		if body != "" {
+			fmt.Fprintf(&b, " \\\n  --data %s", shellQuote(body))
+		}
+		b.WriteString("\n")
+	} else {
+		fmt.Fprintf(&b, "%s %s\n", req.Method, redactURL(req))
+		for _, k := range keys {
+			for _, v := range headers[k] {
+				fmt.Fprintf(&b, "%s: %s\n", k, v)
+			}
+		}
+		if body != "" {
+			fmt.Fprintf(&b, "\n%s\n", body)
+		}
+		writeParams(&b, req, body)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.requests > 0 {
+		fmt.Fprintln(d.w)
+	}
+	d.requests++
+	if _, err := io.WriteString(d.w, b.String()); err != nil {
+		return nil, err
+	}
+	return nil, ErrDryRun
+}
+
+// paramOrder is the order the api parameters of a query are written out in dry run mode, any others follow sorted by name
+var paramOrder = []string{"query", "time", "start", "end", "step"}
+
+// timeParams are the api parameters holding unix timestamps, written out as dates as well in dry run mode
+var timeParams = map[string]bool{"time": true, "start": true, "end": true}
+
+// writeParams writes out the decoded query parameters of req and its form encoded body, as comments after the request
+// The query comes first, so the final expression can be read without unescaping the url
+func writeParams(b *strings.Builder, req *http.Request, body string) {
+	params := req.URL.Query()
+	if body != "" && req.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		if form, err := url.ParseQuery(body); err == nil {
+			for k, values := range form {
+				params[k] = append(params[k], values...)
+			}
+		}
+	}
+	if len(params) == 0 {
+		return
+	}
+	var keys, rest []string
+	for _, k := range paramOrder {
+		if _, ok := params[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	for k := range params {
+		if !slices.Contains(paramOrder, k) {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	keys = append(keys, rest...)
+	b.WriteString("\n")
+	for _, k := range keys {
+		for _, v := range params[k] {
+			if t, err := parseUnixTime(v); err == nil && timeParams[k] {
+				v = fmt.Sprintf("%s (%s)", v, t.Format(time.RFC3339))
+			}
+			fmt.Fprintf(b, "# %s: %s\n", k, v)
+		}
+	}
+}
+
+// parseUnixTime parses a unix timestamp in seconds, with optional fractional seconds, as sent to the api
+func parseUnixTime(s string) (time.Time, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)), nil
+}
+
+// shellQuote single quotes s for use as a shell argument
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,154 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dnsSRVPrefix marks a host as a DNS SRV name to resolve to the endpoints to query, e.g. dnssrv+_prometheus._tcp.example.com
+const dnsSRVPrefix = "dnssrv+"
+
+// lookupSRV resolves SRV records, it's a variable so tests can stub it
+var lookupSRV = net.LookupSRV
+
+// IsFailoverHost returns whether host names several endpoints to fail over between, either as a comma separated list or a DNS SRV name
+func IsFailoverHost(host string) bool {
+	return strings.Contains(host, ",") || strings.HasPrefix(host, dnsSRVPrefix)
+}
+
+// ParseEndpoints returns the endpoints of a failover host, in the order they should be tried
+// A comma separated list is tried in order. A DNS SRV name, optionally with a scheme and path e.g. dnssrv+https://_prometheus._tcp.example.com/prometheus,
+// is resolved to its targets ordered by priority and then weight, defaulting to http
+func ParseEndpoints(host string) ([]*url.URL, error) {
+	if !strings.HasPrefix(host, dnsSRVPrefix) {
+		var endpoints []*url.URL
+		for _, h := range strings.Split(host, ",") {
+			u, err := url.Parse(strings.TrimSpace(h))
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return nil, fmt.Errorf("invalid host %q, expected a url e.g. http://prometheus-0:9090", h)
+			}
+			endpoints = append(endpoints, u)
+		}
+		return endpoints, nil
+	}
+
+	name := strings.TrimPrefix(host, dnsSRVPrefix)
+	if !strings.Contains(name, "://") {
+		name = "http://" + name
+	}
+	u, err := url.Parse(name)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid DNS SRV host %q, expected e.g. dnssrv+_prometheus._tcp.example.com", host)
+	}
+	_, records, err := lookupSRV("", "", u.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("error resolving DNS SRV host %s: %v", u.Hostname(), err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("DNS SRV host %s has no records", u.Hostname())
+	}
+	// Lower priorities are tried first, and higher weights first among equal priorities
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Priority != records[j].Priority {
+			return records[i].Priority < records[j].Priority
+		}
+		return records[i].Weight > records[j].Weight
+	})
+	endpoints := make([]*url.URL, 0, len(records))
+	for _, r := range records {
+		e := *u
+		e.Host = net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+		endpoints = append(endpoints, &e)
+	}
+	return endpoints, nil
+}
+
+// FailoverRoundTripper sends requests to the first of several endpoints, e.g. HA prometheus replicas, failing over to the next on connection errors and 5xx responses
+// Requests are made against the first endpoint, and rewritten to the endpoint they're sent to
+// Admin api requests, like deleting series, aren't idempotent and are only sent to the first endpoint
+type FailoverRoundTripper struct {
+	endpoints []*url.URL
+	logger    *log.Logger
+	rt        http.RoundTripper
+}
+
+// NewFailoverRoundTripper returns a FailoverRoundTripper failing over between endpoints, in order, and logging each failover to logger
+func NewFailoverRoundTripper(endpoints []*url.URL, logger *log.Logger, rt http.RoundTripper) *FailoverRoundTripper {
+	return &FailoverRoundTripper{
+		endpoints: endpoints,
+		logger:    logger,
+		rt:        rt,
+	}
+}
+
+func (rt *FailoverRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.Contains(req.URL.Path, "/api/v1/admin/") {
+		return rt.rt.RoundTrip(req)
+	}
+	for i, e := range rt.endpoints {
+		r := cloneRequest(req)
+		u := *req.URL
+		u.Scheme, u.Host = e.Scheme, e.Host
+		u.Path = e.Path + strings.TrimPrefix(req.URL.Path, rt.endpoints[0].Path)
+		u.RawPath = ""
+		r.URL, r.Host = &u, ""
+		if req.Host != "" && req.Host != req.URL.Host {
+			// Keep a Host explicitly set by a header
+			r.Host = req.Host
+		}
+		if i > 0 && req.Body != nil && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+		resp, err := rt.rt.RoundTrip(r)
+		last := i == len(rt.endpoints)-1
+		if err == nil && (resp.StatusCode/100 != 5 || last) {
+			return resp, nil
+		}
+		if err != nil && (last || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+			return nil, err
+		}
+
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = "server returned " + resp.Status
+			// Drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if rt.logger != nil {
+			rt.logger.Printf("Failing over from %s to %s: %s\n", e.Host, rt.endpoints[i+1].Host, reason)
+		}
+	}
+	// Unreachable, the last endpoint always returns
+	return nil, fmt.Errorf("no endpoints to send the request to")
+}
@@ -0,0 +1,60 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// GCPMonitoringHost is the host of Google Cloud Managed Service for Prometheus' query api
+const GCPMonitoringHost = "monitoring.googleapis.com"
+
+// gcpScope is the oauth scope requested for querying Managed Service for Prometheus
+const gcpScope = "https://www.googleapis.com/auth/monitoring.read"
+
+// GCPPrometheusURL returns the url of the prometheus api of a Google Cloud project
+func GCPPrometheusURL(project string) string {
+	return fmt.Sprintf("https://%s/v1/projects/%s/location/global/prometheus", GCPMonitoringHost, url.PathEscape(project))
+}
+
+// IsGCPHost returns whether host is a Managed Service for Prometheus url, which needs Google credentials
+func IsGCPHost(host string) bool {
+	u, err := url.Parse(host)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Hostname(), GCPMonitoringHost)
+}
+
+// NewGCPRoundTripper returns rt wrapped to authenticate requests with Google Application Default Credentials
+// Tokens are fetched when first needed and refreshed when they expire
+func NewGCPRoundTripper(ctx context.Context, rt http.RoundTripper) (http.RoundTripper, error) {
+	creds, err := google.FindDefaultCredentials(ctx, gcpScope)
+	if err != nil {
+		return nil, fmt.Errorf("error finding Google application default credentials, run gcloud auth application-default login or set GOOGLE_APPLICATION_CREDENTIALS: %v", err)
+	}
+	return &oauth2.Transport{
+		Source: oauth2.ReuseTokenSource(nil, creds.TokenSource),
+		Base:   rt,
+	}, nil
+}
@@ -0,0 +1,152 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// apiEnvelope is the body of a prometheus api query response
+type apiEnvelope struct {
+	Status    string          `json:"status"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+	Data      json.RawMessage `json:"data"`
+	Warnings  v1.Warnings     `json:"warnings"`
+}
+
+// queryData is the data of a prometheus api query response
+type queryData struct {
+	ResultType model.ValueType `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// ReadResult reads a saved query result, either a prometheus api query response or the json output of promql
+// Vectors and matrices are told apart by their samples, an empty array is read as an empty vector
+// Error responses are returned as a *v1.Error with the server's error type, and the warnings of api responses are returned with their result
+func ReadResult(r io.Reader) (model.Value, v1.Warnings, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	b = bytes.TrimSpace(b)
+	switch {
+	case len(b) == 0:
+		return nil, nil, errors.New("no result to read, the input is empty")
+	case b[0] == '{':
+		return readAPIResponse(b)
+	case b[0] == '[':
+		v, err := readOutput(b)
+		return v, nil, err
+	default:
+		return nil, nil, errors.New("unknown result format, expected a prometheus api response or promql json output")
+	}
+}
+
+// readAPIResponse reads the result of a prometheus api query response, or of its data object
+func readAPIResponse(b []byte) (model.Value, v1.Warnings, error) {
+	var env apiEnvelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, nil, err
+	}
+	if env.Status == "error" {
+		return nil, env.Warnings, &v1.Error{Type: v1.ErrorType(env.ErrorType), Msg: env.Error}
+	}
+	data := b
+	if env.Data != nil {
+		data = env.Data
+	}
+	var d queryData
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, env.Warnings, err
+	}
+	if d.Result == nil {
+		return nil, env.Warnings, errors.New("unknown result format, the response has no query result")
+	}
+	v, err := decodeResult(d.ResultType, d.Result)
+	return v, env.Warnings, err
+}
+
+// decodeResult decodes a query result of type t
+func decodeResult(t model.ValueType, b []byte) (model.Value, error) {
+	var v model.Value
+	switch t {
+	case model.ValVector:
+		v = &model.Vector{}
+	case model.ValMatrix:
+		v = &model.Matrix{}
+	case model.ValScalar:
+		v = &model.Scalar{}
+	case model.ValString:
+		v = &model.String{}
+	default:
+		return nil, fmt.Errorf("unknown result type %q", t)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, fmt.Errorf("error reading %s result: %w", t, err)
+	}
+	switch r := v.(type) {
+	case *model.Vector:
+		return *r, nil
+	case *model.Matrix:
+		return *r, nil
+	}
+	return v, nil
+}
+
+// readOutput reads promql json output: an array of samples or series, or a scalar or string as a [timestamp, "value"] pair
+func readOutput(b []byte) (model.Value, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(b, &elements); err != nil {
+		return nil, err
+	}
+	if len(elements) == 0 {
+		return model.Vector{}, nil
+	}
+	if elements[0][0] != '{' {
+		// Scalars and strings are both written as [timestamp, "value"], values that aren't numbers are strings
+		var pair []json.RawMessage
+		if err := json.Unmarshal(b, &pair); err != nil || len(pair) != 2 {
+			return nil, errors.New("unknown result format, expected an array of samples or series, or a [timestamp, \"value\"] pair")
+		}
+		var s string
+		if err := json.Unmarshal(pair[1], &s); err != nil {
+			return nil, fmt.Errorf("error reading scalar result: %w", err)
+		}
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return decodeResult(model.ValScalar, b)
+		}
+		return decodeResult(model.ValString, b)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(elements[0], &fields); err != nil {
+		return nil, err
+	}
+	_, values := fields["values"]
+	_, histograms := fields["histograms"]
+	if values || histograms {
+		return decodeResult(model.ValMatrix, b)
+	}
+	return decodeResult(model.ValVector, b)
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Verbosity levels of the LoggingRoundTripper
+const (
+	// VerbosityRequests logs the request line, response status, response time, and bytes received of every request
+	VerbosityRequests = 1
+	// VerbosityBodies also logs request headers with secrets redacted, and request and response bodies
+	VerbosityBodies = 2
+)
+
+// maxLoggedBody is the number of bytes of response bodies logged before they're truncated
+const maxLoggedBody = 4096
+
+// LoggingRoundTripper logs every http round trip, including each retry attempt and redirect
+type LoggingRoundTripper struct {
+	verbosity int
+	logger    *log.Logger
+	rt        http.RoundTripper
+}
+
+// NewLoggingRoundTripper returns a LoggingRoundTripper logging round trips to logger at the given verbosity
+func NewLoggingRoundTripper(verbosity int, logger *log.Logger, rt http.RoundTripper) *LoggingRoundTripper {
+	return &LoggingRoundTripper{
+		verbosity: verbosity,
+		logger:    logger,
+		rt:        rt,
+	}
+}
+
+func (rt *LoggingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.logger.Printf("> %s %s\n", req.Method, redactURL(req))
+	if rt.verbosity >= VerbosityBodies {
+		rt.logHeaders(">", RedactHeaders(req.Header))
+		if req.Body != nil && req.GetBody != nil {
+			if body, err := req.GetBody(); err == nil {
+				b, _ := io.ReadAll(body)
+				body.Close()
+				rt.logger.Printf("> %s\n", b)
+			}
+		}
+	}
+
+	start := time.Now()
+	resp, err := rt.rt.RoundTrip(req)
+	if err != nil {
+		rt.logger.Printf("< error after %s: %v\n", time.Since(start).Round(time.Millisecond), err)
+		return nil, err
+	}
+	// Read the body up front to log its size, client_golang reads whole responses into memory anyway
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		rt.logger.Printf("< %s, error reading body after %s: %v\n", resp.Status, elapsed, err)
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	rt.logger.Printf("< %s (%s, %d bytes)\n", resp.Status, elapsed, len(b))
+	if rt.verbosity >= VerbosityBodies {
+		rt.logHeaders("<", resp.Header)
+		rt.logger.Printf("< %s\n", formatBody(b))
+	}
+	return resp, nil
+}
+
+// logHeaders logs each header value on its own line, sorted by name, with the given direction prefix
+func (rt *LoggingRoundTripper) logHeaders(prefix string, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			rt.logger.Printf("%s %s: %s\n", prefix, k, v)
+		}
+	}
+}
+
+// formatBody pretty prints json response bodies, truncating bodies longer than maxLoggedBody
+func formatBody(b []byte) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", "  "); err == nil {
+		b = buf.Bytes()
+	}
+	if len(b) <= maxLoggedBody {
+		return string(b)
+	}
+	return fmt.Sprintf("%s... (truncated %d bytes)", b[:maxLoggedBody], len(b)-maxLoggedBody)
+}
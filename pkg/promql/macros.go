@@ -0,0 +1,128 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Macros are named sub-expressions referenced in queries as $name or ${name}, and expanded before the query is sent
+type Macros map[string]string
+
+// ParseMacros parses a macros file of name = expr definitions, one per line
+// Indented lines continue the previous definition, and blank lines and lines starting with # are ignored
+func ParseMacros(r io.Reader) (Macros, error) {
+	var (
+		macros = Macros{}
+		last   string
+		line   int
+	)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line++
+		l := strings.TrimSpace(s.Text())
+		switch {
+		case l == "" || strings.HasPrefix(l, "#"):
+			continue
+		case last != "" && (s.Text()[0] == ' ' || s.Text()[0] == '\t'):
+			macros[last] += "\n" + strings.TrimRight(s.Text(), " \t")
+			continue
+		}
+		name, expr, ok := strings.Cut(l, "=")
+		name, expr = strings.TrimSpace(name), strings.TrimSpace(expr)
+		if !ok || !validMacroName(name) || expr == "" {
+			return nil, fmt.Errorf("line %d: invalid macro definition %q, expected name = expr", line, l)
+		}
+		if _, ok := macros[name]; ok {
+			return nil, fmt.Errorf("line %d: macro %s is already defined", line, name)
+		}
+		macros[name] = expr
+		last = name
+	}
+	return macros, s.Err()
+}
+
+// validMacroName returns whether name can be referenced as a $name placeholder
+func validMacroName(name string) bool {
+	if name == "" || !isParamStart(rune(name[0])) {
+		return false
+	}
+	for _, c := range name {
+		if !isParamChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Expand recursively replaces the $name and ${name} macro references in query with their expressions
+// Placeholders inside string literals and those named in vars are left for Substitute, any other placeholder is an undefined macro
+// Expressions are expanded as is, so macros used as operands should be parenthesized in their definition
+func (m Macros) Expand(query string, vars map[string]string) (string, error) {
+	return m.expand(query, vars, nil)
+}
+
+// expand expands the macros in query, where stack is the macros being expanded, to detect cycles
+func (m Macros) expand(query string, vars map[string]string, stack []string) (string, error) {
+	var (
+		b       strings.Builder
+		quote   rune
+		escaped bool
+	)
+	r := []rune(query)
+	for i := 0; i < len(r); i++ {
+		c := r[i]
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && c == '\\' && quote != '`':
+			escaped = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\'' || c == '`'):
+			quote = c
+		case quote == 0 && c == '$':
+			name, end := paramName(r, i+1)
+			if name == "" {
+				break
+			}
+			expr, ok := m[name]
+			if !ok {
+				if _, ok := vars[name]; ok {
+					break
+				}
+				return "", fmt.Errorf("undefined macro $%s", name)
+			}
+			for j, s := range stack {
+				if s == name {
+					return "", fmt.Errorf("macro cycle: $%s -> $%s", strings.Join(stack[j:], " -> $"), name)
+				}
+			}
+			expanded, err := m.expand(expr, vars, append(stack, name))
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(expanded)
+			i = end - 1
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The methods queries can be sent with, see MethodRoundTripper
+const (
+	MethodAuto = "auto"
+	MethodGet  = "get"
+	MethodPost = "post"
+)
+
+// DefaultMaxURLLength is the longest GET url auto sends queries with, below the 8KB limit common to load balancers and proxies with room to spare
+const DefaultMaxURLLength = 4096
+
+// ParseMethod validates a query method
+func ParseMethod(method string) (string, error) {
+	switch m := strings.ToLower(method); m {
+	case "":
+		return MethodAuto, nil
+	case MethodAuto, MethodGet, MethodPost:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown http method %q. Options: %s,%s,%s", method, MethodAuto, MethodGet, MethodPost)
+	}
+}
+
+// MethodRoundTripper sends instant and range queries as GET or form encoded POST requests
+// With MethodAuto queries are sent as GET requests, unless their url would be longer than maxURLLength (e.g. for long generated queries), where they're POSTed instead
+type MethodRoundTripper struct {
+	method       string
+	maxURLLength int
+	rt           http.RoundTripper
+}
+
+// NewMethodRoundTripper returns a MethodRoundTripper sending queries with method
+func NewMethodRoundTripper(method string, maxURLLength int, rt http.RoundTripper) *MethodRoundTripper {
+	if maxURLLength <= 0 {
+		maxURLLength = DefaultMaxURLLength
+	}
+	return &MethodRoundTripper{
+		method:       method,
+		maxURLLength: maxURLLength,
+		rt:           rt,
+	}
+}
+
+func (rt *MethodRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isQueryPath(req.URL.Path) || (req.Method != http.MethodGet && req.Method != http.MethodPost) {
+		return rt.rt.RoundTrip(req)
+	}
+	params, err := requestParams(req)
+	if err != nil {
+		return nil, err
+	}
+	u := *req.URL
+	u.RawQuery = params.Encode()
+	method := rt.method
+	if method == MethodAuto {
+		method = MethodGet
+		if len(u.String()) > rt.maxURLLength {
+			method = MethodPost
+		}
+	}
+
+	req = cloneRequest(req)
+	if method == MethodGet {
+		req.Method = http.MethodGet
+		req.URL = &u
+		req.Body, req.GetBody, req.ContentLength = nil, nil, 0
+		req.Header.Del("Content-Type")
+		return rt.rt.RoundTrip(req)
+	}
+	body := params.Encode()
+	u.RawQuery = ""
+	req.Method = http.MethodPost
+	req.URL = &u
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(body)), nil
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return rt.rt.RoundTrip(req)
+}
+
+// isQueryPath returns whether path is the instant or range query endpoint, the endpoints that accept both GET and form encoded POST requests
+func isQueryPath(path string) bool {
+	return strings.HasSuffix(path, "/api/v1/query") || strings.HasSuffix(path, "/api/v1/query_range")
+}
+
+// requestParams returns the parameters of a query request from its url and form encoded body
+func requestParams(req *http.Request) (url.Values, error) {
+	params := req.URL.Query()
+	if req.Body == nil || req.Body == http.NoBody {
+		return params, nil
+	}
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing query request body: %v", err)
+	}
+	for k, values := range form {
+		for _, v := range values {
+			params.Add(k, v)
+		}
+	}
+	return params, nil
+}
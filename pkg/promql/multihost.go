@@ -0,0 +1,176 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// DefaultHostLabel is the label added to every series of a multi host query to identify the host it came from
+const DefaultHostLabel = "prom_host"
+
+// HostTarget is a named prometheus server queried as part of a multi host query
+type HostTarget struct {
+	Name string
+	Host string
+}
+
+// ParseHosts parses a list of hosts in the form name=url or url.
+// Hosts without a name are named after the host portion of their url.
+func ParseHosts(hosts []string) ([]HostTarget, error) {
+	targets := make([]HostTarget, 0, len(hosts))
+	names := make(map[string]struct{}, len(hosts))
+	for _, h := range hosts {
+		var t HostTarget
+		if name, host, ok := strings.Cut(h, "="); ok && !strings.Contains(name, "/") {
+			t = HostTarget{Name: name, Host: host}
+		} else {
+			u, err := url.Parse(h)
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("invalid host %q, expected name=url or url", h)
+			}
+			t = HostTarget{Name: u.Host, Host: h}
+		}
+		if _, ok := names[t.Name]; ok {
+			return nil, fmt.Errorf("duplicate host name %q", t.Name)
+		}
+		names[t.Name] = struct{}{}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// HostErrors holds the errors of the hosts that failed during a multi host query, keyed by host name
+type HostErrors map[string]error
+
+func (e HostErrors) Error() string {
+	errs := make([]string, 0, len(e))
+	for _, n := range e.hosts() {
+		errs = append(errs, fmt.Sprintf("%s: %v", n, e[n]))
+	}
+	return fmt.Sprintf("%d host(s) failed: %s", len(e), strings.Join(errs, "; "))
+}
+
+// hosts returns the names of the failed hosts in order
+func (e HostErrors) hosts() []string {
+	names := make([]string, 0, len(e))
+	for n := range e {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hostResult is the outcome of a query against a single host of a multi host query
+type hostResult struct {
+	target   HostTarget
+	result   model.Value
+	warnings v1.Warnings
+	err      error
+}
+
+// multiHostQuery runs query concurrently against every host in p.Hosts, labels each series with the name of its host,
+// and merges the results. Hosts returning both vectors and matrices are an error, as they can't be merged. Failed hosts are reported as warnings unless p.RequireAllHosts is set (or every host failed),
+// in which case a HostErrors is returned.
+func (p *PromQL) multiHostQuery(query func(p *PromQL) (model.Value, v1.Warnings, error)) (model.Value, v1.Warnings, error) {
+	label := model.LabelName(p.HostLabel)
+	if label == "" {
+		label = DefaultHostLabel
+	}
+	results := make([]hostResult, len(p.Hosts))
+	var wg sync.WaitGroup
+	for i, t := range p.Hosts {
+		wg.Add(1)
+		go func(i int, t HostTarget) {
+			defer wg.Done()
+			results[i].target = t
+			hp := *p
+			hp.Hosts = nil
+			hp.Host = t.Host
+			cl, err := CreateAPIClientWithOptions(hp.Host, hp.Auth, hp.TLSConfig, hp.ClientOptions)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			hp.APIClient = cl
+			hp.Client = v1.NewAPI(cl)
+			results[i].result, results[i].warnings, results[i].err = query(&hp)
+		}(i, t)
+	}
+	wg.Wait()
+
+	var (
+		warnings v1.Warnings
+		vector   model.Vector
+		matrix   model.Matrix
+		// The hosts that returned each kind of result, as vectors and matrices can't be merged
+		vectorHosts, matrixHosts []string
+	)
+	errs := HostErrors{}
+	for _, r := range results {
+		for _, w := range r.warnings {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", r.target.Name, w))
+		}
+		if r.err != nil {
+			errs[r.target.Name] = r.err
+			continue
+		}
+		switch v := r.result.(type) {
+		case model.Vector:
+			for _, s := range v {
+				s.Metric[label] = model.LabelValue(r.target.Name)
+			}
+			vector = append(vector, v...)
+			vectorHosts = append(vectorHosts, r.target.Name)
+		case model.Matrix:
+			matrixHosts = append(matrixHosts, r.target.Name)
+			for _, s := range v {
+				s.Metric[label] = model.LabelValue(r.target.Name)
+			}
+			matrix = append(matrix, v...)
+		case *model.Scalar:
+			// Scalars have no labels to tell hosts apart, so each becomes a sample labelled with its host
+			vector = append(vector, &model.Sample{
+				Metric:    model.Metric{label: model.LabelValue(r.target.Name)},
+				Value:     v.Value,
+				Timestamp: v.Timestamp,
+			})
+			vectorHosts = append(vectorHosts, r.target.Name)
+		default:
+			errs[r.target.Name] = fmt.Errorf("unable to merge %s results across hosts", r.result.Type())
+		}
+	}
+	if len(errs) > 0 && (p.RequireAllHosts || len(errs) == len(results)) {
+		return nil, warnings, errs
+	}
+	for _, name := range errs.hosts() {
+		warnings = append(warnings, fmt.Sprintf("%s: partial results, query failed: %v", name, errs[name]))
+	}
+	if len(matrixHosts) > 0 && len(vectorHosts) > 0 {
+		return nil, warnings, fmt.Errorf("unable to merge results across hosts: %s returned instant vectors but %s returned range vectors", strings.Join(vectorHosts, ", "), strings.Join(matrixHosts, ", "))
+	}
+	if len(matrixHosts) > 0 {
+		return matrix, warnings, nil
+	}
+	return vector, warnings, nil
+}
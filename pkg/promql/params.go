@@ -0,0 +1,185 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SavedQuery is a named query from the config file, run with the run command
+type SavedQuery struct {
+	Description string `mapstructure:"description"`
+	// Query is the PromQL expression, which may contain $param or ${param} placeholders
+	Query string `mapstructure:"query"`
+	// Params are the default values of the query's placeholders
+	Params map[string]string `mapstructure:"params"`
+}
+
+// Render substitutes params, falling back to the saved query's defaults, into the query
+// Param names are matched case insensitively, as config keys (and so the default params) are lowercased when read
+func (q SavedQuery) Render(params map[string]string) (string, error) {
+	values := make(map[string]string, len(q.Params)+len(params))
+	for k, v := range q.Params {
+		values[strings.ToLower(k)] = v
+	}
+	for k, v := range params {
+		values[strings.ToLower(k)] = v
+	}
+	return substitute(q.Query, func(name string) (string, bool) {
+		v, ok := values[strings.ToLower(name)]
+		return v, ok
+	})
+}
+
+// bareParamValue matches the values that are safe to substitute outside of a string literal e.g. durations, numbers, and label names
+var bareParamValue = regexp.MustCompile(`^[a-zA-Z0-9_:.]+$`)
+
+// ParseParams parses a list of key=value parameters
+func ParseParams(params []string) (map[string]string, error) {
+	p := make(map[string]string, len(params))
+	for _, param := range params {
+		parts := strings.SplitN(param, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid param format %q, expected key=value", param)
+		}
+		p[parts[0]] = parts[1]
+	}
+	return p, nil
+}
+
+// Substitute replaces the $name and ${name} placeholders in query with their values from params.
+// Values substituted into a string literal (e.g. a label matcher) are escaped so they can't end the string,
+// and values substituted anywhere else must be a bare duration, number, or name so they can't change the query's structure.
+// Unresolved placeholders in string literals are left as they are, as they're also how regex group references are written.
+func Substitute(query string, params map[string]string) (string, error) {
+	return substitute(query, func(name string) (string, bool) {
+		v, ok := params[name]
+		return v, ok
+	})
+}
+
+// substitute replaces the placeholders in query with the values returned by lookup
+func substitute(query string, lookup func(name string) (string, bool)) (string, error) {
+	var (
+		b          strings.Builder
+		quote      rune
+		escaped    bool
+		unresolved = map[string]struct{}{}
+	)
+	r := []rune(query)
+	for i := 0; i < len(r); i++ {
+		c := r[i]
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && c == '\\' && quote != '`':
+			escaped = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\'' || c == '`'):
+			quote = c
+		case c == '$':
+			name, end := paramName(r, i+1)
+			if name == "" {
+				break
+			}
+			value, ok := lookup(name)
+			if !ok && quote != 0 {
+				// Left as is, e.g. the $1 or $name group references of label_replace replacements
+				b.WriteString(string(r[i:end]))
+				i = end - 1
+				continue
+			}
+			i = end - 1
+			if !ok {
+				unresolved[name] = struct{}{}
+				continue
+			}
+			v, err := escapeParam(name, value, quote)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			continue
+		}
+		b.WriteRune(c)
+	}
+	if len(unresolved) > 0 {
+		names := make([]string, 0, len(unresolved))
+		for name := range unresolved {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("unresolved query variables: %s", strings.Join(names, ", "))
+	}
+	return b.String(), nil
+}
+
+// escapeParam escapes a param value for the string literal quoted with quote, or validates it if it's outside of one
+func escapeParam(name, value string, quote rune) (string, error) {
+	switch quote {
+	case 0:
+		if !bareParamValue.MatchString(value) {
+			return "", fmt.Errorf("invalid value %q for param %s, values used outside of quotes may only contain letters, numbers, '_', ':', and '.'", value, name)
+		}
+		return value, nil
+	case '`':
+		if strings.ContainsRune(value, '`') {
+			return "", fmt.Errorf("invalid value %q for param %s, values used in raw strings can't contain '`'", value, name)
+		}
+		return value, nil
+	default:
+		value = strings.ReplaceAll(value, `\`, `\\`)
+		value = strings.ReplaceAll(value, string(quote), `\`+string(quote))
+		value = strings.ReplaceAll(value, "\n", `\n`)
+		return value, nil
+	}
+}
+
+// paramName returns the name of the $name or ${name} placeholder starting at r[start], just after the $,
+// and the index following it. The name is empty if there's no placeholder at start
+func paramName(r []rune, start int) (string, int) {
+	braced := start < len(r) && r[start] == '{'
+	if braced {
+		start++
+	}
+	if start >= len(r) || !isParamStart(r[start]) {
+		return "", start
+	}
+	end := start
+	for end < len(r) && isParamChar(r[end]) {
+		end++
+	}
+	name := string(r[start:end])
+	if braced {
+		if end >= len(r) || r[end] != '}' {
+			return "", start
+		}
+		end++
+	}
+	return name, end
+}
+
+func isParamStart(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isParamChar(c rune) bool {
+	return isParamStart(c) || (c >= '0' && c <= '9')
+}
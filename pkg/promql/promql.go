@@ -18,18 +18,28 @@ limitations under the License.
 package promql
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/common/model"
 	"github.com/spf13/viper"
+
+	"github.com/nalbury/promql-cli/pkg/util"
 ) // Client is our prometheus v1 API interface
 type Client interface {
 	v1.API
@@ -59,6 +69,11 @@ func cloneRequest(r *http.Request) *http.Request {
 func (rt *HeadersRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	req = cloneRequest(req)
 	for k, v := range rt.headers {
+		// The Host header is ignored by the transport, it's sent from the request's Host field instead
+		if k == "Host" {
+			req.Host = v[len(v)-1]
+			continue
+		}
 		for _, val := range v {
 			req.Header.Add(k, val)
 		}
@@ -66,55 +81,243 @@ func (rt *HeadersRoundTripper) RoundTrip(req *http.Request) (*http.Response, err
 	return rt.rt.RoundTrip(req)
 }
 
+// headerFlags returns the raw values of the header flag, or of a header list in the config file or context
+// A single header set as a string (e.g. in the PROMQL_HEADER env var) isn't split on its spaces
+func headerFlags() []string {
+	if h, ok := viper.Get("header").(string); ok {
+		if h == "" {
+			return nil
+		}
+		return []string{h}
+	}
+	return viper.GetStringSlice("header")
+}
+
+// ParseHeaders parses headers using the header syntax from curl, where "Name: value" is passed as a string
+// Headers are split on their first colon only, so values can contain colons
+func ParseHeaders(raw []string) (http.Header, error) {
+	headers := http.Header{}
+	for _, header := range raw {
+		name, value, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header format: %s, expected 'Name: value'", header)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
 // CreateClientWithAuth creates a Client interface witht the provided hostname and auth config
 func CreateClientWithAuth(host string, authCfg config.Authorization, tlsCfg config.TLSConfig) (v1.API, error) {
+	a, err := CreateAPIClientWithAuth(host, authCfg, tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	return v1.NewAPI(a), nil
+}
+
+// ClientOptions holds the optional transport settings used when creating a client
+type ClientOptions struct {
+	// Retries is the number of times a failed request is retried
+	Retries int
+	// Logger receives client diagnostics like retry attempts, nothing is logged if it's nil
+	Logger *log.Logger
+	// DryRun writes out requests instead of sending them when set
+	DryRun *DryRun
+	// Verbosity logs http round trips to Logger, see VerbosityRequests and VerbosityBodies
+	Verbosity int
+	// Timing accumulates the timings of every request when set
+	Timing *Timing
+	// QueryParams are extra query parameters added to every request, e.g. see ThanosParams
+	QueryParams url.Values
+	// HTTPAuth authenticates requests with basic auth or a bearer token, it can't be combined with an auth config
+	HTTPAuth HTTPAuth
+	// Tenant is sent as the X-Scope-OrgID header of multi-tenant servers like cortex, mimir, and thanos, e.g. "team-a|team-b"
+	Tenant string
+	// SigV4 signs requests with AWS credentials, e.g. for Amazon Managed Prometheus, it can't be combined with other auth
+	SigV4 SigV4
+	// GCPAuth authenticates requests with Google Application Default Credentials, it's also used for Managed Service for Prometheus hosts without other auth
+	GCPAuth bool
+	// Proxy is the http or socks5 proxy requests are sent through, overriding the HTTPS_PROXY, HTTP_PROXY, and NO_PROXY env vars
+	Proxy *url.URL
+	// Method is how instant and range queries are sent, see MethodRoundTripper. The v1 client's POST with GET fallback is used when it's empty
+	Method string
+	// MaxURLLength is the longest url MethodAuto sends GET requests with, defaulting to DefaultMaxURLLength
+	MaxURLLength int
+	// NoCompress disables requesting gzipped responses, e.g. to debug responses with the raw bytes sent
+	NoCompress bool
+	// UserAgent is sent as the User-Agent header of every request, unless it's set as a custom header
+	UserAgent string
+	// RequestIDs stamps every request with a fresh id when set
+	RequestIDs *RequestIDs
+	// RateLimiter limits how often requests are sent when set, it's shared by every client created with it
+	RateLimiter *RateLimiter
+	// UnixSocket is the path of a unix socket requests to the host are sent over, keeping the host's name and path in the requests
+	UnixSocket string
+}
+
+// maxIdleConnsPerHost is the number of idle connections kept per host, http.Transport's default of 2 is exceeded by parallel chunks
+const maxIdleConnsPerHost = 16
+
+// CreateAPIClientWithAuth creates the underlying HTTP API client with the provided hostname and auth config.
+// It's used directly for endpoints that aren't part of the v1 API (e.g. /federate)
+func CreateAPIClientWithAuth(host string, authCfg config.Authorization, tlsCfg config.TLSConfig) (api.Client, error) {
+	return CreateAPIClientWithOptions(host, authCfg, tlsCfg, ClientOptions{})
+}
+
+// CreateAPIClientWithOptions creates the underlying HTTP API client with the provided hostname, auth config, and transport options
+func CreateAPIClientWithOptions(host string, authCfg config.Authorization, tlsCfg config.TLSConfig, opts ClientOptions) (api.Client, error) {
 	cfg := api.Config{
 		Address: host,
 	}
+	if err := ValidateTLSConfig(tlsCfg); err != nil {
+		return nil, err
+	}
 	tc, err := config.NewTLSConfig(&tlsCfg)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing TLS config, %s", err)
 	}
+	var endpoints []*url.URL
+	if IsFailoverHost(host) {
+		if endpoints, err = ParseEndpoints(host); err != nil {
+			return nil, err
+		}
+		cfg.Address = endpoints[0].String()
+	}
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != nil {
+		proxy = http.ProxyURL(opts.Proxy)
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	dial := dialer.DialContext
+	if socket, ok := UnixSocketPath(host); ok {
+		if opts.Proxy != nil {
+			return nil, fmt.Errorf("please specify either a unix socket host or --proxy, not both")
+		}
+		if opts.UnixSocket != "" {
+			return nil, fmt.Errorf("please specify either a unix socket host or --unix-socket, not both")
+		}
+		dial = unixSocketDialer(dialer, socket)
+		cfg.Address = unixSocketHost
+		// Requests over the socket never go through a proxy
+		proxy = nil
+	}
+	if opts.UnixSocket != "" {
+		if opts.Proxy != nil {
+			return nil, fmt.Errorf("please specify either --unix-socket or --proxy, not both")
+		}
+		dial = unixSocketDialer(dialer, opts.UnixSocket)
+		proxy = nil
+	}
+	// Keep enough idle connections to reuse across parallel chunks and repeated queries, e.g. wait's polling
+	// Responses are requested gzipped, and decompressed by the transport, unless compression is disabled
 	var rt http.RoundTripper = &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
+		Proxy:               proxy,
+		DialContext:         dial,
 		TLSHandshakeTimeout: 10 * time.Second,
 		TLSClientConfig:     tc,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		IdleConnTimeout:     90 * time.Second,
+		DisableCompression:  opts.NoCompress,
+	}
+	if proxy != nil {
+		rt = NewProxyErrorRoundTripper(proxy, rt)
 	}
 
-	if viper.GetStringSlice("header") != nil {
-		headers := http.Header{}
-		for _, header := range viper.GetStringSlice("header") {
-			parts := strings.SplitN(header, ":", 2)
-			if len(parts) != 2 {
-				return nil, fmt.Errorf("invalid header format: %s", header)
-			}
-			headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	if opts.Timing != nil {
+		rt = NewTimingRoundTripper(opts.Timing, rt)
+	}
+
+	// Log round trips right above the transport so every retry attempt and redirect is logged with the headers actually sent
+	if opts.Verbosity > 0 && opts.Logger != nil {
+		rt = NewLoggingRoundTripper(opts.Verbosity, opts.Logger, rt)
+	}
+
+	// Limit requests below retries and failover, so every request actually sent counts against the limit
+	if opts.RateLimiter != nil {
+		rt = NewRateLimitRoundTripper(opts.RateLimiter, rt)
+	}
+
+	// There's nothing to send in dry run mode, where requests are written out in place of the transport
+	if opts.DryRun != nil {
+		rt = opts.DryRun
+	}
+
+	// Sign requests below failover and retries, so each request is signed for the endpoint it's failed over to and signed again when it's retried
+	// It's above dry run, so dry runs show the signed requests
+	if opts.SigV4.Enabled() && (authCfg != (config.Authorization{}) || opts.HTTPAuth != (HTTPAuth{})) {
+		return nil, fmt.Errorf("please specify either --sigv4-region, --sigv4-profile or --sigv4-role-arn or other authentication, not both")
+	}
+	rt, err = opts.SigV4.RoundTripper(rt)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun == nil {
+		// Fail over between endpoints below retries, so each retry attempt starts from the first endpoint again
+		if len(endpoints) > 1 {
+			rt = NewFailoverRoundTripper(endpoints, opts.Logger, rt)
 		}
+		// Retry failed requests below everything but signing, so every attempt carries our headers and auth
+		if opts.Retries > 0 {
+			rt = NewRetryRoundTripper(opts.Retries, opts.Logger, rt)
+		}
+	}
 
+	// Pick the method below everything that adds query parameters, and above dry run and logging so they show the method actually used
+	if opts.Method != "" {
+		rt = NewMethodRoundTripper(opts.Method, opts.MaxURLLength, rt)
+	}
+
+	if len(opts.QueryParams) > 0 {
+		rt = NewQueryParamsRoundTripper(opts.QueryParams, rt)
+	}
+
+	// Hint at --tenant below our headers, so only requests that were really sent without one get the hint
+	rt = NewTenantHintRoundTripper(rt)
+
+	if opts.RequestIDs != nil {
+		rt = NewRequestIDRoundTripper(opts.RequestIDs, rt)
+	}
+
+	headers, err := ParseHeaders(headerFlags())
+	if err != nil {
+		return nil, err
+	}
+	if opts.Tenant != "" {
+		headers.Set(TenantHeader, opts.Tenant)
+	}
+	if opts.UserAgent != "" && headers.Get("User-Agent") == "" {
+		headers.Set("User-Agent", opts.UserAgent)
+	}
+	if len(headers) > 0 {
 		rt = &HeadersRoundTripper{
 			headers: headers,
 			rt:      rt,
 		}
 	}
 
-	if viper.GetStringSlice("header") != nil {
-		headers := http.Header{}
-		// Use header syntax from curl where key:value is passed as a string
-		for _, header := range viper.GetStringSlice("header") {
-			parts := strings.SplitN(header, ":", 2)
-			if len(parts) != 2 {
-				return nil, fmt.Errorf("invalid header format: %s", header)
-			}
-			headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
-		}
+	if authCfg != (config.Authorization{}) && opts.HTTPAuth != (HTTPAuth{}) {
+		return nil, fmt.Errorf("please specify either --auth-type credentials or basic auth and bearer token flags, not both")
+	}
+	rt, err = opts.HTTPAuth.RoundTripper(rt)
+	if err != nil {
+		return nil, err
+	}
 
-		rt = &HeadersRoundTripper{
-			headers: headers,
-			rt:      rt,
+	otherAuth := authCfg != (config.Authorization{}) || opts.HTTPAuth != (HTTPAuth{}) || opts.SigV4.Enabled()
+	if opts.GCPAuth && otherAuth {
+		return nil, fmt.Errorf("please specify either --gcp-auth or other authentication, not both")
+	}
+	if opts.GCPAuth || (IsGCPHost(host) && !otherAuth) {
+		if rt, err = NewGCPRoundTripper(context.Background(), rt); err != nil {
+			return nil, err
 		}
 	}
 
@@ -131,11 +334,11 @@ func CreateClientWithAuth(host string, authCfg config.Authorization, tlsCfg conf
 		}
 	}
 	cfg.RoundTripper = rt
-	a, err := api.NewClient(cfg)
+	client, err := api.NewClient(cfg)
 	if err != nil {
 		return nil, err
 	}
-	return v1.NewAPI(a), nil
+	return apiErrorClient{client}, nil
 }
 
 // Cfg conatins the final configuration params parsed from a combo of flags, config file values, and env vars.
@@ -148,27 +351,93 @@ type PromQL struct {
 	Time            time.Time
 	Start           string
 	End             string
+	Chunk           time.Duration
+	ChunkParallel   int
 	NoHeaders       bool
 	Auth            config.Authorization
 	Client          v1.API
+	APIClient       api.Client
 	TLSConfig       config.TLSConfig
+	ClientOptions   ClientOptions
+	// Context is the parent context of every query, cancel it to abort queries in flight e.g. on Ctrl-C. Defaults to context.Background()
+	Context context.Context
+	// Hosts fans queries out to multiple servers, merging their results, when set
+	Hosts           []HostTarget
+	HostLabel       string
+	RequireAllHosts bool
+}
+
+// queryContext returns the context of a single query, done once TimeoutDuration has passed or Context is cancelled
+func (p *PromQL) queryContext() (context.Context, context.CancelFunc) {
+	parent := p.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	return context.WithTimeout(parent, p.TimeoutDuration)
 }
 
 // InstantQuery performs an instant query and returns the result
-func (p *PromQL) InstantQuery(queryString string) (model.Vector, v1.Warnings, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), p.TimeoutDuration)
+// The result is usually a model.Vector, but may also be a model.Matrix (e.g. for a range selector like up[5m]), *model.Scalar, or *model.String
+func (p *PromQL) InstantQuery(queryString string) (model.Value, v1.Warnings, error) {
+	if len(p.Hosts) > 0 {
+		return p.multiHostQuery(func(hp *PromQL) (model.Value, v1.Warnings, error) {
+			return hp.InstantQuery(queryString)
+		})
+	}
+	ctx, cancel := p.queryContext()
 	defer cancel()
 
 	result, warnings, err := p.Client.Query(ctx, queryString, p.Time)
+	if err != nil && strings.Contains(err.Error(), `unexpected value type "string"`) {
+		result, warnings, err = p.stringQuery(ctx, queryString)
+	}
 	if err != nil {
-		return nil, warnings, fmt.Errorf("error querying prometheus: %v", err)
+		return nil, warnings, fmt.Errorf("error querying prometheus: %w", err)
 	}
+	return result, warnings, nil
+}
 
-	if result, ok := result.(model.Vector); ok {
-		return result, warnings, nil
-	} else {
-		return nil, warnings, fmt.Errorf("did not receive an instant vector result")
+// stringQuery performs an instant query with a string result
+// The v1 client can't decode string results, so we make the request and decode the response ourselves
+func (p *PromQL) stringQuery(ctx context.Context, queryString string) (model.Value, v1.Warnings, error) {
+	if p.APIClient == nil {
+		return nil, nil, fmt.Errorf("unable to decode string result: no api client")
 	}
+	u := p.APIClient.URL("/api/v1/query", nil)
+	q := u.Query()
+	q.Set("query", queryString)
+	if !p.Time.IsZero() {
+		q.Set("time", strconv.FormatFloat(float64(p.Time.UnixNano())/1e9, 'f', -1, 64))
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, body, err := p.APIClient.Do(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	var resp struct {
+		Status    string      `json:"status"`
+		Error     string      `json:"error"`
+		ErrorType string      `json:"errorType"`
+		Warnings  v1.Warnings `json:"warnings"`
+		Data      struct {
+			ResultType model.ValueType `json:"resultType"`
+			Result     model.String    `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, nil, err
+	}
+	if resp.Status != "success" {
+		return nil, resp.Warnings, &v1.Error{Type: v1.ErrorType(resp.ErrorType), Msg: resp.Error}
+	}
+	if resp.Data.ResultType != model.ValString {
+		return nil, resp.Warnings, fmt.Errorf("unexpected value type %q", resp.Data.ResultType)
+	}
+	return &resp.Data.Result, resp.Warnings, nil
 }
 
 func parseRangeStart(s string) (time.Time, error) {
@@ -177,8 +446,7 @@ func parseRangeStart(s string) (time.Time, error) {
 	} else if d, err := time.ParseDuration(s); err == nil {
 		return time.Now().Add(-d), nil
 	} else {
-		err = fmt.Errorf("unable to parse range start time, %v", err)
-		return time.Time{}, nil
+		return time.Time{}, fmt.Errorf("unable to parse range start time, %v", err)
 	}
 }
 
@@ -220,16 +488,35 @@ func (p *PromQL) getRange() (r v1.Range, err error) {
 	return r, err
 }
 
+// Range returns the start, end, and step range queries are run with
+// Relative times like "now" are evaluated again, so they may be a little later than those of a query that's already run
+func (p *PromQL) Range() (v1.Range, error) {
+	return p.getRange()
+}
+
 // rangeQuery performs a range query and writes the results to stdout
 func (p *PromQL) RangeQuery(queryString string) (model.Matrix, v1.Warnings, error) {
+	if len(p.Hosts) > 0 {
+		result, warnings, err := p.multiHostQuery(func(hp *PromQL) (model.Value, v1.Warnings, error) {
+			return hp.RangeQuery(queryString)
+		})
+		if err != nil {
+			return nil, warnings, err
+		}
+		return result.(model.Matrix), warnings, nil
+	}
 	// create context with a timeout,
-	ctx, cancel := context.WithTimeout(context.Background(), p.TimeoutDuration)
+	ctx, cancel := p.queryContext()
 	defer cancel()
 
 	r, err := p.getRange()
 	if err != nil {
 		return nil, nil, err
 	}
+	// split long ranges into sequential sub-range queries if requested
+	if p.Chunk > 0 {
+		return p.chunkedRangeQuery(queryString, r)
+	}
 	// execute query
 	result, warnings, err := p.Client.QueryRange(ctx, queryString, r)
 	if err != nil {
@@ -245,7 +532,7 @@ func (p *PromQL) RangeQuery(queryString string) (model.Matrix, v1.Warnings, erro
 
 // LabelsQuery runs a labels query and returns the result
 func (p *PromQL) LabelsQuery(query string) (model.Vector, v1.Warnings, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), p.TimeoutDuration)
+	ctx, cancel := p.queryContext()
 	defer cancel()
 
 	result, warnings, err := p.Client.Query(ctx, query, time.Now())
@@ -264,18 +551,49 @@ func (p *PromQL) LabelsQuery(query string) (model.Vector, v1.Warnings, error) {
 
 // MetaQuery returns prometheus metrics metadata. Used for our metrics and meta commands
 func (p *PromQL) MetaQuery(query string) (map[string][]v1.Metadata, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), p.TimeoutDuration)
+	ctx, cancel := p.queryContext()
 	defer cancel()
 
 	result, err := p.Client.Metadata(ctx, query, "")
 	if err != nil {
-		return map[string][]v1.Metadata{}, fmt.Errorf("Error querying metadata endpoint: %v", err)
+		return map[string][]v1.Metadata{}, fmt.Errorf("Error querying metadata endpoint: %w", err)
 	}
 	return result, nil
 }
 
-// SeriesQuery returns prometheus series data
-func (p *PromQL) SeriesQuery(query string) ([]model.LabelSet, v1.Warnings, error) {
+// MetricsMetadataQuery returns the metadata of each of the metrics
+// Metrics with no metadata of their own (e.g. histogram _bucket series) fall back to the metadata of their base metric
+func (p *PromQL) MetricsMetadataQuery(names []string) (map[string][]v1.Metadata, error) {
+	result := make(map[string][]v1.Metadata, len(names))
+	for _, name := range names {
+		if _, ok := result[name]; ok {
+			continue
+		}
+		meta, err := p.MetaQuery(name)
+		if err != nil {
+			return result, err
+		}
+		if len(meta) == 0 {
+			if base, ok := util.BaseMetricName(name); ok {
+				if meta, err = p.MetaQuery(base); err != nil {
+					return result, err
+				}
+			}
+		}
+		for k, v := range meta {
+			result[k] = v
+		}
+		// Remember metrics without metadata so they're only queried once
+		if _, ok := result[name]; !ok {
+			result[name] = nil
+		}
+	}
+	return result, nil
+}
+
+// SeriesQuery returns prometheus series data for the series matching any of the selectors
+// Like prometheus, multiple selectors are ORed together and each matching series is returned once
+func (p *PromQL) SeriesQuery(matches []string) ([]model.LabelSet, v1.Warnings, error) {
 	// Set defaults based on time flag
 	s := p.Time.Add(-15 * time.Second)
 	e := p.Time
@@ -294,11 +612,145 @@ func (p *PromQL) SeriesQuery(query string) ([]model.LabelSet, v1.Warnings, error
 			return []model.LabelSet{}, v1.Warnings{}, err
 		}
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), p.TimeoutDuration)
+	ctx, cancel := p.queryContext()
 	defer cancel()
-	result, warnings, err := p.Client.Series(ctx, []string{query}, s, e)
+	result, warnings, err := p.Client.Series(ctx, matches, s, e)
+	if err != nil {
+		return []model.LabelSet{}, warnings, fmt.Errorf("error querying series endpoint: %w", err)
+	}
+	return uniqSeries(result), warnings, err
+}
+
+// uniqSeries removes duplicate series, keeping the first occurrence of each
+func uniqSeries(series []model.LabelSet) []model.LabelSet {
+	seen := make(map[model.Fingerprint]struct{}, len(series))
+	result := make([]model.LabelSet, 0, len(series))
+	for _, ls := range series {
+		fp := ls.Fingerprint()
+		if _, ok := seen[fp]; ok {
+			continue
+		}
+		seen[fp] = struct{}{}
+		result = append(result, ls)
+	}
+	return result
+}
+
+// SeriesLabelsQuery returns the series matching any of the selectors as a vector, so their labels can be written like a labels query result
+// Sample values and timestamps are left unset
+func (p *PromQL) SeriesLabelsQuery(matches []string) (model.Vector, v1.Warnings, error) {
+	series, warnings, err := p.SeriesQuery(matches)
 	if err != nil {
-		return []model.LabelSet{}, warnings, fmt.Errorf("error querying series endpoint: %v", err)
+		return nil, warnings, err
+	}
+	result := make(model.Vector, 0, len(series))
+	for _, ls := range series {
+		result = append(result, &model.Sample{Metric: model.Metric(ls)})
+	}
+	return result, warnings, nil
+}
+
+// FederateQuery fetches the federation endpoint for the provided match[] selectors and returns the raw exposition format payload
+func (p *PromQL) FederateQuery(matches []string) ([]byte, error) {
+	ctx, cancel := p.queryContext()
+	defer cancel()
+
+	u := p.APIClient.URL("/federate", nil)
+	q := u.Query()
+	for _, m := range matches {
+		q.Add("match[]", m)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, body, err := p.APIClient.Do(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("error querying federate endpoint: %w", err)
+	}
+	// Error statuses are already returned as an *APIError, except the 405 and 501 responses the api client leaves to its caller
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error querying federate endpoint: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// ParseFederation parses an exposition format payload from the federation endpoint into an instant vector
+func ParseFederation(payload []byte) (model.Vector, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing federation payload: %v", err)
+	}
+	fams := make([]*dto.MetricFamily, 0, len(families))
+	for _, f := range families {
+		fams = append(fams, f)
+	}
+	return expfmt.ExtractSamples(&expfmt.DecodeOptions{Timestamp: model.Now()}, fams...)
+}
+
+// ErrAdminAPIDisabled is returned by admin queries when the prometheus server wasn't started with --web.enable-admin-api
+var ErrAdminAPIDisabled = errors.New("the prometheus admin API is disabled, restart prometheus with --web.enable-admin-api to use admin commands")
+
+// adminError translates the generic 503 error returned by a server with the admin API disabled into ErrAdminAPIDisabled
+func adminError(err error) error {
+	var apiErr *v1.Error
+	if errors.As(err, &apiErr) && strings.Contains(apiErr.Msg+apiErr.Detail, "admin APIs disabled") {
+		return ErrAdminAPIDisabled
+	}
+	return err
+}
+
+// DeleteRange returns the start and end of the window used for series deletion
+// Unlike other queries an unset start means the beginning of time, which is returned as a zero time.Time
+func (p *PromQL) DeleteRange() (start time.Time, end time.Time, err error) {
+	if p.Start != "" {
+		start, err = parseRangeStart(p.Start)
+		if err != nil {
+			return start, end, err
+		}
+	}
+	end, err = parseRangeEnd(p.End)
+	return start, end, err
+}
+
+// DeleteSeriesCount returns the number of series matching the selectors within the deletion window
+func (p *PromQL) DeleteSeriesCount(matches []string) (int, v1.Warnings, error) {
+	s, e, err := p.DeleteRange()
+	if err != nil {
+		return 0, nil, err
+	}
+	ctx, cancel := p.queryContext()
+	defer cancel()
+	result, warnings, err := p.Client.Series(ctx, matches, s, e)
+	if err != nil {
+		return 0, warnings, fmt.Errorf("error querying series endpoint: %w", err)
+	}
+	return len(result), warnings, nil
+}
+
+// DeleteSeries deletes the series matching the selectors within the deletion window using the admin API
+func (p *PromQL) DeleteSeries(matches []string) error {
+	s, e, err := p.DeleteRange()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := p.queryContext()
+	defer cancel()
+	if err := p.Client.DeleteSeries(ctx, matches, s, e); err != nil {
+		return fmt.Errorf("error deleting series: %w", adminError(err))
+	}
+	return nil
+}
+
+// CleanTombstones removes the deleted data from disk using the admin API
+func (p *PromQL) CleanTombstones() error {
+	ctx, cancel := p.queryContext()
+	defer cancel()
+	if err := p.Client.CleanTombstones(ctx); err != nil {
+		return fmt.Errorf("error cleaning tombstones: %w", adminError(err))
 	}
-	return result, warnings, err
+	return nil
 }
@@ -0,0 +1,148 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// pushJobLabel is the label the pushgateway sets to the job of each push
+const pushJobLabel model.LabelName = "job"
+
+// PushGroup is the samples pushed to a pushgateway under one grouping key, as the gauges of its metric families
+type PushGroup struct {
+	// Grouping is the grouping key, besides the job, from the values of the grouping labels of its samples
+	Grouping model.LabelSet
+	Families []*dto.MetricFamily
+	// Samples is the number of samples in the group
+	Samples int
+}
+
+// String returns the grouping key of the group e.g. {instance="a"}
+func (g PushGroup) String() string {
+	return g.Grouping.String()
+}
+
+// GroupPushSamples groups the samples of v by the values of their grouping labels, for each group to be pushed to a pushgateway with its grouping key
+// The other labels of each sample become the labels of its gauge, except job which the pushgateway sets from the job of the push.
+// Samples without a metric name are named name, and it's an error if name is empty. Samples missing a grouping label, and samples that would be pushed as the same gauge, are errors too, so nothing is pushed unless every sample can be.
+func GroupPushSamples(v model.Vector, groupingLabels []model.LabelName, name string) ([]PushGroup, error) {
+	if name != "" && !model.IsValidMetricName(model.LabelValue(name)) {
+		return nil, fmt.Errorf("invalid metric name %q", name)
+	}
+	for _, l := range groupingLabels {
+		if !l.IsValid() {
+			return nil, fmt.Errorf("invalid grouping label name %q", l)
+		}
+		if l == pushJobLabel {
+			return nil, fmt.Errorf("job is always part of the grouping key, set it with the job of the push")
+		}
+	}
+	var groups []PushGroup
+	groupIndex := map[model.Fingerprint]int{}
+	families := map[model.Fingerprint]map[string]*dto.MetricFamily{}
+	seen := map[model.Fingerprint]model.Metric{}
+	for _, s := range v {
+		if s.Histogram != nil {
+			return nil, fmt.Errorf("%s is a histogram sample, only float samples can be pushed as gauges", s.Metric)
+		}
+		grouping := model.LabelSet{}
+		for _, l := range groupingLabels {
+			value, ok := s.Metric[l]
+			if !ok {
+				return nil, fmt.Errorf("%s has no %s label to group it by", s.Metric, l)
+			}
+			grouping[l] = value
+		}
+		metric := s.Metric.Clone()
+		delete(metric, pushJobLabel)
+		for l := range grouping {
+			delete(metric, l)
+		}
+		if _, ok := metric[model.MetricNameLabel]; !ok {
+			if name == "" {
+				return nil, fmt.Errorf("%s has no metric name to push it as, name it e.g. with label_replace in the query", s.Metric)
+			}
+			metric[model.MetricNameLabel] = model.LabelValue(name)
+		}
+		gf := grouping.Fingerprint()
+		key := model.LabelSet(metric).Merge(grouping).Fingerprint()
+		if other, ok := seen[key]; ok {
+			return nil, fmt.Errorf("%s and %s would both be pushed as %s in group %s, group them by the labels that tell them apart", other, s.Metric, metric, grouping)
+		}
+		seen[key] = s.Metric
+		i, ok := groupIndex[gf]
+		if !ok {
+			i = len(groups)
+			groupIndex[gf] = i
+			groups = append(groups, PushGroup{Grouping: grouping})
+			families[gf] = map[string]*dto.MetricFamily{}
+		}
+		metricName := string(metric[model.MetricNameLabel])
+		family, ok := families[gf][metricName]
+		if !ok {
+			family = &dto.MetricFamily{Name: &metricName, Type: dto.MetricType_GAUGE.Enum()}
+			families[gf][metricName] = family
+			groups[i].Families = append(groups[i].Families, family)
+		}
+		family.Metric = append(family.Metric, gaugeMetric(metric, float64(s.Value)))
+		groups[i].Samples++
+	}
+	return groups, nil
+}
+
+// gaugeMetric returns a gauge with the labels of m other than its name, sorted by name, and the value v
+func gaugeMetric(m model.Metric, v float64) *dto.Metric {
+	labels := make([]*dto.LabelPair, 0, len(m)-1)
+	for name, value := range m {
+		if name == model.MetricNameLabel {
+			continue
+		}
+		n, val := string(name), string(value)
+		labels = append(labels, &dto.LabelPair{Name: &n, Value: &val})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].GetName() < labels[j].GetName()
+	})
+	return &dto.Metric{Label: labels, Gauge: &dto.Gauge{Value: &v}}
+}
+
+// Push pushes each group to the pushgateway at url under job, replacing the metrics previously pushed with the same grouping key
+func Push(ctx context.Context, client *http.Client, url, job string, groups []PushGroup) error {
+	url = strings.TrimSuffix(url, "/")
+	for _, g := range groups {
+		families := g.Families
+		p := push.New(url, job).Client(client).Gatherer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return families, nil
+		}))
+		for l, v := range g.Grouping {
+			p = p.Grouping(string(l), string(v))
+		}
+		if err := p.PushContext(ctx); err != nil {
+			return fmt.Errorf("error pushing group %s: %w", g, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// FileQuery is a single expression from a query file along with its preceding comment
+type FileQuery struct {
+	Comment string
+	Expr    string
+	// Line is the line number the expression starts on
+	Line int
+}
+
+// ParseQueryFile parses a file of queries.
+// Expressions are separated by blank lines and may span multiple lines.
+// Lines starting with # are comments, and the comments preceding an expression are used as its description.
+func ParseQueryFile(r io.Reader) ([]FileQuery, error) {
+	var (
+		queries  []FileQuery
+		comments []string
+		expr     []string
+		start    int
+	)
+	flush := func() {
+		if len(expr) > 0 {
+			queries = append(queries, FileQuery{
+				Comment: strings.Join(comments, " "),
+				Expr:    strings.Join(expr, "\n"),
+				Line:    start,
+			})
+			comments = nil
+		}
+		expr = nil
+	}
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		l := strings.TrimSpace(s.Text())
+		switch {
+		case l == "":
+			flush()
+		case strings.HasPrefix(l, "#"):
+			// A comment after an expression starts the next section
+			flush()
+			comments = append(comments, strings.TrimSpace(strings.TrimPrefix(l, "#")))
+		default:
+			if len(expr) == 0 {
+				start = line
+			}
+			expr = append(expr, s.Text())
+		}
+	}
+	flush()
+	return queries, s.Err()
+}
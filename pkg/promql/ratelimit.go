@@ -0,0 +1,134 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxThrottleWaits is the number of times a request rejected with a 429 is sent again after waiting out its Retry-After header
+const maxThrottleWaits = 5
+
+// RateLimiter spaces out requests to at most qps per second, as a token bucket holding a single token
+// It's shared by every client it's set on, so e.g. parallel chunks and each host of a multi host query are limited together
+type RateLimiter struct {
+	interval time.Duration
+	logger   *log.Logger
+	mu       sync.Mutex
+	// next is when the next request may be sent
+	next      time.Time
+	throttled bool
+}
+
+// NewRateLimiter returns a RateLimiter allowing qps requests per second, noting on logger when requests are first held back
+func NewRateLimiter(qps float64, logger *log.Logger) *RateLimiter {
+	return &RateLimiter{
+		interval: time.Duration(float64(time.Second) / qps),
+		logger:   logger,
+	}
+}
+
+// reserve takes the next free slot to send a request in, returning how long to wait for it
+func (l *RateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	d := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	if d > 0 && !l.throttled && l.logger != nil {
+		l.throttled = true
+		l.logger.Printf("Throttling requests to %g per second\n", float64(time.Second)/float64(l.interval))
+	}
+	return d
+}
+
+// pause holds back every request for d, e.g. when the server asks us to slow down
+func (l *RateLimiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); l.next.Before(until) {
+		l.next = until
+	}
+}
+
+// Wait blocks until a request may be sent, or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	d := l.reserve()
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// RateLimitRoundTripper waits for its RateLimiter before sending each request
+// Requests rejected with a 429 and a Retry-After header are sent again once it's passed, holding back other requests until then
+type RateLimitRoundTripper struct {
+	limiter *RateLimiter
+	rt      http.RoundTripper
+}
+
+// NewRateLimitRoundTripper returns a RateLimitRoundTripper sending requests as allowed by limiter
+func NewRateLimitRoundTripper(limiter *RateLimiter, rt http.RoundTripper) *RateLimitRoundTripper {
+	return &RateLimitRoundTripper{
+		limiter: limiter,
+		rt:      rt,
+	}
+}
+
+func (rt *RateLimitRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	for waits := 0; ; waits++ {
+		r := req
+		if waits > 0 && req.Body != nil && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = cloneRequest(req)
+			r.Body = body
+		}
+		if err := rt.limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+		resp, err := rt.rt.RoundTrip(r)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || waits >= maxThrottleWaits {
+			return resp, err
+		}
+		d, ok := retryAfter(resp)
+		if !ok {
+			return resp, nil
+		}
+		// Drain the body so the connection can be reused
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if rt.limiter.logger != nil {
+			rt.limiter.logger.Printf("Server returned %s, waiting %s as asked by its Retry-After header\n", resp.Status, d.Round(time.Millisecond))
+		}
+		rt.limiter.pause(d)
+	}
+}
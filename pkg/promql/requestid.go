@@ -0,0 +1,70 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// DefaultRequestIDHeader is the header request ids are usually read from by proxies and gateways
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// RequestIDs stamps every request with a fresh uuid in Header, remembering the last one sent so it can be reported alongside errors
+// Retries of a request are sent with the same id
+type RequestIDs struct {
+	Header string
+	mu     sync.Mutex
+	last   string
+}
+
+// Last returns the id of the last request sent, or an empty string if none have been
+func (ids *RequestIDs) Last() string {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	return ids.last
+}
+
+// next returns a fresh request id, recording it as the last one sent
+func (ids *RequestIDs) next() string {
+	id := uuid.NewString()
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	ids.last = id
+	return id
+}
+
+// RequestIDRoundTripper sets the request id header of every request
+type RequestIDRoundTripper struct {
+	ids *RequestIDs
+	rt  http.RoundTripper
+}
+
+// NewRequestIDRoundTripper returns a RequestIDRoundTripper stamping requests with ids
+func NewRequestIDRoundTripper(ids *RequestIDs, rt http.RoundTripper) *RequestIDRoundTripper {
+	return &RequestIDRoundTripper{
+		ids: ids,
+		rt:  rt,
+	}
+}
+
+func (rt *RequestIDRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	req.Header.Set(rt.ids.Header, rt.ids.next())
+	return rt.rt.RoundTrip(req)
+}
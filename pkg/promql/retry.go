@@ -0,0 +1,160 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRetryBackoff is the initial delay between retries, doubled on every attempt
+	defaultRetryBackoff = 500 * time.Millisecond
+	// maxRetryBackoff caps the exponential backoff delay
+	maxRetryBackoff = 30 * time.Second
+)
+
+// RetryRoundTripper retries requests that fail with a transport error, a 5xx, or a 429 response
+// using exponential backoff with jitter. Other 4xx responses (e.g. bad queries) are never retried.
+// Only reads are retried, see retryableRequest, so requests that change anything are never sent twice.
+type RetryRoundTripper struct {
+	retries int
+	backoff time.Duration
+	logger  *log.Logger
+	rt      http.RoundTripper
+}
+
+// NewRetryRoundTripper returns a RetryRoundTripper that retries failed requests up to retries times, logging each attempt to logger
+func NewRetryRoundTripper(retries int, logger *log.Logger, rt http.RoundTripper) *RetryRoundTripper {
+	return &RetryRoundTripper{
+		retries: retries,
+		backoff: defaultRetryBackoff,
+		logger:  logger,
+		rt:      rt,
+	}
+}
+
+// retryable reports whether a response status should be retried
+func retryable(code int) bool {
+	return code == http.StatusTooManyRequests || code/100 == 5
+}
+
+// retryableRequest reports whether req only reads, so it's safe to send again
+// That's GET and HEAD requests, and the form encoded POSTs queries are sent as, but never the admin api, e.g. deleting series
+func retryableRequest(req *http.Request) bool {
+	if strings.Contains(req.URL.Path, "/api/v1/admin/") {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodPost:
+		return strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded")
+	default:
+		return false
+	}
+}
+
+// retryAfter returns the delay asked for by the Retry-After header of resp, in seconds or as an http date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	ra := resp.Header.Get("Retry-After")
+	if ra == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(ra); err == nil {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(ra); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+// delay returns the backoff delay for the given attempt, honoring the Retry-After header of resp if present
+func (rt *RetryRoundTripper) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d, ok := retryAfter(resp); ok {
+			return d
+		}
+	}
+	d := rt.backoff << (attempt - 1)
+	if d > maxRetryBackoff || d <= 0 {
+		d = maxRetryBackoff
+	}
+	// Jitter the delay between d/2 and d so concurrent clients don't retry in lockstep
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (rt *RetryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !retryableRequest(req) {
+		return rt.rt.RoundTrip(req)
+	}
+	attempts := rt.retries + 1
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 && req.Body != nil && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = cloneRequest(req)
+			r.Body = body
+		}
+		resp, err := rt.rt.RoundTrip(r)
+		if err == nil && !retryable(resp.StatusCode) {
+			return resp, nil
+		}
+
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = "server returned " + resp.Status
+		}
+		if attempt >= attempts {
+			if err != nil {
+				return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			if rt.retries == 0 {
+				return resp, nil
+			}
+			b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			return nil, fmt.Errorf("giving up after %d attempts: %s: %s", attempt, reason, strings.TrimSpace(string(b)))
+		}
+
+		d := rt.delay(attempt, resp)
+		if resp != nil {
+			// Drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if rt.logger != nil {
+			rt.logger.Printf("Retry %d/%d: %s, retrying in %s\n", attempt, rt.retries, reason, d.Round(time.Millisecond))
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(d):
+		}
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultScrapeInterval is the global scrape interval of prometheus servers whose config doesn't set one
+const defaultScrapeInterval = time.Minute
+
+// ScrapeInterval returns the global scrape interval of the server, read from its config at /api/v1/status/config
+func (p *PromQL) ScrapeInterval() (time.Duration, error) {
+	ctx, cancel := p.queryContext()
+	defer cancel()
+	cfg, err := p.Client.Config(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error getting the prometheus config: %w", err)
+	}
+	return parseScrapeInterval(cfg.YAML)
+}
+
+// parseScrapeInterval returns the global scrape interval of a prometheus config file
+func parseScrapeInterval(config string) (time.Duration, error) {
+	var cfg struct {
+		Global struct {
+			ScrapeInterval model.Duration `yaml:"scrape_interval"`
+		} `yaml:"global"`
+	}
+	if err := yaml.Unmarshal([]byte(config), &cfg); err != nil {
+		return 0, fmt.Errorf("unable to parse the prometheus config: %w", err)
+	}
+	if cfg.Global.ScrapeInterval <= 0 {
+		return defaultScrapeInterval, nil
+	}
+	return time.Duration(cfg.Global.ScrapeInterval), nil
+}
+
+// SnapStep rounds step up to the nearest multiple of the scrape interval, so each point of a range query lines up with a scrape
+// Steps that are already a multiple are left as is
+func SnapStep(step, interval time.Duration) time.Duration {
+	switch {
+	case interval <= 0:
+		return step
+	case step <= interval:
+		return interval
+	case step%interval == 0:
+		return step
+	default:
+		return step - step%interval + interval
+	}
+}
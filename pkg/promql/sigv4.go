@@ -0,0 +1,146 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// sigv4Service is the service requests to Amazon Managed Prometheus are signed for
+const sigv4Service = "aps"
+
+// SigV4 holds the settings for signing requests with AWS SigV4, e.g. for Amazon Managed Prometheus
+// Credentials and the region are looked up with the default AWS config chain, like the AWS cli does
+type SigV4 struct {
+	// Region is the region requests are signed for, defaulting to the region of the AWS profile
+	Region string
+	// Profile is the AWS profile to use instead of the default or AWS_PROFILE
+	Profile string
+	// RoleARN is a role assumed with the looked up credentials, whose credentials then sign requests
+	RoleARN string
+}
+
+// Enabled returns true if requests should be signed
+func (s SigV4) Enabled() bool {
+	return s != (SigV4{})
+}
+
+// RoundTripper returns rt wrapped to sign requests, or rt if signing isn't enabled
+func (s SigV4) RoundTripper(rt http.RoundTripper) (http.RoundTripper, error) {
+	if !s.Enabled() {
+		return rt, nil
+	}
+	var opts []func(*awsconfig.LoadOptions) error
+	if s.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(s.Region))
+	}
+	if s.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(s.Profile))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("please specify the AWS region to sign requests for with --sigv4-region")
+	}
+	credentials := cfg.Credentials
+	if s.RoleARN != "" {
+		credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), s.RoleARN))
+	}
+	return &SigV4RoundTripper{
+		region:      cfg.Region,
+		credentials: credentials,
+		signer:      v4.NewSigner(),
+		rt:          rt,
+	}, nil
+}
+
+// SigV4RoundTripper signs every request with AWS SigV4
+type SigV4RoundTripper struct {
+	region      string
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	rt          http.RoundTripper
+}
+
+func (rt *SigV4RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	creds, err := rt.credentials.Retrieve(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("error getting AWS credentials: %w", err)
+	}
+	var body []byte
+	if req.Body != nil {
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	req = cloneRequest(req)
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	payloadHash := sha256.Sum256(body)
+	if err := rt.signer.SignHTTP(req.Context(), creds, req, hex.EncodeToString(payloadHash[:]), sigv4Service, rt.region, time.Now()); err != nil {
+		return nil, fmt.Errorf("error signing request: %w", err)
+	}
+
+	resp, err := rt.rt.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		return resp, err
+	}
+	// AWS rejects requests with bad or expired credentials with a 403 whose body says why
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	return nil, &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: awsErrorMessage(b)}
+}
+
+// awsErrorMessage returns the message of an AWS error response, or the whole body if it has none
+func awsErrorMessage(body []byte) string {
+	var e struct {
+		Message      string `json:"message"`
+		MessageUpper string `json:"Message"`
+	}
+	if err := json.Unmarshal(body, &e); err == nil {
+		if e.Message != "" {
+			return e.Message
+		}
+		if e.MessageUpper != "" {
+			return e.MessageUpper
+		}
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// TenantHeader is the header multi-tenant servers like cortex, mimir, and thanos read the tenant (org id) of requests from
+const TenantHeader = "X-Scope-OrgID"
+
+// noOrgIDMessage is the error returned by cortex and mimir for requests without a tenant
+const noOrgIDMessage = "no org id"
+
+// TenantHintRoundTripper adds a hint to set a tenant to the errors of servers that require one
+type TenantHintRoundTripper struct {
+	rt http.RoundTripper
+}
+
+// NewTenantHintRoundTripper returns a TenantHintRoundTripper
+func NewTenantHintRoundTripper(rt http.RoundTripper) *TenantHintRoundTripper {
+	return &TenantHintRoundTripper{rt: rt}
+}
+
+func (rt *TenantHintRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.rt.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || req.Header.Get(TenantHeader) != "" {
+		return resp, err
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(b), noOrgIDMessage) {
+		return nil, fmt.Errorf("%w (this server requires a tenant, set one with --tenant or PROMQL_TENANT)", newAPIError(resp, b))
+	}
+	resp.Body = io.NopCloser(strings.NewReader(string(b)))
+	return resp, nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Thanos query api parameters
+const (
+	ThanosDedup           = "dedup"
+	ThanosPartialResponse = "partial_response"
+)
+
+// ThanosParams returns the thanos query api parameters for the provided options, nil options are left unset so thanos uses its defaults
+func ThanosParams(dedup, partialResponse *bool) url.Values {
+	params := url.Values{}
+	if dedup != nil {
+		params.Set(ThanosDedup, strconv.FormatBool(*dedup))
+	}
+	if partialResponse != nil {
+		params.Set(ThanosPartialResponse, strconv.FormatBool(*partialResponse))
+	}
+	return params
+}
+
+// QueryParamsRoundTripper adds extra query parameters (e.g. thanos' dedup and partial_response) to every request
+type QueryParamsRoundTripper struct {
+	params url.Values
+	rt     http.RoundTripper
+}
+
+// NewQueryParamsRoundTripper returns a QueryParamsRoundTripper adding params to every request
+func NewQueryParamsRoundTripper(params url.Values, rt http.RoundTripper) *QueryParamsRoundTripper {
+	return &QueryParamsRoundTripper{
+		params: params,
+		rt:     rt,
+	}
+}
+
+func (rt *QueryParamsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	u := *req.URL
+	q := u.Query()
+	for k, values := range rt.params {
+		for _, v := range values {
+			q.Add(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	req.URL = &u
+	resp, err := rt.rt.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		return resp, err
+	}
+	// Servers that don't support a parameter (e.g. vanilla prometheus) respond with bad request errors mentioning it
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range rt.names() {
+		if strings.Contains(string(b), k) {
+			return nil, fmt.Errorf("%w (the %s parameters are only supported by thanos, is this a thanos query endpoint?)", newAPIError(resp, b), strings.Join(rt.names(), ","))
+		}
+	}
+	resp.Body = io.NopCloser(strings.NewReader(string(b)))
+	return resp, nil
+}
+
+// names returns the sorted names of the parameters added to requests
+func (rt *QueryParamsRoundTripper) names() []string {
+	names := make([]string, 0, len(rt.params))
+	for k := range rt.params {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
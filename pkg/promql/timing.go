@@ -0,0 +1,141 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timing accumulates how long the phases of http requests took, summed over every request (e.g. retries or chunks) since it was last reset
+// Phases of reused connections, like DNS and connect, take no time
+type Timing struct {
+	mu       sync.Mutex
+	requests int
+	dns      time.Duration
+	connect  time.Duration
+	tls      time.Duration
+	ttfb     time.Duration
+	total    time.Duration
+}
+
+// TimingSummary is a snapshot of a Timing
+type TimingSummary struct {
+	Requests int
+	DNS      time.Duration
+	Connect  time.Duration
+	TLS      time.Duration
+	// TTFB is the time until the first response byte was received
+	TTFB time.Duration
+	// Total is the time until the whole response body was read
+	Total time.Duration
+}
+
+// Reset clears the timings, e.g. before each query
+func (t *Timing) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests, t.dns, t.connect, t.tls, t.ttfb, t.total = 0, 0, 0, 0, 0, 0
+}
+
+// Summary returns the timings accumulated since the last reset
+func (t *Timing) Summary() TimingSummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return TimingSummary{
+		Requests: t.requests,
+		DNS:      t.dns,
+		Connect:  t.connect,
+		TLS:      t.tls,
+		TTFB:     t.ttfb,
+		Total:    t.total,
+	}
+}
+
+// add adds the timings of a single request
+func (t *Timing) add(s TimingSummary) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests++
+	t.dns += s.DNS
+	t.connect += s.Connect
+	t.tls += s.TLS
+	t.ttfb += s.TTFB
+	t.total += s.Total
+}
+
+// TimingRoundTripper traces the phases of every request with httptrace, adding them to a Timing
+type TimingRoundTripper struct {
+	timing *Timing
+	rt     http.RoundTripper
+}
+
+// NewTimingRoundTripper returns a TimingRoundTripper adding the timings of requests to timing
+func NewTimingRoundTripper(timing *Timing, rt http.RoundTripper) *TimingRoundTripper {
+	return &TimingRoundTripper{
+		timing: timing,
+		rt:     rt,
+	}
+}
+
+func (rt *TimingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		s                                TimingSummary
+		start                            = time.Now()
+		dnsStart, connectStart, tlsStart time.Time
+	)
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { s.DNS = time.Since(dnsStart) },
+		ConnectStart:      func(string, string) { connectStart = time.Now() },
+		ConnectDone:       func(string, string, error) { s.Connect = time.Since(connectStart) },
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { s.TLS = time.Since(tlsStart) },
+		GotFirstResponseByte: func() {
+			s.TTFB = time.Since(start)
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := rt.rt.RoundTrip(req)
+	if err != nil {
+		s.Total = time.Since(start)
+		rt.timing.add(s)
+		return nil, err
+	}
+	// The request is complete once its body has been read and closed
+	resp.Body = &timedBody{ReadCloser: resp.Body, done: func() {
+		s.Total = time.Since(start)
+		rt.timing.add(s)
+	}}
+	return resp, nil
+}
+
+// timedBody calls done once when the body is closed
+type timedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *timedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
@@ -0,0 +1,49 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/prometheus/common/config"
+)
+
+// ValidateTLSConfig loads the CA and client certificate files of cfg, so a bad file fails up front naming the file rather than during the TLS handshake
+func ValidateTLSConfig(cfg config.TLSConfig) error {
+	if cfg.CAFile != "" {
+		b, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("error loading CA cert %s: %v", cfg.CAFile, err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(b) {
+			return fmt.Errorf("error loading CA cert %s: no PEM encoded certificates found", cfg.CAFile)
+		}
+	}
+	switch {
+	case cfg.CertFile != "" && cfg.KeyFile == "":
+		return fmt.Errorf("client cert %s requires a client key", cfg.CertFile)
+	case cfg.CertFile == "" && cfg.KeyFile != "":
+		return fmt.Errorf("client key %s requires a client cert", cfg.KeyFile)
+	case cfg.CertFile != "":
+		if _, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile); err != nil {
+			return fmt.Errorf("error loading client cert %s and key %s: %v", cfg.CertFile, cfg.KeyFile, err)
+		}
+	}
+	return nil
+}
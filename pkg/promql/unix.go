@@ -0,0 +1,45 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package promql
+
+import (
+	"context"
+	"net"
+	"net/url"
+)
+
+// unixSocketHost is the host requests over a unix socket are sent with, as http needs one but the socket decides where they go
+const unixSocketHost = "http://localhost"
+
+// UnixSocketPath returns the socket path of a unix:///path/to/prometheus.sock host, and whether host is one
+func UnixSocketPath(host string) (string, bool) {
+	u, err := url.Parse(host)
+	if err != nil || u.Scheme != "unix" {
+		return "", false
+	}
+	if u.Path == "" {
+		// unix:relative.sock
+		return u.Opaque, u.Opaque != ""
+	}
+	return u.Path, true
+}
+
+// unixSocketDialer returns a DialContext that connects to socket regardless of the address being dialed
+func unixSocketDialer(dialer *net.Dialer, socket string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", socket)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package transform
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/common/model"
+)
+
+// DropLabels removes the provided labels from every series in result.
+// Unlike excluding columns from the output this changes the identity of each series,
+// so series that only differed by the dropped labels are merged by summing their values.
+// For range results the values of merged series are summed per timestamp.
+func DropLabels(result model.Value, labels []model.LabelName) (model.Value, error) {
+	drop := func(m model.Metric) model.Metric {
+		c := m.Clone()
+		for _, l := range labels {
+			delete(c, l)
+		}
+		return c
+	}
+	switch r := result.(type) {
+	case model.Vector:
+		var v model.Vector
+		seen := make(map[model.Fingerprint]*model.Sample)
+		for _, s := range r {
+			m := drop(s.Metric)
+			if existing, ok := seen[m.Fingerprint()]; ok {
+				existing.Value += s.Value
+				continue
+			}
+			sample := &model.Sample{Metric: m, Value: s.Value, Timestamp: s.Timestamp}
+			seen[m.Fingerprint()] = sample
+			v = append(v, sample)
+		}
+		return v, nil
+	case model.Matrix:
+		var mat model.Matrix
+		seen := make(map[model.Fingerprint]*model.SampleStream)
+		for _, s := range r {
+			m := drop(s.Metric)
+			existing, ok := seen[m.Fingerprint()]
+			if !ok {
+				stream := &model.SampleStream{Metric: m, Values: append([]model.SamplePair{}, s.Values...)}
+				seen[m.Fingerprint()] = stream
+				mat = append(mat, stream)
+				continue
+			}
+			existing.Values = sumSamplePairs(existing.Values, s.Values)
+		}
+		return mat, nil
+	default:
+		return result, fmt.Errorf("unable to transform result: unknown query result type: %T", r)
+	}
+}
+
+// sumSamplePairs merges two lists of samples, summing the values of samples with the same timestamp
+func sumSamplePairs(a, b []model.SamplePair) []model.SamplePair {
+	values := make(map[model.Time]model.SampleValue, len(a)+len(b))
+	for _, p := range a {
+		values[p.Timestamp] += p.Value
+	}
+	for _, p := range b {
+		values[p.Timestamp] += p.Value
+	}
+	merged := make([]model.SamplePair, 0, len(values))
+	for ts, v := range values {
+		merged = append(merged, model.SamplePair{Timestamp: ts, Value: v})
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Timestamp < merged[j].Timestamp
+	})
+	return merged
+}
@@ -0,0 +1,109 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package transform
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// The ways FillGaps can fill missing samples
+const (
+	FillZero     = "zero"
+	FillPrevious = "previous"
+	FillNaN      = "nan"
+)
+
+// FillGaps inserts a sample at every step between start and end that a series of m is missing, so every series has the same regularly spaced timestamps.
+// Missing samples are filled with 0 (FillZero), NaN (FillNaN), or the series' previous value (FillPrevious), which leaves leading gaps as NaN.
+// Steps are aligned to the timestamps of the result rather than start, as every sample of a range query is an exact number of steps from its start
+func FillGaps(m model.Matrix, start, end time.Time, step time.Duration, mode string) (model.Matrix, error) {
+	switch mode {
+	case FillZero, FillPrevious, FillNaN:
+	default:
+		return m, fmt.Errorf("unknown --fill-gaps mode %q. Options: %s,%s,%s", mode, FillZero, FillPrevious, FillNaN)
+	}
+	if step <= 0 {
+		return m, fmt.Errorf("unable to fill gaps: step must be positive")
+	}
+	grid := stepTimestamps(m, model.TimeFromUnixNano(start.UnixNano()), model.TimeFromUnixNano(end.UnixNano()), model.Time(step.Milliseconds()))
+	if len(grid) == 0 {
+		return m, nil
+	}
+
+	filled := make(model.Matrix, 0, len(m))
+	for _, s := range m {
+		values := make([]model.SamplePair, 0, len(grid))
+		i := 0
+		prev := model.SampleValue(math.NaN())
+		for _, ts := range grid {
+			// Keep samples that aren't on a step as is, e.g. from a server with a different alignment
+			for i < len(s.Values) && s.Values[i].Timestamp < ts {
+				values = append(values, s.Values[i])
+				prev = s.Values[i].Value
+				i++
+			}
+			if i < len(s.Values) && s.Values[i].Timestamp == ts {
+				values = append(values, s.Values[i])
+				prev = s.Values[i].Value
+				i++
+				continue
+			}
+			v := model.SampleValue(0)
+			switch mode {
+			case FillPrevious:
+				v = prev
+			case FillNaN:
+				v = model.SampleValue(math.NaN())
+			}
+			values = append(values, model.SamplePair{Timestamp: ts, Value: v})
+		}
+		values = append(values, s.Values[i:]...)
+		filled = append(filled, &model.SampleStream{Metric: s.Metric, Values: values})
+	}
+	return filled, nil
+}
+
+// stepTimestamps returns the timestamps every step between start and end, aligned to the first sample of m
+// start may be a little later than the start the query ran with (e.g. "now" minus a duration evaluated again), so steps less than a step before it are included
+func stepTimestamps(m model.Matrix, start, end, step model.Time) []model.Time {
+	var anchor model.Time
+	found := false
+	for _, s := range m {
+		if len(s.Values) > 0 {
+			anchor, found = s.Values[0].Timestamp, true
+			break
+		}
+	}
+	if !found || step <= 0 {
+		return nil
+	}
+	first := anchor
+	for first > start {
+		first -= step
+	}
+	for first <= start-step {
+		first += step
+	}
+	var grid []model.Time
+	for ts := first; ts <= end; ts += step {
+		grid = append(grid, ts)
+	}
+	return grid
+}
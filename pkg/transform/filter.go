@@ -0,0 +1,105 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package transform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// LabelFilter selects the series of a result by the value of one of their labels
+type LabelFilter struct {
+	Label model.LabelName
+	Regex *regexp.Regexp
+	// Negate drops the series the regex matches, rather than keeping them
+	Negate bool
+}
+
+// ParseLabelFilter parses a filter in the form label=regex, negated if negate is set
+// The regex is anchored at both ends like a =~ matcher, and series without the label are matched as having it empty
+func ParseLabelFilter(filter string, negate bool) (LabelFilter, error) {
+	var f LabelFilter
+	label, regex, ok := strings.Cut(filter, "=")
+	if !ok {
+		return f, fmt.Errorf("invalid label filter %q, expected label=regex", filter)
+	}
+	f.Label = model.LabelName(label)
+	if !f.Label.IsValid() {
+		return f, fmt.Errorf("invalid label filter %q, %q is not a valid label name", filter, f.Label)
+	}
+	re, err := regexp.Compile("^(?:" + regex + ")$")
+	if err != nil {
+		return f, fmt.Errorf("invalid label filter %q, %v", filter, err)
+	}
+	f.Regex = re
+	f.Negate = negate
+	return f, nil
+}
+
+// ParseLabelFilters parses a list of label filters, see ParseLabelFilter for the filter format
+func ParseLabelFilters(filters []string, negate bool) ([]LabelFilter, error) {
+	f := make([]LabelFilter, 0, len(filters))
+	for _, filter := range filters {
+		lf, err := ParseLabelFilter(filter, negate)
+		if err != nil {
+			return nil, err
+		}
+		f = append(f, lf)
+	}
+	return f, nil
+}
+
+// keeps returns whether a series with the labels m passes the filter
+func (f LabelFilter) keeps(m model.Metric) bool {
+	return f.Regex.MatchString(string(m[f.Label])) != f.Negate
+}
+
+// keepSeries returns whether a series with the labels m passes every filter
+func keepSeries(m model.Metric, filters []LabelFilter) bool {
+	for _, f := range filters {
+		if !f.keeps(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterSeries keeps the series of result that pass every filter, in order
+func FilterSeries(result model.Value, filters []LabelFilter) (model.Value, error) {
+	switch r := result.(type) {
+	case model.Vector:
+		v := model.Vector{}
+		for _, s := range r {
+			if keepSeries(s.Metric, filters) {
+				v = append(v, s)
+			}
+		}
+		return v, nil
+	case model.Matrix:
+		m := model.Matrix{}
+		for _, s := range r {
+			if keepSeries(s.Metric, filters) {
+				m = append(m, s)
+			}
+		}
+		return m, nil
+	default:
+		return result, fmt.Errorf("unable to transform result: unknown query result type: %T", r)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package transform
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// FlattenedLabel is the label the other labels of a series are flattened into
+const FlattenedLabel model.LabelName = "labels"
+
+// FlattenOptions sets how flattened labels are joined
+type FlattenOptions struct {
+	// Separator joins the key/value pairs, defaulting to ,
+	Separator string
+	// KeyValueSeparator joins each key and value, defaulting to =
+	KeyValueSeparator string
+}
+
+// Flatten returns the labels of m other than its metric name as a single string of sorted key/value pairs e.g. instance=web-1,job=api
+func (o FlattenOptions) Flatten(m model.Metric) string {
+	sep, kvSep := o.Separator, o.KeyValueSeparator
+	if sep == "" {
+		sep = ","
+	}
+	if kvSep == "" {
+		kvSep = "="
+	}
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		if k != model.MetricNameLabel {
+			pairs = append(pairs, string(k)+kvSep+string(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, sep)
+}
+
+// FlattenLabels replaces the labels of every series in result, other than its metric name, with a single labels label of their flattened key/value pairs
+// This writes one labels column in table and csv output, however many labels the series have
+func FlattenLabels(result model.Value, opts FlattenOptions) (model.Value, error) {
+	flatten := func(m model.Metric) model.Metric {
+		c := model.Metric{FlattenedLabel: model.LabelValue(opts.Flatten(m))}
+		if name, ok := m[model.MetricNameLabel]; ok {
+			c[model.MetricNameLabel] = name
+		}
+		return c
+	}
+	switch r := result.(type) {
+	case model.Vector:
+		v := make(model.Vector, 0, len(r))
+		for _, s := range r {
+			v = append(v, &model.Sample{Metric: flatten(s.Metric), Value: s.Value, Timestamp: s.Timestamp, Histogram: s.Histogram})
+		}
+		return v, nil
+	case model.Matrix:
+		m := make(model.Matrix, 0, len(r))
+		for _, s := range r {
+			m = append(m, &model.SampleStream{Metric: flatten(s.Metric), Values: s.Values, Histograms: s.Histograms})
+		}
+		return m, nil
+	default:
+		return result, fmt.Errorf("unable to transform result: unknown query result type: %T", r)
+	}
+}
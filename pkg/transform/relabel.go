@@ -0,0 +1,110 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package transform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// defaultRelabelRegex matches the entire source label value, mirroring the prometheus relabel_config default
+const defaultRelabelRegex = "(.*)"
+
+// RelabelRule is a single "replace" relabel rule
+// It mirrors the semantics of the prometheus relabel_config replace action for a single source label
+type RelabelRule struct {
+	SourceLabel model.LabelName
+	TargetLabel model.LabelName
+	Replacement string
+	Regex       *regexp.Regexp
+}
+
+// ParseRelabelRule parses a rule in the form source_label,target_label,replacement[,regex]
+// The regex is optional (defaulting to "(.*)") and is anchored at both ends like prometheus relabel configs.
+// As the regex is the last field it may itself contain commas.
+func ParseRelabelRule(rule string) (RelabelRule, error) {
+	var r RelabelRule
+	parts := strings.SplitN(rule, ",", 4)
+	if len(parts) < 3 {
+		return r, fmt.Errorf("invalid relabel rule %q, expected source_label,target_label,replacement[,regex]", rule)
+	}
+	r.SourceLabel = model.LabelName(parts[0])
+	r.TargetLabel = model.LabelName(parts[1])
+	r.Replacement = parts[2]
+	if !r.SourceLabel.IsValid() {
+		return r, fmt.Errorf("invalid relabel rule %q, %q is not a valid source label name", rule, r.SourceLabel)
+	}
+	if !r.TargetLabel.IsValid() {
+		return r, fmt.Errorf("invalid relabel rule %q, %q is not a valid target label name", rule, r.TargetLabel)
+	}
+	regex := defaultRelabelRegex
+	if len(parts) == 4 {
+		regex = parts[3]
+	}
+	re, err := regexp.Compile("^(?:" + regex + ")$")
+	if err != nil {
+		return r, fmt.Errorf("invalid relabel rule %q, %v", rule, err)
+	}
+	r.Regex = re
+	return r, nil
+}
+
+// ParseRelabelRules parses a list of relabel rules, see ParseRelabelRule for the rule format
+func ParseRelabelRules(rules []string) ([]RelabelRule, error) {
+	r := make([]RelabelRule, 0, len(rules))
+	for _, rule := range rules {
+		rr, err := ParseRelabelRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, rr)
+	}
+	return r, nil
+}
+
+// apply applies the rule to a single series' labels
+// If the regex matches the source label value the target label is set to the expanded replacement,
+// and removed entirely if the replacement expands to an empty string.
+func (r RelabelRule) apply(m model.Metric) {
+	val := string(m[r.SourceLabel])
+	indexes := r.Regex.FindStringSubmatchIndex(val)
+	if indexes == nil {
+		return
+	}
+	res := r.Regex.ExpandString([]byte{}, r.Replacement, val, indexes)
+	if len(res) == 0 {
+		delete(m, r.TargetLabel)
+		return
+	}
+	m[r.TargetLabel] = model.LabelValue(res)
+}
+
+// Relabel applies the relabel rules, in order, to the labels of every series in result
+func Relabel(result model.Value, rules []RelabelRule) (model.Value, error) {
+	m, err := metrics(result)
+	if err != nil {
+		return result, err
+	}
+	for _, metric := range m {
+		for _, r := range rules {
+			r.apply(metric)
+		}
+	}
+	return result, nil
+}
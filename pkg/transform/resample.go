@@ -0,0 +1,107 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package transform
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// The aggregations Resample can combine the samples of an interval with
+const (
+	ResampleAvg  = "avg"
+	ResampleMin  = "min"
+	ResampleMax  = "max"
+	ResampleLast = "last"
+	ResampleSum  = "sum"
+)
+
+// ParseResample parses a resample flag value in the form interval:aggregation e.g. 5m:avg
+func ParseResample(s string) (time.Duration, string, error) {
+	raw, agg, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, "", fmt.Errorf("invalid --resample %q, expected interval:aggregation e.g. 5m:avg", s)
+	}
+	interval, err := model.ParseDuration(raw)
+	if err != nil || interval <= 0 {
+		return 0, "", fmt.Errorf("invalid --resample interval %q, expected a positive duration e.g. 5m", raw)
+	}
+	switch agg {
+	case ResampleAvg, ResampleMin, ResampleMax, ResampleLast, ResampleSum:
+	default:
+		return 0, "", fmt.Errorf("unknown --resample aggregation %q. Options: %s,%s,%s,%s,%s", agg, ResampleAvg, ResampleMin, ResampleMax, ResampleLast, ResampleSum)
+	}
+	return time.Duration(interval), agg, nil
+}
+
+// Resample downsamples each series of m by combining its samples in each interval with agg, returning a new matrix
+// Like the prometheus *_over_time functions, each interval is written at its end and covers the samples after the previous one up to and including it.
+// Intervals are aligned to the unix epoch, so series are resampled to the same timestamps, and intervals without samples are left out
+func Resample(m model.Matrix, interval time.Duration, agg string) (model.Matrix, error) {
+	step := model.Time(interval.Milliseconds())
+	if step <= 0 {
+		return m, fmt.Errorf("unable to resample: interval must be at least 1ms")
+	}
+	resampled := make(model.Matrix, 0, len(m))
+	for _, s := range m {
+		var values []model.SamplePair
+		for i := 0; i < len(s.Values); {
+			end := intervalEnd(s.Values[i].Timestamp, step)
+			j := i
+			for j < len(s.Values) && s.Values[j].Timestamp <= end {
+				j++
+			}
+			values = append(values, model.SamplePair{Timestamp: end, Value: aggregate(s.Values[i:j], agg)})
+			i = j
+		}
+		resampled = append(resampled, &model.SampleStream{Metric: s.Metric, Values: values})
+	}
+	return resampled, nil
+}
+
+// intervalEnd returns the end of the interval holding ts
+func intervalEnd(ts, step model.Time) model.Time {
+	end := ts - ts%step
+	if end < ts {
+		end += step
+	}
+	return end
+}
+
+// aggregate combines a non-empty interval of samples with agg
+func aggregate(samples []model.SamplePair, agg string) model.SampleValue {
+	v := float64(samples[0].Value)
+	for _, s := range samples[1:] {
+		switch agg {
+		case ResampleAvg, ResampleSum:
+			v += float64(s.Value)
+		case ResampleMin:
+			v = math.Min(v, float64(s.Value))
+		case ResampleMax:
+			v = math.Max(v, float64(s.Value))
+		case ResampleLast:
+			v = float64(s.Value)
+		}
+	}
+	if agg == ResampleAvg {
+		v /= float64(len(samples))
+	}
+	return model.SampleValue(v)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package transform
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/prometheus/common/model"
+)
+
+// The ways Sanitize can merge samples at the same timestamp
+const (
+	MergeFirst = "first"
+	MergeLast  = ResampleLast
+	MergeMax   = ResampleMax
+	MergeMin   = ResampleMin
+	MergeAvg   = ResampleAvg
+)
+
+// ParseMerge validates a --merge-duplicate-timestamps flag value
+func ParseMerge(s string) (string, error) {
+	switch s {
+	case MergeFirst, MergeLast, MergeMax, MergeMin, MergeAvg:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown --merge-duplicate-timestamps %q. Options: %s,%s,%s,%s,%s", s, MergeFirst, MergeLast, MergeMax, MergeMin, MergeAvg)
+	}
+}
+
+// Sanitize sorts the samples of each series of m by timestamp, merging samples at the same timestamp with merge, one of the Merge constants
+// Servers occasionally return series with out of order or repeated samples e.g. from thanos deduplication bugs or federation merges, which graphs and gap filling assume never happens.
+// Histogram samples can't be aggregated, so they keep the first sample with MergeFirst and the last otherwise.
+// It returns a new matrix, along with the metrics of the series whose samples at the same timestamp had different values. Series already in order are kept as is.
+func Sanitize(m model.Matrix, merge string) (model.Matrix, []model.Metric) {
+	var (
+		sanitized = make(model.Matrix, 0, len(m))
+		conflicts []model.Metric
+	)
+	mergeValues := func(group []model.SamplePair) model.SamplePair {
+		if merge == MergeFirst {
+			return group[0]
+		}
+		return model.SamplePair{Timestamp: group[0].Timestamp, Value: aggregate(group, merge)}
+	}
+	mergeHistograms := func(group []model.SampleHistogramPair) model.SampleHistogramPair {
+		if merge == MergeFirst {
+			return group[0]
+		}
+		return group[len(group)-1]
+	}
+	for _, s := range m {
+		values, valuesConflict, valuesChanged := sanitizeSamples(s.Values, func(p model.SamplePair) model.Time { return p.Timestamp }, func(a, b model.SamplePair) bool {
+			return a.Value.Equal(b.Value)
+		}, mergeValues)
+		histograms, histogramsConflict, histogramsChanged := sanitizeSamples(s.Histograms, func(p model.SampleHistogramPair) model.Time { return p.Timestamp }, func(a, b model.SampleHistogramPair) bool {
+			return a.Equal(&b)
+		}, mergeHistograms)
+		if valuesChanged || histogramsChanged {
+			s = &model.SampleStream{Metric: s.Metric, Values: values, Histograms: histograms}
+		}
+		if valuesConflict || histogramsConflict {
+			conflicts = append(conflicts, s.Metric)
+		}
+		sanitized = append(sanitized, s)
+	}
+	return sanitized, conflicts
+}
+
+// sanitizeSamples returns a copy of samples stable sorted by timestamp, with each group of samples at the same timestamp merged into one by merge
+// conflict is true if the samples of any group had different values. Samples with strictly increasing timestamps are returned as is, with changed false.
+func sanitizeSamples[T any](samples []T, timestamp func(T) model.Time, equal func(a, b T) bool, merge func(group []T) T) (sanitized []T, conflict, changed bool) {
+	inOrder := true
+	for i := 1; i < len(samples) && inOrder; i++ {
+		inOrder = timestamp(samples[i-1]) < timestamp(samples[i])
+	}
+	if inOrder {
+		return samples, false, false
+	}
+	sorted := slices.Clone(samples)
+	slices.SortStableFunc(sorted, func(a, b T) int { return cmp.Compare(timestamp(a), timestamp(b)) })
+	sanitized = sorted[:0]
+	for i := 0; i < len(sorted); {
+		j := i + 1
+		for j < len(sorted) && timestamp(sorted[j]) == timestamp(sorted[i]) {
+			if !equal(sorted[i], sorted[j]) {
+				conflict = true
+			}
+			j++
+		}
+		// Merged samples are written over the front of sorted, which the remaining groups are read from after
+		sanitized = append(sanitized, merge(sorted[i:j]))
+		i = j
+	}
+	return sanitized, conflict, true
+}
@@ -0,0 +1,50 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package transform
+
+import (
+	"sort"
+
+	"github.com/prometheus/common/model"
+)
+
+// SortSeries sorts the series of result by their metric e.g. up{instance="a", job="b"}, so they're written in the same order whatever order the server returned them in
+// Results other than vectors and matrices are returned as is
+func SortSeries(result model.Value) model.Value {
+	switch r := result.(type) {
+	case model.Vector:
+		sortByMetric(r, func(s *model.Sample) model.Metric { return s.Metric })
+	case model.Matrix:
+		sortByMetric(r, func(s *model.SampleStream) model.Metric { return s.Metric })
+	}
+	return result
+}
+
+// sortByMetric stable sorts series in place by the string of their metric, formatting each once rather than on every comparison
+func sortByMetric[T any](series []T, metric func(T) model.Metric) {
+	type keyed struct {
+		key    string
+		series T
+	}
+	k := make([]keyed, len(series))
+	for i, s := range series {
+		k[i] = keyed{key: metric(s).String(), series: s}
+	}
+	sort.SliceStable(k, func(i, j int) bool { return k[i].key < k[j].key })
+	for i := range k {
+		series[i] = k[i].series
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// transform provides functions for modifying query results before they're written
+package transform
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// metrics returns the label sets of every series in a query result.
+// The returned metrics share their underlying maps with the result, so modifying them modifies the result.
+func metrics(result model.Value) ([]model.Metric, error) {
+	var m []model.Metric
+	switch r := result.(type) {
+	case model.Matrix:
+		for _, s := range r {
+			m = append(m, s.Metric)
+		}
+	case model.Vector:
+		for _, s := range r {
+			m = append(m, s.Metric)
+		}
+	default:
+		return nil, fmt.Errorf("unable to transform result: unknown query result type: %T", r)
+	}
+	return m, nil
+}
+
+// ParseRenames parses a list of old=new strings into a map of metric name renames
+func ParseRenames(renames []string) (map[model.LabelValue]model.LabelValue, error) {
+	r := make(map[model.LabelValue]model.LabelValue, len(renames))
+	for _, rename := range renames {
+		parts := strings.SplitN(rename, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid metric rename %q, expected old=new", rename)
+		}
+		r[model.LabelValue(parts[0])] = model.LabelValue(parts[1])
+	}
+	return r, nil
+}
+
+// SetLabel sets the label name to value on every series in result, replacing any existing value
+func SetLabel(result model.Value, name model.LabelName, value model.LabelValue) (model.Value, error) {
+	m, err := metrics(result)
+	if err != nil {
+		return result, err
+	}
+	for _, metric := range m {
+		metric[name] = value
+	}
+	return result, nil
+}
+
+// RenameMetrics rewrites the __name__ label of every series in result using the provided old -> new mapping.
+// Series whose name isn't in renames are left untouched.
+func RenameMetrics(result model.Value, renames map[model.LabelValue]model.LabelValue) (model.Value, error) {
+	m, err := metrics(result)
+	if err != nil {
+		return result, err
+	}
+	for _, metric := range m {
+		name, ok := metric[model.MetricNameLabel]
+		if !ok {
+			continue
+		}
+		if n, ok := renames[name]; ok {
+			metric[model.MetricNameLabel] = n
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,486 @@
+package transform
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenameMetrics(t *testing.T) {
+	renames, err := ParseRenames([]string{"my_metric=renamed_metric"})
+	assert.NoError(t, err)
+
+	result := model.Vector{
+		{Metric: model.Metric{"__name__": "my_metric", "job": "a"}, Value: 1},
+		{Metric: model.Metric{"__name__": "my_other_metric", "job": "b"}, Value: 2},
+		{Metric: model.Metric{"job": "c"}, Value: 3},
+	}
+	v, err := RenameMetrics(result, renames)
+	assert.NoError(t, err)
+	expected := model.Vector{
+		{Metric: model.Metric{"__name__": "renamed_metric", "job": "a"}, Value: 1},
+		{Metric: model.Metric{"__name__": "my_other_metric", "job": "b"}, Value: 2},
+		{Metric: model.Metric{"job": "c"}, Value: 3},
+	}
+	assert.Equal(t, expected, v)
+}
+
+func TestSetLabel(t *testing.T) {
+	cases := []struct {
+		Result   model.Value
+		Expected model.Value
+	}{
+		{
+			Result: model.Vector{
+				{Metric: model.Metric{"__name__": "up", "job": "a"}, Value: 1},
+				{Metric: model.Metric{"query": "old"}, Value: 2},
+			},
+			Expected: model.Vector{
+				{Metric: model.Metric{"__name__": "up", "job": "a", "query": "up"}, Value: 1},
+				{Metric: model.Metric{"query": "up"}, Value: 2},
+			},
+		},
+		{
+			Result:   model.Matrix{{Metric: model.Metric{"job": "a"}}},
+			Expected: model.Matrix{{Metric: model.Metric{"job": "a", "query": "up"}}},
+		},
+	}
+	for i, c := range cases {
+		r, err := SetLabel(c.Result, "query", "up")
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, r, "Unexpected output for case %d", i)
+	}
+	_, err := SetLabel(&model.Scalar{Value: 1}, "query", "up")
+	assert.Error(t, err)
+}
+
+func TestParseRenamesInvalid(t *testing.T) {
+	for _, r := range []string{"my_metric", "=new", "old="} {
+		_, err := ParseRenames([]string{r})
+		assert.Error(t, err, "Expected error for rename %q", r)
+	}
+}
+
+func TestRelabel(t *testing.T) {
+	cases := []struct {
+		Rules    []string
+		Result   model.Value
+		Expected model.Value
+	}{
+		{
+			// Capture group substitution into a new label
+			Rules: []string{`instance,host,${1},(.*):\d+`},
+			Result: model.Vector{
+				{Metric: model.Metric{"instance": "web-1:9100"}, Value: 1},
+				{Metric: model.Metric{"instance": "web-2"}, Value: 2},
+			},
+			Expected: model.Vector{
+				{Metric: model.Metric{"instance": "web-1:9100", "host": "web-1"}, Value: 1},
+				{Metric: model.Metric{"instance": "web-2"}, Value: 2},
+			},
+		},
+		{
+			// Default regex copies the whole value, an empty replacement removes the target
+			Rules: []string{"job,service,prom-$1", "instance,instance,"},
+			Result: model.Matrix{
+				{Metric: model.Metric{"job": "api", "instance": "web-1:9100"}},
+			},
+			Expected: model.Matrix{
+				{Metric: model.Metric{"job": "api", "service": "prom-api"}},
+			},
+		},
+		{
+			// Rules are applied in order and the regex may contain commas
+			Rules: []string{"code,class,${1}xx,([0-9])[0-9]{2,}", "class,class,errors,[45]xx"},
+			Result: model.Vector{
+				{Metric: model.Metric{"code": "200"}, Value: 1},
+				{Metric: model.Metric{"code": "503"}, Value: 2},
+			},
+			Expected: model.Vector{
+				{Metric: model.Metric{"code": "200", "class": "2xx"}, Value: 1},
+				{Metric: model.Metric{"code": "503", "class": "errors"}, Value: 2},
+			},
+		},
+	}
+	for i, c := range cases {
+		rules, err := ParseRelabelRules(c.Rules)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		v, err := Relabel(c.Result, rules)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, v, "Unexpected output for case %d", i)
+	}
+}
+
+func TestParseRelabelRuleInvalid(t *testing.T) {
+	for _, r := range []string{"instance,host", "1nstance,host,$1", "instance,host,$1,(", "instance,ho-st,$1"} {
+		_, err := ParseRelabelRule(r)
+		assert.Error(t, err, "Expected error for rule %q", r)
+	}
+}
+
+func TestFilterSeries(t *testing.T) {
+	v := model.Vector{
+		{Metric: model.Metric{"job": "api", "instance": "a"}, Value: 1},
+		{Metric: model.Metric{"job": "kube-state-metrics", "instance": "b"}, Value: 2},
+		{Metric: model.Metric{"job": "kube-proxy"}, Value: 3},
+		{Metric: model.Metric{"instance": "d"}, Value: 4},
+	}
+	cases := []struct {
+		Filters    []string
+		FiltersNot []string
+		Result     model.Value
+		Expected   model.Value
+	}{
+		{
+			Filters:  []string{"job=kube-.*"},
+			Result:   v,
+			Expected: model.Vector{v[1], v[2]},
+		},
+		{
+			// Regexes are anchored, and series without the label match it as empty
+			FiltersNot: []string{"job=kube-.*", "job=ap"},
+			Result:     v,
+			Expected:   model.Vector{v[0], v[3]},
+		},
+		{
+			Filters:    []string{"job=.+"},
+			FiltersNot: []string{"instance=b|"},
+			Result:     v,
+			Expected:   model.Vector{v[0]},
+		},
+		{
+			FiltersNot: []string{"job=.*"},
+			Result:     model.Matrix{{Metric: model.Metric{"job": "api"}}},
+			Expected:   model.Matrix{},
+		},
+	}
+	for i, c := range cases {
+		keep, err := ParseLabelFilters(c.Filters, false)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		drop, err := ParseLabelFilters(c.FiltersNot, true)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		r, err := FilterSeries(c.Result, append(keep, drop...))
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, r, "Unexpected output for case %d", i)
+	}
+	for _, f := range []string{"job", "1job=api", "job=(", "=api"} {
+		_, err := ParseLabelFilter(f, false)
+		assert.Error(t, err, "Expected error for filter %q", f)
+	}
+	_, err := FilterSeries(&model.Scalar{}, nil)
+	assert.Error(t, err)
+}
+
+func TestDropLabels(t *testing.T) {
+	cases := []struct {
+		Result   model.Value
+		Expected model.Value
+	}{
+		{
+			Result: model.Vector{
+				{Metric: model.Metric{"job": "api", "instance": "a"}, Value: 1, Timestamp: 10},
+				{Metric: model.Metric{"job": "db", "instance": "a"}, Value: 2, Timestamp: 10},
+				{Metric: model.Metric{"job": "api", "instance": "b"}, Value: 3, Timestamp: 10},
+			},
+			Expected: model.Vector{
+				{Metric: model.Metric{"job": "api"}, Value: 4, Timestamp: 10},
+				{Metric: model.Metric{"job": "db"}, Value: 2, Timestamp: 10},
+			},
+		},
+		{
+			Result: model.Matrix{
+				{
+					Metric: model.Metric{"job": "api", "instance": "a"},
+					Values: []model.SamplePair{{Timestamp: 10, Value: 1}, {Timestamp: 20, Value: 1}},
+				},
+				{
+					Metric: model.Metric{"job": "api", "instance": "b"},
+					Values: []model.SamplePair{{Timestamp: 20, Value: 2}, {Timestamp: 30, Value: 2}},
+				},
+			},
+			Expected: model.Matrix{
+				{
+					Metric: model.Metric{"job": "api"},
+					Values: []model.SamplePair{{Timestamp: 10, Value: 1}, {Timestamp: 20, Value: 3}, {Timestamp: 30, Value: 2}},
+				},
+			},
+		},
+	}
+	for i, c := range cases {
+		v, err := DropLabels(c.Result, []model.LabelName{"instance"})
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, v, "Unexpected output for case %d", i)
+	}
+}
+
+func TestFillGaps(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(steps int) model.Time { return model.TimeFromUnixNano(start.Add(time.Duration(steps) * time.Minute).UnixNano()) }
+	result := model.Matrix{
+		{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{{Timestamp: at(1), Value: 1}, {Timestamp: at(3), Value: 3}}},
+		{Metric: model.Metric{"job": "b"}, Values: []model.SamplePair{{Timestamp: at(0), Value: 5}}},
+	}
+	cases := []struct {
+		mode     string
+		expected [][]string
+	}{
+		{mode: FillZero, expected: [][]string{{"0", "1", "0", "3", "0"}, {"5", "0", "0", "0", "0"}}},
+		{mode: FillNaN, expected: [][]string{{"NaN", "1", "NaN", "3", "NaN"}, {"5", "NaN", "NaN", "NaN", "NaN"}}},
+		{mode: FillPrevious, expected: [][]string{{"NaN", "1", "1", "3", "3"}, {"5", "5", "5", "5", "5"}}},
+	}
+	for i, c := range cases {
+		// The start is evaluated again a little after the query ran, which shouldn't shift the steps
+		m, err := FillGaps(result, start.Add(150*time.Millisecond), start.Add(4*time.Minute+150*time.Millisecond), time.Minute, c.mode)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		var got [][]string
+		for _, s := range m {
+			var values []string
+			for j, v := range s.Values {
+				assert.Equal(t, at(j), v.Timestamp, "Unexpected timestamp for case %d", i)
+				values = append(values, v.Value.String())
+			}
+			got = append(got, values)
+		}
+		assert.Equal(t, c.expected, got, "Unexpected output for case %d", i)
+	}
+	assert.Len(t, result[0].Values, 2, "Expected the result to be left as is")
+
+	_, err := FillGaps(result, start, start, time.Minute, "linear")
+	assert.ErrorContains(t, err, "unknown --fill-gaps mode")
+}
+
+func TestResample(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) model.Time { return model.TimeFromUnixNano(start.Add(time.Duration(minutes) * time.Minute).UnixNano()) }
+	result := model.Matrix{
+		{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{
+			{Timestamp: at(1), Value: 4}, {Timestamp: at(3), Value: 2}, {Timestamp: at(5), Value: 6},
+			{Timestamp: at(6), Value: 1}, {Timestamp: at(14), Value: 3},
+		}},
+		{Metric: model.Metric{"job": "b"}},
+	}
+	cases := []struct {
+		flag     string
+		expected []string
+		err      string
+	}{
+		// Samples at the end of an interval belong to it, and empty intervals are left out
+		{flag: "5m:avg", expected: []string{"5:4", "10:1", "15:3"}},
+		{flag: "5m:min", expected: []string{"5:2", "10:1", "15:3"}},
+		{flag: "5m:max", expected: []string{"5:6", "10:1", "15:3"}},
+		{flag: "5m:last", expected: []string{"5:6", "10:1", "15:3"}},
+		{flag: "5m:sum", expected: []string{"5:12", "10:1", "15:3"}},
+		{flag: "1h:sum", expected: []string{"60:16"}},
+		{flag: "5m", err: "invalid --resample"},
+		{flag: "0m:avg", err: "invalid --resample interval"},
+		{flag: "5m:median", err: "unknown --resample aggregation"},
+	}
+	for i, c := range cases {
+		interval, agg, err := ParseResample(c.flag)
+		if c.err != "" {
+			assert.ErrorContains(t, err, c.err, "Unexpected err for case %d", i)
+			continue
+		}
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		m, err := Resample(result, interval, agg)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		var got []string
+		for _, v := range m[0].Values {
+			got = append(got, fmt.Sprintf("%d:%s", int(v.Timestamp.Time().Sub(start).Minutes()), v.Value))
+		}
+		assert.Equal(t, c.expected, got, "Unexpected output for case %d", i)
+		assert.Empty(t, m[1].Values, "Unexpected output for case %d", i)
+	}
+	assert.Len(t, result[0].Values, 5, "Expected the result to be left as is")
+}
+
+func TestHeadTail(t *testing.T) {
+	result := model.Matrix{
+		{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{
+			{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}, {Timestamp: 3, Value: 3}, {Timestamp: 4, Value: 4},
+		}},
+		{Metric: model.Metric{"job": "b"}, Values: []model.SamplePair{{Timestamp: 1, Value: 5}}},
+		{Metric: model.Metric{"job": "c"}},
+	}
+	cases := []struct {
+		window   func(model.Matrix, int) model.Matrix
+		n        int
+		expected [][]model.SampleValue
+	}{
+		{window: Head, n: 2, expected: [][]model.SampleValue{{1, 2}, {5}, nil}},
+		{window: Tail, n: 2, expected: [][]model.SampleValue{{3, 4}, {5}, nil}},
+		// Series with fewer samples are kept whole
+		{window: Head, n: 10, expected: [][]model.SampleValue{{1, 2, 3, 4}, {5}, nil}},
+		{window: Tail, n: 10, expected: [][]model.SampleValue{{1, 2, 3, 4}, {5}, nil}},
+		{window: Tail, n: 0, expected: [][]model.SampleValue{nil, nil, nil}},
+	}
+	for i, c := range cases {
+		m := c.window(result, c.n)
+		var got [][]model.SampleValue
+		for j, s := range m {
+			assert.Equal(t, result[j].Metric, s.Metric, "Unexpected output for case %d", i)
+			var values []model.SampleValue
+			for _, v := range s.Values {
+				values = append(values, v.Value)
+			}
+			got = append(got, values)
+		}
+		assert.Equal(t, c.expected, got, "Unexpected output for case %d", i)
+	}
+	assert.Len(t, result[0].Values, 4, "Expected the result to be left as is")
+}
+
+func TestSanitize(t *testing.T) {
+	pairs := func(samples ...float64) []model.SamplePair {
+		var p []model.SamplePair
+		for i := 0; i < len(samples); i += 2 {
+			p = append(p, model.SamplePair{Timestamp: model.Time(samples[i]), Value: model.SampleValue(samples[i+1])})
+		}
+		return p
+	}
+	ordered := &model.SampleStream{Metric: model.Metric{"job": "ordered"}, Values: pairs(1, 1, 2, 2, 3, 3)}
+	cases := []struct {
+		series    *model.SampleStream
+		expected  []model.SamplePair
+		conflicts bool
+	}{
+		{series: ordered, expected: ordered.Values},
+		{series: &model.SampleStream{Values: pairs(3, 3, 1, 1, 2, 2)}, expected: pairs(1, 1, 2, 2, 3, 3)},
+		// Exact duplicates are collapsed without a conflict
+		{series: &model.SampleStream{Values: pairs(1, 1, 2, 2, 1, 1, 2, 2)}, expected: pairs(1, 1, 2, 2)},
+		{series: &model.SampleStream{Values: pairs(1, math.NaN(), 1, math.NaN())}, expected: pairs(1, math.NaN())},
+		// Disagreeing duplicates keep the last value returned
+		{series: &model.SampleStream{Values: pairs(2, 5, 1, 1, 2, 6)}, expected: pairs(1, 1, 2, 6), conflicts: true},
+		{series: &model.SampleStream{}},
+	}
+	for i, c := range cases {
+		m, conflicts := Sanitize(model.Matrix{c.series}, MergeLast)
+		assert.Equal(t, len(c.expected), len(m[0].Values), "Unexpected output for case %d", i)
+		for j := range c.expected {
+			assert.True(t, c.expected[j].Equal(&m[0].Values[j]), "Unexpected output for case %d", i)
+		}
+		assert.Equal(t, c.conflicts, len(conflicts) > 0, "Unexpected conflicts for case %d", i)
+	}
+	m, _ := Sanitize(model.Matrix{ordered}, MergeLast)
+	assert.Same(t, ordered, m[0], "Expected series in order to be kept as is")
+	histograms := &model.SampleStream{Histograms: []model.SampleHistogramPair{
+		{Timestamp: 2, Histogram: &model.SampleHistogram{Count: 2}},
+		{Timestamp: 1, Histogram: &model.SampleHistogram{Count: 1}},
+		{Timestamp: 2, Histogram: &model.SampleHistogram{Count: 3}},
+	}}
+	m, conflicts := Sanitize(model.Matrix{histograms}, MergeLast)
+	assert.Len(t, m[0].Histograms, 2)
+	assert.Equal(t, model.FloatString(3), m[0].Histograms[1].Histogram.Count)
+	assert.Len(t, conflicts, 1)
+	m, _ = Sanitize(model.Matrix{histograms}, MergeMax)
+	assert.Equal(t, model.FloatString(3), m[0].Histograms[1].Histogram.Count, "Expected histograms to keep the last sample")
+	m, _ = Sanitize(model.Matrix{histograms}, MergeFirst)
+	assert.Equal(t, model.FloatString(2), m[0].Histograms[1].Histogram.Count)
+}
+
+func TestMergeDuplicateTimestamps(t *testing.T) {
+	series := &model.SampleStream{Values: []model.SamplePair{
+		{Timestamp: 2, Value: 4}, {Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 8}, {Timestamp: 2, Value: 3}, {Timestamp: 3, Value: 5},
+	}}
+	cases := []struct {
+		merge    string
+		expected []model.SampleValue
+		err      bool
+	}{
+		{merge: MergeFirst, expected: []model.SampleValue{1, 4, 5}},
+		{merge: MergeLast, expected: []model.SampleValue{1, 3, 5}},
+		{merge: MergeMax, expected: []model.SampleValue{1, 8, 5}},
+		{merge: MergeMin, expected: []model.SampleValue{1, 3, 5}},
+		{merge: MergeAvg, expected: []model.SampleValue{1, 5, 5}},
+		{merge: "sum", err: true},
+	}
+	for i, c := range cases {
+		merge, err := ParseMerge(c.merge)
+		if c.err {
+			assert.Error(t, err, "Expected err for case %d", i)
+			continue
+		}
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		m, conflicts := Sanitize(model.Matrix{series}, merge)
+		var got []model.SampleValue
+		for j, v := range m[0].Values {
+			assert.Equal(t, model.Time(j+1), v.Timestamp, "Unexpected output for case %d", i)
+			got = append(got, v.Value)
+		}
+		assert.Equal(t, c.expected, got, "Unexpected output for case %d", i)
+		assert.Len(t, conflicts, 1, "Unexpected conflicts for case %d", i)
+	}
+	assert.Equal(t, model.SampleValue(4), series.Values[0].Value, "Expected the result to be left as is")
+}
+
+func TestFlattenLabels(t *testing.T) {
+	cases := []struct {
+		Result   model.Value
+		Opts     FlattenOptions
+		Expected model.Value
+	}{
+		{
+			Result: model.Vector{
+				{Metric: model.Metric{"__name__": "up", "job": "api", "instance": "a:9090"}, Value: 1, Timestamp: 10},
+			},
+			Expected: model.Vector{
+				{Metric: model.Metric{"__name__": "up", "labels": "instance=a:9090,job=api"}, Value: 1, Timestamp: 10},
+			},
+		},
+		{
+			Result: model.Matrix{
+				{
+					Metric: model.Metric{"job": "api", "instance": "a:9090"},
+					Values: []model.SamplePair{{Timestamp: 10, Value: 1}},
+				},
+			},
+			Opts: FlattenOptions{Separator: ";", KeyValueSeparator: ":"},
+			Expected: model.Matrix{
+				{
+					Metric: model.Metric{"labels": "instance:a:9090;job:api"},
+					Values: []model.SamplePair{{Timestamp: 10, Value: 1}},
+				},
+			},
+		},
+	}
+	for i, c := range cases {
+		result, err := FlattenLabels(c.Result, c.Opts)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, result, "Unexpected output for case %d", i)
+	}
+}
+
+func TestSortSeries(t *testing.T) {
+	metrics := []model.Metric{
+		{"__name__": "up", "instance": "b", "job": "api"},
+		{"__name__": "up", "instance": "a", "job": "api"},
+		{"__name__": "up", "instance": "a", "job": "web"},
+		{"__name__": "node_load1", "instance": "a"},
+		{"instance": "a"},
+	}
+	expected := []model.Metric{metrics[3], metrics[1], metrics[2], metrics[0], metrics[4]}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		v := make(model.Vector, 0, len(metrics))
+		m := make(model.Matrix, 0, len(metrics))
+		for _, metric := range metrics {
+			v = append(v, &model.Sample{Metric: metric})
+			m = append(m, &model.SampleStream{Metric: metric})
+		}
+		r.Shuffle(len(v), func(i, j int) { v[i], v[j] = v[j], v[i] })
+		r.Shuffle(len(m), func(i, j int) { m[i], m[j] = m[j], m[i] })
+		sortedV := SortSeries(v).(model.Vector)
+		sortedM := SortSeries(m).(model.Matrix)
+		for j := range expected {
+			assert.Equal(t, expected[j], sortedV[j].Metric, "Unexpected vector output for case %d", i)
+			assert.Equal(t, expected[j], sortedM[j].Metric, "Unexpected matrix output for case %d", i)
+		}
+	}
+	s := &model.Scalar{Value: 1}
+	assert.Equal(t, s, SortSeries(s))
+}
@@ -0,0 +1,50 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package transform
+
+import (
+	"github.com/prometheus/common/model"
+)
+
+// Head keeps the first n samples of each series of m, returning a new matrix
+// Series with n or fewer samples are kept whole
+func Head(m model.Matrix, n int) model.Matrix {
+	return window(m, func(l int) (int, int) { return 0, min(n, l) })
+}
+
+// Tail keeps the last n samples of each series of m, returning a new matrix
+// Series with n or fewer samples are kept whole
+func Tail(m model.Matrix, n int) model.Matrix {
+	return window(m, func(l int) (int, int) { return max(l-n, 0), l })
+}
+
+// window slices the float and histogram samples of each series of m to the bounds returned for their number of samples
+func window(m model.Matrix, bounds func(l int) (int, int)) model.Matrix {
+	w := make(model.Matrix, 0, len(m))
+	for _, s := range m {
+		c := &model.SampleStream{Metric: s.Metric}
+		if s.Values != nil {
+			from, to := bounds(len(s.Values))
+			c.Values = s.Values[from:to]
+		}
+		if s.Histograms != nil {
+			from, to := bounds(len(s.Histograms))
+			c.Histograms = s.Histograms[from:to]
+		}
+		w = append(w, c)
+	}
+	return w
+}
@@ -28,6 +28,7 @@ import (
 )
 
 // UniqLabels takes an interface model.Value and returns a slice of label names.
+// The labels are the union of the labels of every series, sorted by name, so every label gets a column in the same place regardless of series order.
 func UniqLabels(result model.Value) (labels []model.LabelName, err error) {
 	labelKeys := make(map[model.LabelName]struct{})
 	switch r := result.(type) {
@@ -58,6 +59,20 @@ func UniqLabels(result model.Value) (labels []model.LabelName, err error) {
 	return labels, err
 }
 
+// metricSuffixes are the suffixes of histogram and summary series, whose metadata is under their base metric name
+var metricSuffixes = []string{"_bucket", "_sum", "_count"}
+
+// BaseMetricName returns the name of the histogram or summary a series named name belongs to (e.g. foo for foo_bucket),
+// and whether name had a histogram or summary suffix
+func BaseMetricName(name string) (string, bool) {
+	for _, suffix := range metricSuffixes {
+		if base := strings.TrimSuffix(name, suffix); base != name && base != "" {
+			return base, true
+		}
+	}
+	return name, false
+}
+
 // TermDimensions stores the width and height of the current terminal window
 // Used when setting the ascii graph size for range queries
 type TermDimensions struct {
@@ -75,7 +90,7 @@ func TerminalSize() (dimensions TermDimensions, err error) {
 	sttySize.Stdin = os.Stdin
 	stdout, err = sttySize.Output()
 	if err != nil {
-		return dimensions, err
+		return dimensions, fmt.Errorf("stty size: %v", err)
 	}
 	o := strings.TrimSuffix(string(stdout), "\n")
 	d := strings.Split(o, " ")
@@ -89,3 +104,53 @@ func TerminalSize() (dimensions TermDimensions, err error) {
 	}
 	return dimensions, nil
 }
+
+// IsTerminal returns whether f is a terminal, as opposed to e.g. a pipe or a file
+// Used to only write terminal escape codes like colors when they'll be displayed
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Page selects a page of a sorted listing, e.g. of label or metric names
+// A zero Limit means every item after Offset
+type Page struct {
+	Offset int
+	Limit  int
+}
+
+// Enabled returns whether the page selects anything but the whole listing
+func (p Page) Enabled() bool {
+	return p.Offset > 0 || p.Limit > 0
+}
+
+// Bounds returns the start and end indexes of the page in a listing of total items
+func (p Page) Bounds(total int) (start, end int) {
+	start = p.Offset
+	if start > total {
+		start = total
+	}
+	end = total
+	if p.Limit > 0 && start+p.Limit < total {
+		end = start + p.Limit
+	}
+	return start, end
+}
+
+// Footer describes which items of a listing of total items are on the page, e.g. "showing 1-100 of 50234"
+func (p Page) Footer(total int) string {
+	start, end := p.Bounds(total)
+	if start == end {
+		return fmt.Sprintf("showing 0 of %d", total)
+	}
+	return fmt.Sprintf("showing %d-%d of %d", start+1, end, total)
+}
+
+// Paginate returns the items on page p
+func Paginate[T any](items []T, p Page) []T {
+	start, end := p.Bounds(len(items))
+	return items[start:end]
+}
@@ -0,0 +1,63 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Time bucket modes, adding csv columns that group samples by their timestamp e.g. for spreadsheet pivot tables
+const (
+	// TimeBucketsHour adds the date and hour of each sample
+	TimeBucketsHour = "hour"
+	// TimeBucketsDay adds the date of each sample
+	TimeBucketsDay = "day"
+	// TimeBucketsWeek adds the ISO week of each sample, e.g. 2020-W39
+	TimeBucketsWeek = "week"
+)
+
+// timeBucketColumns returns the names of the columns added by the time buckets mode
+func timeBucketColumns(mode string) ([]string, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case TimeBucketsHour:
+		return []string{"date", "hour"}, nil
+	case TimeBucketsDay:
+		return []string{"date"}, nil
+	case TimeBucketsWeek:
+		return []string{"week"}, nil
+	default:
+		return nil, fmt.Errorf("unknown time buckets %q, options: %s,%s,%s", mode, TimeBucketsHour, TimeBucketsDay, TimeBucketsWeek)
+	}
+}
+
+// timeBucketCells returns the cells of the columns added by the time buckets mode for a sample at t, in t's location
+func timeBucketCells(mode string, t time.Time) []string {
+	switch mode {
+	case TimeBucketsHour:
+		return []string{t.Format(time.DateOnly), strconv.Itoa(t.Hour())}
+	case TimeBucketsDay:
+		return []string{t.Format(time.DateOnly)}
+	case TimeBucketsWeek:
+		year, week := t.ISOWeek()
+		return []string{fmt.Sprintf("%d-W%02d", year, week)}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/nalbury/promql-cli/pkg/assertion"
+)
+
+// ANSI color codes used for table values
+const (
+	colorRed     = "31"
+	colorGreen   = "32"
+	colorYellow  = "33"
+	colorDefault = "39"
+)
+
+// ColorThresholds colors table values by the thresholds they cross, e.g. yellow above a warning and red above a critical threshold
+type ColorThresholds struct {
+	Warn *assertion.Condition
+	Crit *assertion.Condition
+}
+
+// ParseColorThresholds parses thresholds in the form "warn:>80,crit:>95", either threshold may be omitted
+func ParseColorThresholds(s string) (*ColorThresholds, error) {
+	var c ColorThresholds
+	for _, t := range strings.Split(s, ",") {
+		level, expr, ok := strings.Cut(strings.TrimSpace(t), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid color threshold %q, expected a level and condition like 'warn:>80'", t)
+		}
+		cond, err := assertion.ParseCondition(expr)
+		if err != nil {
+			return nil, err
+		}
+		switch strings.TrimSpace(level) {
+		case "warn":
+			c.Warn = &cond
+		case "crit":
+			c.Crit = &cond
+		default:
+			return nil, fmt.Errorf("unknown color threshold level %q, options: warn,crit", level)
+		}
+	}
+	return &c, nil
+}
+
+// color returns the color of a value, red if it crosses the critical threshold, yellow if it crosses the warning threshold, and green otherwise
+// Values that can't be compared (NaN) are left the default color
+func (c *ColorThresholds) color(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return colorDefault
+	case c.Crit != nil && c.Crit.Eval(v):
+		return colorRed
+	case c.Warn != nil && c.Warn.Eval(v):
+		return colorYellow
+	default:
+		return colorGreen
+	}
+}
+
+// colorize wraps s in the escape codes for an ANSI color
+// Every color code is the same length, so columns of colorized cells stay aligned in tabwriter tables
+func colorize(s string, color string) string {
+	return "\x1b[" + color + "m" + s + "\x1b[0m"
+}
@@ -0,0 +1,306 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/nalbury/promql-cli/pkg/util"
+	"github.com/prometheus/common/model"
+)
+
+// CSV quoting modes
+const (
+	// CSVQuoteMinimal only quotes fields that need it, the encoding/csv default
+	CSVQuoteMinimal = "minimal"
+	// CSVQuoteAlways quotes every field, for strict csv consumers
+	CSVQuoteAlways = "always"
+)
+
+// csvFlushRows is the number of rows streamed csv output is flushed every, so large results are written out as they're generated
+const csvFlushRows = 1000
+
+// CSVStreamer is implemented by results that can write their csv output directly to an io.Writer, row by row,
+// rather than building the whole document in memory first
+// The csv settings of opts apply: NoHeaders, CSVQuote, TimeBuckets, and for range results CSVPivot
+type CSVStreamer interface {
+	WriteCsv(w io.Writer, opts Options) error
+}
+
+// csvWriter writes csv rows with the given quoting mode, flushing them every csvFlushRows rows
+type csvWriter struct {
+	minimal *csv.Writer
+	// always is the buffered output CSVQuoteAlways rows are written to, as encoding/csv only quotes fields that need it
+	always *bufio.Writer
+	rows   int
+}
+
+// newCSVWriter returns a csvWriter writing to w with the quoting mode quote, one of the CSVQuote constants
+func newCSVWriter(w io.Writer, quote string) (*csvWriter, error) {
+	switch quote {
+	case "", CSVQuoteMinimal:
+		return &csvWriter{minimal: csv.NewWriter(w)}, nil
+	case CSVQuoteAlways:
+		return &csvWriter{always: bufio.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown csv quote mode %q, options: %s,%s", quote, CSVQuoteMinimal, CSVQuoteAlways)
+	}
+}
+
+// Write writes a single row
+func (w *csvWriter) Write(row []string) error {
+	if w.minimal != nil {
+		if err := w.minimal.Write(row); err != nil {
+			return err
+		}
+	} else {
+		for i, f := range row {
+			if i > 0 {
+				if err := w.always.WriteByte(','); err != nil {
+					return err
+				}
+			}
+			if err := w.writeQuoted(f); err != nil {
+				return err
+			}
+		}
+		if err := w.always.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	w.rows++
+	if w.rows%csvFlushRows == 0 {
+		return w.Flush()
+	}
+	return nil
+}
+
+// writeQuoted writes the field f wrapped in double quotes, doubling any quotes within it
+func (w *csvWriter) writeQuoted(f string) error {
+	if err := w.always.WriteByte('"'); err != nil {
+		return err
+	}
+	for {
+		i := strings.IndexByte(f, '"')
+		if i < 0 {
+			break
+		}
+		if _, err := w.always.WriteString(f[:i+1]); err != nil {
+			return err
+		}
+		if err := w.always.WriteByte('"'); err != nil {
+			return err
+		}
+		f = f[i+1:]
+	}
+	if _, err := w.always.WriteString(f); err != nil {
+		return err
+	}
+	return w.always.WriteByte('"')
+}
+
+// Flush writes any buffered rows to the underlying io.Writer
+func (w *csvWriter) Flush() error {
+	if w.minimal != nil {
+		w.minimal.Flush()
+		return w.minimal.Error()
+	}
+	return w.always.Flush()
+}
+
+// writeCSVHeader writes the header row of labels followed by the value and timestamp columns, and any time bucket columns, unless noHeaders is set
+func writeCSVHeader(w *csvWriter, labels []model.LabelName, buckets []string, noHeaders bool) error {
+	if noHeaders {
+		return nil
+	}
+	titleRow := make([]string, 0, len(labels)+2+len(buckets))
+	for _, k := range labels {
+		titleRow = append(titleRow, string(k))
+	}
+	titleRow = append(titleRow, "value", "timestamp")
+	return w.Write(append(titleRow, buckets...))
+}
+
+// WriteCsv writes the response from a range query as a csv to out, a row at a time
+func (r *RangeResult) WriteCsv(out io.Writer, opts Options) error {
+	w, err := newCSVWriter(out, opts.CSVQuote)
+	if err != nil {
+		return err
+	}
+	buckets, err := timeBucketColumns(opts.TimeBuckets)
+	if err != nil {
+		return err
+	}
+	if opts.CSVPivot {
+		if err := r.writePivotCsv(w, buckets, opts); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+	labels, err := util.UniqLabels(r.Matrix)
+	if err != nil {
+		return err
+	}
+	if err := writeCSVHeader(w, labels, buckets, opts.NoHeaders); err != nil {
+		return err
+	}
+	// Generate the rows of each series as they're written, rather than all of them up front like RangeRows
+	times := newTimeCells(opts.TimeBuckets)
+	for _, s := range r.Matrix {
+		row := labelCells(s.Metric, labels, 2+len(buckets))
+		for _, v := range s.Values {
+			row = sampleCells(row, len(labels), v.Value, times.cells(v.Timestamp))
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// writePivotCsv writes the response from a range query with a row per timestamp, the union of the timestamps of every series, and a column per series
+// Series columns are headed by the series' labels e.g. up{instance="a"}, and are left empty at timestamps the series has no sample at
+// Samples must be sorted by timestamp, as they are unless --no-sanitize is set. Of samples repeating a timestamp only the first is written
+func (r *RangeResult) writePivotCsv(w *csvWriter, buckets []string, opts Options) error {
+	if !opts.NoHeaders {
+		header := make([]string, 0, 1+len(buckets)+len(r.Matrix))
+		header = append(append(header, "timestamp"), buckets...)
+		for _, s := range r.Matrix {
+			header = append(header, s.Metric.String())
+		}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+	}
+	var timestamps []model.Time
+	for _, s := range r.Matrix {
+		for _, v := range s.Values {
+			timestamps = append(timestamps, v.Timestamp)
+		}
+	}
+	slices.Sort(timestamps)
+	timestamps = slices.Compact(timestamps)
+	times := newTimeCells(opts.TimeBuckets)
+	// next is the index of the next sample of each series to write
+	next := make([]int, len(r.Matrix))
+	row := make([]string, 0, 1+len(buckets)+len(r.Matrix))
+	for _, t := range timestamps {
+		row = append(row[:0], times.cells(t)...)
+		for i, s := range r.Matrix {
+			for next[i] < len(s.Values) && s.Values[next[i]].Timestamp < t {
+				next[i]++
+			}
+			cell := ""
+			if next[i] < len(s.Values) && s.Values[next[i]].Timestamp == t {
+				cell = FormatValue(float64(s.Values[next[i]].Value))
+				next[i]++
+			}
+			row = append(row, cell)
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCsv writes the response from an instant query as a csv to out, a row at a time
+func (r *InstantResult) WriteCsv(out io.Writer, opts Options) error {
+	w, err := newCSVWriter(out, opts.CSVQuote)
+	if err != nil {
+		return err
+	}
+	buckets, err := timeBucketColumns(opts.TimeBuckets)
+	if err != nil {
+		return err
+	}
+	labels, err := util.UniqLabels(r.Vector)
+	if err != nil {
+		return err
+	}
+	if err := writeCSVHeader(w, labels, buckets, opts.NoHeaders); err != nil {
+		return err
+	}
+	times := newTimeCells(opts.TimeBuckets)
+	var row []string
+	for _, s := range r.Vector {
+		row = sampleCells(labelCellsInto(row, s.Metric, labels), len(labels), s.Value, times.cells(s.Timestamp))
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// writeCSV writes the csv output of r to w with the quoting mode of opts
+// Results implementing CSVStreamer are streamed, which matters for long ranges of many series. Time buckets only apply to them, other results have no samples to bucket
+func writeCSV(w io.Writer, r Writer, opts Options) error {
+	if s, ok := r.(CSVStreamer); ok {
+		out := bufio.NewWriter(w)
+		if err := s.WriteCsv(out, opts); err != nil {
+			return err
+		}
+		return out.Flush()
+	}
+	var buf bytes.Buffer
+	if err := r.Csv(&buf, opts.NoHeaders); err != nil {
+		return err
+	}
+	buf, err := csvQuote(buf, opts.CSVQuote)
+	if err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// csvQuote rewrites csv output with the given quoting mode
+func csvQuote(buf bytes.Buffer, mode string) (bytes.Buffer, error) {
+	switch mode {
+	case "", CSVQuoteMinimal:
+		return buf, nil
+	case CSVQuoteAlways:
+		return quoteAll(buf)
+	default:
+		return buf, fmt.Errorf("unknown csv quote mode %q, options: %s,%s", mode, CSVQuoteMinimal, CSVQuoteAlways)
+	}
+}
+
+// quoteAll rewrites csv output with every field wrapped in double quotes
+func quoteAll(buf bytes.Buffer) (bytes.Buffer, error) {
+	var out bytes.Buffer
+	r := csv.NewReader(&buf)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return out, err
+	}
+	for _, record := range records {
+		fields := make([]string, len(record))
+		for i, f := range record {
+			fields[i] = `"` + strings.ReplaceAll(f, `"`, `""`) + `"`
+		}
+		out.WriteString(strings.Join(fields, ","))
+		out.WriteString("\n")
+	}
+	return out, nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"math"
+
+	"github.com/prometheus/common/model"
+)
+
+// The arrows marking table values that went up or down
+const (
+	deltaUp   = "↑"
+	deltaDown = "↓"
+)
+
+// Deltas tracks the value of each series across tables of the same query, e.g. between the refreshes of --watch
+// Table values that went up or down since the previous table are marked with an arrow
+type Deltas struct {
+	// Color colors values that went up green and values that went down red
+	Color bool
+	// previous holds the values of the previous table by series, current those of the table being written
+	previous map[model.Fingerprint]float64
+	current  map[model.Fingerprint]float64
+}
+
+// mark returns the value cell s of v, the value of the series m, marked with an arrow if v changed since the previous table
+// Every cell is padded to the same width, so the arrows line up. It also returns the color of the change, empty if v didn't change.
+// Series that weren't in the previous table, and NaN values, aren't marked
+func (d *Deltas) mark(m model.Metric, v float64, s string) (string, string) {
+	if d.current == nil {
+		d.current = map[model.Fingerprint]float64{}
+	}
+	fp := m.Fingerprint()
+	d.current[fp] = v
+	prev, ok := d.previous[fp]
+	switch {
+	case !ok || math.IsNaN(v) || math.IsNaN(prev) || v == prev:
+		return s + "  ", ""
+	case v > prev:
+		return s + " " + deltaUp, colorGreen
+	default:
+		return s + " " + deltaDown, colorRed
+	}
+}
+
+// next makes the values of the table just written the ones the next table is compared to
+func (d *Deltas) next() {
+	d.previous, d.current = d.current, nil
+}
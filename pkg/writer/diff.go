@@ -0,0 +1,274 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/nalbury/promql-cli/pkg/util"
+	"github.com/prometheus/common/model"
+)
+
+// Diff statuses for a single series
+const (
+	DiffOK       = "ok"
+	DiffMismatch = "mismatch"
+	DiffOnlyA    = "only_a"
+	DiffOnlyB    = "only_b"
+)
+
+// Tolerance is the allowed difference between two values before they're considered a mismatch
+type Tolerance struct {
+	Value float64
+	// Percent makes Value relative to the first value rather than absolute
+	Percent bool
+}
+
+// ParseTolerance parses a tolerance as either an absolute value (e.g. 0.5) or a percentage (e.g. 0.1%)
+func ParseTolerance(s string) (Tolerance, error) {
+	var t Tolerance
+	if s == "" {
+		return t, nil
+	}
+	num := s
+	if strings.HasSuffix(s, "%") {
+		t.Percent = true
+		num = strings.TrimSuffix(s, "%")
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return t, fmt.Errorf("invalid tolerance %q, expected a positive number or percentage (e.g. 0.5 or 0.1%%)", s)
+	}
+	t.Value = v
+	return t, nil
+}
+
+// DiffRow is the comparison of a single series between two results
+// ValueA or ValueB is nil when the series is only present in the other result
+type DiffRow struct {
+	Metric  model.Metric `json:"metric"`
+	ValueA  *float64     `json:"value_a"`
+	ValueB  *float64     `json:"value_b"`
+	Diff    *float64     `json:"diff"`
+	DiffPct *float64     `json:"diff_pct"`
+	Status  string       `json:"status"`
+}
+
+// JSONStringValues writes the values of json output that would otherwise be json numbers as strings, like prometheus writes sample values
+// Sample values of query results are always strings, this applies to the values of DiffResult and flat json output
+var JSONStringValues bool
+
+// MarshalJSON encodes the row, with its values as strings if JSONStringValues is set
+func (r DiffRow) MarshalJSON() ([]byte, error) {
+	type row DiffRow
+	if !JSONStringValues {
+		return json.Marshal(row(r))
+	}
+	str := func(v *float64) *string {
+		if v == nil {
+			return nil
+		}
+		s := model.SampleValue(*v).String()
+		return &s
+	}
+	return json.Marshal(struct {
+		Metric  model.Metric `json:"metric"`
+		ValueA  *string      `json:"value_a"`
+		ValueB  *string      `json:"value_b"`
+		Diff    *string      `json:"diff"`
+		DiffPct *string      `json:"diff_pct"`
+		Status  string       `json:"status"`
+	}{Metric: r.Metric, ValueA: str(r.ValueA), ValueB: str(r.ValueB), Diff: str(r.Diff), DiffPct: str(r.DiffPct), Status: r.Status})
+}
+
+// DiffResult is the comparison of the same instant query against two servers, joined by label set
+// It satisfies the InstantWriter interface
+type DiffResult []DiffRow
+
+// Diff joins two instant query results by label set and compares their values using the provided tolerance
+func Diff(a, b model.Vector, tolerance Tolerance) DiffResult {
+	type pair struct {
+		metric model.Metric
+		a, b   *float64
+	}
+	pairs := make(map[model.Fingerprint]*pair)
+	for _, s := range a {
+		v := float64(s.Value)
+		pairs[s.Metric.Fingerprint()] = &pair{metric: s.Metric, a: &v}
+	}
+	for _, s := range b {
+		v := float64(s.Value)
+		if p, ok := pairs[s.Metric.Fingerprint()]; ok {
+			p.b = &v
+			continue
+		}
+		pairs[s.Metric.Fingerprint()] = &pair{metric: s.Metric, b: &v}
+	}
+
+	var d DiffResult
+	for _, p := range pairs {
+		row := DiffRow{Metric: p.metric, ValueA: p.a, ValueB: p.b}
+		switch {
+		case p.b == nil:
+			row.Status = DiffOnlyA
+		case p.a == nil:
+			row.Status = DiffOnlyB
+		default:
+			diff := *p.b - *p.a
+			pct := 0.0
+			if diff != 0 {
+				pct = diff / math.Abs(*p.a) * 100
+			}
+			row.Diff, row.DiffPct = &diff, &pct
+			row.Status = DiffOK
+			bothNaN := math.IsNaN(*p.a) && math.IsNaN(*p.b)
+			if !bothNaN && !tolerance.within(diff, pct) {
+				row.Status = DiffMismatch
+			}
+		}
+		d = append(d, row)
+	}
+	sort.Slice(d, func(i, j int) bool {
+		return d[i].Metric.String() < d[j].Metric.String()
+	})
+	return d
+}
+
+// within reports whether a difference is within the tolerance
+func (t Tolerance) within(diff, pct float64) bool {
+	if t.Percent {
+		return math.Abs(pct) <= t.Value
+	}
+	return math.Abs(diff) <= t.Value
+}
+
+// Mismatches returns the number of series that differ beyond the tolerance or are only present in one result
+func (r *DiffResult) Mismatches() int {
+	n := 0
+	for _, row := range *r {
+		if row.Status != DiffOK {
+			n++
+		}
+	}
+	return n
+}
+
+// vector returns the union of series in the diff, used to determine the label columns
+func (r *DiffResult) vector() model.Vector {
+	v := make(model.Vector, 0, len(*r))
+	for _, row := range *r {
+		v = append(v, &model.Sample{Metric: row.Metric})
+	}
+	return v
+}
+
+// formatDiffValue formats an optional value, missing values are written as empty strings
+func formatDiffValue(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return FormatValue(*v)
+}
+
+// rows returns the label values and comparison columns of each row
+func (r *DiffResult) rows(labels []model.LabelName) [][]string {
+	rows := make([][]string, 0, len(*r))
+	for _, row := range *r {
+		data := labelCells(row.Metric, labels, 5)
+		pct := ""
+		if row.DiffPct != nil {
+			pct = strconv.FormatFloat(*row.DiffPct, 'f', 2, 64) + "%"
+		}
+		data = append(data, formatDiffValue(row.ValueA), formatDiffValue(row.ValueB), formatDiffValue(row.Diff), pct, row.Status)
+		rows = append(rows, data)
+	}
+	return rows
+}
+
+// Table writes the comparison as a tab separated table
+func (r *DiffResult) Table(out io.Writer, noHeaders bool, opts TableOptions) error {
+	const padding = 4
+	w := tabwriter.NewWriter(out, 0, 0, padding, ' ', 0)
+	labels, err := util.UniqLabels(r.vector())
+	if err != nil {
+		return err
+	}
+	var titles []string
+	if !noHeaders {
+		for _, k := range labels {
+			titles = append(titles, strings.ToUpper(string(k)))
+		}
+		titles = append(titles, "VALUE_A", "VALUE_B", "DIFF", "DIFF_PCT", "STATUS")
+		if err := opts.writeRow(w, titles, len(labels)); err != nil {
+			return err
+		}
+	}
+	for i, row := range r.rows(labels) {
+		if err := opts.repeatHeader(w, titles, len(labels), i); err != nil {
+			return err
+		}
+		if err := opts.writeRow(w, row, len(labels)); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Json writes the comparison as json
+func (r *DiffResult) Json(out io.Writer) error {
+	return writeJSON(out, r)
+}
+
+// Csv writes the comparison as a csv
+func (r *DiffResult) Csv(out io.Writer, noHeaders bool) error {
+	var rows [][]string
+	w := csv.NewWriter(out)
+	labels, err := util.UniqLabels(r.vector())
+	if err != nil {
+		return err
+	}
+	if !noHeaders {
+		var titleRow []string
+		for _, k := range labels {
+			titleRow = append(titleRow, string(k))
+		}
+		titleRow = append(titleRow, "value_a", "value_b", "diff", "diff_pct", "status")
+		rows = append(rows, titleRow)
+	}
+	rows = append(rows, r.rows(labels)...)
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Template executes an output template over the comparison
+// The template is executed with the list of DiffRow
+func (r *DiffResult) Template(out io.Writer, t *template.Template) error {
+	return executeTemplate(out, t, []DiffRow(*r))
+}
@@ -0,0 +1,257 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/guptarohit/asciigraph"
+	"github.com/prometheus/common/model"
+)
+
+// Graph styles
+const (
+	// GraphStyleLine is the default asciigraph line plot using unicode box drawing characters
+	GraphStyleLine = "line"
+	// GraphStyleASCII is a line plot using only plain ascii characters, for fonts that don't render box drawing characters well
+	GraphStyleASCII = "ascii"
+	// GraphStyleScatter plots a marker per sample positioned by its timestamp, which better represents sparse or irregularly sampled data
+	GraphStyleScatter = "scatter"
+)
+
+// scatterMarker is the character used to plot samples in the scatter style
+const scatterMarker = '•'
+
+// asciiReplacer converts the box drawing characters used by asciigraph to plain ascii
+var asciiReplacer = strings.NewReplacer(
+	"┼", "+",
+	"┤", "|",
+	"─", "-",
+	"│", "|",
+	"╭", ".",
+	"╮", ".",
+	"╰", "'",
+	"╯", "'",
+	"╴", "-",
+	"╶", "-",
+)
+
+// GraphOptions holds the settings used when graphing range query results
+type GraphOptions struct {
+	// Style is one of the GraphStyle constants, defaulting to GraphStyleLine
+	Style string
+	// Normalize scales each series to 0..1 by its own min and max, so the shapes of series of very different magnitudes can be compared
+	Normalize bool
+	// TimeAxis labels the x axis of each graph with the timestamps of a few evenly spaced columns
+	TimeAxis bool
+	// Separator draws a horizontal rule the width of the terminal between the graphs of each series
+	Separator bool
+}
+
+// separator returns the horizontal rule drawn between graphs, with plain dashes for the ascii style
+func separator(width int, style string) string {
+	if style == GraphStyleASCII {
+		return strings.Repeat("-", width)
+	}
+	return strings.Repeat("─", width)
+}
+
+// timeAxisGap is the least number of spaces between the labels of a time axis
+const timeAxisGap = 4
+
+// timeAxis returns a line labelling the x axis of graph, which plots values, with the timestamps of evenly spaced columns.
+// Line graphs plot samples by their index, so a column is labelled with the timestamp of the sample it plots, while scatter graphs plot them by their timestamps.
+// Labels are aligned to the columns after the y axis labels of the graph, which are found by the axis character of its first line
+func timeAxis(graph string, values []model.SamplePair, style string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	first, _, _ := strings.Cut(graph, "\n")
+	line := []rune(first)
+	offset := -1
+	for i, r := range line {
+		if strings.ContainsRune("┤┼|+", r) {
+			offset = i + 1
+			break
+		}
+	}
+	if offset < 0 {
+		return ""
+	}
+	// Graphs are as wide as their longest line, as trailing spaces are trimmed
+	width := 0
+	for _, l := range strings.Split(graph, "\n") {
+		if n := len([]rune(l)) - offset; n > width {
+			width = n
+		}
+	}
+	start, end := values[0].Timestamp, values[len(values)-1].Timestamp
+	layout := "15:04"
+	switch span := end.Sub(start); {
+	case span > 24*time.Hour:
+		layout = "Jan 02 15:04"
+	case span < 10*time.Minute:
+		layout = "15:04:05"
+	}
+	labelWidth := len(start.Time().Format(layout))
+	if width < labelWidth {
+		return ""
+	}
+	// The first and last labels are aligned to the edges of the graph rather than centered, so leave room for half a label more between them
+	labels := (width-1)/(labelWidth+labelWidth/2+timeAxisGap) + 1
+	if labels > len(values) {
+		labels = len(values)
+	}
+
+	axis := []rune(strings.Repeat(" ", offset+width))
+	for i := 0; i < labels; i++ {
+		x := 0
+		if labels > 1 {
+			x = i * (width - 1) / (labels - 1)
+		}
+		ts := start
+		if width > 1 {
+			if style == GraphStyleScatter {
+				ts = start + model.Time(float64(end-start)*float64(x)/float64(width-1))
+			} else {
+				ts = values[int(math.Round(float64(len(values)-1)*float64(x)/float64(width-1)))].Timestamp
+			}
+		}
+		// Center labels on their column, keeping the first and last inside the graph
+		pos := x - labelWidth/2
+		switch {
+		case i == 0:
+			pos = 0
+		case i == labels-1:
+			pos = width - labelWidth
+		}
+		copy(axis[offset+pos:], []rune(ts.Time().Format(layout)))
+	}
+	return strings.TrimRight(string(axis), " ")
+}
+
+// normalize returns values min-max scaled to 0..1, along with their real min and max
+// Series with a single distinct value are scaled to 0, and NaN and infinite values are left out of the min and max
+func normalize(values []model.SamplePair) ([]model.SamplePair, model.SampleValue, model.SampleValue) {
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		f := float64(v.Value)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			continue
+		}
+		min, max = math.Min(min, f), math.Max(max, f)
+	}
+	if min > max {
+		return values, model.SampleValue(math.NaN()), model.SampleValue(math.NaN())
+	}
+	normalized := make([]model.SamplePair, len(values))
+	for i, v := range values {
+		n := 0.0
+		if f := float64(v.Value); math.IsNaN(f) {
+			n = f
+		} else if max > min {
+			n = (f - min) / (max - min)
+		}
+		normalized[i] = model.SamplePair{Timestamp: v.Timestamp, Value: model.SampleValue(n)}
+	}
+	return normalized, model.SampleValue(min), model.SampleValue(max)
+}
+
+// plot returns the graph of a single series sized to the provided height and width
+func plot(values []model.SamplePair, height int, width int, opts GraphOptions) (string, error) {
+	data := make([]float64, 0, len(values))
+	for _, v := range values {
+		data = append(data, float64(v.Value))
+	}
+	switch opts.Style {
+	case "", GraphStyleLine:
+		return asciigraph.Plot(data, asciigraph.Height(height), asciigraph.Width(width)), nil
+	case GraphStyleASCII:
+		return asciiReplacer.Replace(asciigraph.Plot(data, asciigraph.Height(height), asciigraph.Width(width))), nil
+	case GraphStyleScatter:
+		return scatter(values, height, width), nil
+	default:
+		return "", fmt.Errorf("unknown graph style %q, options: %s,%s,%s", opts.Style, GraphStyleLine, GraphStyleASCII, GraphStyleScatter)
+	}
+}
+
+// scatter plots a marker for every sample, positioned on the x axis by its timestamp rather than its index.
+// The y axis is labelled the same way as asciigraph's line plots.
+func scatter(values []model.SamplePair, height int, width int) string {
+	minimum, maximum := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		f := float64(v.Value)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			continue
+		}
+		minimum = math.Min(minimum, f)
+		maximum = math.Max(maximum, f)
+	}
+	if math.IsInf(minimum, 1) {
+		return ""
+	}
+	if height < 1 {
+		height = 1
+	}
+	if width < 1 {
+		width = 1
+	}
+	rows := height
+	interval := maximum - minimum
+	if interval == 0 {
+		rows = 0
+	}
+
+	const precision = 2
+	labelWidth := int(math.Max(
+		float64(len(fmt.Sprintf("%0.*f", precision, maximum))),
+		float64(len(fmt.Sprintf("%0.*f", precision, minimum))),
+	)) + 1
+
+	grid := make([][]rune, rows+1)
+	for i := range grid {
+		grid[i] = []rune(strings.Repeat(" ", width))
+	}
+	first, last := values[0].Timestamp, values[len(values)-1].Timestamp
+	for _, v := range values {
+		f := float64(v.Value)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			continue
+		}
+		x := 0
+		if last > first {
+			x = int(math.Round(float64(v.Timestamp-first) / float64(last-first) * float64(width-1)))
+		}
+		y := 0
+		if rows > 0 {
+			y = int(math.Round((maximum - f) / interval * float64(rows)))
+		}
+		grid[y][x] = scatterMarker
+	}
+
+	lines := make([]string, 0, rows+1)
+	for y, row := range grid {
+		magnitude := maximum
+		if rows > 0 {
+			magnitude = maximum - float64(y)*interval/float64(rows)
+		}
+		lines = append(lines, strings.TrimRight(fmt.Sprintf("%*.*f ┤%s", labelWidth, precision, magnitude, string(row)), " "))
+	}
+	return strings.Join(lines, "\n")
+}
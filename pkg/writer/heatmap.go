@@ -0,0 +1,224 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nalbury/promql-cli/pkg/util"
+	"github.com/prometheus/common/model"
+)
+
+// heatmapShades are the heatmap cell characters from lowest to highest intensity
+var heatmapShades = []rune(" ░▒▓█")
+
+// heatmapASCIIShades are the heatmap cell characters used with the ascii graph style
+var heatmapASCIIShades = []rune(" .:-=+*#%@")
+
+// histogram is a set of cumulative bucket series sharing the same labels other than le
+type histogram struct {
+	metric  model.Metric
+	buckets []bucket
+}
+
+// bucket is a single le bucket series of a histogram
+type bucket struct {
+	le     string
+	bound  float64
+	values map[model.Time]float64
+}
+
+// histograms groups the series of a range query result into histograms by their labels other than le
+func histograms(matrix model.Matrix) ([]histogram, error) {
+	groups := map[model.Fingerprint]*histogram{}
+	var order []model.Fingerprint
+	for _, s := range matrix {
+		le, ok := s.Metric[model.BucketLabel]
+		if !ok {
+			return nil, fmt.Errorf("heatmap output requires histogram bucket series with an %q label, %s has none", model.BucketLabel, s.Metric)
+		}
+		bound, err := strconv.ParseFloat(string(le), 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %q label of %s: %v", model.BucketLabel, s.Metric, err)
+		}
+		metric := s.Metric.Clone()
+		delete(metric, model.BucketLabel)
+		fp := metric.Fingerprint()
+		h, ok := groups[fp]
+		if !ok {
+			h = &histogram{metric: metric}
+			groups[fp] = h
+			order = append(order, fp)
+		}
+		b := bucket{le: string(le), bound: bound, values: make(map[model.Time]float64, len(s.Values))}
+		for _, v := range s.Values {
+			b.values[v.Timestamp] = float64(v.Value)
+		}
+		h.buckets = append(h.buckets, b)
+	}
+	result := make([]histogram, 0, len(order))
+	for _, fp := range order {
+		h := groups[fp]
+		sort.Slice(h.buckets, func(i, j int) bool {
+			return h.buckets[i].bound < h.buckets[j].bound
+		})
+		result = append(result, *h)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].metric.String() < result[j].metric.String()
+	})
+	return result, nil
+}
+
+// timestamps returns the sorted timestamps of all of the histogram's buckets
+func (h histogram) timestamps() []model.Time {
+	seen := map[model.Time]struct{}{}
+	var ts []model.Time
+	for _, b := range h.buckets {
+		for t := range b.values {
+			if _, ok := seen[t]; !ok {
+				seen[t] = struct{}{}
+				ts = append(ts, t)
+			}
+		}
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+	return ts
+}
+
+// grid returns the count of each bucket (rows, lowest bound first) at each timestamp (columns) downsampled to width columns.
+// Counts are the difference between neighbouring cumulative buckets, and are NaN when a bucket has no sample.
+func (h histogram) grid(ts []model.Time, width int) [][]float64 {
+	cols := len(ts)
+	if cols > width {
+		cols = width
+	}
+	grid := make([][]float64, len(h.buckets))
+	for i, b := range h.buckets {
+		sums := make([]float64, cols)
+		counts := make([]int, cols)
+		for j, t := range ts {
+			v, ok := b.values[t]
+			if !ok || math.IsNaN(v) {
+				continue
+			}
+			if i > 0 {
+				prev, ok := h.buckets[i-1].values[t]
+				if ok && !math.IsNaN(prev) {
+					v -= prev
+				}
+			}
+			// Counter resets between buckets can make the difference negative
+			if v < 0 {
+				v = 0
+			}
+			col := j * cols / len(ts)
+			sums[col] += v
+			counts[col]++
+		}
+		grid[i] = make([]float64, cols)
+		for c := range sums {
+			if counts[c] == 0 {
+				grid[i][c] = math.NaN()
+				continue
+			}
+			grid[i][c] = sums[c] / float64(counts[c])
+		}
+	}
+	return grid
+}
+
+// shade returns the character representing v on a scale from 0 to maximum
+func shade(v float64, maximum float64, shades []rune) rune {
+	if math.IsNaN(v) || v <= 0 || maximum <= 0 || math.IsInf(v, 0) {
+		return shades[0]
+	}
+	i := int(math.Ceil(v / maximum * float64(len(shades)-1)))
+	if i >= len(shades) {
+		i = len(shades) - 1
+	}
+	return shades[i]
+}
+
+// Heatmap writes a heatmap of the histogram bucket series from a range query, with time on the x axis and the le bucket bounds on the y axis.
+// Cell intensity is the count of each bucket, that is its value less the value of the bucket below it.
+func (r *RangeResult) Heatmap(out io.Writer, dim util.TermDimensions, opts GraphOptions) error {
+	shades := heatmapShades
+	axis, corner, line := "┤", "└", "─"
+	if opts.Style == GraphStyleASCII {
+		shades = heatmapASCIIShades
+		axis, corner, line = "|", "+", "-"
+	}
+	hists, err := histograms(r.Matrix)
+	if err != nil {
+		return err
+	}
+	for _, h := range hists {
+		ts := h.timestamps()
+		if len(ts) == 0 {
+			continue
+		}
+		labelWidth := 0
+		for _, b := range h.buckets {
+			if len(b.le) > labelWidth {
+				labelWidth = len(b.le)
+			}
+		}
+		width := dim.Width - labelWidth - 2
+		if width < 1 {
+			width = 1
+		}
+		grid := h.grid(ts, width)
+		maximum := 0.0
+		for _, row := range grid {
+			for _, v := range row {
+				if !math.IsNaN(v) && !math.IsInf(v, 0) {
+					maximum = math.Max(maximum, v)
+				}
+			}
+		}
+		start := formatCaption(ts[0].Time())
+		end := formatCaption(ts[len(ts)-1].Time())
+		if err := writeGraphHeader(out, start+" -> "+end, h.metric.String(), dim.Width); err != nil {
+			return err
+		}
+		// The highest bucket is written first so bounds increase up the y axis
+		for i := len(grid) - 1; i >= 0; i-- {
+			cells := make([]rune, len(grid[i]))
+			for c, v := range grid[i] {
+				cells[c] = shade(v, maximum, shades)
+			}
+			if _, err := fmt.Fprintf(out, "%*s %s%s\n", labelWidth, h.buckets[i].le, axis, strings.TrimRight(string(cells), " ")); err != nil {
+				return err
+			}
+		}
+		cols := len(grid[0])
+		if _, err := fmt.Fprintf(out, "%*s %s%s\n", labelWidth, "", corner, strings.Repeat(line, cols)); err != nil {
+			return err
+		}
+		// e.g. ░▒▓█ 0 -> 12.5
+		legend := fmt.Sprintf("%s 0 -> %s", string(shades[1:]), strconv.FormatFloat(maximum, 'g', 4, 64))
+		if _, err := fmt.Fprintf(out, "%*s %s\n", labelWidth, "", legend); err != nil {
+			return err
+		}
+	}
+	return nil
+}
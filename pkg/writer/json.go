@@ -0,0 +1,231 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/common/model"
+)
+
+// writeJSONArray writes the n elements appended by element to out as a json array, followed by a newline
+// Elements are encoded one at a time into a reused buffer, so large results don't have to be held in memory twice and output starts right away
+func writeJSONArray(out io.Writer, n int, element func(b []byte, i int) ([]byte, error)) error {
+	w := bufio.NewWriter(out)
+	b := []byte{'['}
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		var err error
+		if b, err = element(b, i); err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		b = b[:0]
+	}
+	if _, err := w.Write(append(b, "]\n"...)); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// appendSeriesJSON appends the json encoding of s to b, the same as json.Marshal(s) without allocating for each sample
+// Series with histograms are rare and marshaled as is
+func appendSeriesJSON(b []byte, s *model.SampleStream) ([]byte, error) {
+	if len(s.Histograms) > 0 {
+		j, err := json.Marshal(s)
+		return append(b, j...), err
+	}
+	b, err := appendMetricJSON(b, s.Metric)
+	if err != nil {
+		return b, err
+	}
+	b = append(b, `,"values":`...)
+	if s.Values == nil {
+		b = append(b, "null"...)
+	} else {
+		b = append(b, '[')
+		for i, v := range s.Values {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendSamplePairJSON(b, v.Timestamp, v.Value)
+		}
+		b = append(b, ']')
+	}
+	return append(b, '}'), nil
+}
+
+// appendSampleJSON appends the json encoding of s to b, the same as json.Marshal(s)
+func appendSampleJSON(b []byte, s *model.Sample) ([]byte, error) {
+	if s.Histogram != nil {
+		j, err := json.Marshal(s)
+		return append(b, j...), err
+	}
+	b, err := appendMetricJSON(b, s.Metric)
+	if err != nil {
+		return b, err
+	}
+	b = append(b, `,"value":`...)
+	return append(appendSamplePairJSON(b, s.Timestamp, s.Value), '}'), nil
+}
+
+// appendMetricJSON appends the opening of a sample or series object with its metric to b
+func appendMetricJSON(b []byte, m model.Metric) ([]byte, error) {
+	j, err := json.Marshal(m)
+	if err != nil {
+		return b, err
+	}
+	return append(append(b, `{"metric":`...), j...), nil
+}
+
+// appendSamplePairJSON appends a sample as prometheus encodes it to b, a unix timestamp in seconds and the value as a string
+func appendSamplePairJSON(b []byte, t model.Time, v model.SampleValue) []byte {
+	b = append(b, '[')
+	b = strconv.AppendFloat(b, float64(t)/1000, 'f', -1, 64)
+	b = append(b, `,"`...)
+	b = strconv.AppendFloat(b, float64(v), 'f', -1, 64)
+	return append(b, `"]`...)
+}
+
+// Keys of the value fields of flat json output
+const (
+	flatJSONValueKey     = "__value__"
+	flatJSONTimestampKey = "__timestamp__"
+	flatJSONHistogramKey = "__histogram__"
+	// flatJSONLabelPrefix is prepended to labels named the same as a value field, or as another label once prefixed
+	flatJSONLabelPrefix = "label_"
+)
+
+// FlatJSONWriter is implemented by results that can be written as flat json, an object per sample with its labels as top level fields
+type FlatJSONWriter interface {
+	FlatJson(w io.Writer) error
+}
+
+// writeJSONOutput writes r as json, flattened if opts.JSONFlat is set and r supports it
+func writeJSONOutput(w io.Writer, r Writer, opts Options) error {
+	if f, ok := r.(FlatJSONWriter); ok && opts.JSONFlat {
+		return f.FlatJson(w)
+	}
+	return r.Json(w)
+}
+
+// FlatJson writes the response from a range query as flat json, an object per sample of each series in turn
+func (r *RangeResult) FlatJson(out io.Writer) error {
+	var samples []*model.Sample
+	for _, s := range r.Matrix {
+		for _, v := range s.Values {
+			samples = append(samples, &model.Sample{Metric: s.Metric, Value: v.Value, Timestamp: v.Timestamp})
+		}
+		for _, h := range s.Histograms {
+			samples = append(samples, &model.Sample{Metric: s.Metric, Histogram: h.Histogram, Timestamp: h.Timestamp})
+		}
+	}
+	return writeFlatJSON(out, samples)
+}
+
+// FlatJson writes the response from an instant query as flat json, an object per sample
+func (r *InstantResult) FlatJson(out io.Writer) error {
+	return writeFlatJSON(out, r.Vector)
+}
+
+// writeFlatJSON writes samples as a json array of flat objects
+func writeFlatJSON(out io.Writer, samples []*model.Sample) error {
+	return writeJSONArray(out, len(samples), func(b []byte, i int) ([]byte, error) {
+		return appendFlatSampleJSON(b, samples[i])
+	})
+}
+
+// appendFlatSampleJSON appends s to b as a json object of its labels in sorted order, followed by its value and timestamp
+// Values are json numbers, unless JSONStringValues is set or they're NaN or infinite, which json numbers can't represent
+// Native histograms are written under __histogram__ in place of __value__
+func appendFlatSampleJSON(b []byte, s *model.Sample) ([]byte, error) {
+	b = append(b, '{')
+	for _, k := range flatJSONLabelKeys(s.Metric) {
+		var err error
+		if b, err = appendJSONString(b, k.key); err != nil {
+			return b, err
+		}
+		b = append(b, ':')
+		if b, err = appendJSONString(b, string(s.Metric[k.name])); err != nil {
+			return b, err
+		}
+		b = append(b, ',')
+	}
+	if s.Histogram != nil {
+		h, err := json.Marshal(s.Histogram)
+		if err != nil {
+			return b, err
+		}
+		b = append(append(b, `"`+flatJSONHistogramKey+`":`...), h...)
+	} else {
+		b = append(b, `"`+flatJSONValueKey+`":`...)
+		v := float64(s.Value)
+		if JSONStringValues || math.IsNaN(v) || math.IsInf(v, 0) {
+			b = append(append(append(b, '"'), s.Value.String()...), '"')
+		} else {
+			b = strconv.AppendFloat(b, v, 'f', -1, 64)
+		}
+	}
+	b = append(b, `,"`+flatJSONTimestampKey+`":`...)
+	b = strconv.AppendFloat(b, float64(s.Timestamp)/1000, 'f', -1, 64)
+	return append(b, '}'), nil
+}
+
+// flatJSONLabel is a label of a flat json object and the key it's written under
+type flatJSONLabel struct {
+	name model.LabelName
+	key  string
+}
+
+// flatJSONLabelKeys returns the labels of m sorted by name with the keys they're written under
+// Labels named the same as a value field are prefixed with flatJSONLabelPrefix until they don't collide with it or another label
+func flatJSONLabelKeys(m model.Metric) []flatJSONLabel {
+	labels := make([]flatJSONLabel, 0, len(m))
+	for name := range m {
+		labels = append(labels, flatJSONLabel{name: name, key: string(name)})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	for i, l := range labels {
+		if !isFlatJSONValueKey(l.key) {
+			continue
+		}
+		for isFlatJSONValueKey(l.key) || m[model.LabelName(l.key)] != "" {
+			l.key = flatJSONLabelPrefix + l.key
+		}
+		labels[i] = l
+	}
+	return labels
+}
+
+// isFlatJSONValueKey returns true if k is the key of a value field of flat json output
+func isFlatJSONValueKey(k string) bool {
+	return k == flatJSONValueKey || k == flatJSONTimestampKey || k == flatJSONHistogramKey
+}
+
+// appendJSONString appends the json encoding of s to b
+func appendJSONString(b []byte, s string) ([]byte, error) {
+	j, err := json.Marshal(s)
+	return append(b, j...), err
+}
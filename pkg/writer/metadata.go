@@ -0,0 +1,138 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/nalbury/promql-cli/pkg/util"
+)
+
+// MetadataResult is an instant query result along with the metadata of its metrics, written as type and help columns
+// Other than tables, csv, and json it's written like an InstantResult
+type MetadataResult struct {
+	InstantResult
+	Metadata MetaResult
+}
+
+// MetadataSample is a single sample along with its metric's metadata, used when writing a MetadataResult as json
+type MetadataSample struct {
+	Metric model.Metric     `json:"metric"`
+	Value  model.SamplePair `json:"value"`
+	Type   v1.MetricType    `json:"type"`
+	Help   string           `json:"help"`
+	Unit   string           `json:"unit"`
+}
+
+// lookup returns the metadata of the metric a sample belongs to, or empty metadata if it isn't known
+// Histogram and summary series (e.g. _bucket) fall back to the metadata of their base metric
+func (r *MetadataResult) lookup(m model.Metric) v1.Metadata {
+	name := string(m[model.MetricNameLabel])
+	if meta, ok := r.Metadata[name]; ok && len(meta) > 0 {
+		return meta[0]
+	}
+	if base, ok := util.BaseMetricName(name); ok {
+		if meta, ok := r.Metadata[base]; ok && len(meta) > 0 {
+			return meta[0]
+		}
+	}
+	return v1.Metadata{}
+}
+
+// Table writes the response from an instant query as a table, with the type and help text of each series' metric after its value
+func (r *MetadataResult) Table(out io.Writer, noHeaders bool, opts TableOptions) error {
+	const padding = 4
+	w := tabwriter.NewWriter(out, 0, 0, padding, ' ', 0)
+	labels, err := util.UniqLabels(r.Vector)
+	if err != nil {
+		return err
+	}
+	var titles []string
+	if !noHeaders {
+		for _, k := range labels {
+			titles = append(titles, strings.ToUpper(string(k)))
+		}
+		titles = append(titles, opts.valueHeader("VALUE"), "TIMESTAMP", "TYPE", "HELP")
+		if err := opts.writeRow(w, titles, len(labels)); err != nil {
+			return err
+		}
+	}
+	for i, v := range r.Vector {
+		if err := opts.repeatHeader(w, titles, len(labels), i); err != nil {
+			return err
+		}
+		data := labelCells(v.Metric, labels, 4)
+		meta := r.lookup(v.Metric)
+		data = append(data, opts.valueCell(float64(v.Value), FormatValue(float64(v.Value))), FormatTimestamp(v.Timestamp.Time()), string(meta.Type), meta.Help)
+		if err := opts.writeRow(w, data, len(labels)); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Json writes the response from an instant query as json, with the type, help text, and unit of each series' metric
+func (r *MetadataResult) Json(out io.Writer) error {
+	samples := make([]MetadataSample, 0, len(r.Vector))
+	for _, v := range r.Vector {
+		meta := r.lookup(v.Metric)
+		samples = append(samples, MetadataSample{
+			Metric: v.Metric,
+			Value:  model.SamplePair{Timestamp: v.Timestamp, Value: v.Value},
+			Type:   meta.Type,
+			Help:   meta.Help,
+			Unit:   meta.Unit,
+		})
+	}
+	return writeJSON(out, samples)
+}
+
+// Csv writes the response from an instant query as a csv, with the type and help text of each series' metric after its value
+func (r *MetadataResult) Csv(out io.Writer, noHeaders bool) error {
+	var rows [][]string
+	w := csv.NewWriter(out)
+	labels, err := util.UniqLabels(r.Vector)
+	if err != nil {
+		return err
+	}
+	if !noHeaders {
+		var titleRow []string
+		for _, k := range labels {
+			titleRow = append(titleRow, string(k))
+		}
+		titleRow = append(titleRow, "value", "timestamp", "type", "help")
+		rows = append(rows, titleRow)
+	}
+	for _, v := range r.Vector {
+		row := labelCells(v.Metric, labels, 4)
+		meta := r.lookup(v.Metric)
+		row = append(row, FormatValue(float64(v.Value)), FormatTimestamp(v.Timestamp.Time()), string(meta.Type), meta.Help)
+		rows = append(rows, row)
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return nil
+}
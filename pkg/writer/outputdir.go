@@ -0,0 +1,104 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// maxSeriesFileName is the longest series file name we write, below the 255 byte limit of most filesystems to leave room for a suffix
+const maxSeriesFileName = 200
+
+// SeriesFileName returns a file name for a series made from its labels, e.g. up_instance=web-1_9090_job=node.csv
+// Characters that aren't safe in file names on every major filesystem are replaced with _, so names may clash, see WriteSeriesFiles
+func SeriesFileName(m model.Metric) string {
+	var parts []string
+	if name, ok := m[model.MetricNameLabel]; ok {
+		parts = append(parts, string(name))
+	}
+	names := make([]string, 0, len(m))
+	for k := range m {
+		if k != model.MetricNameLabel {
+			names = append(names, string(k))
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		parts = append(parts, k+"="+string(m[model.LabelName(k)]))
+	}
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '=', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.Join(parts, "_"))
+	// A leading . would hide the file, or make it . or ..
+	if strings.HasPrefix(name, ".") {
+		name = "_" + name[1:]
+	}
+	if len(name) > maxSeriesFileName {
+		name = name[:maxSeriesFileName]
+	}
+	if name == "" {
+		name = "series"
+	}
+	return name + ".csv"
+}
+
+// WriteSeriesFiles writes each series of a range query result to its own csv file in dir, named by SeriesFileName
+// Names that clash, ignoring case for case insensitive filesystems, get a numeric suffix e.g. up_job=node-2.csv
+// It returns the paths of the files written, in series order
+func WriteSeriesFiles(m model.Matrix, dir string, noHeaders bool) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating output dir: %v", err)
+	}
+	var (
+		paths []string
+		used  = make(map[string]struct{}, len(m))
+	)
+	for _, s := range m {
+		name := SeriesFileName(s.Metric)
+		base := strings.TrimSuffix(name, ".csv")
+		for i := 2; ; i++ {
+			if _, ok := used[strings.ToLower(name)]; !ok {
+				break
+			}
+			name = fmt.Sprintf("%s-%d.csv", base, i)
+		}
+		used[strings.ToLower(name)] = struct{}{}
+
+		r := RangeResult{Matrix: model.Matrix{s}}
+		var buf bytes.Buffer
+		if err := r.Csv(&buf, noHeaders); err != nil {
+			return paths, err
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			return paths, fmt.Errorf("error writing series file: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
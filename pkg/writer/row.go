@@ -0,0 +1,134 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Row is a single sample of a query result as plain go types
+// It's what our table, csv, and template writers write a row for, and lets library users consume results without parsing our output
+type Row struct {
+	Labels    map[string]string
+	Value     float64
+	Timestamp time.Time
+}
+
+// InstantRows returns a row for each sample of an instant query result, in result order
+func InstantRows(v model.Vector) []Row {
+	rows := make([]Row, 0, len(v))
+	for _, s := range v {
+		rows = append(rows, Row{
+			Labels:    templateLabels(s.Metric),
+			Value:     float64(s.Value),
+			Timestamp: s.Timestamp.Time(),
+		})
+	}
+	return rows
+}
+
+// RangeRows returns a row for each sample of a range query result, series by series
+// Rows of the same series share their Labels map
+func RangeRows(m model.Matrix) []Row {
+	var rows []Row
+	for _, s := range m {
+		labels := templateLabels(s.Metric)
+		for _, v := range s.Values {
+			rows = append(rows, Row{
+				Labels:    labels,
+				Value:     float64(v.Value),
+				Timestamp: v.Timestamp.Time(),
+			})
+		}
+	}
+	return rows
+}
+
+// labelCells returns the values of m's labels in the label column order, leaving the columns of labels it doesn't have empty
+// labels must be the same sorted union of label names the header was written from, e.g. from util.UniqLabels
+func labelCells(m model.Metric, labels []model.LabelName, extra int) []string {
+	cells := make([]string, len(labels), len(labels)+extra)
+	for i, k := range labels {
+		cells[i] = string(m[k])
+	}
+	return cells
+}
+
+// labelCellsInto returns the label cells of m like labelCells, reusing the backing array of row
+// It's for writers that are done with each row before building the next, so a single row is allocated for the whole result
+func labelCellsInto(row []string, m model.Metric, labels []model.LabelName) []string {
+	row = row[:0]
+	for _, k := range labels {
+		row = append(row, string(m[k]))
+	}
+	return row
+}
+
+// DefaultScientificExponent is the default ScientificExponent
+const DefaultScientificExponent = 21
+
+// ScientificExponent is the decimal exponent from which FormatValue writes values in scientific notation
+// Values of at least 10^ScientificExponent, or below 10^-ScientificExponent, are written like 1.5e+22 rather than dozens of digits
+var ScientificExponent = DefaultScientificExponent
+
+// FormatValue formats a sample value for table and csv output, in plain decimal notation e.g. 12345678 rather than 1.2345678e+07
+// Values keep their full precision, as the shortest decimal that parses back to the same float. Outside the range set by ScientificExponent they're written in scientific notation.
+// NaN and infinities are written as NaN, +Inf and -Inf, like prometheus does
+func FormatValue(v float64) string {
+	if a := math.Abs(v); a != 0 && !math.IsInf(v, 0) && (a >= math.Pow10(ScientificExponent) || a < math.Pow10(-ScientificExponent)) {
+		return strconv.FormatFloat(v, 'e', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// maxCachedTimes bounds the timestamps a timeCells caches, it's the most samples prometheus returns for a series of a range query
+const maxCachedTimes = 11000
+
+// timeCells formats the timestamp cell and any time bucket cells of samples
+// The series of a range query share their timestamps, so each is formatted once rather than for every series
+type timeCells struct {
+	buckets string
+	cache   map[model.Time][]string
+}
+
+// newTimeCells returns a timeCells adding the cells of the time buckets mode buckets
+func newTimeCells(buckets string) *timeCells {
+	return &timeCells{buckets: buckets, cache: map[model.Time][]string{}}
+}
+
+// cells returns the timestamp cell, formatted with FormatTimestamp, of a sample of a sample at t followed by its time bucket cells
+func (c *timeCells) cells(t model.Time) []string {
+	if cells, ok := c.cache[t]; ok {
+		return cells
+	}
+	if len(c.cache) >= maxCachedTimes {
+		clear(c.cache)
+	}
+	tm := t.Time()
+	cells := append([]string{FormatTimestamp(tm)}, timeBucketCells(c.buckets, tm)...)
+	c.cache[t] = cells
+	return cells
+}
+
+// sampleCells replaces the cells of row after its first labels label cells with the value v and the time cells times
+// It reuses the backing array of row, so rows are only allocated once per series rather than once per sample
+func sampleCells(row []string, labels int, v model.SampleValue, times []string) []string {
+	return append(append(row[:labels], FormatValue(float64(v))), times...)
+}
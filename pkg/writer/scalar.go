@@ -0,0 +1,136 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// ScalarResult is a wrapper of the prometheus model.Scalar type returned from instant queries like scalar(sum(up))
+// Satisfies the InstantWriter interface
+type ScalarResult struct {
+	*model.Scalar
+}
+
+// Table writes a scalar as a single row table
+func (r *ScalarResult) Table(out io.Writer, noHeaders bool, opts TableOptions) error {
+	return valueTable(out, noHeaders, opts.valueHeader("VALUE"), opts.valueCell(float64(r.Scalar.Value), FormatValue(float64(r.Scalar.Value))), r.Timestamp)
+}
+
+// Json writes a scalar as json, in the same [timestamp, "value"] form as the prometheus api
+func (r *ScalarResult) Json(out io.Writer) error {
+	return writeJSON(out, r.Scalar)
+}
+
+// Csv writes a scalar as a single row csv
+func (r *ScalarResult) Csv(out io.Writer, noHeaders bool) error {
+	return valueCsv(out, noHeaders, FormatValue(float64(r.Scalar.Value)), r.Timestamp)
+}
+
+// Template executes an output template over a scalar
+// The template is executed with a single TemplateSample, with no name or labels
+func (r *ScalarResult) Template(out io.Writer, t *template.Template) error {
+	return executeTemplate(out, t, TemplateSample{
+		Labels:    map[string]string{},
+		Value:     float64(r.Scalar.Value),
+		Timestamp: r.Timestamp.Time(),
+	})
+}
+
+// Value writes only the value of the scalar, for use in scripts
+func (r *ScalarResult) Value(out io.Writer) error {
+	_, err := io.WriteString(out, FormatValue(float64(r.Scalar.Value)))
+	return err
+}
+
+// StringResult is a wrapper of the prometheus model.String type returned from instant queries of string literals
+// Satisfies the InstantWriter interface
+type StringResult struct {
+	*model.String
+}
+
+// TemplateString is a string result as exposed to output templates
+type TemplateString struct {
+	Value     string
+	Timestamp time.Time
+}
+
+// Table writes the string verbatim
+func (r *StringResult) Table(out io.Writer, noHeaders bool, opts TableOptions) error {
+	_, err := io.WriteString(out, r.String.Value)
+	return err
+}
+
+// Json writes a string as json, in the same [timestamp, "value"] form as the prometheus api
+func (r *StringResult) Json(out io.Writer) error {
+	return writeJSON(out, r.String)
+}
+
+// Csv writes a string as a single row csv
+func (r *StringResult) Csv(out io.Writer, noHeaders bool) error {
+	return valueCsv(out, noHeaders, r.String.Value, r.Timestamp)
+}
+
+// Template executes an output template over a string
+// The template is executed with a single TemplateString
+func (r *StringResult) Template(out io.Writer, t *template.Template) error {
+	return executeTemplate(out, t, TemplateString{Value: r.String.Value, Timestamp: r.Timestamp.Time()})
+}
+
+// Value writes the string verbatim, for use in scripts
+func (r *StringResult) Value(out io.Writer) error {
+	_, err := io.WriteString(out, r.String.Value)
+	return err
+}
+
+// valueTable writes a table of a single value and timestamp, under the value column header
+func valueTable(out io.Writer, noHeaders bool, header string, value string, ts model.Time) error {
+	const padding = 4
+	w := tabwriter.NewWriter(out, 0, 0, padding, ' ', 0)
+	if !noHeaders {
+		if _, err := fmt.Fprintf(w, "%s\tTIMESTAMP\n", header); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "%s\t%s\n", value, FormatTimestamp(ts.Time())); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// valueCsv writes a csv of a single value and timestamp
+func valueCsv(out io.Writer, noHeaders bool, value string, ts model.Time) error {
+	var rows [][]string
+	w := csv.NewWriter(out)
+	if !noHeaders {
+		rows = append(rows, []string{"value", "timestamp"})
+	}
+	rows = append(rows, []string{value, FormatTimestamp(ts.Time())})
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return nil
+}
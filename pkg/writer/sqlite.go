@@ -0,0 +1,246 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/nalbury/promql-cli/pkg/util"
+
+	// Registers the pure go sqlite driver, so we don't need cgo
+	_ "modernc.org/sqlite"
+)
+
+// The modes of writing to an existing sqlite table
+const (
+	// SQLiteAppend adds rows to the table, adding columns for any labels it doesn't have yet
+	SQLiteAppend = "append"
+	// SQLiteReplace drops the table and creates it again
+	SQLiteReplace = "replace"
+)
+
+// DefaultSQLiteTable is the table query results are written to unless another is set
+const DefaultSQLiteTable = "results"
+
+// SQLiteOptions holds the settings used when writing query results to a sqlite database
+type SQLiteOptions struct {
+	// Table is the name of the table rows are written to, defaulting to DefaultSQLiteTable
+	Table string
+	// Mode is one of the SQLite mode constants, defaulting to SQLiteAppend
+	Mode string
+}
+
+// sqliteColumn is a column of the table a query result is written to, named after the label it holds
+type sqliteColumn struct {
+	label model.LabelName
+	name  string
+}
+
+// WriteSQLite writes a row per sample of an instant or range query result to the table of the sqlite database at path, creating them if they don't exist.
+// The table has a text column per label, named after the label sanitized to a valid identifier, followed by value and timestamp columns.
+// Labels a series doesn't have are NULL, and timestamps are written in UTC as RFC3339 for sqlite's date functions.
+// All rows are inserted in a single transaction, so a failed write leaves the table as it was
+func WriteSQLite(path string, result model.Value, opts SQLiteOptions) (int, error) {
+	switch result.(type) {
+	case model.Vector, model.Matrix:
+	default:
+		return 0, fmt.Errorf("unable to write sqlite: unsupported query result type: %T, only instant and range vectors can be written as rows", result)
+	}
+	mode := opts.Mode
+	if mode == "" {
+		mode = SQLiteAppend
+	}
+	if mode != SQLiteAppend && mode != SQLiteReplace {
+		return 0, fmt.Errorf("unknown sqlite mode %q, options: %s,%s", mode, SQLiteAppend, SQLiteReplace)
+	}
+	labels, err := util.UniqLabels(result)
+	if err != nil {
+		return 0, err
+	}
+	columns := sqliteColumns(labels)
+	name := opts.Table
+	if name == "" {
+		name = DefaultSQLiteTable
+	}
+	name = SQLiteIdentifier(name)
+	table := quoteIdentifier(name)
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return 0, fmt.Errorf("error opening sqlite database: %v", err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error opening sqlite database: %v", err)
+	}
+	// Rollback is a no-op once the transaction is committed
+	defer tx.Rollback()
+
+	if mode == SQLiteReplace {
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			return 0, fmt.Errorf("error replacing sqlite table: %v", err)
+		}
+	}
+	if err := createSQLiteTable(tx, name, columns); err != nil {
+		return 0, err
+	}
+
+	names := make([]string, 0, len(columns)+2)
+	for _, c := range columns {
+		names = append(names, quoteIdentifier(c.name))
+	}
+	names = append(names, `"value"`, `"timestamp"`)
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (?%s)", table, strings.Join(names, ", "), strings.Repeat(", ?", len(names)-1)))
+	if err != nil {
+		return 0, fmt.Errorf("error writing sqlite rows: %v", err)
+	}
+	defer insert.Close()
+
+	rows := 0
+	write := func(m model.Metric, v model.SampleValue, ts model.Time) error {
+		args := make([]interface{}, 0, len(columns)+2)
+		for _, c := range columns {
+			if l, ok := m[c.label]; ok {
+				args = append(args, string(l))
+			} else {
+				args = append(args, nil)
+			}
+		}
+		args = append(args, sqliteValue(v), ts.Time().UTC().Format(time.RFC3339Nano))
+		if _, err := insert.Exec(args...); err != nil {
+			return fmt.Errorf("error writing sqlite rows: %v", err)
+		}
+		rows++
+		return nil
+	}
+	switch r := result.(type) {
+	case model.Vector:
+		for _, s := range r {
+			if err := write(s.Metric, s.Value, s.Timestamp); err != nil {
+				return 0, err
+			}
+		}
+	case model.Matrix:
+		for _, s := range r {
+			for _, v := range s.Values {
+				if err := write(s.Metric, v.Value, v.Timestamp); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error writing sqlite rows: %v", err)
+	}
+	return rows, nil
+}
+
+// createSQLiteTable creates the table if it doesn't exist, and adds any of the label columns it doesn't have yet
+func createSQLiteTable(tx *sql.Tx, name string, columns []sqliteColumn) error {
+	table := quoteIdentifier(name)
+	defs := make([]string, 0, len(columns)+2)
+	for _, c := range columns {
+		defs = append(defs, quoteIdentifier(c.name)+" TEXT")
+	}
+	defs = append(defs, `"value" REAL`, `"timestamp" TEXT`)
+	if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(defs, ", "))); err != nil {
+		return fmt.Errorf("error creating sqlite table: %v", err)
+	}
+
+	existing := map[string]struct{}{}
+	info, err := tx.Query("SELECT name FROM pragma_table_info(?)", name)
+	if err != nil {
+		return fmt.Errorf("error reading sqlite table columns: %v", err)
+	}
+	defer info.Close()
+	for info.Next() {
+		var name string
+		if err := info.Scan(&name); err != nil {
+			return fmt.Errorf("error reading sqlite table columns: %v", err)
+		}
+		existing[strings.ToLower(name)] = struct{}{}
+	}
+	if err := info.Err(); err != nil {
+		return fmt.Errorf("error reading sqlite table columns: %v", err)
+	}
+	for _, c := range columns {
+		if _, ok := existing[strings.ToLower(c.name)]; ok {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s TEXT", table, quoteIdentifier(c.name))); err != nil {
+			return fmt.Errorf("error adding sqlite table column: %v", err)
+		}
+	}
+	return nil
+}
+
+// sqliteColumns returns the columns of each label, in label order
+// Names clashing with another column, ignoring case as sqlite does, get a numeric suffix e.g. value_2
+func sqliteColumns(labels []model.LabelName) []sqliteColumn {
+	used := map[string]struct{}{"value": {}, "timestamp": {}}
+	columns := make([]sqliteColumn, 0, len(labels))
+	for _, l := range labels {
+		base := SQLiteIdentifier(string(l))
+		name := base
+		for i := 2; ; i++ {
+			if _, ok := used[strings.ToLower(name)]; !ok {
+				break
+			}
+			name = fmt.Sprintf("%s_%d", base, i)
+		}
+		used[strings.ToLower(name)] = struct{}{}
+		columns = append(columns, sqliteColumn{label: l, name: name})
+	}
+	return columns
+}
+
+// SQLiteIdentifier sanitizes s to a valid sqlite identifier of letters, digits, and underscores that doesn't start with a digit
+// Identifiers are still quoted when used, so sqlite keywords like order are also valid
+func SQLiteIdentifier(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	id := b.String()
+	if id == "" || (id[0] >= '0' && id[0] <= '9') {
+		id = "_" + id
+	}
+	return id
+}
+
+// quoteIdentifier double quotes a sanitized identifier
+func quoteIdentifier(id string) string {
+	return `"` + id + `"`
+}
+
+// sqliteValue returns v as a sqlite value, sqlite has no NaN so it's written as NULL
+func sqliteValue(v model.SampleValue) interface{} {
+	if math.IsNaN(float64(v)) {
+		return nil
+	}
+	return float64(v)
+}
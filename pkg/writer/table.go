@@ -0,0 +1,172 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"io"
+	"unicode/utf8"
+
+	"github.com/prometheus/common/model"
+)
+
+// ellipsis marks the end of a truncated table cell
+const ellipsis = "…"
+
+// TableOptions holds the settings for tables of instant query results
+type TableOptions struct {
+	// MaxColWidth is the maximum width in characters of label cells, 0 means unlimited
+	MaxColWidth int
+	// Wrap wraps label cells wider than MaxColWidth onto multiple lines instead of truncating them
+	Wrap bool
+	// Colors colors value cells by threshold, nil disables colors
+	Colors *ColorThresholds
+	// RepeatHeader writes the header again every RepeatHeader data rows, 0 writes it once
+	RepeatHeader int
+	// Deltas marks instant query values by how they changed since the previous table written with the same Deltas, nil disables them
+	Deltas *Deltas
+}
+
+// repeatHeader writes header again before the data row at index i if it's due to be repeated
+// A nil header (e.g. with --no-headers) is never written
+func (o TableOptions) repeatHeader(w io.Writer, header []string, limited int, i int) error {
+	if header == nil || o.RepeatHeader <= 0 || i == 0 || i%o.RepeatHeader != 0 {
+		return nil
+	}
+	return o.writeRow(w, header, limited)
+}
+
+// colored returns true if value cells are colorized, by color thresholds or deltas
+func (o TableOptions) colored() bool {
+	return o.Colors != nil || (o.Deltas != nil && o.Deltas.Color)
+}
+
+// valueHeader returns the header of the value column
+// With colors the header is written in the default color, so it's the same length as the colorized values below it
+func (o TableOptions) valueHeader(s string) string {
+	if !o.colored() {
+		return s
+	}
+	return colorize(s, colorDefault)
+}
+
+// valueCell returns the cell of the value v formatted as s, colorized by the color thresholds if set
+func (o TableOptions) valueCell(v float64, s string) string {
+	switch {
+	case o.Colors != nil:
+		return colorize(s, o.Colors.color(v))
+	case o.colored():
+		return colorize(s, colorDefault)
+	default:
+		return s
+	}
+}
+
+// seriesValueCell returns the cell of the value v of the series m formatted as s
+// With Deltas it's marked by how v changed since the previous table, and colorized by the change instead of the color thresholds if it changed
+func (o TableOptions) seriesValueCell(m model.Metric, v float64, s string) string {
+	if o.Deltas == nil {
+		return o.valueCell(v, s)
+	}
+	s, color := o.Deltas.mark(m, v, s)
+	if color == "" || !o.Deltas.Color {
+		return o.valueCell(v, s)
+	}
+	return colorize(s, color)
+}
+
+// fits returns true if the cell s is written as is, without truncating or wrapping it
+func (o TableOptions) fits(s string) bool {
+	return o.MaxColWidth <= 0 || utf8.RuneCountInString(s) <= o.MaxColWidth
+}
+
+// cell returns the lines a single table cell is written as
+func (o TableOptions) cell(s string) []string {
+	if o.fits(s) {
+		return []string{s}
+	}
+	r := []rune(s)
+	if !o.Wrap {
+		return []string{string(r[:o.MaxColWidth-1]) + ellipsis}
+	}
+	var lines []string
+	for len(r) > o.MaxColWidth {
+		lines = append(lines, string(r[:o.MaxColWidth]))
+		r = r[o.MaxColWidth:]
+	}
+	return append(lines, string(r))
+}
+
+// writeRow writes a tab separated row of cells to w
+// The first limited cells are truncated or wrapped to MaxColWidth, the rest (e.g. values and timestamps) are always written in full.
+// Wrapped cells continue on the following lines, leaving the other columns empty.
+func (o TableOptions) writeRow(w io.Writer, cells []string, limited int) error {
+	if o.fitRow(cells, limited) {
+		return writeLine(w, cells)
+	}
+	var (
+		lines  = make([][]string, len(cells))
+		height = 1
+	)
+	for i, c := range cells {
+		if i < limited {
+			lines[i] = o.cell(c)
+		} else {
+			lines[i] = []string{c}
+		}
+		if len(lines[i]) > height {
+			height = len(lines[i])
+		}
+	}
+	for l := 0; l < height; l++ {
+		row := make([]string, len(cells))
+		for i := range cells {
+			if l < len(lines[i]) {
+				row[i] = lines[i][l]
+			}
+		}
+		if err := writeLine(w, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fitRow returns true if none of the first limited cells need to be truncated or wrapped, so the row is written as a single line
+func (o TableOptions) fitRow(cells []string, limited int) bool {
+	for _, c := range cells[:min(limited, len(cells))] {
+		if !o.fits(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeLine writes cells to w as a single tab separated line
+func writeLine(w io.Writer, cells []string) error {
+	n := len(cells)
+	for _, c := range cells {
+		n += len(c)
+	}
+	line := make([]byte, 0, n)
+	for i, c := range cells {
+		if i > 0 {
+			line = append(line, '\t')
+		}
+		line = append(line, c...)
+	}
+	_, err := w.Write(append(line, '\n'))
+	return err
+}
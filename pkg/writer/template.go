@@ -0,0 +1,194 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"text/template"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// TemplateFuncs are the helper functions available to output templates, in addition to the text/template builtins (e.g. printf)
+var TemplateFuncs = template.FuncMap{
+	"humanize": humanize,
+	"now":      time.Now,
+}
+
+// NewTemplate parses text into an output template with our helper functions available
+func NewTemplate(text string) (*template.Template, error) {
+	t, err := template.New("output").Funcs(TemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing output template: %v", err)
+	}
+	return t, nil
+}
+
+// TemplateSample is a single sample of an instant query result as exposed to output templates
+type TemplateSample struct {
+	Name      string
+	Labels    map[string]string
+	Value     float64
+	Timestamp time.Time
+}
+
+// TemplatePoint is a single value of a range query series as exposed to output templates
+type TemplatePoint struct {
+	Value     float64
+	Timestamp time.Time
+}
+
+// TemplateSeries is a single series of a range query result as exposed to output templates
+type TemplateSeries struct {
+	Name   string
+	Labels map[string]string
+	Values []TemplatePoint
+}
+
+// templateLabels converts a metric to a plain string map for easier use in templates
+func templateLabels(m model.Metric) map[string]string {
+	labels := make(map[string]string, len(m))
+	for k, v := range m {
+		labels[string(k)] = string(v)
+	}
+	return labels
+}
+
+// executeTemplate executes t against data, writing the output to out
+func executeTemplate(out io.Writer, t *template.Template, data interface{}) error {
+	if t == nil {
+		return fmt.Errorf("no output template provided, use --template-string or --template-file")
+	}
+	if err := t.Execute(out, data); err != nil {
+		return fmt.Errorf("error executing output template: %w", err)
+	}
+	return nil
+}
+
+// humanize formats a number with an SI prefix, matching the humanize function in prometheus alerting templates
+func humanize(i interface{}) (string, error) {
+	var v float64
+	switch n := i.(type) {
+	case float64:
+		v = n
+	case int:
+		v = float64(n)
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return "", err
+		}
+		v = f
+	default:
+		return "", fmt.Errorf("humanize: unsupported value type %T", i)
+	}
+	if v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Sprintf("%.4g", v), nil
+	}
+	prefix := ""
+	if math.Abs(v) >= 1 {
+		for _, p := range []string{"k", "M", "G", "T", "P", "E", "Z", "Y"} {
+			if math.Abs(v) < 1000 {
+				break
+			}
+			prefix = p
+			v /= 1000
+		}
+		return fmt.Sprintf("%.4g%s", v, prefix), nil
+	}
+	for _, p := range []string{"m", "u", "n", "p", "f", "a", "z", "y"} {
+		if math.Abs(v) >= 1 {
+			break
+		}
+		prefix = p
+		v *= 1000
+	}
+	return fmt.Sprintf("%.4g%s", v, prefix), nil
+}
+
+// Template executes an output template over the series of a range query
+// The template is executed with a list of TemplateSeries
+func (r *RangeResult) Template(out io.Writer, t *template.Template) error {
+	series := make([]TemplateSeries, 0, len(r.Matrix))
+	for _, m := range r.Matrix {
+		s := TemplateSeries{
+			Name:   string(m.Metric[model.MetricNameLabel]),
+			Labels: templateLabels(m.Metric),
+			Values: make([]TemplatePoint, 0, len(m.Values)),
+		}
+		for _, v := range m.Values {
+			s.Values = append(s.Values, TemplatePoint{Value: float64(v.Value), Timestamp: v.Timestamp.Time()})
+		}
+		series = append(series, s)
+	}
+	return executeTemplate(out, t, series)
+}
+
+// Template executes an output template over the samples of an instant query
+// The template is executed with a list of TemplateSample
+func (r *InstantResult) Template(out io.Writer, t *template.Template) error {
+	samples := make([]TemplateSample, 0, len(r.Vector))
+	for _, row := range InstantRows(r.Vector) {
+		samples = append(samples, TemplateSample{
+			Name:      row.Labels[string(model.MetricNameLabel)],
+			Labels:    row.Labels,
+			Value:     row.Value,
+			Timestamp: row.Timestamp,
+		})
+	}
+	return executeTemplate(out, t, samples)
+}
+
+// Template executes an output template over a metrics query result
+// The template is executed with the list of metric names
+func (r *MetricsResult) Template(out io.Writer, t *template.Template) error {
+	return executeTemplate(out, t, []string(*r))
+}
+
+// Template executes an output template over the labels from an instant query
+// The template is executed with the sorted list of unique label names
+func (r *LabelsResult) Template(out io.Writer, t *template.Template) error {
+	labels, err := r.labels()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(labels))
+	for _, l := range labels {
+		names = append(names, string(l))
+	}
+	return executeTemplate(out, t, names)
+}
+
+// Template executes an output template over the series of a series query
+// The template is executed with a list of the label sets of the series
+func (r *SeriesResult) Template(out io.Writer, t *template.Template) error {
+	series := make([]map[string]string, 0, len(*r))
+	for _, ls := range *r {
+		series = append(series, templateLabels(model.Metric(ls)))
+	}
+	return executeTemplate(out, t, series)
+}
+
+// Template executes an output template over a metadata query result
+// The template is executed with the map of metric names to their metadata
+func (r *MetaResult) Template(out io.Writer, t *template.Template) error {
+	return executeTemplate(out, t, map[string][]v1.Metadata(*r))
+}
@@ -0,0 +1,63 @@
+/*
+Copyright © 2020 Nick Albury nickalbury@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package writer
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timestamp precisions of table and csv output, and graph captions
+const (
+	// TimePrecisionSeconds writes timestamps to the second, e.g. 2020-09-13T12:26:40Z
+	TimePrecisionSeconds = "s"
+	// TimePrecisionMillis writes timestamps to the millisecond, the precision of prometheus timestamps, e.g. 2020-09-13T12:26:40.500Z
+	TimePrecisionMillis = "ms"
+	// TimePrecisionNanos writes timestamps to the nanosecond, e.g. 2020-09-13T12:26:40.500000000Z
+	TimePrecisionNanos = "ns"
+)
+
+// timestampLayout and captionLayout are the layouts of timestamps and graph captions, as set by SetTimePrecision
+var (
+	timestampLayout = time.RFC3339
+	captionLayout   = time.Stamp
+)
+
+// SetTimePrecision sets the precision FormatTimestamp and graph captions write timestamps with, one of the TimePrecision constants
+// Fractional seconds are always written with the same number of digits, so timestamps line up
+func SetTimePrecision(precision string) error {
+	switch precision {
+	case TimePrecisionSeconds:
+		timestampLayout, captionLayout = time.RFC3339, time.Stamp
+	case TimePrecisionMillis:
+		timestampLayout, captionLayout = "2006-01-02T15:04:05.000Z07:00", time.StampMilli
+	case TimePrecisionNanos:
+		timestampLayout, captionLayout = "2006-01-02T15:04:05.000000000Z07:00", time.StampNano
+	default:
+		return fmt.Errorf("unknown time precision %q, options: %s,%s,%s", precision, TimePrecisionSeconds, TimePrecisionMillis, TimePrecisionNanos)
+	}
+	return nil
+}
+
+// FormatTimestamp formats a sample timestamp for table and csv output, in RFC3339 format with the precision set by SetTimePrecision
+func FormatTimestamp(t time.Time) string {
+	return t.Format(timestampLayout)
+}
+
+// formatCaption formats the start or end timestamp of a graph caption
+func formatCaption(t time.Time) string {
+	return t.Format(captionLayout)
+}
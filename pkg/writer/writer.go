@@ -14,43 +14,66 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// writer provides our stdout writers for promql query results
+// writer provides our writers for promql query results
 package writer
 
 import (
-	"bytes"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"sort"
 	"strings"
 	"text/tabwriter"
-	"time"
+	"text/template"
 
-	"github.com/guptarohit/asciigraph"
 	"github.com/nalbury/promql-cli/pkg/util"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
 )
 
 // Writer is our base interface for promql writers
-// Defines Json and Csv writers
+// Defines Json, Csv, and Template writers
 type Writer interface {
-	Json() (bytes.Buffer, error)
-	Csv(noHeaders bool) (bytes.Buffer, error)
+	Json(w io.Writer) error
+	Csv(w io.Writer, noHeaders bool) error
+	Template(w io.Writer, t *template.Template) error
 }
 
-// RangeWriter extends the Writer interface by adding a Graph method
+// Options holds the settings used when writing out query results
+type Options struct {
+	// NoHeaders disables the headers of table and csv output
+	NoHeaders bool
+	// Template is the parsed output template used by the template format
+	Template *template.Template
+	// Graph holds the settings for graphs of range query results
+	Graph GraphOptions
+	// Table holds the settings for tables of instant query results
+	Table TableOptions
+	// CSVQuote is one of the CSVQuote constants, defaulting to CSVQuoteMinimal
+	CSVQuote string
+	// TimeBuckets is one of the TimeBuckets constants, adding csv columns that bucket each sample by its timestamp, empty adds none
+	TimeBuckets string
+	// CSVPivot writes range query csv output with a row per timestamp and a column per series, rather than a row per sample
+	CSVPivot bool
+	// JSONFlat writes range and instant query json output as an object per sample, with its labels as top level fields alongside __value__ and __timestamp__
+	JSONFlat bool
+}
+
+// RangeWriter extends the Writer interface by adding Graph and Heatmap methods
 // Used specifically for writing the results of range queries
 type RangeWriter interface {
 	Writer
-	Graph(dim util.TermDimensions) (bytes.Buffer, error)
+	Graph(w io.Writer, dim util.TermDimensions, opts GraphOptions) error
+	Heatmap(w io.Writer, dim util.TermDimensions, opts GraphOptions) error
 }
 
 // InstantWriter extends the Writer interface by adding a Table method
 // Use specifically for writing the results of instant queries
 type InstantWriter interface {
 	Writer
-	Table(noHeaders bool) (bytes.Buffer, error)
+	Table(w io.Writer, noHeaders bool, opts TableOptions) error
 }
 
 // RangeResult is wrapper of the prometheus model.Matrix type returned from range queries
@@ -59,157 +82,177 @@ type RangeResult struct {
 	model.Matrix
 }
 
-// Graph returns an ascii graph using https://github.com/guptarohit/asciigraph
-func (r *RangeResult) Graph(dim util.TermDimensions) (bytes.Buffer, error) {
-	var buf bytes.Buffer
+// Graph writes an ascii graph using https://github.com/guptarohit/asciigraph
+// Series without float samples, e.g. native histograms, are skipped, and an error naming them is returned when no series can be graphed
+func (r *RangeResult) Graph(out io.Writer, dim util.TermDimensions, opts GraphOptions) error {
 
-	termHeightOpt := asciigraph.Height(dim.Height / 5)
-	termWidthOpt := asciigraph.Width(dim.Width - 8)
+	termHeight := dim.Height / 5
+	termWidth := dim.Width - 8
 
+	var (
+		graphed int
+		skipped []string
+	)
 	for _, m := range r.Matrix {
 		var (
-			data         []float64
-			start        string
-			end          string
-			borderLength int
+			start string
+			end   string
 		)
-
-		for _, v := range m.Values {
-			data = append(data, float64(v.Value))
+		if len(m.Values) == 0 {
+			skipped = append(skipped, m.Metric.String())
+			continue
 		}
 
-		start = m.Values[0].Timestamp.Time().Format(time.Stamp)
-		end = m.Values[(len(m.Values) - 1)].Timestamp.Time().Format(time.Stamp)
+		start = formatCaption(m.Values[0].Timestamp.Time())
+		end = formatCaption(m.Values[(len(m.Values) - 1)].Timestamp.Time())
 
 		timeRange := start + " -> " + end
 
+		// Normalize each series on its own, noting the range of its real values in its header
+		values := m.Values
+		var extra []string
+		if opts.Normalize {
+			var min, max model.SampleValue
+			values, min, max = normalize(m.Values)
+			extra = append(extra, fmt.Sprintf("# VALUE_RANGE: %s -> %s (normalized to 0..1)", min, max))
+		}
+
 		// Generate the graph boxed to our terminal size
-		graph := asciigraph.Plot(data, termHeightOpt, termWidthOpt)
-
-		// Create our header for each graph
-		// # TIME_RANGE: Sep 27 09:08:09 -> Sep 27 09:18:09
-		timeRangeHeader := "# TIME_RANGE: " + timeRange
-		// # METRIC: {instance="10.202.38.101:6443"}
-		metricHeader := "# METRIC: " + m.Metric.String()
-		// Truncate the metric header to the term width - 2
-		// This ensures that long metric headers don't overflow onto a new line
-		if len(metricHeader) > (dim.Width - 2) {
-			metricHeader = metricHeader[:(dim.Width - 2)]
-		}
-		// Determine the longest header string and set the border (######) to it's length + 2
-		// Add spacing to the shortest header
-		if len(timeRangeHeader) > len(metricHeader) {
-			borderLength = len(timeRangeHeader) + 2
-			s := len(timeRangeHeader) - len(metricHeader)
-			metricHeader = metricHeader + strings.Repeat(" ", s)
-		} else {
-			borderLength = len(metricHeader) + 2
-			s := len(metricHeader) - len(timeRangeHeader)
-			timeRangeHeader = timeRangeHeader + strings.Repeat(" ", s)
-		}
-		// Create the border of '#'
-		border := strings.Repeat("#", borderLength)
-		// Write out
-		if _, err := fmt.Fprintf(&buf, "\n%s\n", border); err != nil {
-			return buf, err
-		}
-		if _, err := fmt.Fprintf(&buf, "%s #\n", timeRangeHeader); err != nil {
-			return buf, err
-		}
-		if _, err := fmt.Fprintf(&buf, "%s #\n", metricHeader); err != nil {
-			return buf, err
-		}
-		if _, err := fmt.Fprintf(&buf, "%s\n", border); err != nil {
-			return buf, err
-		}
-		if _, err := fmt.Fprintf(&buf, "%s\n", graph); err != nil {
-			return buf, err
-		}
-	}
-	return buf, nil
-}
-
-// Json returns the response from a range query as json
-func (r *RangeResult) Json() (bytes.Buffer, error) {
-	var buf bytes.Buffer
-	o, err := json.Marshal(r.Matrix)
-	if err != nil {
-		return buf, err
+		graph, err := plot(values, termHeight, termWidth, opts)
+		if err != nil {
+			return err
+		}
+
+		if opts.Separator && graphed > 0 {
+			if _, err := fmt.Fprintln(out, separator(dim.Width, opts.Style)); err != nil {
+				return err
+			}
+		}
+		if err := writeGraphHeader(out, timeRange, m.Metric.String(), dim.Width, extra...); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "%s\n", graph); err != nil {
+			return err
+		}
+		if opts.TimeAxis {
+			if _, err := fmt.Fprintf(out, "%s\n", timeAxis(graph, values, opts.Style)); err != nil {
+				return err
+			}
+		}
+		graphed++
 	}
-	buf.Write(o)
-	return buf, nil
+	if graphed == 0 && len(skipped) > 0 {
+		return fmt.Errorf("unable to graph series without float samples: %s", strings.Join(skipped, ", "))
+	}
+	return nil
 }
 
-// Csv returns the response from a range query as a csv
-func (r *RangeResult) Csv(noHeaders bool) (bytes.Buffer, error) {
-	var (
-		buf  bytes.Buffer
-		rows [][]string
-	)
-	w := csv.NewWriter(&buf)
-	labels, err := util.UniqLabels(r.Matrix)
-	if err != nil {
-		return buf, err
+// writeGraphHeader writes the boxed header of a single graph e.g.
+// ##################################################
+// # TIME_RANGE: Sep 26 09:37:35 -> Sep 27 09:37:35 #
+// # METRIC: {job="apiserver"}                      #
+// ##################################################
+func writeGraphHeader(w io.Writer, timeRange string, metric string, width int, extra ...string) error {
+	metricHeader := "# METRIC: " + metric
+	// Truncate the metric header to the term width - 2
+	// This ensures that long metric headers don't overflow onto a new line
+	if len(metricHeader) > (width - 2) {
+		metricHeader = metricHeader[:(width - 2)]
+	}
+	headers := append([]string{"# TIME_RANGE: " + timeRange, metricHeader}, extra...)
+	// Determine the longest header string and set the border (######) to it's length + 2
+	// Add spacing to the shorter headers
+	longest := 0
+	for _, h := range headers {
+		if len(h) > longest {
+			longest = len(h)
+		}
 	}
-	if !noHeaders {
-		var titleRow []string
-		for _, k := range labels {
-			titleRow = append(titleRow, string(k))
+	// Create the border of '#'
+	border := strings.Repeat("#", longest+2)
+	// Write out
+	if _, err := fmt.Fprintf(w, "\n%s\n", border); err != nil {
+		return err
+	}
+	for _, h := range headers {
+		if _, err := fmt.Fprintf(w, "%s #\n", h+strings.Repeat(" ", longest-len(h))); err != nil {
+			return err
 		}
+	}
+	if _, err := fmt.Fprintf(w, "%s\n", border); err != nil {
+		return err
+	}
+	return nil
+}
 
-		titleRow = append(titleRow, "value")
-		titleRow = append(titleRow, "timestamp")
+// lineWriter tracks the last byte written through it, so output can be ended with exactly one newline
+type lineWriter struct {
+	w    io.Writer
+	last byte
+}
 
-		rows = append(rows, titleRow)
+func (l *lineWriter) Write(p []byte) (int, error) {
+	n, err := l.w.Write(p)
+	if n > 0 {
+		l.last = p[n-1]
 	}
+	return n, err
+}
 
-	for _, m := range r.Matrix {
-		for _, v := range m.Values {
-			row := make([]string, len(labels))
-			for i, key := range labels {
-				row[i] = string(m.Metric[key])
-			}
-			row = append(row, v.Value.String())
-			row = append(row, v.Timestamp.Time().Format(time.RFC3339))
-			rows = append(rows, row)
-		}
+// terminate ends any output that doesn't already end in a newline with one, e.g. a graph or a single value
+func (l *lineWriter) terminate() error {
+	if l.last == 0 || l.last == '\n' {
+		return nil
 	}
-	if err := w.WriteAll(rows); err != nil {
-		return buf, err
+	_, err := l.w.Write([]byte{'\n'})
+	return err
+}
+
+// writeJSON writes v to out as json, followed by a newline
+func writeJSON(out io.Writer, v interface{}) error {
+	return json.NewEncoder(out).Encode(v)
+}
+
+// Json writes the response from a range query as json
+// Series are encoded one at a time, so large results don't have to be held in memory twice and output starts right away
+func (r *RangeResult) Json(out io.Writer) error {
+	if r.Matrix == nil {
+		return writeJSON(out, r.Matrix)
 	}
-	return buf, nil
+	return writeJSONArray(out, len(r.Matrix), func(b []byte, i int) ([]byte, error) {
+		return appendSeriesJSON(b, r.Matrix[i])
+	})
 }
 
-// WriteRange writes out the results of the query to an
-// output buffer and prints it to stdout
-func WriteRange(r RangeWriter, format string, noHeaders bool) error {
-	var (
-		buf bytes.Buffer
-		err error
-	)
-	switch format {
-	case "json":
-		buf, err = r.Json()
-		if err != nil {
-			return err
-		}
-	case "csv":
-		buf, err = r.Csv(noHeaders)
-		if err != nil {
-			return err
-		}
-	default:
-		dim, err := util.TerminalSize()
-		if err != nil {
-			return err
-		}
-		buf, err = r.Graph(dim)
-		if err != nil {
-			return err
-		}
+// Csv writes the response from a range query as a csv
+func (r *RangeResult) Csv(out io.Writer, noHeaders bool) error {
+	err := r.WriteCsv(out, Options{NoHeaders: noHeaders})
+	return err
+}
+
+// terminalSize looks up the dimensions graphs are drawn at, it's a variable so tests can stub it
+var terminalSize = util.TerminalSize
+
+// stderr receives warnings that shouldn't mix with results on stdout
+var stderr io.Writer = os.Stderr
+
+// defaultTermDimensions are the dimensions graphs are drawn at when the terminal size can't be determined, e.g. in cron jobs and CI
+var defaultTermDimensions = util.TermDimensions{Height: 24, Width: 80}
+
+// graphDimensions returns the dimensions of the terminal, falling back to defaultTermDimensions with a warning if they can't be determined
+func graphDimensions() util.TermDimensions {
+	dim, err := terminalSize()
+	if err != nil {
+		fmt.Fprintf(stderr, "Warning: unable to determine terminal size (%v), drawing graphs at %dx%d\n", err, defaultTermDimensions.Width, defaultTermDimensions.Height)
+		return defaultTermDimensions
 	}
-	fmt.Println(buf.String())
-	return nil
+	return dim
+}
+
+// WriteRange writes out the results of the query to w in the given format
+// It's a thin wrapper over the dispatch Write uses, for range writers that aren't a model.Value
+func WriteRange(w io.Writer, r RangeWriter, format string, opts Options) error {
+	return write(w, r, format, opts)
 }
 
 // InstantResult is wrapper of the prometheus model.Matrix type returned from instant queries
@@ -218,93 +261,162 @@ type InstantResult struct {
 	model.Vector
 }
 
-// Table returns the response from an instant query as a tab separated table
-func (r *InstantResult) Table(noHeaders bool) (bytes.Buffer, error) {
-	var buf bytes.Buffer
+// Table writes the response from an instant query as a tab separated table
+func (r *InstantResult) Table(out io.Writer, noHeaders bool, opts TableOptions) error {
 	const padding = 4
-	w := tabwriter.NewWriter(&buf, 0, 0, padding, ' ', 0)
+	w := tabwriter.NewWriter(out, 0, 0, padding, ' ', 0)
 	labels, err := util.UniqLabels(r.Vector)
 	if err != nil {
-		return buf, err
+		return err
 	}
+	var titles []string
 	if !noHeaders {
-		var titles []string
 		for _, k := range labels {
 			titles = append(titles, strings.ToUpper(string(k)))
 		}
-		titles = append(titles, "VALUE")
+		titles = append(titles, opts.valueHeader("VALUE"))
 		titles = append(titles, "TIMESTAMP")
-		titleRow := strings.Join(titles, "\t")
-		if _, err := fmt.Fprintln(w, titleRow); err != nil {
-			return buf, err
+		if err := opts.writeRow(w, titles, len(labels)); err != nil {
+			return err
 		}
 	}
 
-	for _, v := range r.Vector {
-		data := make([]string, len(labels))
-		for i, key := range labels {
-			data[i] = string(v.Metric[key])
+	times := newTimeCells("")
+	var data []string
+	for i, s := range r.Vector {
+		if err := opts.repeatHeader(w, titles, len(labels), i); err != nil {
+			return err
 		}
-		data = append(data, v.Value.String())
-		data = append(data, v.Timestamp.Time().Format(time.RFC3339))
-		row := strings.Join(data, "\t")
-		if _, err := fmt.Fprintln(w, row); err != nil {
-			return buf, err
+		data = sampleCells(labelCellsInto(data, s.Metric, labels), len(labels), s.Value, times.cells(s.Timestamp))
+		data[len(labels)] = opts.seriesValueCell(s.Metric, float64(s.Value), data[len(labels)])
+		if err := opts.writeRow(w, data, len(labels)); err != nil {
+			return err
 		}
 	}
+	if opts.Deltas != nil {
+		opts.Deltas.next()
+	}
 	if err := w.Flush(); err != nil {
-		return buf, err
+		return err
 	}
-	return buf, nil
+	return nil
 }
 
-// Json returns the response from an instant query as json
-func (r *InstantResult) Json() (bytes.Buffer, error) {
-	var buf bytes.Buffer
-	o, err := json.Marshal(r.Vector)
-	if err != nil {
-		return buf, err
+// Json writes the response from an instant query as json
+// Samples are encoded one at a time like range query results
+func (r *InstantResult) Json(out io.Writer) error {
+	if r.Vector == nil {
+		return writeJSON(out, r.Vector)
 	}
-	buf.Write(o)
-	return buf, nil
+	return writeJSONArray(out, len(r.Vector), func(b []byte, i int) ([]byte, error) {
+		return appendSampleJSON(b, r.Vector[i])
+	})
 }
 
-// Csv returns the response from an instant query as a csv
-func (r *InstantResult) Csv(noHeaders bool) (bytes.Buffer, error) {
-	var (
-		buf  bytes.Buffer
-		rows [][]string
-	)
-	w := csv.NewWriter(&buf)
-	labels, err := util.UniqLabels(r.Vector)
-	if err != nil {
-		return buf, err
+// Csv writes the response from an instant query as a csv
+func (r *InstantResult) Csv(out io.Writer, noHeaders bool) error {
+	err := r.WriteCsv(out, Options{NoHeaders: noHeaders})
+	return err
+}
+
+// Value writes only the value of the single sample of an instant query, for use in scripts
+// It errors if the result doesn't contain exactly one sample
+func (r *InstantResult) Value(out io.Writer) error {
+	switch len(r.Vector) {
+	case 0:
+		return fmt.Errorf("unable to write value: the query returned no results")
+	case 1:
+		_, err := io.WriteString(out, FormatValue(float64(r.Vector[0].Value)))
+		return err
+	default:
+		return fmt.Errorf("unable to write value: the query returned %d series, narrow it to a single series (e.g. with topk(1, ...))", len(r.Vector))
 	}
-	if !noHeaders {
-		var titleRow []string
-		for _, k := range labels {
-			titleRow = append(titleRow, string(k))
-		}
+}
 
-		titleRow = append(titleRow, "value")
-		titleRow = append(titleRow, "timestamp")
+// ValueWriter is implemented by results that can be written as a single value
+type ValueWriter interface {
+	Value(w io.Writer) error
+}
 
-		rows = append(rows, titleRow)
+// WriteValue writes out only the value of an instant query result (e.g. its single sample) to w
+func WriteValue(w io.Writer, r ValueWriter) error {
+	out := &lineWriter{w: w}
+	if err := r.Value(out); err != nil {
+		return err
+	}
+	return out.terminate()
+}
+
+// CountResult is the number of series in a query result
+type CountResult struct {
+	Count int `json:"count"`
+}
+
+// Count returns the number of series in an instant or range query result
+func Count(result model.Value) (CountResult, error) {
+	switch r := result.(type) {
+	case model.Vector:
+		return CountResult{Count: len(r)}, nil
+	case model.Matrix:
+		return CountResult{Count: len(r)}, nil
+	default:
+		return CountResult{}, fmt.Errorf("unable to count series: unknown query result type: %T", r)
+	}
+}
+
+// WriteCount writes out the number of series in a query result to w
+// The json format writes it as {"count":N}, every other format as just the number
+func WriteCount(w io.Writer, r CountResult, format string) error {
+	if format == "json" {
+		return writeJSON(w, r)
 	}
+	_, err := fmt.Fprintln(w, r.Count)
+	return err
+}
 
-	for _, v := range r.Vector {
-		row := make([]string, len(labels))
-		for i, key := range labels {
-			row[i] = string(v.Metric[key])
+// DistinctResult is the sorted distinct values of a label across the series of a query result
+type DistinctResult []string
+
+// Distinct returns the sorted distinct values of the label name across the series of an instant or range query result
+// Series without the label are skipped
+func Distinct(result model.Value, name model.LabelName) (DistinctResult, error) {
+	var metrics []model.Metric
+	switch r := result.(type) {
+	case model.Vector:
+		for _, s := range r {
+			metrics = append(metrics, s.Metric)
+		}
+	case model.Matrix:
+		for _, s := range r {
+			metrics = append(metrics, s.Metric)
+		}
+	default:
+		return nil, fmt.Errorf("unable to list distinct label values: unknown query result type: %T", r)
+	}
+	seen := map[model.LabelValue]bool{}
+	values := DistinctResult{}
+	for _, m := range metrics {
+		if v, ok := m[name]; ok && !seen[v] {
+			seen[v] = true
+			values = append(values, string(v))
 		}
-		row = append(row, v.Value.String())
-		row = append(row, v.Timestamp.Time().Format(time.RFC3339))
-		rows = append(rows, row)
 	}
-	if err := w.WriteAll(rows); err != nil {
-		return buf, err
+	sort.Strings(values)
+	return values, nil
+}
+
+// WriteDistinct writes out the distinct values of a label to w
+// The json format writes them as an array, every other format as one value per line
+func WriteDistinct(w io.Writer, r DistinctResult, format string) error {
+	if format == "json" {
+		return writeJSON(w, r)
 	}
-	return buf, nil
+	for _, v := range r {
+		if _, err := fmt.Fprintln(w, v); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // MetricsResult is the list of metrics names from a metadata query result
@@ -312,47 +424,40 @@ func (r *InstantResult) Csv(noHeaders bool) (bytes.Buffer, error) {
 // a point in time (e.g. what metrics are currently queryable)
 type MetricsResult []string
 
-// Table returns the response from a metrics query as a single column table
-func (r *MetricsResult) Table(noHeaders bool) (bytes.Buffer, error) {
-	var buf bytes.Buffer
+// Table writes the response from a metrics query as a single column table
+func (r *MetricsResult) Table(out io.Writer, noHeaders bool, opts TableOptions) error {
 	const padding = 4
-	w := tabwriter.NewWriter(&buf, 0, 0, padding, ' ', 0)
+	w := tabwriter.NewWriter(out, 0, 0, padding, ' ', 0)
+	var titles []string
 	if !noHeaders {
-		titleRow := "METRICS"
-		if _, err := fmt.Fprintln(w, titleRow); err != nil {
-			return buf, err
+		titles = []string{"METRICS"}
+		if err := opts.writeRow(w, titles, 0); err != nil {
+			return err
 		}
 	}
-	for _, l := range *r {
-		row := string(l)
-		if _, err := fmt.Fprintln(w, row); err != nil {
-			return buf, err
+	for i, l := range *r {
+		if err := opts.repeatHeader(w, titles, 0, i); err != nil {
+			return err
+		}
+		if err := opts.writeRow(w, []string{l}, 1); err != nil {
+			return err
 		}
 	}
 	if err := w.Flush(); err != nil {
-		return buf, err
+		return err
 	}
-	return buf, nil
+	return nil
 }
 
-// Json returns the response from a metrics query as json
-func (r *MetricsResult) Json() (bytes.Buffer, error) {
-	var buf bytes.Buffer
-	o, err := json.Marshal(r)
-	if err != nil {
-		return buf, err
-	}
-	buf.Write(o)
-	return buf, nil
+// Json writes the response from a metrics query as json
+func (r *MetricsResult) Json(out io.Writer) error {
+	return writeJSON(out, r)
 }
 
-// Csv returns the response from a metrics query as a single column csv
-func (r *MetricsResult) Csv(noHeaders bool) (bytes.Buffer, error) {
-	var (
-		buf  bytes.Buffer
-		rows [][]string
-	)
-	w := csv.NewWriter(&buf)
+// Csv writes the response from a metrics query as a single column csv
+func (r *MetricsResult) Csv(out io.Writer, noHeaders bool) error {
+	var rows [][]string
+	w := csv.NewWriter(out)
 	if !noHeaders {
 		titleRow := []string{"metrics"}
 		rows = append(rows, titleRow)
@@ -362,9 +467,9 @@ func (r *MetricsResult) Csv(noHeaders bool) (bytes.Buffer, error) {
 		rows = append(rows, row)
 	}
 	if err := w.WriteAll(rows); err != nil {
-		return buf, err
+		return err
 	}
-	return buf, nil
+	return nil
 }
 
 // LabelsResult is the result of an instant query
@@ -372,60 +477,64 @@ func (r *MetricsResult) Csv(noHeaders bool) (bytes.Buffer, error) {
 // the unique labels present in a query result.
 type LabelsResult struct {
 	model.Vector
+	// Page selects the page of the sorted labels that's written, the zero value writes them all
+	Page util.Page
 }
 
-// Table returns the labels from an instant query as a single column table
-func (r *LabelsResult) Table(noHeaders bool) (bytes.Buffer, error) {
-	var buf bytes.Buffer
-	const padding = 4
-	w := tabwriter.NewWriter(&buf, 0, 0, padding, ' ', 0)
+// labels returns the page of the unique labels in the result
+func (r *LabelsResult) labels() ([]model.LabelName, error) {
 	labels, err := util.UniqLabels(r.Vector)
 	if err != nil {
-		return buf, err
+		return nil, err
+	}
+	return util.Paginate(labels, r.Page), nil
+}
+
+// Table writes the labels from an instant query as a single column table
+func (r *LabelsResult) Table(out io.Writer, noHeaders bool, opts TableOptions) error {
+	const padding = 4
+	w := tabwriter.NewWriter(out, 0, 0, padding, ' ', 0)
+	labels, err := r.labels()
+	if err != nil {
+		return err
 	}
+	var titles []string
 	if !noHeaders {
-		titleRow := "LABELS"
-		if _, err := fmt.Fprintln(w, titleRow); err != nil {
-			return buf, err
+		titles = []string{"LABELS"}
+		if err := opts.writeRow(w, titles, 0); err != nil {
+			return err
 		}
 	}
-	for _, l := range labels {
-		row := string(l)
-		if _, err := fmt.Fprintln(w, row); err != nil {
-			return buf, err
+	for i, l := range labels {
+		if err := opts.repeatHeader(w, titles, 0, i); err != nil {
+			return err
+		}
+		if err := opts.writeRow(w, []string{string(l)}, 1); err != nil {
+			return err
 		}
 	}
 	if err := w.Flush(); err != nil {
-		return buf, err
+		return err
 	}
-	return buf, nil
+	return nil
 }
 
-// Json returns the labels from an instant query as json
-func (r *LabelsResult) Json() (bytes.Buffer, error) {
-	var buf bytes.Buffer
-	labels, err := util.UniqLabels(r.Vector)
-	if err != nil {
-		return buf, err
-	}
-	o, err := json.Marshal(labels)
+// Json writes the labels from an instant query as json
+func (r *LabelsResult) Json(out io.Writer) error {
+	labels, err := r.labels()
 	if err != nil {
-		return buf, err
+		return err
 	}
-	buf.Write(o)
-	return buf, nil
+	return writeJSON(out, labels)
 }
 
-// Csv returns the labels from an instant query as a single column csv
-func (r *LabelsResult) Csv(noHeaders bool) (bytes.Buffer, error) {
-	var (
-		buf  bytes.Buffer
-		rows [][]string
-	)
-	w := csv.NewWriter(&buf)
-	labels, err := util.UniqLabels(r.Vector)
+// Csv writes the labels from an instant query as a single column csv
+func (r *LabelsResult) Csv(out io.Writer, noHeaders bool) error {
+	var rows [][]string
+	w := csv.NewWriter(out)
+	labels, err := r.labels()
 	if err != nil {
-		return buf, err
+		return err
 	}
 	if !noHeaders {
 		titleRow := []string{"labels"}
@@ -436,49 +545,41 @@ func (r *LabelsResult) Csv(noHeaders bool) (bytes.Buffer, error) {
 		rows = append(rows, row)
 	}
 	if err := w.WriteAll(rows); err != nil {
-		return buf, err
+		return err
 	}
-	return buf, nil
+	return nil
 }
 
 // MetaResult is the result of our metadata query
 // It satisfies the InstantWriter interface
 type MetaResult map[string][]v1.Metadata
 
-// Metrics returns an array of metrics from a metadata query result
+// Metrics returns an array of metrics from a metadata query result, sorted by name
 func (r *MetaResult) Metrics() MetricsResult {
 	var metrics MetricsResult = make([]string, 0, len(*r))
 	for k := range *r {
 		metrics = append(metrics, k)
 	}
+	sort.Strings(metrics)
 	return metrics
 }
 
-// Json returns the result from a metadata query as json
-func (r *MetaResult) Json() (bytes.Buffer, error) {
-	var buf bytes.Buffer
-	j, err := json.Marshal(r)
-	if err != nil {
-		return buf, err
-	}
-	buf.Write(j)
-	return buf, nil
+// Json writes the result from a metadata query as json
+func (r *MetaResult) Json(out io.Writer) error {
+	return writeJSON(out, r)
 }
 
-// Csv returns the result from a metadata query as csv
-func (r *MetaResult) Csv(noHeaders bool) (bytes.Buffer, error) {
-	var (
-		buf  bytes.Buffer
-		rows [][]string
-	)
-	w := csv.NewWriter(&buf)
+// Csv writes the result from a metadata query as csv
+func (r *MetaResult) Csv(out io.Writer, noHeaders bool) error {
+	var rows [][]string
+	w := csv.NewWriter(out)
 	if !noHeaders {
 		titleRow := []string{"metric", "type", "help", "unit"}
 		rows = append(rows, titleRow)
 	}
 
-	for metric, meta := range *r {
-		for _, m := range meta {
+	for _, metric := range r.Metrics() {
+		for _, m := range (*r)[metric] {
 			data := make([]string, 0, 4)
 			data = append(data, metric)
 			data = append(data, string(m.Type))
@@ -489,45 +590,121 @@ func (r *MetaResult) Csv(noHeaders bool) (bytes.Buffer, error) {
 	}
 
 	if err := w.WriteAll(rows); err != nil {
-		return buf, err
+		return err
 	}
-	return buf, nil
+	return nil
 }
 
-// Table returns the result from a metadata query as tab separated table
-func (r *MetaResult) Table(noHeaders bool) (bytes.Buffer, error) {
-	var buf bytes.Buffer
+// Table writes the result from a metadata query as tab separated table
+func (r *MetaResult) Table(out io.Writer, noHeaders bool, opts TableOptions) error {
 	const padding = 4
-	w := tabwriter.NewWriter(&buf, 0, 0, padding, ' ', 0)
+	w := tabwriter.NewWriter(out, 0, 0, padding, ' ', 0)
+	var titles []string
 	if !noHeaders {
-		titles := []string{"METRIC", "TYPE", "HELP", "UNIT"}
-		titleRow := strings.Join(titles, "\t")
-		if _, err := fmt.Fprintln(w, titleRow); err != nil {
-			return buf, err
+		titles = []string{"METRIC", "TYPE", "HELP", "UNIT"}
+		if err := opts.writeRow(w, titles, len(titles)); err != nil {
+			return err
 		}
 	}
-	for metric, meta := range *r {
-		for _, m := range meta {
+	i := 0
+	for _, metric := range r.Metrics() {
+		for _, m := range (*r)[metric] {
+			if err := opts.repeatHeader(w, titles, len(titles), i); err != nil {
+				return err
+			}
+			i++
 			data := make([]string, 0, 4)
 			data = append(data, metric)
 			data = append(data, string(m.Type))
 			data = append(data, m.Help)
 			data = append(data, m.Unit)
-			row := strings.Join(data, "\t")
-			if _, err := fmt.Fprintln(w, row); err != nil {
-				return buf, err
+			if err := opts.writeRow(w, data, len(data)); err != nil {
+				return err
 			}
 		}
 	}
 	if err := w.Flush(); err != nil {
-		return buf, err
+		return err
 	}
-	return buf, nil
+	return nil
 }
 
-// SeriesResult currently doesn't write anything itself but helps create other result types like metrics from the series api
+// SeriesResult is the result of a series query, the label sets of the matching series
+// It satisfies the InstantWriter interface, and helps create other result types like metrics from the series api
 type SeriesResult []model.LabelSet
 
+// labels returns the sorted unique label names of the series
+func (r *SeriesResult) labels() []model.LabelName {
+	seen := make(map[model.LabelName]struct{})
+	var labels []model.LabelName
+	for _, ls := range *r {
+		for k := range ls {
+			if _, ok := seen[k]; !ok {
+				seen[k] = struct{}{}
+				labels = append(labels, k)
+			}
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i] < labels[j] })
+	return labels
+}
+
+// Table writes the series as a table with a column per label
+func (r *SeriesResult) Table(out io.Writer, noHeaders bool, opts TableOptions) error {
+	const padding = 4
+	w := tabwriter.NewWriter(out, 0, 0, padding, ' ', 0)
+	labels := r.labels()
+	var titles []string
+	if !noHeaders {
+		for _, k := range labels {
+			titles = append(titles, strings.ToUpper(string(k)))
+		}
+		if err := opts.writeRow(w, titles, len(labels)); err != nil {
+			return err
+		}
+	}
+	var data []string
+	for i, ls := range *r {
+		if err := opts.repeatHeader(w, titles, len(labels), i); err != nil {
+			return err
+		}
+		data = labelCellsInto(data, model.Metric(ls), labels)
+		if err := opts.writeRow(w, data, len(labels)); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// Json writes the series as a json array of label sets, like the series api
+func (r *SeriesResult) Json(out io.Writer) error {
+	return writeJSON(out, []model.LabelSet(*r))
+}
+
+// Csv writes the series as a csv with a column per label
+func (r *SeriesResult) Csv(out io.Writer, noHeaders bool) error {
+	w := csv.NewWriter(out)
+	labels := r.labels()
+	if !noHeaders {
+		titleRow := make([]string, 0, len(labels))
+		for _, k := range labels {
+			titleRow = append(titleRow, string(k))
+		}
+		if err := w.Write(titleRow); err != nil {
+			return err
+		}
+	}
+	var data []string
+	for _, ls := range *r {
+		data = labelCellsInto(data, model.Metric(ls), labels)
+		if err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
 // Metrics creates a MetricsResult from a SeriesResult
 func (r *SeriesResult) Metrics() MetricsResult {
 	u := make(map[string]struct{})
@@ -542,30 +719,57 @@ func (r *SeriesResult) Metrics() MetricsResult {
 	return m
 }
 
-// WriteInstant writes out the results of the query to an
-// output buffer and prints it to stdout
-func WriteInstant(i InstantWriter, format string, noHeaders bool) error {
-	var (
-		buf bytes.Buffer
-		err error
-	)
+// WriteInstant writes out the results of the query to w in the given format
+// It's a thin wrapper over the dispatch Write uses, for instant writers that aren't a model.Value, e.g. labels and metadata
+func WriteInstant(w io.Writer, i InstantWriter, format string, opts Options) error {
+	return write(w, i, format, opts)
+}
+
+// Write writes out a query result of any type to w in the given format, picking the writer for its type
+// Vectors, scalars and strings are written as instant results, and matrices as range results, whether they're from a range query or a range selector in an instant query (e.g. up[5m])
+func Write(v model.Value, format string, opts Options, w io.Writer) error {
+	switch r := v.(type) {
+	case model.Vector:
+		return write(w, &InstantResult{Vector: r}, format, opts)
+	case model.Matrix:
+		return write(w, &RangeResult{Matrix: r}, format, opts)
+	case *model.Scalar:
+		return write(w, &ScalarResult{Scalar: r}, format, opts)
+	case *model.String:
+		return write(w, &StringResult{String: r}, format, opts)
+	default:
+		return fmt.Errorf("unable to write result: unknown query result type: %T", v)
+	}
+}
+
+// write writes out r to w in the given format
+// Range writers are drawn as a graph or heatmap, and instant writers as a table, in any format but json, csv and template
+func write(w io.Writer, r Writer, format string, opts Options) error {
+	out := &lineWriter{w: w}
+	var err error
 	switch format {
 	case "json":
-		buf, err = i.Json()
-		if err != nil {
-			return err
-		}
+		err = writeJSONOutput(out, r, opts)
 	case "csv":
-		buf, err = i.Csv(noHeaders)
-		if err != nil {
-			return err
-		}
+		err = writeCSV(out, r, opts)
+	case "template":
+		err = r.Template(out, opts.Template)
 	default:
-		buf, err = i.Table(noHeaders)
-		if err != nil {
-			return err
+		switch r := r.(type) {
+		case RangeWriter:
+			if format == "heatmap" {
+				err = r.Heatmap(out, graphDimensions(), opts.Graph)
+			} else {
+				err = r.Graph(out, graphDimensions(), opts.Graph)
+			}
+		case InstantWriter:
+			err = r.Table(out, opts.NoHeaders, opts.Table)
+		default:
+			err = fmt.Errorf("unable to write %T as %s", r, format)
 		}
 	}
-	fmt.Println(buf.String())
-	return nil
+	if err != nil {
+		return err
+	}
+	return out.terminate()
 }
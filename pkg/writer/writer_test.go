@@ -1,7 +1,19 @@
 package writer
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -39,7 +51,7 @@ func TestRangeGraph(t *testing.T) {
 			},
 
 			Expected: fmt.Sprintf(
-				"\n##################################################\n# TIME_RANGE: %s -> %s #\n# METRIC: my_metric                              #\n##################################################\n 1.00 ┼───────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────── \n",
+				"\n##################################################\n# TIME_RANGE: %s -> %s #\n# METRIC: my_metric                              #\n##################################################\n 1.00 ┼─────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────────\n",
 				model.TimeFromUnix(now.Unix()-60).Time().Format(time.Stamp),
 				now.Time().Format(time.Stamp),
 			),
@@ -50,12 +62,44 @@ func TestRangeGraph(t *testing.T) {
 			Height: 49,
 			Width:  178,
 		}
-		buf, err := c.Result.Graph(dim)
+		var buf bytes.Buffer
+		err := c.Result.Graph(&buf, dim, GraphOptions{})
 		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
 		assert.Equal(t, c.Expected, buf.String(), "Unexpected output for case %d", i)
 	}
 }
 
+func TestGraphDimensions(t *testing.T) {
+	defer func(f func() (util.TermDimensions, error), w io.Writer) {
+		terminalSize, stderr = f, w
+	}(terminalSize, stderr)
+	var warnings bytes.Buffer
+	stderr = &warnings
+
+	terminalSize = func() (util.TermDimensions, error) {
+		return util.TermDimensions{Height: 40, Width: 120}, nil
+	}
+	assert.Equal(t, util.TermDimensions{Height: 40, Width: 120}, graphDimensions())
+	assert.Empty(t, warnings.String())
+
+	terminalSize = func() (util.TermDimensions, error) {
+		return util.TermDimensions{}, errors.New("stty size: exit status 1")
+	}
+	assert.Equal(t, util.TermDimensions{Height: 24, Width: 80}, graphDimensions())
+	assert.Equal(t, "Warning: unable to determine terminal size (stty size: exit status 1), drawing graphs at 80x24\n", warnings.String())
+
+	// Graphs are still written when the terminal size can't be determined
+	r := &RangeResult{
+		model.Matrix{
+			{
+				Metric: model.Metric{"__name__": "up"},
+				Values: []model.SamplePair{{Timestamp: 1600000000000, Value: 1}, {Timestamp: 1600000060000, Value: 2}},
+			},
+		},
+	}
+	assert.NoError(t, WriteRange(io.Discard, r, "", Options{}))
+}
+
 func TestJson(t *testing.T) {
 	now := model.Now()
 	cases := []struct {
@@ -114,7 +158,7 @@ func TestJson(t *testing.T) {
 		},
 		{
 			Result: &LabelsResult{
-				model.Vector{
+				Vector: model.Vector{
 					{
 						Metric: map[model.LabelName]model.LabelValue{
 							"__name__": "my_metric",
@@ -127,6 +171,13 @@ func TestJson(t *testing.T) {
 			},
 			Expected: "[\"__name__\",\"label\"]",
 		},
+		{
+			Result: &SeriesResult{
+				{"__name__": "my_metric", "label": "value"},
+				{"__name__": "my_metric"},
+			},
+			Expected: "[{\"__name__\":\"my_metric\",\"label\":\"value\"},{\"__name__\":\"my_metric\"}]",
+		},
 		{
 			Result: &MetaResult{
 				"my_metric": []v1.Metadata{
@@ -139,9 +190,18 @@ func TestJson(t *testing.T) {
 			},
 			Expected: "{\"my_metric\":[{\"type\":\"counter\",\"help\":\"The best metric you've ever recorded\",\"unit\":\"\"}]}",
 		},
+		{
+			Result:   &ScalarResult{&model.Scalar{Value: 42, Timestamp: now}},
+			Expected: fmt.Sprintf("[%s,\"42\"]", now.String()),
+		},
+		{
+			Result:   &StringResult{&model.String{Value: "hello", Timestamp: now}},
+			Expected: fmt.Sprintf("[%s,\"hello\"]", now.String()),
+		},
 	}
 	for i, c := range cases {
-		buf, err := c.Result.Json()
+		var buf bytes.Buffer
+		err := c.Result.Json(&buf)
 		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
 		assert.JSONEq(t, c.Expected, buf.String(), "Unexpected output for case %d", i)
 	}
@@ -205,7 +265,7 @@ func TestCsv(t *testing.T) {
 		},
 		{
 			Result: &LabelsResult{
-				model.Vector{
+				Vector: model.Vector{
 					{
 						Metric: map[model.LabelName]model.LabelValue{
 							"__name__": "my_metric",
@@ -218,6 +278,13 @@ func TestCsv(t *testing.T) {
 			},
 			Expected: "labels\n__name__\nlabel\n",
 		},
+		{
+			Result: &SeriesResult{
+				{"__name__": "my_metric", "label": "value"},
+				{"__name__": "my_metric"},
+			},
+			Expected: "__name__,label\nmy_metric,value\nmy_metric,\n",
+		},
 		{
 			Result: &MetaResult{
 				"my_metric": []v1.Metadata{
@@ -230,9 +297,18 @@ func TestCsv(t *testing.T) {
 			},
 			Expected: "metric,type,help,unit\nmy_metric,counter,The best metric you've ever recorded,\n",
 		},
+		{
+			Result:   &ScalarResult{&model.Scalar{Value: 42, Timestamp: now}},
+			Expected: fmt.Sprintf("value,timestamp\n42,%s\n", now.Time().Format(time.RFC3339)),
+		},
+		{
+			Result:   &StringResult{&model.String{Value: "hello, world", Timestamp: now}},
+			Expected: fmt.Sprintf("value,timestamp\n\"hello, world\",%s\n", now.Time().Format(time.RFC3339)),
+		},
 	}
 	for i, c := range cases {
-		buf, err := c.Result.Csv(false)
+		var buf bytes.Buffer
+		err := c.Result.Csv(&buf, false)
 		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
 		assert.Equal(t, c.Expected, buf.String(), "Unexpected output for case %d", i)
 	}
@@ -270,7 +346,7 @@ func TestTable(t *testing.T) {
 		},
 		{
 			Result: &LabelsResult{
-				model.Vector{
+				Vector: model.Vector{
 					{
 						Metric: map[model.LabelName]model.LabelValue{
 							"__name__": "my_metric",
@@ -283,6 +359,13 @@ func TestTable(t *testing.T) {
 			},
 			Expected: "LABELS\n__name__\nlabel\n",
 		},
+		{
+			Result: &SeriesResult{
+				{"__name__": "my_metric", "label": "value"},
+				{"__name__": "my_metric"},
+			},
+			Expected: "__NAME__     LABEL\nmy_metric    value\nmy_metric    \n",
+		},
 		{
 			Result: &MetaResult{
 				"my_metric": []v1.Metadata{
@@ -295,10 +378,1420 @@ func TestTable(t *testing.T) {
 			},
 			Expected: "METRIC       TYPE       HELP                                    UNIT\nmy_metric    counter    The best metric you've ever recorded    \n",
 		},
+		{
+			Result:   &ScalarResult{&model.Scalar{Value: 42, Timestamp: now}},
+			Expected: fmt.Sprintf("VALUE    TIMESTAMP\n42       %s\n", now.Time().Format(time.RFC3339)),
+		},
+		{
+			Result:   &StringResult{&model.String{Value: "hello", Timestamp: now}},
+			Expected: "hello",
+		},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		err := c.Result.Table(&buf, false, TableOptions{})
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, buf.String(), "Unexpected output for case %d", i)
+	}
+}
+
+func TestTableColumnWidth(t *testing.T) {
+	now := model.Now()
+	ts := now.Time().Format(time.RFC3339)
+	result := &InstantResult{
+		model.Vector{
+			{
+				Metric: map[model.LabelName]model.LabelValue{
+					"path": "/var/lib/data",
+				},
+				Value:     1234567.5,
+				Timestamp: now,
+			},
+		},
+	}
+	cases := []struct {
+		Opts     TableOptions
+		Expected string
+	}{
+		{
+			Opts:     TableOptions{},
+			Expected: fmt.Sprintf("PATH             VALUE        TIMESTAMP\n/var/lib/data    1234567.5    %s\n", ts),
+		},
+		{
+			Opts:     TableOptions{MaxColWidth: 6},
+			Expected: fmt.Sprintf("PATH      VALUE        TIMESTAMP\n/var/…    1234567.5    %s\n", ts),
+		},
+		{
+			Opts: TableOptions{MaxColWidth: 6, Wrap: true},
+			Expected: fmt.Sprintf(
+				"PATH      VALUE        TIMESTAMP\n/var/l    1234567.5    %s\nib/dat                 \na                      \n",
+				ts,
+			),
+		},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		err := result.Table(&buf, false, c.Opts)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, buf.String(), "Unexpected output for case %d", i)
+	}
+}
+
+func TestTableRepeatHeader(t *testing.T) {
+	now := model.Now()
+	ts := now.Time().Format(time.RFC3339)
+	result := &InstantResult{
+		model.Vector{
+			{Metric: model.Metric{"instance": "a"}, Value: 1, Timestamp: now},
+			{Metric: model.Metric{"instance": "b"}, Value: 2, Timestamp: now},
+			{Metric: model.Metric{"instance": "c"}, Value: 3, Timestamp: now},
+		},
+	}
+	header := "INSTANCE    VALUE    TIMESTAMP\n"
+	cases := []struct {
+		NoHeaders bool
+		Expected  string
+	}{
+		{
+			Expected: header +
+				fmt.Sprintf("a           1        %s\n", ts) +
+				fmt.Sprintf("b           2        %s\n", ts) +
+				header +
+				fmt.Sprintf("c           3        %s\n", ts),
+		},
+		{
+			NoHeaders: true,
+			Expected: fmt.Sprintf("a    1    %s\n", ts) +
+				fmt.Sprintf("b    2    %s\n", ts) +
+				fmt.Sprintf("c    3    %s\n", ts),
+		},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		err := result.Table(&buf, c.NoHeaders, TableOptions{RepeatHeader: 2})
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, buf.String(), "Unexpected output for case %d", i)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	now := model.Now()
+	cases := []struct {
+		Result   Writer
+		Template string
+		Expected string
+	}{
+		{
+			Result: &InstantResult{
+				model.Vector{
+					{
+						Metric: map[model.LabelName]model.LabelValue{
+							"__name__": "my_metric",
+							"job":      "api",
+						},
+						Value:     1234567,
+						Timestamp: now,
+					},
+				},
+			},
+			Template: `{{range .}}{{.Name}} {{.Labels.job}} {{humanize .Value}} {{printf "%.1f" .Value}} {{.Timestamp.Unix}}{{end}}`,
+			Expected: fmt.Sprintf("my_metric api 1.235M 1234567.0 %d", now.Unix()),
+		},
+		{
+			Result: &RangeResult{
+				model.Matrix{
+					{
+						Metric: map[model.LabelName]model.LabelValue{
+							"__name__": "my_metric",
+						},
+						Values: []model.SamplePair{
+							{Timestamp: now, Value: 0.005},
+							{Timestamp: now, Value: 2},
+						},
+					},
+				},
+			},
+			Template: `{{range .}}{{.Name}}:{{range .Values}} {{humanize .Value}}{{end}}{{end}}`,
+			Expected: "my_metric: 5m 2",
+		},
+		{
+			Result:   &MetricsResult{"my_metric", "my_other_metric"},
+			Template: `{{range $i, $m := .}}{{if $i}},{{end}}{{$m}}{{end}}`,
+			Expected: "my_metric,my_other_metric",
+		},
+	}
+	for i, c := range cases {
+		tmpl, err := NewTemplate(c.Template)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		var buf bytes.Buffer
+		err = c.Result.Template(&buf, tmpl)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, buf.String(), "Unexpected output for case %d", i)
+	}
+}
+
+func TestValue(t *testing.T) {
+	now := model.Now()
+	sample := &model.Sample{Metric: model.Metric{"__name__": "my_metric"}, Value: 0.25, Timestamp: now}
+	r := InstantResult{model.Vector{sample}}
+	var buf bytes.Buffer
+	err := r.Value(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.25", buf.String())
+
+	for i, v := range []model.Vector{{}, {sample, sample}} {
+		r := InstantResult{v}
+		err := r.Value(io.Discard)
+		assert.Error(t, err, "Expected err for case %d", i)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	now := model.Now()
+	a := model.Vector{
+		{Metric: model.Metric{"job": "api"}, Value: 100, Timestamp: now},
+		{Metric: model.Metric{"job": "db"}, Value: 100, Timestamp: now},
+		{Metric: model.Metric{"job": "old"}, Value: 1, Timestamp: now},
+	}
+	b := model.Vector{
+		{Metric: model.Metric{"job": "api"}, Value: 100.05, Timestamp: now},
+		{Metric: model.Metric{"job": "db"}, Value: 110, Timestamp: now},
+		{Metric: model.Metric{"job": "new"}, Value: 2, Timestamp: now},
+	}
+	tolerance, err := ParseTolerance("0.1%")
+	assert.NoError(t, err)
+	r := Diff(a, b, tolerance)
+	assert.Equal(t, 3, r.Mismatches())
+
+	var buf bytes.Buffer
+	err = r.Csv(&buf, false)
+	assert.NoError(t, err)
+	expected := "job,value_a,value_b,diff,diff_pct,status\n" +
+		"api,100,100.05,0.04999999999999716,0.05%,ok\n" +
+		"db,100,110,10,10.00%,mismatch\n" +
+		"new,,2,,,only_b\n" +
+		"old,1,,,,only_a\n"
+	assert.Equal(t, expected, buf.String())
+
+	_, err = ParseTolerance("ten%")
+	assert.Error(t, err)
+}
+
+func TestDiffJSONStringValues(t *testing.T) {
+	defer func(v bool) { JSONStringValues = v }(JSONStringValues)
+	a := model.Vector{
+		{Metric: model.Metric{"job": "api"}, Value: 9007199254740993},
+		{Metric: model.Metric{"job": "db"}, Value: model.SampleValue(math.NaN())},
+	}
+	b := model.Vector{{Metric: model.Metric{"job": "api"}, Value: 9007199254740994.5}}
+	r := Diff(a, b, Tolerance{})
+	var buf bytes.Buffer
+	assert.Error(t, r.Json(&buf), "Expected NaN to fail as a json number")
+	JSONStringValues = true
+	buf.Reset()
+	assert.NoError(t, r.Json(&buf))
+	assert.Equal(t, `[{"metric":{"job":"api"},"value_a":"9007199254740992","value_b":"9007199254740994","diff":"2","diff_pct":"0.00000000000002220446049250313","status":"mismatch"},{"metric":{"job":"db"},"value_a":"NaN","value_b":null,"diff":null,"diff_pct":null,"status":"only_a"}]`+"\n", buf.String())
+}
+
+func TestGraphStyles(t *testing.T) {
+	values := []model.SamplePair{
+		{Timestamp: 0, Value: 1},
+		{Timestamp: 1000, Value: 3},
+		// A gap between samples is kept in the scatter x axis
+		{Timestamp: 4000, Value: 2},
+	}
+	cases := []struct {
+		Style    string
+		Expected string
+	}{
+		{
+			Style:    GraphStyleScatter,
+			Expected: " 3.00 ┤  •\n 2.00 ┤         •\n 1.00 ┤•",
+		},
+		{
+			Style:    GraphStyleASCII,
+			Expected: " 2.89 |   .---.\n 1.94 |.--'   '-\n 1.00 +'",
+		},
+	}
+	for i, c := range cases {
+		graph, err := plot(values, 2, 10, GraphOptions{Style: c.Style})
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, graph, "Unexpected output for case %d", i)
+	}
+	_, err := plot(values, 2, 10, GraphOptions{Style: "sparkles"})
+	assert.Error(t, err)
+}
+
+func TestHeatmap(t *testing.T) {
+	bucketSeries := func(le string, values ...float64) *model.SampleStream {
+		s := &model.SampleStream{
+			Metric: model.Metric{"__name__": "request_duration_seconds_bucket", "le": model.LabelValue(le)},
+		}
+		for i, v := range values {
+			s.Values = append(s.Values, model.SamplePair{Timestamp: model.TimeFromUnix(int64(i * 60)), Value: model.SampleValue(v)})
+		}
+		return s
+	}
+	// Buckets are cumulative: requests shift from the 0.1 bucket to the 1 bucket over time
+	result := RangeResult{
+		model.Matrix{
+			bucketSeries("+Inf", 8, 8, 8, 8),
+			bucketSeries("0.1", 8, 6, 2, 0),
+			bucketSeries("1", 8, 8, 8, 8),
+		},
+	}
+	dim := util.TermDimensions{Width: 40, Height: 20}
+	var buf bytes.Buffer
+	err := result.Heatmap(&buf, dim, GraphOptions{})
+	assert.NoError(t, err)
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, []string{
+		"+Inf ┤",
+		"   1 ┤ ░▓█",
+		" 0.1 ┤█▓░",
+		"     └────",
+		"     ░▒▓█ 0 -> 8",
+	}, lines[5:10], "Unexpected heatmap")
+
+	buf.Reset()
+	err = result.Heatmap(&buf, dim, GraphOptions{Style: GraphStyleASCII})
+	assert.NoError(t, err)
+	lines = strings.Split(buf.String(), "\n")
+	assert.Equal(t, []string{"+Inf |", "   1 | -#@", " 0.1 |@#-", "     +----"}, lines[5:9], "Unexpected ascii heatmap")
+
+	result = RangeResult{model.Matrix{{Metric: model.Metric{"__name__": "up"}}}}
+	err = result.Heatmap(io.Discard, dim, GraphOptions{})
+	assert.Error(t, err, "Expected err for series without an le label")
+}
+
+func TestCSVQuote(t *testing.T) {
+	input := "__name__,path,value\nmy_metric,\"/a,b\",1\nmy_metric,\"say \"\"hi\"\"\",2\n"
+	cases := []struct {
+		mode     string
+		expected string
+		err      bool
+	}{
+		{mode: CSVQuoteMinimal, expected: input},
+		{mode: CSVQuoteAlways, expected: "\"__name__\",\"path\",\"value\"\n\"my_metric\",\"/a,b\",\"1\"\n\"my_metric\",\"say \"\"hi\"\"\",\"2\"\n"},
+		{mode: "sometimes", err: true},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		buf.WriteString(input)
+		out, err := csvQuote(buf, c.mode)
+		if c.err {
+			assert.Error(t, err, "Expected err for case %d", i)
+			continue
+		}
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.expected, out.String(), "Unexpected output for case %d", i)
+	}
+}
+
+func TestWriteCsv(t *testing.T) {
+	m := model.Matrix{
+		{Metric: model.Metric{"__name__": "my_metric", "path": "/a,b"}, Values: []model.SamplePair{{Timestamp: 1600000000000, Value: 1}, {Timestamp: 1600000060000, Value: 2}}},
+		{Metric: model.Metric{"__name__": "my_metric", "path": `say "hi"`}, Values: []model.SamplePair{{Timestamp: 1600000000000, Value: 3}}},
+	}
+	v := model.Vector{{Metric: m[0].Metric, Value: 1, Timestamp: 1600000000000}}
+	ts := func(ms int64) string { return model.Time(ms).Time().Format(time.RFC3339) }
+	cases := []struct {
+		Result   Writer
+		Opts     Options
+		Expected string
+	}{
+		{
+			Result:   &RangeResult{Matrix: m},
+			Expected: "__name__,path,value,timestamp\nmy_metric,\"/a,b\",1," + ts(1600000000000) + "\nmy_metric,\"/a,b\",2," + ts(1600000060000) + "\nmy_metric,\"say \"\"hi\"\"\",3," + ts(1600000000000) + "\n",
+		},
+		{
+			Result:   &RangeResult{Matrix: m[1:]},
+			Opts:     Options{NoHeaders: true, CSVQuote: CSVQuoteAlways},
+			Expected: "\"my_metric\",\"say \"\"hi\"\"\",\"3\",\"" + ts(1600000000000) + "\"\n",
+		},
+		{
+			Result:   &InstantResult{Vector: v},
+			Opts:     Options{CSVQuote: CSVQuoteAlways},
+			Expected: "\"__name__\",\"path\",\"value\",\"timestamp\"\n\"my_metric\",\"/a,b\",\"1\",\"" + ts(1600000000000) + "\"\n",
+		},
+	}
+	for i, c := range cases {
+		var out bytes.Buffer
+		err := writeCSV(&out, c.Result, c.Opts)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, out.String(), "Unexpected output for case %d", i)
+	}
+	err := writeCSV(io.Discard, &RangeResult{Matrix: m}, Options{CSVQuote: "sometimes"})
+	assert.ErrorContains(t, err, "unknown csv quote mode")
+}
+
+// benchmarkMatrix returns a range query result of series with samples each
+func benchmarkMatrix(series, samples int) model.Matrix {
+	m := make(model.Matrix, 0, series)
+	for i := 0; i < series; i++ {
+		values := make([]model.SamplePair, samples)
+		for j := range values {
+			values[j] = model.SamplePair{Timestamp: model.Time(1600000000000 + int64(j)*60000), Value: model.SampleValue(j)}
+		}
+		m = append(m, &model.SampleStream{Metric: model.Metric{"__name__": "my_metric", "instance": model.LabelValue(fmt.Sprintf("web-%d:9090", i)), "job": "web"}, Values: values})
+	}
+	return m
+}
+
+// BenchmarkCsv compares building csv output in memory with streaming it, for results of increasing size.
+// The peak-heap-B/op metric is the most heap in use while writing, which grows with the result for buffered output and stays flat when streaming
+func BenchmarkCsv(b *testing.B) {
+	for _, samples := range []int{100, 1000, 10000} {
+		r := &RangeResult{Matrix: benchmarkMatrix(100, samples)}
+		runtime.GC()
+		var base runtime.MemStats
+		runtime.ReadMemStats(&base)
+		b.Run(fmt.Sprintf("buffered/%d", 100*samples), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var buf bytes.Buffer
+				if err := r.Csv(&buf, false); err != nil {
+					b.Fatal(err)
+				}
+				peak := &heapSampler{}
+				peak.sample()
+				_, _ = io.Copy(io.Discard, &buf)
+				b.ReportMetric(float64(int64(peak.max)-int64(base.HeapAlloc)), "peak-heap-B/op")
+			}
+		})
+		b.Run(fmt.Sprintf("streamed/%d", 100*samples), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				peak := &heapSampler{}
+				if err := r.WriteCsv(peak, Options{}); err != nil {
+					b.Fatal(err)
+				}
+				b.ReportMetric(float64(int64(peak.max)-int64(base.HeapAlloc)), "peak-heap-B/op")
+			}
+		})
+	}
+}
+
+// benchmarkVector returns an instant query result of series samples
+func benchmarkVector(series int) model.Vector {
+	v := make(model.Vector, 0, series)
+	for i, s := range benchmarkMatrix(series, 1) {
+		v = append(v, &model.Sample{Metric: s.Metric, Value: model.SampleValue(i), Timestamp: s.Values[0].Timestamp})
+	}
+	return v
+}
+
+// BenchmarkWriters measures the allocations of writing large results in each format
+// Compare runs with benchstat, e.g. go test -run '^$' -bench Writers -benchmem -count 10 ./pkg/writer
+func BenchmarkWriters(b *testing.B) {
+	r := &RangeResult{Matrix: benchmarkMatrix(100, 10000)}
+	v := &InstantResult{Vector: benchmarkVector(100000)}
+	benchmarks := []struct {
+		name  string
+		write func(w io.Writer) error
+	}{
+		{name: "range/csv", write: func(w io.Writer) error { return r.WriteCsv(w, Options{}) }},
+		{name: "range/csv-quoted", write: func(w io.Writer) error { return r.WriteCsv(w, Options{CSVQuote: CSVQuoteAlways}) }},
+		{name: "range/json", write: r.Json},
+		{name: "instant/csv", write: func(w io.Writer) error { return v.WriteCsv(w, Options{}) }},
+		{name: "instant/table", write: func(w io.Writer) error { return v.Table(w, false, TableOptions{}) }},
+		{name: "instant/json", write: v.Json},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := bm.write(io.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// heapSampler discards what's written to it, recording the most heap in use every so many writes
+type heapSampler struct {
+	writes int
+	max    uint64
+}
+
+func (h *heapSampler) sample() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if stats.HeapAlloc > h.max {
+		h.max = stats.HeapAlloc
+	}
+}
+
+func (h *heapSampler) Write(p []byte) (int, error) {
+	if h.writes%100 == 0 {
+		h.sample()
+	}
+	h.writes++
+	return len(p), nil
+}
+
+func TestFormatValue(t *testing.T) {
+	defer func(e int) { ScientificExponent = e }(ScientificExponent)
+	cases := []struct {
+		Exponent int
+		Value    float64
+		Expected string
+	}{
+		{Exponent: DefaultScientificExponent, Value: 12345678, Expected: "12345678"},
+		{Exponent: DefaultScientificExponent, Value: 0.30000000000000004, Expected: "0.30000000000000004"},
+		{Exponent: DefaultScientificExponent, Value: -1.5e20, Expected: "-150000000000000000000"},
+		{Exponent: DefaultScientificExponent, Value: 1.5e21, Expected: "1.5e+21"},
+		{Exponent: DefaultScientificExponent, Value: 1e-22, Expected: "1e-22"},
+		{Exponent: DefaultScientificExponent, Value: 0, Expected: "0"},
+		{Exponent: 6, Value: 1234567, Expected: "1.234567e+06"},
+		{Exponent: 6, Value: 0.0000001, Expected: "1e-07"},
+		{Exponent: DefaultScientificExponent, Value: math.NaN(), Expected: "NaN"},
+		{Exponent: DefaultScientificExponent, Value: math.Inf(1), Expected: "+Inf"},
+		{Exponent: DefaultScientificExponent, Value: math.Inf(-1), Expected: "-Inf"},
+	}
+	for i, c := range cases {
+		ScientificExponent = c.Exponent
+		assert.Equal(t, c.Expected, FormatValue(c.Value), "Unexpected output for case %d", i)
+	}
+
+	// Tables and csv use it, json keeps the raw value
+	ScientificExponent = DefaultScientificExponent
+	r := InstantResult{Vector: model.Vector{{Metric: model.Metric{"job": "a"}, Value: 12345678.9, Timestamp: 0}}}
+	var buf bytes.Buffer
+	assert.NoError(t, r.Csv(&buf, true))
+	assert.Equal(t, "a,12345678.9,"+time.Unix(0, 0).Format(time.RFC3339)+"\n", buf.String())
+	buf.Reset()
+	assert.NoError(t, r.Json(&buf))
+	assert.Contains(t, buf.String(), `"12345678.9"`)
+	s := ScalarResult{Scalar: &model.Scalar{Value: 2e22}}
+	buf.Reset()
+	assert.NoError(t, s.Value(&buf))
+	assert.Equal(t, "2e+22", buf.String())
+}
+
+func TestTimeBuckets(t *testing.T) {
+	defer func(loc *time.Location) { time.Local = loc }(time.Local)
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	// 2020-12-31T23:30:00Z is in 2021 in UTC+2, in the last ISO week of 2020
+	ts := model.TimeFromUnix(1609457400)
+	m := model.Matrix{{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{{Timestamp: ts, Value: 1}}}}
+	v := model.Vector{{Metric: model.Metric{"job": "a"}, Value: 1, Timestamp: ts}}
+	cases := []struct {
+		Buckets  string
+		Expected string
+	}{
+		{Expected: "job,value,timestamp\na,1,2021-01-01T01:30:00+02:00\n"},
+		{Buckets: TimeBucketsHour, Expected: "job,value,timestamp,date,hour\na,1,2021-01-01T01:30:00+02:00,2021-01-01,1\n"},
+		{Buckets: TimeBucketsDay, Expected: "job,value,timestamp,date\na,1,2021-01-01T01:30:00+02:00,2021-01-01\n"},
+		{Buckets: TimeBucketsWeek, Expected: "job,value,timestamp,week\na,1,2021-01-01T01:30:00+02:00,2020-W53\n"},
+	}
+	for i, c := range cases {
+		for _, write := range []func(w io.Writer) error{
+			func(w io.Writer) error {
+				return WriteRange(w, &RangeResult{Matrix: m}, "csv", Options{TimeBuckets: c.Buckets})
+			},
+			func(w io.Writer) error {
+				return WriteInstant(w, &InstantResult{Vector: v}, "csv", Options{TimeBuckets: c.Buckets})
+			},
+		} {
+			var buf bytes.Buffer
+			err := write(&buf)
+			assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+			assert.Equal(t, c.Expected, buf.String(), "Unexpected output for case %d", i)
+		}
+	}
+	err := WriteRange(io.Discard, &RangeResult{Matrix: m}, "csv", Options{TimeBuckets: "month"})
+	assert.Error(t, err)
+}
+
+func TestCsvPivot(t *testing.T) {
+	defer func(loc *time.Location) { time.Local = loc }(time.Local)
+	time.Local = time.UTC
+	pair := func(ts int64, v float64) model.SamplePair {
+		return model.SamplePair{Timestamp: model.TimeFromUnix(ts), Value: model.SampleValue(v)}
+	}
+	m := model.Matrix{
+		{Metric: model.Metric{"__name__": "up", "job": "a"}, Values: []model.SamplePair{pair(1600000000, 1), pair(1600000060, 2), pair(1600000060, 3)}},
+		{Metric: model.Metric{"job": "b"}, Values: []model.SamplePair{pair(1600000060, 0.5), pair(1600000120, 4)}},
+	}
+	cases := []struct {
+		Result   model.Matrix
+		Options  Options
+		Expected string
+	}{
+		{
+			Result:   m,
+			Expected: "timestamp,\"up{job=\"\"a\"\"}\",\"{job=\"\"b\"\"}\"\n2020-09-13T12:26:40Z,1,\n2020-09-13T12:27:40Z,2,0.5\n2020-09-13T12:28:40Z,,4\n",
+		},
+		{
+			Result:   m,
+			Options:  Options{NoHeaders: true, TimeBuckets: TimeBucketsDay},
+			Expected: "2020-09-13T12:26:40Z,2020-09-13,1,\n2020-09-13T12:27:40Z,2020-09-13,2,0.5\n2020-09-13T12:28:40Z,2020-09-13,,4\n",
+		},
+		{Result: model.Matrix{}, Expected: "timestamp\n"},
 	}
 	for i, c := range cases {
-		buf, err := c.Result.Table(false)
+		c.Options.CSVPivot = true
+		var buf bytes.Buffer
+		err := WriteRange(&buf, &RangeResult{Matrix: c.Result}, "csv", c.Options)
 		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
 		assert.Equal(t, c.Expected, buf.String(), "Unexpected output for case %d", i)
 	}
 }
+
+func TestJsonFlat(t *testing.T) {
+	m := model.Matrix{
+		{Metric: model.Metric{"__name__": "up", "job": "a"}, Values: []model.SamplePair{{Timestamp: 1600000000000, Value: 1}, {Timestamp: 1600000060500, Value: 0.5}}},
+		{Metric: model.Metric{"job": "b", "__value__": "x", "label___value__": "y"}, Values: []model.SamplePair{{Timestamp: 1600000000000, Value: model.SampleValue(math.NaN())}}},
+	}
+	v := model.Vector{
+		{Metric: model.Metric{"__name__": "up", "job": "a\"b"}, Value: 2, Timestamp: 1600000000000},
+		{Metric: model.Metric{"__timestamp__": "x"}, Value: model.SampleValue(math.Inf(1)), Timestamp: 1600000000000},
+	}
+	cases := []struct {
+		Result   Writer
+		Expected string
+	}{
+		{
+			Result:   &RangeResult{Matrix: m},
+			Expected: `[{"__name__":"up","job":"a","__value__":1,"__timestamp__":1600000000},{"__name__":"up","job":"a","__value__":0.5,"__timestamp__":1600000060.5},{"label_label___value__":"x","job":"b","label___value__":"y","__value__":"NaN","__timestamp__":1600000000}]` + "\n",
+		},
+		{
+			Result:   &InstantResult{Vector: v},
+			Expected: `[{"__name__":"up","job":"a\"b","__value__":2,"__timestamp__":1600000000},{"label___timestamp__":"x","__value__":"+Inf","__timestamp__":1600000000}]` + "\n",
+		},
+		{Result: &RangeResult{}, Expected: "[]\n"},
+		{Result: &InstantResult{Vector: model.Vector{}}, Expected: "[]\n"},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		var err error
+		switch r := c.Result.(type) {
+		case *RangeResult:
+			err = WriteRange(&buf, r, "json", Options{JSONFlat: true})
+		case *InstantResult:
+			err = WriteInstant(&buf, r, "json", Options{JSONFlat: true})
+		}
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, buf.String(), "Unexpected output for case %d", i)
+	}
+}
+
+func TestTimePrecision(t *testing.T) {
+	defer func(loc *time.Location) { time.Local = loc }(time.Local)
+	time.Local = time.UTC
+	defer SetTimePrecision(TimePrecisionSeconds)
+	m := model.Matrix{{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{{Timestamp: 1600000000000, Value: 1}, {Timestamp: 1600000000500, Value: 2}}}}
+	v := model.Vector{{Metric: model.Metric{"job": "a"}, Value: 1, Timestamp: 1600000000500}}
+	cases := []struct {
+		Precision string
+		Csv       string
+		Table     string
+		Caption   string
+	}{
+		{
+			Precision: TimePrecisionSeconds,
+			Csv:       "job,value,timestamp\na,1,2020-09-13T12:26:40Z\na,2,2020-09-13T12:26:40Z\n",
+			Table:     "JOB    VALUE    TIMESTAMP\na      1        2020-09-13T12:26:40Z\n",
+			Caption:   "Sep 13 12:26:40",
+		},
+		{
+			Precision: TimePrecisionMillis,
+			Csv:       "job,value,timestamp\na,1,2020-09-13T12:26:40.000Z\na,2,2020-09-13T12:26:40.500Z\n",
+			Table:     "JOB    VALUE    TIMESTAMP\na      1        2020-09-13T12:26:40.500Z\n",
+			Caption:   "Sep 13 12:26:40.500",
+		},
+		{
+			Precision: TimePrecisionNanos,
+			Csv:       "job,value,timestamp\na,1,2020-09-13T12:26:40.000000000Z\na,2,2020-09-13T12:26:40.500000000Z\n",
+			Table:     "JOB    VALUE    TIMESTAMP\na      1        2020-09-13T12:26:40.500000000Z\n",
+			Caption:   "Sep 13 12:26:40.500000000",
+		},
+	}
+	for i, c := range cases {
+		err := SetTimePrecision(c.Precision)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		var csv, table bytes.Buffer
+		err = WriteRange(&csv, &RangeResult{Matrix: m}, "csv", Options{})
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Csv, csv.String(), "Unexpected output for case %d", i)
+		err = WriteInstant(&table, &InstantResult{Vector: v}, "table", Options{})
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Table, table.String(), "Unexpected output for case %d", i)
+		assert.Equal(t, c.Caption, formatCaption(v[0].Timestamp.Time()), "Unexpected output for case %d", i)
+	}
+	assert.Error(t, SetTimePrecision("us"))
+}
+
+func TestCount(t *testing.T) {
+	cases := []struct {
+		Result   model.Value
+		Expected int
+		Err      bool
+	}{
+		{Result: model.Vector{{Metric: model.Metric{"job": "a"}}, {Metric: model.Metric{"job": "b"}}}, Expected: 2},
+		{Result: model.Matrix{{Metric: model.Metric{"job": "a"}}}, Expected: 1},
+		{Result: model.Vector{}, Expected: 0},
+		{Result: &model.String{Value: "a"}, Err: true},
+	}
+	for i, c := range cases {
+		r, err := Count(c.Result)
+		if c.Err {
+			assert.Error(t, err, "Expected err for case %d", i)
+			continue
+		}
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, r.Count, "Unexpected output for case %d", i)
+	}
+}
+
+func TestDistinct(t *testing.T) {
+	cases := []struct {
+		Result   model.Value
+		Expected DistinctResult
+		Err      bool
+	}{
+		{
+			Result: model.Vector{
+				{Metric: model.Metric{"job": "web", "instance": "b"}},
+				{Metric: model.Metric{"job": "api", "instance": "a"}},
+				{Metric: model.Metric{"job": "web", "instance": "a"}},
+				{Metric: model.Metric{"instance": "c"}},
+				{Metric: model.Metric{"job": ""}},
+			},
+			Expected: DistinctResult{"", "api", "web"},
+		},
+		{Result: model.Matrix{{Metric: model.Metric{"job": "b"}}, {Metric: model.Metric{"job": "a"}}}, Expected: DistinctResult{"a", "b"}},
+		{Result: model.Vector{{Metric: model.Metric{"instance": "a"}}}, Expected: DistinctResult{}},
+		{Result: &model.Scalar{Value: 1}, Err: true},
+	}
+	for i, c := range cases {
+		r, err := Distinct(c.Result, "job")
+		if c.Err {
+			assert.Error(t, err, "Expected err for case %d", i)
+			continue
+		}
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, r, "Unexpected output for case %d", i)
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, WriteDistinct(&buf, DistinctResult{"api", "web"}, "table"))
+	assert.Equal(t, "api\nweb\n", buf.String())
+	buf.Reset()
+	assert.NoError(t, WriteDistinct(&buf, DistinctResult{}, "json"))
+	assert.Equal(t, "[]\n", buf.String())
+}
+
+func TestWrite(t *testing.T) {
+	ts := model.TimeFromUnix(1600000000)
+	vector := model.Vector{{Metric: model.Metric{"job": "web"}, Value: 1, Timestamp: ts}}
+	matrix := model.Matrix{{Metric: model.Metric{"job": "web"}, Values: []model.SamplePair{{Timestamp: ts, Value: 1}}}}
+	scalar := &model.Scalar{Value: 2, Timestamp: ts}
+	str := &model.String{Value: "a", Timestamp: ts}
+	cases := []struct {
+		Result   model.Value
+		Expected func(w io.Writer, format string) error
+	}{
+		{Result: vector, Expected: func(w io.Writer, format string) error {
+			return WriteInstant(w, &InstantResult{Vector: vector}, format, Options{})
+		}},
+		{Result: matrix, Expected: func(w io.Writer, format string) error {
+			return WriteRange(w, &RangeResult{Matrix: matrix}, format, Options{})
+		}},
+		{Result: scalar, Expected: func(w io.Writer, format string) error {
+			return WriteInstant(w, &ScalarResult{Scalar: scalar}, format, Options{})
+		}},
+		{Result: str, Expected: func(w io.Writer, format string) error {
+			return WriteInstant(w, &StringResult{String: str}, format, Options{})
+		}},
+	}
+	for i, c := range cases {
+		for _, format := range []string{"json", "csv"} {
+			var expected, out bytes.Buffer
+			assert.NoError(t, c.Expected(&expected, format))
+			err := Write(c.Result, format, Options{}, &out)
+			assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+			assert.Equal(t, expected.String(), out.String(), "Unexpected output for case %d %s", i, format)
+		}
+	}
+	assert.Error(t, Write(nil, "json", Options{}, io.Discard))
+}
+
+func TestTableColors(t *testing.T) {
+	now := model.Now()
+	ts := now.Time().Format(time.RFC3339)
+	result := &InstantResult{
+		model.Vector{
+			{Metric: model.Metric{"instance": "a"}, Value: 50, Timestamp: now},
+			{Metric: model.Metric{"instance": "b"}, Value: 85, Timestamp: now},
+			{Metric: model.Metric{"instance": "c"}, Value: 99.5, Timestamp: now},
+		},
+	}
+	colors, err := ParseColorThresholds("warn:>80,crit:>95")
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	err = result.Table(&buf, false, TableOptions{Colors: colors})
+	assert.NoError(t, err)
+	// The value header and cells carry escape codes of the same length, so the columns stay aligned
+	expected := "INSTANCE    \x1b[39mVALUE\x1b[0m    TIMESTAMP\n" +
+		fmt.Sprintf("a           \x1b[32m50\x1b[0m       %s\n", ts) +
+		fmt.Sprintf("b           \x1b[33m85\x1b[0m       %s\n", ts) +
+		fmt.Sprintf("c           \x1b[31m99.5\x1b[0m     %s\n", ts)
+	assert.Equal(t, expected, buf.String())
+
+	for _, s := range []string{"warn>80", "info:>1", "crit:high"} {
+		_, err := ParseColorThresholds(s)
+		assert.Error(t, err, "Expected err for %q", s)
+	}
+}
+
+func TestTableDeltas(t *testing.T) {
+	now := model.Now()
+	ts := now.Time().Format(time.RFC3339)
+	vector := func(a, b, c model.SampleValue) *InstantResult {
+		return &InstantResult{
+			model.Vector{
+				{Metric: model.Metric{"instance": "a"}, Value: a, Timestamp: now},
+				{Metric: model.Metric{"instance": "b"}, Value: b, Timestamp: now},
+				{Metric: model.Metric{"instance": "c"}, Value: c, Timestamp: now},
+			},
+		}
+	}
+	// Nothing is marked in the first table
+	deltas := &Deltas{}
+	var buf bytes.Buffer
+	err := vector(1, 2, 3).Table(&buf, true, TableOptions{Deltas: deltas})
+	assert.NoError(t, err)
+	expected := fmt.Sprintf("a    1      %s\n", ts) +
+		fmt.Sprintf("b    2      %s\n", ts) +
+		fmt.Sprintf("c    3      %s\n", ts)
+	assert.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	err = vector(2, 2, 1).Table(&buf, true, TableOptions{Deltas: deltas})
+	assert.NoError(t, err)
+	expected = fmt.Sprintf("a    2 ↑    %s\n", ts) +
+		fmt.Sprintf("b    2      %s\n", ts) +
+		fmt.Sprintf("c    1 ↓    %s\n", ts)
+	assert.Equal(t, expected, buf.String())
+
+	// Changes are colored instead of the color thresholds, unchanged values keep their threshold color
+	colors, err := ParseColorThresholds("crit:>95")
+	assert.NoError(t, err)
+	deltas.Color = true
+	buf.Reset()
+	err = vector(2, 99, 1).Table(&buf, false, TableOptions{Deltas: deltas, Colors: colors})
+	assert.NoError(t, err)
+	expected = "INSTANCE    \x1b[39mVALUE\x1b[0m    TIMESTAMP\n" +
+		fmt.Sprintf("a           \x1b[32m2  \x1b[0m      %s\n", ts) +
+		fmt.Sprintf("b           \x1b[32m99 ↑\x1b[0m     %s\n", ts) +
+		fmt.Sprintf("c           \x1b[32m1  \x1b[0m      %s\n", ts)
+	assert.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	err = vector(2, 98, 1).Table(&buf, true, TableOptions{Deltas: deltas})
+	assert.NoError(t, err)
+	expected = fmt.Sprintf("a    \x1b[39m2  \x1b[0m     %s\n", ts) +
+		fmt.Sprintf("b    \x1b[31m98 ↓\x1b[0m    %s\n", ts) +
+		fmt.Sprintf("c    \x1b[39m1  \x1b[0m     %s\n", ts)
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestMetadata(t *testing.T) {
+	now := model.Now()
+	ts := now.Time().Format(time.RFC3339)
+	result := &MetadataResult{
+		InstantResult: InstantResult{
+			model.Vector{
+				{Metric: model.Metric{"__name__": "up"}, Value: 1, Timestamp: now},
+				{Metric: model.Metric{"__name__": "http_duration_seconds_bucket"}, Value: 2, Timestamp: now},
+				{Metric: model.Metric{"__name__": "unknown"}, Value: 3, Timestamp: now},
+			},
+		},
+		Metadata: MetaResult{
+			"up":                    {{Type: "gauge", Help: "Target up"}},
+			"http_duration_seconds": {{Type: "histogram", Help: "Request latency", Unit: "seconds"}},
+		},
+	}
+	var buf bytes.Buffer
+	err := result.Table(&buf, false, TableOptions{})
+	assert.NoError(t, err)
+	expected := fmt.Sprintf("__NAME__                        VALUE    %-*sTYPE         HELP\n", len(ts)+4, "TIMESTAMP") +
+		fmt.Sprintf("up                              1        %s    gauge        Target up\n", ts) +
+		fmt.Sprintf("http_duration_seconds_bucket    2        %s    histogram    Request latency\n", ts) +
+		fmt.Sprintf("unknown                         3        %s                 \n", ts)
+	assert.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	err = result.Json(&buf)
+	assert.NoError(t, err)
+	var samples []MetadataSample
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &samples))
+	assert.Len(t, samples, 3)
+	assert.Equal(t, "seconds", samples[1].Unit)
+	assert.Equal(t, model.SampleValue(2), samples[1].Value.Value)
+}
+
+func TestRows(t *testing.T) {
+	now := model.Now()
+	vector := model.Vector{
+		{Metric: model.Metric{"__name__": "up", "job": "prometheus"}, Value: 1, Timestamp: now},
+		{Metric: model.Metric{"__name__": "up", "job": "node"}, Value: 0.5, Timestamp: now},
+	}
+	expected := []Row{
+		{Labels: map[string]string{"__name__": "up", "job": "prometheus"}, Value: 1, Timestamp: now.Time()},
+		{Labels: map[string]string{"__name__": "up", "job": "node"}, Value: 0.5, Timestamp: now.Time()},
+	}
+	assert.Equal(t, expected, InstantRows(vector))
+
+	matrix := model.Matrix{
+		{
+			Metric: model.Metric{"job": "prometheus"},
+			Values: []model.SamplePair{{Timestamp: now.Add(-time.Minute), Value: 1}, {Timestamp: now, Value: 2}},
+		},
+	}
+	expected = []Row{
+		{Labels: map[string]string{"job": "prometheus"}, Value: 1, Timestamp: now.Add(-time.Minute).Time()},
+		{Labels: map[string]string{"job": "prometheus"}, Value: 2, Timestamp: now.Time()},
+	}
+	assert.Equal(t, expected, RangeRows(matrix))
+	assert.Empty(t, InstantRows(model.Vector{}))
+}
+
+func TestTableMissingLabels(t *testing.T) {
+	now := model.Now()
+	ts := now.Time().Format(time.RFC3339)
+	// Each series has labels the others don't, every label still gets a column and missing labels get empty cells
+	result := &InstantResult{
+		model.Vector{
+			{Metric: model.Metric{"job": "node", "zone": "a"}, Value: 1, Timestamp: now},
+			{Metric: model.Metric{"instance": "host:9090", "job": "prometheus"}, Value: 2, Timestamp: now},
+			{Metric: model.Metric{"zone": "b"}, Value: 3, Timestamp: now},
+		},
+	}
+	var buf bytes.Buffer
+	err := result.Table(&buf, false, TableOptions{})
+	assert.NoError(t, err)
+	expected := "INSTANCE     JOB           ZONE    VALUE    TIMESTAMP\n" +
+		fmt.Sprintf("             node          a       1        %s\n", ts) +
+		fmt.Sprintf("host:9090    prometheus            2        %s\n", ts) +
+		fmt.Sprintf("                           b       3        %s\n", ts)
+	assert.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	err = result.Csv(&buf, false)
+	assert.NoError(t, err)
+	expected = "instance,job,zone,value,timestamp\n" +
+		fmt.Sprintf(",node,a,1,%s\n", ts) +
+		fmt.Sprintf("host:9090,prometheus,,2,%s\n", ts) +
+		fmt.Sprintf(",,b,3,%s\n", ts)
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestCsvHeterogeneousLabels(t *testing.T) {
+	now := model.Now()
+	ts := now.Time().Format(time.RFC3339)
+	// Series with extra labels, different labels, and none at all, in an order that doesn't match the sorted columns
+	metrics := []model.Metric{
+		{"zone": "b", "job": "node", "instance": "host:9100", "__name__": "up"},
+		{},
+		{"job": "prometheus", "__name__": "up"},
+		{"Region": "eu"},
+	}
+	vector := model.Vector{}
+	matrix := model.Matrix{}
+	for i, m := range metrics {
+		vector = append(vector, &model.Sample{Metric: m, Value: model.SampleValue(i), Timestamp: now})
+		matrix = append(matrix, &model.SampleStream{Metric: m, Values: []model.SamplePair{{Timestamp: now, Value: model.SampleValue(i)}}})
+	}
+	rows := fmt.Sprintf(",up,host:9100,node,b,0,%s\n", ts) +
+		fmt.Sprintf(",,,,,1,%s\n", ts) +
+		fmt.Sprintf(",up,,prometheus,,2,%s\n", ts) +
+		fmt.Sprintf("eu,,,,,3,%s\n", ts)
+	cases := []struct {
+		Result   Writer
+		Expected string
+	}{
+		{
+			Result:   &InstantResult{vector},
+			Expected: "Region,__name__,instance,job,zone,value,timestamp\n" + rows,
+		},
+		{
+			Result:   &RangeResult{matrix},
+			Expected: "Region,__name__,instance,job,zone,value,timestamp\n" + rows,
+		},
+		{
+			Result: &MetadataResult{InstantResult: InstantResult{vector}, Metadata: MetaResult{"up": {{Type: "gauge", Help: "Target up"}}}},
+			Expected: "Region,__name__,instance,job,zone,value,timestamp,type,help\n" +
+				fmt.Sprintf(",up,host:9100,node,b,0,%s,gauge,Target up\n", ts) +
+				fmt.Sprintf(",,,,,1,%s,,\n", ts) +
+				fmt.Sprintf(",up,,prometheus,,2,%s,gauge,Target up\n", ts) +
+				fmt.Sprintf("eu,,,,,3,%s,,\n", ts),
+		},
+		{
+			Result: &DiffResult{
+				{Metric: metrics[0], Status: "only_a"},
+				{Metric: metrics[1], Status: "only_a"},
+				{Metric: metrics[3], Status: "only_b"},
+			},
+			Expected: "Region,__name__,instance,job,zone,value_a,value_b,diff,diff_pct,status\n" +
+				",up,host:9100,node,b,,,,,only_a\n" +
+				",,,,,,,,,only_a\n" +
+				"eu,,,,,,,,,only_b\n",
+		},
+	}
+	for i, c := range cases {
+		// The columns are the same on every write, whatever order the labels of each series are iterated in
+		for j := 0; j < 10; j++ {
+			var buf bytes.Buffer
+			err := c.Result.Csv(&buf, false)
+			assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+			assert.Equal(t, c.Expected, buf.String(), "Unexpected output for case %d", i)
+		}
+	}
+
+	// Reordering the series reorders the rows but not the columns
+	reversed := make(model.Vector, len(vector))
+	for i, s := range vector {
+		reversed[len(vector)-1-i] = s
+	}
+	labels, err := util.UniqLabels(reversed)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.LabelName{"Region", "__name__", "instance", "job", "zone"}, labels)
+	var buf bytes.Buffer
+	err = (&InstantResult{reversed}).Table(&buf, false, TableOptions{})
+	assert.NoError(t, err)
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, []string{"REGION", "__NAME__", "INSTANCE", "JOB", "ZONE", "VALUE", "TIMESTAMP"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"eu", "3", ts}, strings.Fields(lines[1]))
+}
+
+func TestLabelsPage(t *testing.T) {
+	result := model.Vector{
+		{Metric: model.Metric{"__name__": "up", "instance": "localhost:9090", "job": "prometheus", "zone": "a"}},
+	}
+	cases := []struct {
+		page     util.Page
+		expected string
+		footer   string
+	}{
+		{page: util.Page{}, expected: "LABELS\n__name__\ninstance\njob\nzone\n", footer: "showing 1-4 of 4"},
+		{page: util.Page{Limit: 2}, expected: "LABELS\n__name__\ninstance\n", footer: "showing 1-2 of 4"},
+		{page: util.Page{Offset: 1, Limit: 2}, expected: "LABELS\ninstance\njob\n", footer: "showing 2-3 of 4"},
+		{page: util.Page{Offset: 3, Limit: 2}, expected: "LABELS\nzone\n", footer: "showing 4-4 of 4"},
+		{page: util.Page{Offset: 10}, expected: "LABELS\n", footer: "showing 0 of 4"},
+	}
+	for i, c := range cases {
+		r := LabelsResult{Vector: result, Page: c.page}
+		var buf bytes.Buffer
+		err := r.Table(&buf, false, TableOptions{})
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.expected, buf.String(), "Unexpected output for case %d", i)
+		assert.Equal(t, c.footer, c.page.Footer(4), "Unexpected footer for case %d", i)
+	}
+}
+
+func TestGraphNormalize(t *testing.T) {
+	now := model.Now()
+	values := []model.SamplePair{
+		{Timestamp: now.Add(-2 * time.Minute), Value: 1000},
+		{Timestamp: now.Add(-time.Minute), Value: 3000},
+		{Timestamp: now, Value: 5000},
+	}
+	normalized, min, max := normalize(values)
+	assert.Equal(t, model.SampleValue(1000), min)
+	assert.Equal(t, model.SampleValue(5000), max)
+	assert.Equal(t, []model.SampleValue{0, 0.5, 1}, []model.SampleValue{normalized[0].Value, normalized[1].Value, normalized[2].Value})
+	assert.Equal(t, values[1].Timestamp, normalized[1].Timestamp)
+
+	// A constant series is flat at 0
+	flat, _, _ := normalize([]model.SamplePair{{Timestamp: now, Value: 7}, {Timestamp: now, Value: 7}})
+	assert.Equal(t, model.SampleValue(0), flat[1].Value)
+
+	r := RangeResult{model.Matrix{{Metric: model.Metric{"__name__": "my_metric"}, Values: values}}}
+	var buf bytes.Buffer
+	err := r.Graph(&buf, util.TermDimensions{Height: 25, Width: 80}, GraphOptions{Normalize: true})
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "# VALUE_RANGE: 1000 -> 5000 (normalized to 0..1) #\n")
+	assert.Contains(t, buf.String(), " 1.00 ┤")
+	assert.Contains(t, buf.String(), " 0.00 ┼")
+}
+
+func TestGraphSeparator(t *testing.T) {
+	now := model.Now()
+	values := []model.SamplePair{{Timestamp: now.Add(-time.Minute), Value: 1}, {Timestamp: now, Value: 2}}
+	r := RangeResult{model.Matrix{
+		{Metric: model.Metric{"__name__": "a"}, Values: values},
+		{Metric: model.Metric{"__name__": "b"}, Values: values},
+		{Metric: model.Metric{"__name__": "c"}, Values: values},
+	}}
+	cases := []struct {
+		Opts     GraphOptions
+		Expected string
+		Count    int
+	}{
+		{Opts: GraphOptions{}, Expected: strings.Repeat("─", 40) + "\n", Count: 0},
+		{Opts: GraphOptions{Separator: true}, Expected: strings.Repeat("─", 40) + "\n", Count: 2},
+		{Opts: GraphOptions{Separator: true, Style: GraphStyleASCII}, Expected: strings.Repeat("-", 40) + "\n", Count: 2},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		err := r.Graph(&buf, util.TermDimensions{Height: 20, Width: 40}, c.Opts)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		// Separators go between graphs, right before the header of every series but the first
+		assert.Equal(t, c.Count, strings.Count(buf.String(), c.Expected+"\n#"), "Unexpected output for case %d", i)
+		assert.False(t, strings.HasSuffix(buf.String(), c.Expected), "Unexpected output for case %d", i)
+	}
+}
+
+func TestGraphWithoutFloatSamples(t *testing.T) {
+	now := model.Now()
+	values := []model.SamplePair{{Timestamp: now.Add(-time.Minute), Value: 1}, {Timestamp: now, Value: 2}}
+	histograms := []model.SampleHistogramPair{{Timestamp: now, Histogram: &model.SampleHistogram{Count: 1, Sum: 1}}}
+	cases := []struct {
+		Matrix   model.Matrix
+		Graphs   int
+		Expected string
+	}{
+		// Series without float samples are skipped
+		{
+			Matrix: model.Matrix{
+				{Metric: model.Metric{"__name__": "a"}, Values: values},
+				{Metric: model.Metric{"__name__": "b"}, Histograms: histograms},
+				{Metric: model.Metric{"__name__": "c"}},
+			},
+			Graphs: 1,
+		},
+		// Unless none of them can be graphed
+		{
+			Matrix: model.Matrix{
+				{Metric: model.Metric{"__name__": "b"}, Histograms: histograms},
+				{Metric: model.Metric{"__name__": "c"}},
+			},
+			Expected: "unable to graph series without float samples: b, c",
+		},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		r := RangeResult{c.Matrix}
+		err := r.Graph(&buf, util.TermDimensions{Height: 20, Width: 40}, GraphOptions{Separator: true})
+		if c.Expected != "" {
+			assert.EqualError(t, err, c.Expected, "Unexpected err for case %d", i)
+			continue
+		}
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Graphs, strings.Count(buf.String(), "# METRIC: "), "Unexpected output for case %d", i)
+		assert.NotContains(t, buf.String(), strings.Repeat("─", 40), "Unexpected output for case %d", i)
+	}
+}
+
+func TestWriteSeriesFiles(t *testing.T) {
+	m := model.Matrix{
+		{
+			Metric: model.Metric{"__name__": "up", "job": "node", "instance": "web-1:9090"},
+			Values: []model.SamplePair{{Timestamp: 1600000000000, Value: 1}, {Timestamp: 1600000060000, Value: 0}},
+		},
+		{
+			// Sanitizes to the same name as the first series
+			Metric: model.Metric{"__name__": "up", "job": "node", "instance": "web-1/9090"},
+			Values: []model.SamplePair{{Timestamp: 1600000000000, Value: 1}},
+		},
+		{
+			Metric: model.Metric{"__name__": "UP", "job": "NODE", "instance": "WEB-1:9090"},
+			Values: []model.SamplePair{{Timestamp: 1600000000000, Value: 1}},
+		},
+		{
+			Metric: model.Metric{"path": "../etc"},
+			Values: []model.SamplePair{{Timestamp: 1600000000000, Value: 2}},
+		},
+		{
+			Metric: model.Metric{},
+			Values: []model.SamplePair{{Timestamp: 1600000000000, Value: 3}},
+		},
+	}
+	dir := t.TempDir()
+	paths, err := WriteSeriesFiles(m, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"up_instance=web-1_9090_job=node.csv",
+		"up_instance=web-1_9090_job=node-2.csv",
+		"UP_instance=WEB-1_9090_job=NODE-3.csv",
+		"path=.._etc.csv",
+		"series.csv",
+	}
+	for i, name := range expected {
+		if i >= len(paths) || paths[i] != filepath.Join(dir, name) {
+			t.Errorf("Unexpected output for case %d: %v", i, paths)
+			continue
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(dir, expected[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	csv := "__name__,instance,job,value,timestamp\n" +
+		"up,web-1:9090,node,1," + time.Unix(1600000000, 0).Format(time.RFC3339) + "\n" +
+		"up,web-1:9090,node,0," + time.Unix(1600000060, 0).Format(time.RFC3339) + "\n"
+	if string(b) != csv {
+		t.Errorf("Unexpected series file contents:\n%s", b)
+	}
+}
+
+func TestGraphTimeAxis(t *testing.T) {
+	at := func(minutes int) model.Time {
+		return model.TimeFromUnixNano(time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local).Add(time.Duration(minutes) * time.Minute).UnixNano())
+	}
+	var hour, days []model.SamplePair
+	for i := 0; i <= 60; i++ {
+		hour = append(hour, model.SamplePair{Timestamp: at(i), Value: model.SampleValue(i % 5)})
+		days = append(days, model.SamplePair{Timestamp: at(i * 60), Value: model.SampleValue(i % 5)})
+	}
+	// Most samples are in the first 10 minutes, which line graphs spread over most columns
+	sparse := append(append([]model.SamplePair{}, hour[:10]...), hour[60])
+	cases := []struct {
+		Style    string
+		Values   []model.SamplePair
+		Width    int
+		Expected string
+	}{
+		// Labels start under the first column of the graph and end under its last
+		{Values: hour, Width: 40, Expected: "       12:00     12:19        12:39      13:00"},
+		{Values: sparse, Width: 40, Expected: "       12:00     12:03        12:07      13:00"},
+		{Style: GraphStyleScatter, Values: sparse, Width: 40, Expected: "       12:00      12:20        12:40      13:00"},
+		// Dates are included for ranges longer than a day
+		{Values: days, Width: 40, Expected: "       Jan 01 12:00               Jan 04 00:00"},
+		// There's no room for labels on very narrow graphs
+		{Values: days, Width: 8, Expected: ""},
+	}
+	for i, c := range cases {
+		graph, err := plot(c.Values, 4, c.Width, GraphOptions{Style: c.Style})
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, c.Expected, timeAxis(graph, c.Values, c.Style), "Unexpected output for case %d", i)
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata with the current output")
+
+// TestGolden compares the output of each format byte for byte with the golden files in testdata.
+// Regenerate them with go test ./pkg/writer -run TestGolden -update after an intended output change
+func TestGolden(t *testing.T) {
+	defer func(f func() (util.TermDimensions, error), loc *time.Location) {
+		terminalSize, time.Local = f, loc
+	}(terminalSize, time.Local)
+	terminalSize = func() (util.TermDimensions, error) {
+		return util.TermDimensions{Height: 12, Width: 60}, nil
+	}
+	time.Local = time.UTC
+
+	m := model.Matrix{
+		{
+			Metric: model.Metric{"__name__": "up", "instance": "web-1:9090", "job": "web"},
+			Values: []model.SamplePair{{Timestamp: 1600000000000, Value: 1}, {Timestamp: 1600000060000, Value: 0}, {Timestamp: 1600000120000, Value: 1}},
+		},
+		{
+			Metric: model.Metric{"__name__": "up", "instance": "web-2:9090", "job": "web"},
+			Values: []model.SamplePair{{Timestamp: 1600000000000, Value: 1}, {Timestamp: 1600000060000, Value: 1}, {Timestamp: 1600000120000, Value: 1}},
+		},
+	}
+	v := model.Vector{
+		{Metric: m[0].Metric, Value: 1, Timestamp: 1600000120000},
+		{Metric: m[1].Metric, Value: 0.5, Timestamp: 1600000120000},
+	}
+	tmpl, err := NewTemplate(`{{range .}}{{.Labels.instance}} {{.Value}}{{"\n"}}{{end}}`)
+	assert.NoError(t, err)
+	metrics := MetricsResult{"node_load1", "up"}
+	cases := []struct {
+		Name  string
+		Write func(w io.Writer) error
+	}{
+		{Name: "range.json", Write: func(w io.Writer) error { return WriteRange(w, &RangeResult{Matrix: m}, "json", Options{}) }},
+		{Name: "range.csv", Write: func(w io.Writer) error { return WriteRange(w, &RangeResult{Matrix: m}, "csv", Options{}) }},
+		{Name: "range_quoted.csv", Write: func(w io.Writer) error {
+			return WriteRange(w, &RangeResult{Matrix: m}, "csv", Options{NoHeaders: true, CSVQuote: CSVQuoteAlways})
+		}},
+		{Name: "range.graph", Write: func(w io.Writer) error { return WriteRange(w, &RangeResult{Matrix: m}, "graph", Options{}) }},
+		{Name: "instant.json", Write: func(w io.Writer) error { return WriteInstant(w, &InstantResult{Vector: v}, "json", Options{}) }},
+		{Name: "instant.csv", Write: func(w io.Writer) error { return WriteInstant(w, &InstantResult{Vector: v}, "csv", Options{}) }},
+		{Name: "instant.table", Write: func(w io.Writer) error { return WriteInstant(w, &InstantResult{Vector: v}, "table", Options{}) }},
+		{Name: "instant.template", Write: func(w io.Writer) error {
+			return WriteInstant(w, &InstantResult{Vector: v}, "template", Options{Template: tmpl})
+		}},
+		{Name: "metrics.table", Write: func(w io.Writer) error { return WriteInstant(w, &metrics, "table", Options{}) }},
+		{Name: "metrics_quoted.csv", Write: func(w io.Writer) error { return WriteInstant(w, &metrics, "csv", Options{CSVQuote: CSVQuoteAlways}) }},
+		{Name: "value.txt", Write: func(w io.Writer) error { return WriteValue(w, &InstantResult{Vector: v[:1]}) }},
+		{Name: "count.json", Write: func(w io.Writer) error { return WriteCount(w, CountResult{Count: len(v)}, "json") }},
+		{Name: "count.txt", Write: func(w io.Writer) error { return WriteCount(w, CountResult{Count: len(v)}, "table") }},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		err := c.Write(&buf)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		path := filepath.Join("testdata", c.Name+".golden")
+		if *update {
+			assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+			continue
+		}
+		expected, err := os.ReadFile(path)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, string(expected), buf.String(), "Unexpected output for case %d (%s)", i, c.Name)
+	}
+}
+
+// jsonEdgeCases are series with values, timestamps and labels whose json encoding is easy to get wrong
+var jsonEdgeCases = model.Matrix{
+	{Metric: model.Metric{"path": `/<a href="x">&amp;</a>`, "name": "ünï\tcode"}, Values: []model.SamplePair{
+		{Timestamp: 1600000000123, Value: model.SampleValue(math.NaN())},
+		{Timestamp: -1500, Value: model.SampleValue(math.Inf(-1))},
+		{Timestamp: 7, Value: 1.5e300},
+		{Timestamp: 1600000000000, Value: 0.30000000000000004},
+	}},
+	{Metric: model.Metric{}},
+	{Metric: nil, Values: []model.SamplePair{}},
+	{Metric: model.Metric{"__name__": "histogram"}, Histograms: []model.SampleHistogramPair{
+		{Timestamp: 1600000000000, Histogram: &model.SampleHistogram{Count: 1, Sum: 2}},
+	}},
+}
+
+func TestRangeJsonStreamed(t *testing.T) {
+	for i, m := range []model.Matrix{nil, {}, benchmarkMatrix(3, 5), jsonEdgeCases} {
+		expected, err := json.Marshal(m)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		r := RangeResult{Matrix: m}
+		var buf bytes.Buffer
+		err = r.Json(&buf)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, string(expected)+"\n", buf.String(), "Unexpected output for case %d", i)
+	}
+}
+
+func TestInstantJsonStreamed(t *testing.T) {
+	edgeCases := model.Vector{{Metric: model.Metric{"__name__": "histogram"}, Histogram: &model.SampleHistogram{Count: 1, Sum: 2}}}
+	for _, s := range jsonEdgeCases {
+		for _, v := range s.Values {
+			edgeCases = append(edgeCases, &model.Sample{Metric: s.Metric, Value: v.Value, Timestamp: v.Timestamp})
+		}
+	}
+	for i, v := range []model.Vector{nil, {}, benchmarkVector(5), edgeCases} {
+		expected, err := json.Marshal(v)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		r := InstantResult{Vector: v}
+		var buf bytes.Buffer
+		err = r.Json(&buf)
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.Equal(t, string(expected)+"\n", buf.String(), "Unexpected output for case %d", i)
+	}
+}
+
+func TestClosedPipe(t *testing.T) {
+	m := benchmarkMatrix(50, 100)
+	v := make(model.Vector, 0, len(m))
+	for _, s := range m {
+		v = append(v, &model.Sample{Metric: s.Metric, Value: s.Values[0].Value, Timestamp: s.Values[0].Timestamp})
+	}
+	tmpl, err := NewTemplate(`{{range .}}{{.Value}}{{"\n"}}{{end}}`)
+	assert.NoError(t, err)
+	cases := []func(w io.Writer) error{
+		func(w io.Writer) error { return WriteRange(w, &RangeResult{Matrix: m}, "json", Options{}) },
+		func(w io.Writer) error { return WriteRange(w, &RangeResult{Matrix: m}, "csv", Options{}) },
+		func(w io.Writer) error {
+			return WriteRange(w, &RangeResult{Matrix: m}, "csv", Options{CSVQuote: CSVQuoteAlways})
+		},
+		func(w io.Writer) error { return WriteRange(w, &RangeResult{Matrix: m}, "graph", Options{}) },
+		func(w io.Writer) error { return WriteInstant(w, &InstantResult{Vector: v}, "json", Options{}) },
+		func(w io.Writer) error { return WriteInstant(w, &InstantResult{Vector: v}, "csv", Options{}) },
+		func(w io.Writer) error { return WriteInstant(w, &InstantResult{Vector: v}, "table", Options{}) },
+		func(w io.Writer) error {
+			return WriteInstant(w, &InstantResult{Vector: v}, "template", Options{Template: tmpl})
+		},
+		func(w io.Writer) error { return WriteValue(w, &InstantResult{Vector: v[:1]}) },
+		func(w io.Writer) error { return WriteCount(w, CountResult{Count: len(v)}, "table") },
+	}
+	for i, write := range cases {
+		r, w, err := os.Pipe()
+		assert.NoError(t, err, "Unexpected err for case %d, %v", i, err)
+		assert.NoError(t, r.Close())
+		err = write(w)
+		assert.Error(t, err, "Expected a write error for case %d", i)
+		if runtime.GOOS != "windows" {
+			assert.True(t, errors.Is(err, syscall.EPIPE), "Expected EPIPE for case %d, got %v", i, err)
+		}
+		assert.NoError(t, w.Close())
+	}
+}
+
+func TestSQLiteIdentifier(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{Input: "job", Expected: "job"},
+		{Input: "__name__", Expected: "__name__"},
+		{Input: "k8s.pod-name", Expected: "k8s_pod_name"},
+		{Input: "2xx", Expected: "_2xx"},
+		{Input: "ümlaut", Expected: "_mlaut"},
+		{Input: "", Expected: "_"},
+	}
+	for i, c := range cases {
+		assert.Equal(t, c.Expected, SQLiteIdentifier(c.Input), "Unexpected output for case %d", i)
+	}
+	columns := sqliteColumns([]model.LabelName{"a-b", "a_b", "value", "Timestamp"})
+	assert.Equal(t, []sqliteColumn{{"a-b", "a_b"}, {"a_b", "a_b_2"}, {"value", "value_2"}, {"Timestamp", "Timestamp_2"}}, columns)
+}
+
+func TestWriteSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+	ts := model.Time(1600000000000)
+	v := model.Vector{
+		{Metric: model.Metric{"__name__": "up", "job": "api"}, Value: 1, Timestamp: ts},
+		{Metric: model.Metric{"__name__": "up"}, Value: model.SampleValue(math.NaN()), Timestamp: ts},
+	}
+	rows, err := WriteSQLite(path, v, SQLiteOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rows)
+
+	// Appending adds columns for new labels, earlier rows have NULL for them
+	m := model.Matrix{
+		{Metric: model.Metric{"__name__": "up", "job": "api", "value": "x"}, Values: []model.SamplePair{{Timestamp: ts, Value: 2}, {Timestamp: ts + 60000, Value: 3}}},
+	}
+	rows, err = WriteSQLite(path, m, SQLiteOptions{Mode: SQLiteAppend})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rows)
+
+	db, err := sql.Open("sqlite", path)
+	assert.NoError(t, err)
+	defer db.Close()
+	read := func(table string) [][]interface{} {
+		r, err := db.Query(`SELECT "__name__", "job", "value_2", "value", "timestamp" FROM ` + table)
+		assert.NoError(t, err)
+		defer r.Close()
+		var out [][]interface{}
+		for r.Next() {
+			var name, job, label, timestamp sql.NullString
+			var value sql.NullFloat64
+			assert.NoError(t, r.Scan(&name, &job, &label, &value, &timestamp))
+			out = append(out, []interface{}{name.String, job, label, value, timestamp.String})
+		}
+		return out
+	}
+	null := sql.NullString{}
+	str := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+	num := func(f float64) sql.NullFloat64 { return sql.NullFloat64{Float64: f, Valid: true} }
+	assert.Equal(t, [][]interface{}{
+		{"up", str("api"), null, num(1), "2020-09-13T12:26:40Z"},
+		{"up", null, null, sql.NullFloat64{}, "2020-09-13T12:26:40Z"},
+		{"up", str("api"), str("x"), num(2), "2020-09-13T12:26:40Z"},
+		{"up", str("api"), str("x"), num(3), "2020-09-13T12:27:40Z"},
+	}, read("results"))
+
+	// Replacing drops the earlier rows
+	rows, err = WriteSQLite(path, m, SQLiteOptions{Mode: SQLiteReplace})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rows)
+	assert.Len(t, read("results"), 2)
+
+	_, err = WriteSQLite(path, m, SQLiteOptions{Mode: "upsert"})
+	assert.ErrorContains(t, err, "unknown sqlite mode")
+	_, err = WriteSQLite(path, &model.Scalar{Value: 1, Timestamp: ts}, SQLiteOptions{})
+	assert.ErrorContains(t, err, "unsupported query result type")
+}